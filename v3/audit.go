@@ -0,0 +1,173 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditAcquire   = "acquire"
+	AuditRelease   = "release"
+	AuditHeartbeat = "heartbeat"
+)
+
+// Results recorded in the audit log.
+const (
+	AuditResultOK            = "ok"
+	AuditResultContention    = "contention"
+	AuditResultOwnershipLost = "ownership_lost"
+	AuditResultError         = "error"
+)
+
+// AuditRecord is a single entry of the audit log, written as one line of JSON.
+// Each record carries the hash of the previous one, so the log is a chain that
+// VerifyAuditLog can check for removed or altered records.
+type AuditRecord struct {
+	Time                time.Time     `json:"time"`
+	Operation           string        `json:"op"`
+	TableName           string        `json:"table"`
+	PartitionKey        string        `json:"partitionKey"`
+	SortKey             string        `json:"sortKey,omitempty"`
+	Owner               string        `json:"owner"`
+	RecordVersionNumber string        `json:"rvn,omitempty"`
+	Result              string        `json:"result"`
+	Latency             time.Duration `json:"latencyNanos"`
+	Error               string        `json:"error,omitempty"`
+	PreviousHash        string        `json:"prevHash"`
+	Hash                string        `json:"hash"`
+}
+
+// WithAuditLog writes to w one AuditRecord per acquisition, release and
+// heartbeat, automatic or not, made by the client, so a trail of who held
+// which lock when can be shipped to a log pipeline. Writes are serialized, but
+// they happen in the goroutine of the operation: w should be buffered if it is
+// slow. Write errors are logged and otherwise ignored.
+func WithAuditLog(w io.Writer) ClientOption {
+	return func(c *commonClient) { c.auditLog = &auditLog{w: w} }
+}
+
+type auditLog struct {
+	mu       sync.Mutex
+	w        io.Writer
+	lastHash string
+}
+
+func (a *auditLog) write(record AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	record.PreviousHash = a.lastHash
+	record.Hash = ""
+	record.Hash = auditHash(record)
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.lastHash = record.Hash
+	return nil
+}
+
+// auditHash hashes the record, whose Hash must be empty.
+func auditHash(record AuditRecord) string {
+	b, _ := json.Marshal(record)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditLog reads an audit log written by WithAuditLog and checks that
+// every record is intact and follows the previous one. It returns the number
+// of records read, and an error describing the first inconsistency found.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var (
+		n        int
+		lastHash string
+	)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		if record.PreviousHash != lastHash {
+			return n, fmt.Errorf("record %d: chain broken", n+1)
+		}
+		hash := record.Hash
+		record.Hash = ""
+		if auditHash(record) != hash {
+			return n, fmt.Errorf("record %d: hash mismatch", n+1)
+		}
+		lastHash = hash
+		n++
+	}
+	return n, scanner.Err()
+}
+
+func auditResult(err error) string {
+	switch {
+	case err == nil:
+		return AuditResultOK
+	case IsOwnershipLost(err) || errors.Is(err, ErrLockAlreadyReleased):
+		return AuditResultOwnershipLost
+	case IsContention(err):
+		return AuditResultContention
+	default:
+		return AuditResultError
+	}
+}
+
+// audit records an operation on the given lock, which may be nil if it was
+// not acquired.
+func (c *commonClient) audit(ctx context.Context, op string, key lockKey, l *Lock, start time.Time, err error) {
+	if c.auditLog == nil {
+		return
+	}
+	tableName := key.tableName
+	if tableName == "" {
+		tableName = c.tableName
+	}
+	record := AuditRecord{
+		Time:                start,
+		Operation:           op,
+		TableName:           tableName,
+		PartitionKey:        key.partitionKey,
+		SortKey:             key.sortKey,
+		Owner:               c.currentOwnerName(),
+		RecordVersionNumber: l.RecordVersionNumber(),
+		Result:              auditResult(err),
+		Latency:             c.now().Sub(start),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := c.auditLog.write(record); err != nil {
+		c.logger.Error(ctx, "cannot write audit log: ", err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c, err := New(&mockDynamoDBClient{}, "locksAudit", "key",
+		DisableHeartbeat(),
+		WithOwnerName("auditor"),
+		WithAuditLog(&buf),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	l, err := c.AcquireLock(context.Background(), "audited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendHeartbeat(context.Background(), l); err == nil {
+		t.Fatal("expected heartbeat of released lock to fail")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []struct{ op, result string }{
+		{AuditAcquire, AuditResultOK},
+		{AuditHeartbeat, AuditResultOK},
+		{AuditRelease, AuditResultOK},
+		{AuditHeartbeat, AuditResultOwnershipLost},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("unexpected audit log:\n%s", buf.String())
+	}
+	for i, line := range lines {
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Operation != want[i].op || record.Result != want[i].result {
+			t.Errorf("unexpected record %d: %s", i, line)
+		}
+		if record.TableName != "locksAudit" || record.PartitionKey != "audited" || record.Owner != "auditor" || record.RecordVersionNumber == "" {
+			t.Errorf("incomplete record %d: %s", i, line)
+		}
+		if !record.Time.Equal(clock.Now()) || record.Latency != 0 {
+			t.Errorf("record %d not timed with the client clock: %s", i, line)
+		}
+	}
+
+	if n, err := VerifyAuditLog(strings.NewReader(buf.String())); err != nil || n != len(want) {
+		t.Fatal("expected audit log to be intact:", n, err)
+	}
+	tampered := strings.Replace(buf.String(), `"owner":"auditor"`, `"owner":"mallory"`, 1)
+	if _, err := VerifyAuditLog(strings.NewReader(tampered)); err == nil {
+		t.Fatal("expected altered record to be detected")
+	}
+	removed := strings.Join(append(lines[:1:1], lines[2:]...), "\n")
+	if _, err := VerifyAuditLog(strings.NewReader(removed)); err == nil {
+		t.Fatal("expected removed record to be detected")
+	}
+}
@@ -29,19 +29,42 @@ type Client struct{ *commonClient }
 
 // New creates a new dynamoDB based distributed lock client.
 func New(dynamoDB DynamoDBClient, tableName, partitionKeyName string, opts ...ClientOption) (*Client, error) {
-	commonClient, err := newCommon(dynamoDB, tableName, partitionKeyName, opts...)
+	commonClient, err := newCommon(dynamoDB, tableName, partitionKeyName, "", opts...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{commonClient}, nil
+	c := &Client{commonClient}
+	if err := c.ensureTable(c.createTableSchema); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 // AcquireLock holds the defined lock. The given context is passed
 // down to the underlying dynamoDB call.
 func (c *Client) AcquireLock(ctx context.Context, partitionKey string, opts ...AcquireLockOption) (*Lock, error) {
-	return c.acquireLock(ctx, partitionKey, opts...)
+	return c.acquireLock(ctx, partitionKey, "", opts...)
+}
+
+// AcquireLockWithStatus acquires the lock in the background, reporting each
+// state transition of the acquisition on the returned channel, so long waits
+// can be surfaced in real time. The last status is either
+// AcquisitionStateAcquired, carrying the lock, or AcquisitionStateFailed,
+// carrying the error; the channel is closed afterwards. Intermediate states
+// are dropped if the channel is not read fast enough. The channel must be
+// drained, otherwise an acquired lock is never handed over. The given context
+// is passed down to the underlying dynamoDB calls.
+func (c *Client) AcquireLockWithStatus(ctx context.Context, partitionKey string, opts ...AcquireLockOption) <-chan AcquisitionStatus {
+	return c.acquireLockWithStatus(ctx, partitionKey, "", opts...)
+}
+
+// DoWithLock acquires the lock, runs fn while holding it and releases it
+// afterwards. Critical sections started with DoWithLock are waited on by Drain.
+// The given context is passed down to fn and to the underlying dynamoDB calls.
+func (c *Client) DoWithLock(ctx context.Context, partitionKey string, fn func(context.Context, *Lock) error, opts ...AcquireLockOption) error {
+	return c.doWithLock(ctx, partitionKey, "", fn, opts...)
 }
 
 // Get finds out who owns the given lock, but does not acquire the
@@ -52,9 +75,27 @@ func (c *Client) AcquireLock(ctx context.Context, partitionKey string, opts ...A
 // the caller should check lockItem.isExpired() to figure out if it currently
 // has the lock.) If the context is canceled, it is going to return the context
 // error on local cache hit. The given context is passed down to the underlying
-// dynamoDB call.
+// dynamoDB call. Lookup tells apart missing, free and owned locks more
+// clearly.
 func (c *Client) Get(ctx context.Context, partitionKey string) (*Lock, error) {
-	return c.get(ctx, partitionKey)
+	return c.get(ctx, partitionKey, "")
+}
+
+// Lookup finds out whether the given lock exists and who owns it, without
+// acquiring it. Unlike Get, the result tells whether the lock is held by this
+// client, and only then carries a Lock that can be released. The given
+// context is passed down to the underlying dynamoDB call.
+func (c *Client) Lookup(ctx context.Context, partitionKey string) (LookupResult, error) {
+	return c.lookup(ctx, partitionKey, "")
+}
+
+// RequestRelease politely asks the holder of the given lock to release it,
+// stating why. Holders learn about it in their next heartbeat, see
+// WithReleaseRequestCallback, and are free to ignore it. It returns
+// ErrLockAlreadyReleased if the lock is not held. The given context is passed
+// down to the underlying dynamoDB call.
+func (c *Client) RequestRelease(ctx context.Context, partitionKey, reason string) error {
+	return c.requestRelease(ctx, partitionKey, "", reason)
 }
 
 // CreateTable prepares a DynamoDB table with the right schema for it
@@ -77,7 +118,7 @@ func (c *Client) createTableSchema() ([]types.KeySchemaElement, []types.Attribut
 	attributeDefinitions := []types.AttributeDefinition{
 		{
 			AttributeName: aws.String(c.partitionKeyName),
-			AttributeType: types.ScalarAttributeTypeS,
+			AttributeType: c.partitionKeyType,
 		},
 	}
 
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Advisory acquires the lock in advisory mode: the lock row is written
+// without any condition, even if another owner holds it, and the conflict is
+// only reported to onConflict, with the holder that was overwritten. It is
+// meant for migration phases, to learn about would-be contention before
+// turning mutual exclusion on. Conflicts are reported whether or not the lease
+// of the holder expired, as that cannot be told apart without waiting for it.
+// The overwritten holder finds out that it lost the lock on its next
+// heartbeat. onConflict runs synchronously in the acquisition goroutine and
+// may be nil; conflicts are logged regardless.
+func Advisory(onConflict func(holder LockInfo)) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.advisory = true
+		opt.onAdvisoryConflict = onConflict
+	}
+}
+
+// storeAdvisoryLock writes the lock row unconditionally, reporting the holder
+// it overwrites, if any.
+func (c *commonClient) storeAdvisoryLock(ctx context.Context, getLockOptions *getLockOptions, existingLock *Lock, newLockData []byte, item map[string]types.AttributeValue, recordVersionNumber string) (*Lock, error) {
+	switch {
+	case existingLock == nil:
+		getLockOptions.acquisitionKind = AcquisitionFresh
+	case existingLock.isReleased:
+		getLockOptions.acquisitionKind = AcquisitionReleased
+	case existingLock.ownerName == c.currentOwnerName():
+		getLockOptions.acquisitionKind = AcquisitionExpired
+	default:
+		getLockOptions.acquisitionKind = AcquisitionAdvisory
+		holder := lockInfo(existingLock)
+		c.logger.Info(ctx, "Advisory lock ", getLockOptions.partitionKey, " acquired over the lock of ", holder.OwnerName)
+		if getLockOptions.onAdvisoryConflict != nil {
+			getLockOptions.onAdvisoryConflict(holder)
+		}
+	}
+	req := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(c.tableName),
+	}
+	return c.putLockItemAndStartSessionMonitor(ctx, getLockOptions.additionalAttributes,
+		getLockOptions.partitionKey, getLockOptions.sortKey, getLockOptions.deleteLockOnRelease,
+		newLockData, recordVersionNumber, getLockOptions.sessionMonitor, req)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAdvisory(t *testing.T) {
+	svc := newHeldLockDynamoDBClient("locksAdvisory", "other")
+	c, err := New(svc, "locksAdvisory", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	var conflicts []LockInfo
+	l, err := c.AcquireLock(context.Background(), "leader",
+		Advisory(func(holder LockInfo) {
+			conflicts = append(conflicts, holder)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].OwnerName != "other" {
+		t.Fatalf("expected the conflict to be reported: %#v", conflicts)
+	}
+	if got := l.Acquisition().Kind; got != AcquisitionAdvisory {
+		t.Fatal("unexpected acquisition kind:", got)
+	}
+	if puts := svc.putInputs(); len(puts) != 1 || puts[0].ConditionExpression != nil {
+		t.Fatal("advisory locks must be written unconditionally")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+)
+
+// OwnershipAssertionError is returned by AssertOwnership when the lock no
+// longer belongs to this client. It matches ErrOwnershipLost with errors.Is.
+type OwnershipAssertionError struct {
+	// Reason tells why the assertion failed.
+	Reason string
+	// OwnerName and RecordVersionNumber are the ones found in the lock
+	// row, if it was read and it still exists.
+	OwnerName           string
+	RecordVersionNumber string
+}
+
+func (e *OwnershipAssertionError) Error() string {
+	if e.OwnerName == "" {
+		return fmt.Sprintf("%v: %s", ErrOwnershipLost, e.Reason)
+	}
+	return fmt.Sprintf("%v: %s (owner %q, record version number %q)", ErrOwnershipLost, e.Reason, e.OwnerName, e.RecordVersionNumber)
+}
+
+// Is makes OwnershipAssertionError match ErrOwnershipLost.
+func (e *OwnershipAssertionError) Is(target error) bool {
+	return target == ErrOwnershipLost
+}
+
+// AssertOwnership verifies, with a consistent read, that the lock row still
+// carries the owner name and the record version number of the given lock, and
+// that its lease did not run out locally. It returns an OwnershipAssertionError
+// otherwise. It is meant as a last-line fencing check, right before irreversible
+// side effects outside of DynamoDB; the lock might still be lost right after
+// the check, so the side effects must take less than the remaining lease. It
+// waits for in-flight heartbeats of the lock. The given context is passed down
+// to the underlying dynamoDB call.
+func (c *commonClient) AssertOwnership(ctx context.Context, lockItem *Lock) error {
+	if lockItem == nil {
+		return ErrCannotRefreshNullLock
+	}
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	ctx = routeToLock(ctx, lockItem)
+
+	lockItem.semaphore.Lock()
+	defer lockItem.semaphore.Unlock()
+
+	if lockItem.ownerName != c.currentOwnerName() {
+		return ErrOwnerMismatched
+	}
+	if lockItem.isReleased {
+		return &OwnershipAssertionError{Reason: "lock released"}
+	}
+	if lockItem.isExpired() {
+		return &OwnershipAssertionError{Reason: "lease expired"}
+	}
+
+	res, err := c.readFromDynamoDB(ctx, lockItem.partitionKey, lockItem.sortKey, true)
+	if err != nil {
+		return err
+	}
+	if len(res.Item) == 0 {
+		return &OwnershipAssertionError{Reason: "lock row missing"}
+	}
+	ownerName := readStringAttr(res.Item[attrOwnerName])
+	recordVersionNumber := readStringAttr(res.Item[attrRecordVersionNumber])
+	switch {
+	case c.isReleasedItem(res.Item):
+		return &OwnershipAssertionError{Reason: "lock row released", OwnerName: ownerName, RecordVersionNumber: recordVersionNumber}
+	case ownerName != lockItem.ownerName || recordVersionNumber != lockItem.recordVersionNumber:
+		return &OwnershipAssertionError{Reason: "lock row changed", OwnerName: ownerName, RecordVersionNumber: recordVersionNumber}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestAssertOwnership(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksAssert", "key", DisableHeartbeat(), WithOwnerName("owner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "assert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := func(owner, rvn string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"key":                   stringAttrValue("assert"),
+			attrOwnerName:           stringAttrValue(owner),
+			attrLeaseDuration:       stringAttrValue("20s"),
+			attrRecordVersionNumber: stringAttrValue(rvn),
+		}
+	}
+
+	svc.putRow("locksAssert", row("owner", l.recordVersionNumber))
+	if err := c.AssertOwnership(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		item   map[string]types.AttributeValue
+		reason string
+	}{
+		{"missing", nil, "lock row missing"},
+		{"taken over", row("other", "other-rvn"), "lock row changed"},
+		{"stale", row("owner", "newer-rvn"), "lock row changed"},
+		{"released", func() map[string]types.AttributeValue {
+			item := row("owner", l.recordVersionNumber)
+			item[attrIsReleased] = stringAttrValue("1")
+			return item
+		}(), "lock row released"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.item == nil {
+				svc.deleteRow("locksAssert", "assert")
+			} else {
+				svc.putRow("locksAssert", tt.item)
+			}
+			err := c.AssertOwnership(context.Background(), l)
+			var errAssertion *OwnershipAssertionError
+			if !errors.As(err, &errAssertion) || errAssertion.Reason != tt.reason {
+				t.Fatal("unexpected error:", err)
+			}
+			if !IsOwnershipLost(err) {
+				t.Fatal("assertion failures should be ownership losses:", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// latencyDynamoDBClient adds a fixed latency to every call, so benchmarks can
+// tell apart the time spent in the client from the time spent waiting on
+// DynamoDB.
+type latencyDynamoDBClient struct {
+	mockDynamoDBClient
+	latency time.Duration
+}
+
+func (m *latencyDynamoDBClient) wait(ctx context.Context) error {
+	if m.latency <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(m.latency):
+		return nil
+	}
+}
+
+func (m *latencyDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	return m.mockDynamoDBClient.GetItem(ctx, params, optFns...)
+}
+
+func (m *latencyDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	return m.mockDynamoDBClient.PutItem(ctx, params, optFns...)
+}
+
+func (m *latencyDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	return m.mockDynamoDBClient.UpdateItem(ctx, params, optFns...)
+}
+
+func (m *latencyDynamoDBClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+	return m.mockDynamoDBClient.DeleteItem(ctx, params, optFns...)
+}
+
+var benchmarkLatencies = []time.Duration{0, 100 * time.Microsecond, time.Millisecond}
+
+func BenchmarkAcquireRelease(b *testing.B) {
+	for _, latency := range benchmarkLatencies {
+		b.Run(fmt.Sprint("latency=", latency), func(b *testing.B) {
+			c, err := New(&latencyDynamoDBClient{latency: latency}, "locksBenchmark", "key",
+				DisableHeartbeat(),
+			)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			var seq int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := strconv.FormatInt(atomic.AddInt64(&seq, 1), 10)
+					l, err := c.AcquireLock(context.Background(), key)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkHeartbeatCycle(b *testing.B) {
+	for _, locks := range []int{10, 100, 10000} {
+		for _, latency := range benchmarkLatencies[:2] {
+			if locks > 100 && latency > 0 {
+				// Heartbeats are sent serially: it would take seconds.
+				continue
+			}
+			b.Run(fmt.Sprint("locks=", locks, "/latency=", latency), func(b *testing.B) {
+				c, err := New(&latencyDynamoDBClient{}, "locksBenchmark", "key",
+					DisableHeartbeat(),
+					WithLeaseDuration(time.Hour),
+				)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer c.Close(context.Background())
+				for i := 0; i < locks; i++ {
+					if _, err := c.AcquireLock(context.Background(), strconv.Itoa(i)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				// The latency only applies to the heartbeats.
+				c.dynamoDB = newMiddlewareDynamoDBClient(&latencyDynamoDBClient{latency: latency}, c.middlewares)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					c.heartbeatLocks(context.Background())
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// BudgetExceededError indicates that the dynamolock gave up acquiring the lock
+// because it would have made more DynamoDB calls than allowed by
+// WithCallBudget.
+type BudgetExceededError struct {
+	Budget int
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("call budget of %d DynamoDB requests exhausted", e.Budget)
+}
+
+// WithCallBudget limits the number of DynamoDB requests, reads and writes
+// alike, that the acquisition may make, so the cost of contended locks on
+// on-demand tables is bounded. Once the budget is exhausted, the acquisition
+// fails with a LockNotGrantedError caused by a BudgetExceededError. Requests
+// made to clean up after the acquisition, like leaving the waiter inbox, are
+// not charged to the budget. A budget of zero or less means no limit.
+func WithCallBudget(n int) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.callBudget = n
+	}
+}
+
+type callBudgetKey struct{}
+
+type callBudget struct {
+	limit int64
+	calls int64
+}
+
+// withCallBudget charges the DynamoDB calls made with the returned context to
+// a budget of n calls.
+func withCallBudget(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, callBudgetKey{}, &callBudget{limit: int64(n)})
+}
+
+// callBudgetMiddleware rejects the calls that exceed the budget of their
+// context, if any.
+func callBudgetMiddleware(next Operation) Operation {
+	return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		if budget, ok := ctx.Value(callBudgetKey{}).(*callBudget); ok {
+			if atomic.AddInt64(&budget.calls, 1) > budget.limit {
+				return nil, &BudgetExceededError{Budget: int(budget.limit)}
+			}
+		}
+		return next(ctx, name, input)
+	}
+}
+
+// budgetExceeded wraps the error of an acquisition that ran out of call budget
+// as the other give ups are.
+func (c *commonClient) budgetExceeded(getLockOptions *getLockOptions, err *BudgetExceededError) error {
+	return &LockNotGrantedError{
+		msg:            "Didn't acquire lock within the call budget",
+		cause:          err,
+		holder:         holderInfo(getLockOptions.lockTryingToBeAcquired),
+		remainingLease: c.remainingLease(getLockOptions.lockTryingToBeAcquired, getLockOptions.expiryGrace),
+	}
+}
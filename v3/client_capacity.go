@@ -0,0 +1,281 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// OperationCapacity is the DynamoDB capacity consumed by the calls of a given
+// operation.
+type OperationCapacity struct {
+	// Operation is the name of the DynamoDB API, for example "PutItem".
+	Operation string
+	// Calls is the number of calls that reported consumed capacity.
+	Calls              uint64
+	ReadCapacityUnits  float64
+	WriteCapacityUnits float64
+}
+
+// ConsumedCapacityStats is the DynamoDB capacity consumed by the client. See
+// WithConsumedCapacity.
+type ConsumedCapacityStats struct {
+	ReadCapacityUnits  float64
+	WriteCapacityUnits float64
+	// Operations breaks down the consumed capacity by operation, sorted by
+	// operation name.
+	Operations []OperationCapacity
+}
+
+type capacityStats struct {
+	mu         sync.Mutex
+	operations map[string]*OperationCapacity
+
+	// windowStart and windowWrite account for the write capacity consumed
+	// in the current minute, checked against the capacity budget.
+	windowStart    time.Time
+	windowWrite    float64
+	windowNotified bool
+}
+
+// CapacityBudgetExceeded describes the minute in which the lock traffic of
+// the client exceeded its capacity budget. See WithCapacityBudget.
+type CapacityBudgetExceeded struct {
+	// Budget is the configured budget, in write capacity units per minute.
+	Budget float64
+	// Consumed is the write capacity consumed in the minute so far.
+	Consumed float64
+	// Since is when the minute started.
+	Since time.Time
+}
+
+// WithCapacityBudget sets a budget for the write capacity consumed by the lock
+// traffic of the client, in write capacity units per minute. onExceeded is
+// called once per minute in which the budget is exceeded; it is called
+// synchronously and must not block. While over budget, the automatic
+// heartbeats of locks acquired with NonCritical are throttled: they are only
+// sent once half of the lease duration elapsed since the previous one. It
+// implies WithConsumedCapacity.
+func WithCapacityBudget(wcuPerMinute float64, onExceeded func(CapacityBudgetExceeded)) ClientOption {
+	return func(c *commonClient) {
+		c.consumedCapacity = true
+		c.capacityBudget = wcuPerMinute
+		c.onCapacityBudgetExceeded = onExceeded
+	}
+}
+
+// NonCritical marks the lock as one whose heartbeats can be throttled when the
+// client exceeds its capacity budget. See WithCapacityBudget.
+func NonCritical() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.nonCritical = true
+	}
+}
+
+// WithConsumedCapacity makes the client ask DynamoDB for the capacity consumed
+// by each call, so the cost of the lock traffic can be attributed precisely.
+// The consumed capacity is aggregated by operation, see ConsumedCapacity, and
+// every call is reported to the metrics hook as a MetricConsumedCapacity.
+// Calls that fail, including failed conditional writes, do not report the
+// capacity they consumed.
+func WithConsumedCapacity() ClientOption {
+	return func(c *commonClient) { c.consumedCapacity = true }
+}
+
+// ConsumedCapacity returns the DynamoDB capacity consumed by the client since
+// it was created, when WithConsumedCapacity is used.
+func (c *commonClient) ConsumedCapacity() ConsumedCapacityStats {
+	c.capacity.mu.Lock()
+	defer c.capacity.mu.Unlock()
+	var stats ConsumedCapacityStats
+	for _, op := range c.capacity.operations {
+		stats.ReadCapacityUnits += op.ReadCapacityUnits
+		stats.WriteCapacityUnits += op.WriteCapacityUnits
+		stats.Operations = append(stats.Operations, *op)
+	}
+	sort.Slice(stats.Operations, func(i, j int) bool {
+		return stats.Operations[i].Operation < stats.Operations[j].Operation
+	})
+	return stats
+}
+
+// consumedCapacityMiddleware requests and records the consumed capacity of
+// every call. It is the innermost middleware, so calls retried by other
+// middlewares are accounted for individually.
+func (c *commonClient) consumedCapacityMiddleware(next Operation) Operation {
+	return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		var key map[string]types.AttributeValue
+		switch in := input.(type) {
+		case *dynamodb.GetItemInput:
+			in.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+			key = in.Key
+		case *dynamodb.PutItemInput:
+			in.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+			key = in.Item
+		case *dynamodb.UpdateItemInput:
+			in.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+			key = in.Key
+		case *dynamodb.DeleteItemInput:
+			in.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+			key = in.Key
+		case *dynamodb.QueryInput:
+			in.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		case *dynamodb.ScanInput:
+			in.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+		}
+		out, err := next(ctx, name, input)
+		var consumed *types.ConsumedCapacity
+		switch o := out.(type) {
+		case *dynamodb.GetItemOutput:
+			if o != nil {
+				consumed = o.ConsumedCapacity
+			}
+		case *dynamodb.PutItemOutput:
+			if o != nil {
+				consumed = o.ConsumedCapacity
+			}
+		case *dynamodb.UpdateItemOutput:
+			if o != nil {
+				consumed = o.ConsumedCapacity
+			}
+		case *dynamodb.DeleteItemOutput:
+			if o != nil {
+				consumed = o.ConsumedCapacity
+			}
+		case *dynamodb.QueryOutput:
+			if o != nil {
+				consumed = o.ConsumedCapacity
+			}
+		case *dynamodb.ScanOutput:
+			if o != nil {
+				consumed = o.ConsumedCapacity
+			}
+		}
+		if consumed != nil {
+			var partitionKey, sortKey string
+			if key != nil {
+				partitionKey = readKeyAttr(key[c.partitionKeyName])
+				if c.sortKeyName != "" {
+					sortKey = readKeyAttr(key[c.sortKeyName])
+				}
+			}
+			c.recordCapacity(name, partitionKey, sortKey, consumed)
+		}
+		return out, err
+	}
+}
+
+func (c *commonClient) recordCapacity(operation, partitionKey, sortKey string, consumed *types.ConsumedCapacity) {
+	var read, write float64
+	if consumed.ReadCapacityUnits != nil || consumed.WriteCapacityUnits != nil {
+		read, write = aws.ToFloat64(consumed.ReadCapacityUnits), aws.ToFloat64(consumed.WriteCapacityUnits)
+	} else if isReadOperation(operation) {
+		read = aws.ToFloat64(consumed.CapacityUnits)
+	} else {
+		write = aws.ToFloat64(consumed.CapacityUnits)
+	}
+
+	c.capacity.mu.Lock()
+	if c.capacity.operations == nil {
+		c.capacity.operations = make(map[string]*OperationCapacity)
+	}
+	op, ok := c.capacity.operations[operation]
+	if !ok {
+		op = &OperationCapacity{Operation: operation}
+		c.capacity.operations[operation] = op
+	}
+	op.Calls++
+	op.ReadCapacityUnits += read
+	op.WriteCapacityUnits += write
+	exceeded, notify := c.accountBudget(write)
+	c.capacity.mu.Unlock()
+
+	if notify && c.onCapacityBudgetExceeded != nil {
+		c.onCapacityBudgetExceeded(exceeded)
+	}
+
+	if c.metricsHook != nil {
+		c.metricsHook(Metric{
+			Kind:               MetricConsumedCapacity,
+			PartitionKey:       partitionKey,
+			SortKey:            sortKey,
+			Operation:          operation,
+			ReadCapacityUnits:  read,
+			WriteCapacityUnits: write,
+		})
+	}
+}
+
+func isReadOperation(operation string) bool {
+	switch operation {
+	case "GetItem", "Query", "Scan":
+		return true
+	}
+	return false
+}
+
+// accountBudget adds the write capacity to the current minute, and reports
+// whether the budget was exceeded for the first time in it. Callers must hold
+// c.capacity.mu.
+func (c *commonClient) accountBudget(write float64) (CapacityBudgetExceeded, bool) {
+	if c.capacityBudget <= 0 {
+		return CapacityBudgetExceeded{}, false
+	}
+	now := c.now()
+	if now.Sub(c.capacity.windowStart) >= time.Minute {
+		c.capacity.windowStart = now
+		c.capacity.windowWrite = 0
+		c.capacity.windowNotified = false
+	}
+	c.capacity.windowWrite += write
+	if c.capacity.windowWrite <= c.capacityBudget || c.capacity.windowNotified {
+		return CapacityBudgetExceeded{}, false
+	}
+	c.capacity.windowNotified = true
+	return CapacityBudgetExceeded{
+		Budget:   c.capacityBudget,
+		Consumed: c.capacity.windowWrite,
+		Since:    c.capacity.windowStart,
+	}, true
+}
+
+func (c *commonClient) overCapacityBudget() bool {
+	if c.capacityBudget <= 0 {
+		return false
+	}
+	c.capacity.mu.Lock()
+	defer c.capacity.mu.Unlock()
+	return c.now().Sub(c.capacity.windowStart) < time.Minute && c.capacity.windowWrite > c.capacityBudget
+}
+
+// throttledHeartbeat reports whether the automatic heartbeat of the lock
+// should be skipped to save capacity.
+func (c *commonClient) throttledHeartbeat(lockItem *Lock) bool {
+	lockItem.semaphore.Lock()
+	nonCritical := lockItem.nonCritical
+	sinceHeartbeat := c.now().Sub(lockItem.lookupTime)
+	leaseDuration := lockItem.leaseDuration
+	lockItem.semaphore.Unlock()
+	return nonCritical && sinceHeartbeat < leaseDuration/2 && c.overCapacityBudget()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type capacityDynamoDBClient struct {
+	mockDynamoDBClient
+	mu        sync.Mutex
+	requested []types.ReturnConsumedCapacity
+}
+
+func (m *capacityDynamoDBClient) request(r types.ReturnConsumedCapacity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requested = append(m.requested, r)
+}
+
+func (m *capacityDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.request(params.ReturnConsumedCapacity)
+	return &dynamodb.GetItemOutput{ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(0.5)}}, nil
+}
+
+func (m *capacityDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.request(params.ReturnConsumedCapacity)
+	return &dynamodb.PutItemOutput{ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(1)}}, nil
+}
+
+func (m *capacityDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.request(params.ReturnConsumedCapacity)
+	return &dynamodb.UpdateItemOutput{ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(2)}}, nil
+}
+
+func TestConsumedCapacity(t *testing.T) {
+	svc := &capacityDynamoDBClient{}
+	var metrics []Metric
+	c, err := New(svc, "locksCapacity", "key",
+		DisableHeartbeat(),
+		WithConsumedCapacity(),
+		WithMetricsHook(func(m Metric) {
+			if m.Kind == MetricConsumedCapacity {
+				metrics = append(metrics, m)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "capacity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range svc.requested {
+		if r != types.ReturnConsumedCapacityTotal {
+			t.Fatal("consumed capacity should be requested in all calls:", svc.requested)
+		}
+	}
+	got := c.ConsumedCapacity()
+	if got.ReadCapacityUnits != 0.5 || got.WriteCapacityUnits != 3 {
+		t.Fatalf("unexpected aggregate consumed capacity: %#v", got)
+	}
+	want := []OperationCapacity{
+		{Operation: "GetItem", Calls: 1, ReadCapacityUnits: 0.5},
+		{Operation: "PutItem", Calls: 1, WriteCapacityUnits: 1},
+		{Operation: "UpdateItem", Calls: 1, WriteCapacityUnits: 2},
+	}
+	if len(got.Operations) != len(want) {
+		t.Fatalf("unexpected consumed capacity by operation: %#v", got.Operations)
+	}
+	for i := range want {
+		if got.Operations[i] != want[i] {
+			t.Fatalf("unexpected consumed capacity by operation: %#v", got.Operations)
+		}
+	}
+	if len(metrics) != 3 {
+		t.Fatalf("unexpected consumed capacity metrics: %#v", metrics)
+	}
+	for _, m := range metrics {
+		if m.PartitionKey != "capacity" {
+			t.Fatalf("consumed capacity should be attributed to the lock: %#v", m)
+		}
+	}
+}
+
+func TestCapacityBudget(t *testing.T) {
+	svc := &capacityDynamoDBClient{}
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var exceeded []CapacityBudgetExceeded
+	c, err := New(svc, "locksCapacity", "key",
+		DisableHeartbeat(),
+		WithClock(clock),
+		WithLeaseDuration(time.Minute),
+		WithCapacityBudget(4, func(e CapacityBudgetExceeded) {
+			exceeded = append(exceeded, e)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	critical, err := c.AcquireLock(context.Background(), "critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonCritical, err := c.AcquireLock(context.Background(), "nonCritical", NonCritical())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exceeded) != 0 || c.overCapacityBudget() {
+		t.Fatal("budget should not be exceeded yet:", exceeded)
+	}
+	if c.throttledHeartbeat(nonCritical) {
+		t.Fatal("heartbeats should not be throttled under budget")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.SendHeartbeat(context.Background(), critical); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(exceeded) != 1 || exceeded[0].Budget != 4 || exceeded[0].Consumed != 6 || !exceeded[0].Since.Equal(time.Unix(0, 0)) {
+		t.Fatalf("budget should be reported as exceeded once: %#v", exceeded)
+	}
+	if c.throttledHeartbeat(critical) || !c.throttledHeartbeat(nonCritical) {
+		t.Fatal("only heartbeats of non-critical locks should be throttled")
+	}
+	clock.Advance(31 * time.Second)
+	if c.throttledHeartbeat(nonCritical) {
+		t.Fatal("throttled heartbeats should be sent once half of the lease elapsed")
+	}
+	if err := c.SendHeartbeat(context.Background(), critical); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(30 * time.Second)
+	if c.overCapacityBudget() {
+		t.Fatal("budget should be reset every minute")
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.SendHeartbeat(context.Background(), critical); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(exceeded) != 2 {
+		t.Fatalf("budget should be reported again in a new minute: %#v", exceeded)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithCoalescedWaits makes the AcquireLock calls of this client that wait for
+// the same key share a single poller: the lock row is read at most once per
+// interval for each key, no matter how many goroutines wait for it, and all
+// of them are woken up at once when the lock is released through this client.
+// It is meant for hot locks contended by many goroutines of the same process.
+func WithCoalescedWaits(interval time.Duration) ClientOption {
+	return func(c *commonClient) { c.coalesceInterval = interval }
+}
+
+type keyCoalescer struct {
+	mu   sync.Mutex
+	keys map[lockKey]*coalescedKey
+}
+
+type coalescedKey struct {
+	waiters  int
+	item     map[string]types.AttributeValue
+	readAt   time.Time
+	inflight chan struct{}
+	wake     chan struct{}
+}
+
+// join registers a waiter for the key, and returns the function that
+// unregisters it.
+func (k *keyCoalescer) join(key lockKey) func() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[lockKey]*coalescedKey)
+	}
+	ck, ok := k.keys[key]
+	if !ok {
+		ck = &coalescedKey{wake: make(chan struct{})}
+		k.keys[key] = ck
+	}
+	ck.waiters++
+	return func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		ck.waiters--
+		if ck.waiters == 0 && ck.inflight == nil {
+			delete(k.keys, key)
+		}
+	}
+}
+
+// wakeup returns the channel closed when the waiters of the key must retry
+// immediately. It is nil if nobody waits for the key.
+func (k *keyCoalescer) wakeup(key lockKey) <-chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if ck, ok := k.keys[key]; ok {
+		return ck.wake
+	}
+	return nil
+}
+
+// invalidate discards the last read of the key, and optionally wakes up its
+// waiters.
+func (k *keyCoalescer) invalidate(key lockKey, wake bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	ck, ok := k.keys[key]
+	if !ok {
+		return
+	}
+	ck.readAt = time.Time{}
+	if wake {
+		close(ck.wake)
+		ck.wake = make(chan struct{})
+	}
+}
+
+// coalescedRead reads the lock row, sharing the read with the other waiters of
+// the key: recent reads are reused, and concurrent reads are joined.
+func (c *commonClient) coalescedRead(ctx context.Context, key lockKey) (map[string]types.AttributeValue, error) {
+	k := &c.coalescer
+	k.mu.Lock()
+	ck, ok := k.keys[key]
+	if !ok {
+		k.mu.Unlock()
+		res, err := c.readFromDynamoDB(ctx, key.partitionKey, key.sortKey, true)
+		if err != nil {
+			return nil, err
+		}
+		return res.Item, nil
+	}
+	for {
+		if !ck.readAt.IsZero() && c.now().Sub(ck.readAt) < c.coalesceInterval {
+			item := copyItem(ck.item)
+			k.mu.Unlock()
+			return item, nil
+		}
+		if ck.inflight == nil {
+			break
+		}
+		inflight := ck.inflight
+		k.mu.Unlock()
+		select {
+		case <-inflight:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		k.mu.Lock()
+	}
+	ck.inflight = make(chan struct{})
+	k.mu.Unlock()
+
+	res, err := c.readFromDynamoDB(ctx, key.partitionKey, key.sortKey, true)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	close(ck.inflight)
+	ck.inflight = nil
+	if ck.waiters == 0 {
+		delete(k.keys, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ck.item = res.Item
+	ck.readAt = c.now()
+	return copyItem(res.Item), nil
+}
+
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	itemCopy := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		itemCopy[k] = v
+	}
+	return itemCopy
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescedWaits(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksCoalescedWaits", "key",
+		WithLeaseDuration(time.Minute),
+		DisableHeartbeat(),
+		WithCoalescedWaits(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "hot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const waiters = 20
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := c.AcquireLock(context.Background(), "hot", WithRefreshPeriod(5*time.Millisecond))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(250 * time.Millisecond)
+	if reads := svc.callCount("GetItem"); reads > 10 {
+		t.Fatal("waiters did not share their reads:", reads)
+	}
+
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiters did not acquire the lock in turn")
+	}
+	c.coalescer.mu.Lock()
+	defer c.coalescer.mu.Unlock()
+	if len(c.coalescer.keys) != 0 {
+		t.Fatal("coalesced keys leaked:", len(c.coalescer.keys))
+	}
+}
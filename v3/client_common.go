@@ -21,9 +21,12 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/big"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -39,6 +42,26 @@ const (
 	attrLeaseDuration       = "leaseDuration"
 	attrRecordVersionNumber = "recordVersionNumber"
 	attrIsReleased          = "isReleased"
+	attrPriority            = "priority"
+	attrPreemptionOwner     = "preemptionRequestedBy"
+	attrPreemptionPriority  = "preemptionRequestedPriority"
+	attrWaitsForPartition   = "waitsForPartitionKey"
+	attrWaitsForSort        = "waitsForSortKey"
+	attrExpiresAt           = "expiresAt"
+	attrSchemaVersion       = "schemaVersion"
+	attrWaiterCount         = "waiterCount"
+	attrIntentOwner         = "intentOwner"
+	attrPreemptionNotice    = "preemptionNoticeMillis"
+	attrReleaseRequestedBy  = "releaseRequestedBy"
+	attrRequestedRelease    = "requestedRelease"
+	attrWaiterInbox         = "waiterInbox"
+	attrTimesAcquired       = "timesAcquired"
+	attrLastAcquiredAt      = "lastAcquiredAt"
+	attrLastOwner           = "lastOwner"
+	attrLastReleasedAt      = "lastReleasedAt"
+	attrDataHistory         = "dataHistory"
+	attrAcquisitionToken    = "acquisitionToken"
+	attrHandoffTo           = "handoffTo"
 
 	defaultBuffer = 1 * time.Second
 )
@@ -49,51 +72,241 @@ var (
 	leaseDurationAttr = expression.Name(attrLeaseDuration)
 	rvnAttr           = expression.Name(attrRecordVersionNumber)
 	isReleasedAttr    = expression.Name(attrIsReleased)
+	priorityAttr      = expression.Name(attrPriority)
+	expiresAtAttr     = expression.Name(attrExpiresAt)
 )
 
 var isReleasedAttrVal = expression.Value("1")
 
+// expiresAt computes the value of the expiresAt attribute of a lock row whose
+// lease starts now: the moment in which the lease expires, in milliseconds
+// since the Unix epoch. It allows finding expired locks with a filter
+// expression, without parsing the lease duration.
+func (c *commonClient) expiresAt(leaseDuration time.Duration) int64 {
+	return c.now().Add(leaseDuration).UnixNano() / int64(time.Millisecond)
+}
+
+// internalAttributes are maintained by the client for coordination purposes.
+// They are neither exposed as additional attributes nor carried over when the
+// lock changes hands.
+var internalAttributes = []string{
+	attrPreemptionOwner,
+	attrPreemptionPriority,
+	attrPreemptionNotice,
+	attrReleaseRequestedBy,
+	attrRequestedRelease,
+	attrWaitsForPartition,
+	attrWaitsForSort,
+	attrExpiresAt,
+	attrSchemaVersion,
+	attrWaiterCount,
+	attrIntentOwner,
+	attrWaiterInbox,
+	attrTimesAcquired,
+	attrLastAcquiredAt,
+	attrLastOwner,
+	attrLastReleasedAt,
+	attrDataHistory,
+	attrAcquisitionToken,
+	attrHandoffTo,
+}
+
 type commonClient struct {
 	dynamoDB DynamoDBClient
 
 	tableName        string
 	partitionKeyName string
+	sortKeyName      string
+	partitionKeyType types.ScalarAttributeType
+	sortKeyType      types.ScalarAttributeType
 
+	timingsMu                   sync.RWMutex
 	leaseDuration               time.Duration
 	heartbeatPeriod             time.Duration
+	heartbeatReconfigured       chan struct{}
+	defaultWaitBuffer           time.Duration
+	defaultRefreshPeriod        time.Duration
+	daemonless                  bool
+	pendingCallbacksMu          sync.Mutex
+	pendingCallbacks            []func()
+	heartbeatData               func(*Lock) []byte
+	idleThreshold               time.Duration
+	idleHook                    func(*Lock, time.Duration)
+	rvnGenerator                func() string
+	dataHistory                 int
+	auditLog                    *auditLog
+	tracer                      Tracer
+	randSource                  io.Reader
+	clock                       Clock
+	metricsHook                 func(Metric)
+	longHoldThreshold           time.Duration
+	longHoldHook                func(*Lock, time.Duration)
+	stats                       lockStats
+	backgroundContext           func(context.Context) context.Context
+	acquisitionSlots            chan struct{}
+	health                      healthState
+	closeTimeout                time.Duration
+	closeParallelism            int
+	reacquirePolicy             ReacquirePolicy
+	leaseDurationFormat         LeaseDurationFormat
+	releasedAttribute           string
+	releasedValue               interface{}
+	releasedAttrValue           types.AttributeValue
+	ownerNameSet                bool
+	heartbeatScheduler          *TableManager
+	persistentStats             bool
+	consumedCapacity            bool
+	createTableIfNotExists      bool
+	createTableOptions          []CreateTableOption
+	capacityBudget              float64
+	onCapacityBudgetExceeded    func(CapacityBudgetExceeded)
+	capacity                    capacityStats
+	requireOwnerName            bool
+	ownerRegistry               func(string) error
+	ownerDeregistry             func(string)
+	heartbeatFilter             func(*Lock) bool
+	serializer                  Serializer
+	coalesceInterval            time.Duration
+	coalescer                   keyCoalescer
+	middlewares                 []func(Operation) Operation
+	preWriteHook                func(context.Context, map[string]types.AttributeValue)
+	orphanInterval              time.Duration
+	orphanThreshold             time.Duration
+	orphanHandler               func(context.Context, *Lock, time.Duration)
+	cleanupStaleRows            bool
+	staleRowAge                 time.Duration
+	staleRowsMu                 sync.Mutex
+	staleRows                   map[lockKey]staleRowObservation
+	ownerIndexName              string
+	expiryGrace                 time.Duration
+	releaseRetries              int
+	releaseRetryDelay           time.Duration
+	v2Compatibility             bool
 	ownerName                   string
 	locks                       sync.Map
 	sessionMonitorCancellations sync.Map
 
 	logger ContextLeveledLogger
 
-	stopHeartbeat context.CancelFunc
-
-	mu        sync.RWMutex
-	closeOnce sync.Once
-	closed    bool
+	// runMu guards the state that Restart recreates: the owner name, the
+	// cancellations of the background goroutines, the heartbeat errors
+	// channel and closeOnce.
+	runMu              sync.RWMutex
+	stopHeartbeat      context.CancelFunc
+	stopOrphanDetector context.CancelFunc
+	background         sync.WaitGroup
+	heartbeatErrors    chan HeartbeatError
+	closeOnce          *sync.Once
+
+	mu     sync.RWMutex
+	closed bool
+
+	// drainMu guards draining and the registration of the critical
+	// sections in inFlight. It is separate from mu, which acquisitions hold
+	// while they wait, so Drain never has to wait for them to flag the
+	// client.
+	drainMu  sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
 }
 
 const (
-	defaultLeaseDuration   = 20 * time.Second
-	defaultHeartbeatPeriod = 5 * time.Second
+	defaultLeaseDuration     = 20 * time.Second
+	defaultHeartbeatPeriod   = 5 * time.Second
+	defaultReleaseRetries    = 2
+	defaultReleaseRetryDelay = 100 * time.Millisecond
 )
 
-func newCommon(dynamoDB DynamoDBClient, tableName, partitionKeyName string, opts ...ClientOption) (*commonClient, error) {
+func newCommon(dynamoDB DynamoDBClient, tableName, partitionKeyName, sortKeyName string, opts ...ClientOption) (*commonClient, error) {
 	c := &commonClient{
-		dynamoDB:         dynamoDB,
-		tableName:        tableName,
-		partitionKeyName: partitionKeyName,
-		leaseDuration:    defaultLeaseDuration,
-		heartbeatPeriod:  defaultHeartbeatPeriod,
-		ownerName:        randString(32),
-		logger:           &plainLogger{logger: log.New(ioutil.Discard, "", 0)},
-		stopHeartbeat:    func() {},
+		dynamoDB:              dynamoDB,
+		tableName:             tableName,
+		partitionKeyName:      partitionKeyName,
+		sortKeyName:           sortKeyName,
+		partitionKeyType:      types.ScalarAttributeTypeS,
+		sortKeyType:           types.ScalarAttributeTypeS,
+		leaseDuration:         defaultLeaseDuration,
+		heartbeatPeriod:       defaultHeartbeatPeriod,
+		heartbeatReconfigured: make(chan struct{}, 1),
+		defaultWaitBuffer:     defaultBuffer,
+		defaultRefreshPeriod:  defaultBuffer,
+		releaseRetries:        defaultReleaseRetries,
+		releaseRetryDelay:     defaultReleaseRetryDelay,
+		ownerName:             randString(32),
+		logger:                &plainLogger{logger: log.New(ioutil.Discard, "", 0)},
+		stopHeartbeat:         func() {},
+		stopOrphanDetector:    func() {},
+		clock:                 systemClock{},
+		closeTimeout:          defaultCloseTimeout,
+		closeParallelism:      defaultCloseParallelism,
+		releasedAttribute:     attrIsReleased,
+		releasedValue:         "1",
+		serializer:            JSONSerializer{},
+		heartbeatErrors:       make(chan HeartbeatError, heartbeatErrorsBuffer),
+		closeOnce:             &sync.Once{},
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.randSource != nil && !c.ownerNameSet {
+		c.ownerName = c.randString(32)
+	}
+
+	if c.v2Compatibility && sortKeyName != "" {
+		return nil, errors.New("tables shared with cirello.io/dynamolock/v2 cannot have a sort key")
+	}
+
+	if c.v2Compatibility && c.partitionKeyType != types.ScalarAttributeTypeS {
+		return nil, errors.New("tables shared with cirello.io/dynamolock/v2 must have a string partition key")
+	}
+
+	if c.v2Compatibility && c.leaseDurationFormat != LeaseDurationString {
+		return nil, errors.New("tables shared with cirello.io/dynamolock/v2 must store lease durations as strings")
+	}
+
+	if c.v2Compatibility && (c.releasedAttribute != attrIsReleased || c.releasedValue != "1") {
+		return nil, errors.New("tables shared with cirello.io/dynamolock/v2 must use the default released marker")
+	}
+
+	if c.releasedAttribute == "" {
+		return nil, errors.New("released marker attribute cannot be empty")
+	}
+	releasedAttrValue, err := releasedMarkerAttrValue(c.releasedValue)
+	if err != nil {
+		return nil, err
+	}
+	c.releasedAttrValue = releasedAttrValue
+
+	if !isValidKeyType(c.partitionKeyType) || !isValidKeyType(c.sortKeyType) {
+		return nil, errors.New("key types must be one of S, N or B")
+	}
+
+	if c.clock == nil {
+		return nil, errors.New("clock cannot be nil")
+	}
+
+	if c.serializer == nil {
+		return nil, errors.New("serializer cannot be nil")
+	}
+
+	if c.closeParallelism < 1 {
+		return nil, errors.New("close parallelism must be positive")
+	}
+
+	if c.preWriteHook != nil {
+		c.middlewares = append(c.middlewares, c.preWriteMiddleware)
+	}
+	if c.consumedCapacity {
+		c.middlewares = append(c.middlewares, c.consumedCapacityMiddleware)
+	}
+	c.middlewares = append(c.middlewares, callBudgetMiddleware)
+	if c.tracer != nil {
+		c.middlewares = append([]func(Operation) Operation{c.tracingMiddleware}, c.middlewares...)
+	}
+	// Routing comes first, so the other middlewares see the actual table.
+	c.middlewares = append([]func(Operation) Operation{tableRoutingMiddleware}, c.middlewares...)
+	c.dynamoDB = newMiddlewareDynamoDBClient(c.dynamoDB, c.middlewares)
 
 	if c.leaseDuration < 2*c.heartbeatPeriod {
 		return nil, errors.New("heartbeat period must be no more than half the length of the Lease Duration, " +
@@ -101,12 +314,42 @@ func newCommon(dynamoDB DynamoDBClient, tableName, partitionKeyName string, opts
 			"4+ times greater)")
 	}
 
-	if c.heartbeatPeriod > 0 {
+	if c.daemonless && c.heartbeatScheduler != nil {
+		return nil, errors.New("daemonless clients cannot be part of a TableManager")
+	}
+
+	if c.defaultWaitBuffer <= 0 || c.defaultRefreshPeriod <= 0 {
+		return nil, errors.New("default wait buffer and refresh period must be positive")
+	}
+
+	if err := c.validateOwnerName(c.ownerName); err != nil {
+		return nil, err
+	}
+
+	c.startBackground()
+	return c, nil
+}
+
+// startBackground starts the goroutines of the client that are stopped by
+// Close: the heartbeats and the orphan detector. It must be called with runMu
+// held, or before the client is returned to the caller.
+func (c *commonClient) startBackground() {
+	if c.daemonless {
+		return
+	}
+	if c.currentHeartbeatPeriod() > 0 && c.heartbeatScheduler == nil {
 		ctx, cancel := context.WithCancel(context.Background())
 		c.stopHeartbeat = cancel
+		c.background.Add(1)
 		go c.heartbeat(ctx)
 	}
-	return c, nil
+
+	if c.orphanInterval > 0 && c.orphanHandler != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stopOrphanDetector = cancel
+		c.background.Add(1)
+		go c.detectOrphans(ctx)
+	}
 }
 
 // ClientOption reconfigure the lock client creation.
@@ -115,7 +358,24 @@ type ClientOption func(*commonClient)
 // WithOwnerName changes the owner linked to the client, and by consequence to
 // locks.
 func WithOwnerName(s string) ClientOption {
-	return func(c *commonClient) { c.ownerName = s }
+	return func(c *commonClient) {
+		c.ownerName = s
+		c.ownerNameSet = true
+	}
+}
+
+// WithMaxConcurrentAcquisitions bounds the number of AcquireLock calls that
+// can be in flight at once in this client, each polling DynamoDB while the
+// lock is contended. The calls above the limit wait for a free slot, or until
+// their context is done. Zero or negative values mean no limit.
+func WithMaxConcurrentAcquisitions(n int) ClientOption {
+	return func(c *commonClient) {
+		if n <= 0 {
+			c.acquisitionSlots = nil
+			return
+		}
+		c.acquisitionSlots = make(chan struct{}, n)
+	}
 }
 
 // WithLeaseDuration defines how long should the lease be held.
@@ -130,12 +390,73 @@ func WithHeartbeatPeriod(d time.Duration) ClientOption {
 	return func(c *commonClient) { c.heartbeatPeriod = d }
 }
 
+// WithDefaultWaitBuffer defines how long acquisitions wait, in addition to the
+// lease duration of the holder, before giving up, unless they set
+// WithAdditionalTimeToWaitForLock. The default is 1 second.
+func WithDefaultWaitBuffer(d time.Duration) ClientOption {
+	return func(c *commonClient) { c.defaultWaitBuffer = d }
+}
+
+// WithDefaultRefreshPeriod defines how often acquisitions poll a held lock,
+// unless they set WithRefreshPeriod. It is also the polling interval of
+// Subscribe when heartbeats are disabled. The default is 1 second.
+func WithDefaultRefreshPeriod(d time.Duration) ClientOption {
+	return func(c *commonClient) { c.defaultRefreshPeriod = d }
+}
+
 // DisableHeartbeat disables automatic hearbeats. Use SendHeartbeat to freshen
 // up the lock.
 func DisableHeartbeat() ClientOption {
 	return WithHeartbeatPeriod(0)
 }
 
+// WithExpiryGrace defines an extra period, on top of the lease duration, that
+// must elapse before a lock held by someone else is considered expired and can
+// be taken over. It protects against moderately skewed clocks causing
+// premature takeovers.
+func WithExpiryGrace(d time.Duration) ClientOption {
+	return func(c *commonClient) { c.expiryGrace = d }
+}
+
+// WithReleaseRetries defines how many times a release is retried, waiting
+// delay between attempts, when DynamoDB fails with a transient error, like a
+// throttled request. If the release ultimately fails with a transient error,
+// the lock is kept and heartbeated as if the release had not been attempted;
+// on other errors, it is left to expire. If the context is done before the
+// release succeeds, a last attempt is made on a context of its own, bounded
+// to 10 seconds. By default, releases are retried twice, 100ms apart.
+func WithReleaseRetries(retries int, delay time.Duration) ClientOption {
+	return func(c *commonClient) {
+		c.releaseRetries = retries
+		c.releaseRetryDelay = delay
+	}
+}
+
+// WithV2Compatibility makes the client store locks exactly as
+// cirello.io/dynamolock/v2 does, so both can share the same table while a
+// fleet is migrated. In this mode, the attributes used by the features that
+// are not present in v2 (priorities, preemption requests and waits-for edges)
+// are neither written nor interpreted, and are handled as regular additional
+// attributes instead. Sort keys are not supported in this mode.
+func WithV2Compatibility() ClientOption {
+	return func(c *commonClient) { c.v2Compatibility = true }
+}
+
+// WithPartitionKeyType defines the type of the partition key of the table, so
+// the client can be used with existing tables whose keys are numbers (N) or
+// binary (B). Keys are still given as strings: numeric keys must be given in
+// their decimal representation (like strconv.Itoa would produce), and binary
+// keys are the raw bytes converted to string. Defaults to S.
+func WithPartitionKeyType(t types.ScalarAttributeType) ClientOption {
+	return func(c *commonClient) { c.partitionKeyType = t }
+}
+
+// WithSortKeyType defines the type of the sort key of the table. See
+// WithPartitionKeyType for how non-string keys are represented. Defaults to S.
+func WithSortKeyType(t types.ScalarAttributeType) ClientOption {
+	return func(c *commonClient) { c.sortKeyType = t }
+}
+
 // WithLogger injects a logger into the client, so its internals can be
 // recorded.
 func WithLogger(l Logger) ClientOption {
@@ -165,6 +486,18 @@ func WithData(b []byte) AcquireLockOption {
 	}
 }
 
+// WithEventuallyConsistentRead reads the lock row with an eventually
+// consistent read before trying to acquire it, which costs half as much as the
+// default strongly consistent read. The conditional write still guarantees
+// that the lock is not granted to two owners; when it fails because the read
+// was stale, the acquisition is retried once with a strongly consistent read.
+// It has no effect when waits are coalesced.
+func WithEventuallyConsistentRead() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.eventuallyConsistentRead = true
+	}
+}
+
 // ReplaceData will force the new content to be stored in the key.
 func ReplaceData() AcquireLockOption {
 	return func(opt *acquireLockOptions) {
@@ -199,13 +532,36 @@ func WithRefreshPeriod(d time.Duration) AcquireLockOption {
 
 // WithAdditionalTimeToWaitForLock defines how long to wait in addition to the
 // lease duration (if set to 10 minutes, this will try to acquire a lock for at
-// least 10 minutes before giving up and returning an error).
+// least 10 minutes before giving up and returning an error). Transient
+// DynamoDB failures, like throttling or network errors, are retried with
+// backoff within the same time budget.
 func WithAdditionalTimeToWaitForLock(d time.Duration) AcquireLockOption {
 	return func(opt *acquireLockOptions) {
 		opt.additionalTimeToWaitForLock = d
 	}
 }
 
+// WithMaxAttempts limits how many times the lock row is read and tried before
+// AcquireLock gives up, independently of how long it has waited. Once the
+// attempts are exhausted, AcquireLock returns a LockNotGrantedError caused by
+// a MaxAttemptsError. Zero or negative values mean no limit.
+func WithMaxAttempts(n int) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.maxAttempts = n
+	}
+}
+
+// WithTakeoverOnlyIfExpiredBy makes the acquisition take over a lock held by
+// someone else only once it has been expired for at least d, giving slow but
+// alive holders, for example stalled by long GC pauses or brief network
+// partitions, a second chance to heartbeat it. It extends the grace defined
+// with WithExpiryGrace when d is longer.
+func WithTakeoverOnlyIfExpiredBy(d time.Duration) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.takeoverGrace = d
+	}
+}
+
 // WithAdditionalAttributes stores some additional attributes with each lock.
 // This can be used to add any arbitrary parameters to each lock row.
 func WithAdditionalAttributes(attr map[string]types.AttributeValue) AcquireLockOption {
@@ -214,6 +570,16 @@ func WithAdditionalAttributes(attr map[string]types.AttributeValue) AcquireLockO
 	}
 }
 
+// WithImmediateHeartbeat sends a heartbeat right after the lock is acquired,
+// so the record version number observed by other waiters changes right away.
+// It shrinks the window in which two waiters race on the same stale record
+// version number.
+func WithImmediateHeartbeat() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.immediateHeartbeat = true
+	}
+}
+
 // WithSessionMonitor registers a callback that is triggered if the lock is
 // about to expire.
 //
@@ -248,16 +614,43 @@ func WithSessionMonitor(safeTime time.Duration, callback func()) AcquireLockOpti
 	}
 }
 
-func (c *commonClient) acquireLock(ctx context.Context, partitionKey string, opts ...AcquireLockOption) (*Lock, error) {
+func (c *commonClient) acquireLock(ctx context.Context, partitionKey, sortKey string, opts ...AcquireLockOption) (acquired *Lock, err error) {
 	if c.isClosed() {
 		return nil, ErrClientClosed
 	}
+	ctx, endSpan := c.startSpan(ctx, SpanAcquireLock, c.lockKeyOf(ctx, partitionKey, sortKey))
+	defer func() { endSpan(err) }()
+	if c.auditLog != nil {
+		start := c.now()
+		defer func() {
+			c.audit(ctx, AuditAcquire, c.lockKeyOf(ctx, partitionKey, sortKey), acquired, start, err)
+		}()
+	}
 	opt := &acquireLockOptions{
 		partitionKey: partitionKey,
+		sortKey:      sortKey,
 	}
 	for _, o := range opts {
 		o(opt)
 	}
+	if opt.dataValue != nil {
+		data, err := c.marshalData(opt.dataValue)
+		if err != nil {
+			return nil, err
+		}
+		opt.data = data
+	}
+
+	// Wait for an acquisition slot before holding the read lock, so
+	// queued acquisitions do not delay Close.
+	if c.acquisitionSlots != nil {
+		select {
+		case c.acquisitionSlots <- struct{}{}:
+			defer func() { <-c.acquisitionSlots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
 
 	// Hold the read lock when acquiring locks. This prevents us from
 	// acquiring a lock while the Client is being closed as we hold the
@@ -267,6 +660,12 @@ func (c *commonClient) acquireLock(ctx context.Context, partitionKey string, opt
 	if c.closed {
 		return nil, ErrClientClosed
 	}
+	if c.isDraining() {
+		return nil, ErrClientDraining
+	}
+	if l, err := c.heldLocally(c.lockKeyOf(ctx, partitionKey, sortKey)); l != nil || err != nil {
+		return l, err
+	}
 
 	attrs := opt.additionalAttributes
 	contains := func(ks ...string) bool {
@@ -278,56 +677,201 @@ func (c *commonClient) acquireLock(ctx context.Context, partitionKey string, opt
 		return false
 	}
 
-	if contains(c.partitionKeyName, attrOwnerName, attrLeaseDuration,
-		attrRecordVersionNumber, attrData) {
-		return nil, fmt.Errorf("additional attribute cannot be one of the following types: %s, %s, %s, %s, %s",
-			c.partitionKeyName, attrOwnerName, attrLeaseDuration, attrRecordVersionNumber, attrData)
+	reservedAttrs := c.reservedAttributes()
+	if contains(reservedAttrs...) {
+		return nil, fmt.Errorf("additional attribute cannot be one of the following types: %s",
+			strings.Join(reservedAttrs, ", "))
 	}
 
 	getLockOptions := getLockOptions{
+		tableName:            c.routedTable(ctx),
 		partitionKey:         opt.partitionKey,
+		sortKey:              opt.sortKey,
 		deleteLockOnRelease:  opt.deleteLockOnRelease,
 		sessionMonitor:       opt.sessionMonitor,
-		start:                time.Now(),
+		start:                c.now(),
 		replaceData:          opt.replaceData,
 		data:                 opt.data,
 		additionalAttributes: attrs,
 		failIfLocked:         opt.failIfLocked,
+		priority:             opt.priority,
+		requestPreemption:    opt.requestPreemption,
+		preemptionNotice:     opt.preemptionNotice,
+		recordWaitsFor:       opt.recordWaitsFor,
+		maxAttempts:          opt.maxAttempts,
+		countAsWaiter:        opt.countAsWaiter,
+		declareIntent:        opt.declareIntent,
+		joinInbox:            opt.joinInbox,
+		eventuallyConsistent: opt.eventuallyConsistentRead,
+		updateItem:           opt.updateItemAcquisition && !c.v2Compatibility,
+		advisory:             opt.advisory,
+		onAdvisoryConflict:   opt.onAdvisoryConflict,
+		onStatus:             opt.onStatus,
+		expiryGrace:          c.expiryGrace,
+		acquisitionToken:     opt.acquisitionToken,
+	}
+	if getLockOptions.acquisitionToken == "" && !c.v2Compatibility {
+		getLockOptions.acquisitionToken = c.randString(32)
+	}
+	if opt.takeoverGrace > getLockOptions.expiryGrace {
+		getLockOptions.expiryGrace = opt.takeoverGrace
+	}
+
+	getLockOptions.waitStrategy = opt.waitStrategy
+	if getLockOptions.waitStrategy == nil {
+		s := &defaultWaitStrategy{
+			refreshPeriod: c.defaultRefreshPeriod,
+			timeToWait:    c.defaultWaitBuffer,
+			expiryGrace:   getLockOptions.expiryGrace,
+		}
+		if opt.additionalTimeToWaitForLock > 0 {
+			s.timeToWait = opt.additionalTimeToWaitForLock
+		}
+		// Preempted holders keep the lock for the notice period.
+		s.timeToWait += opt.preemptionNotice
+		if opt.refreshPeriod > 0 {
+			s.refreshPeriod = opt.refreshPeriod
+		}
+		getLockOptions.waitStrategy = s
 	}
 
-	getLockOptions.millisecondsToWait = defaultBuffer
-	if opt.additionalTimeToWaitForLock > 0 {
-		getLockOptions.millisecondsToWait = opt.additionalTimeToWaitForLock
-	}
+	defer c.clearWaitsFor(ctx, &getLockOptions)
+	defer c.uncountWaiter(ctx, &getLockOptions)
+	defer c.withdrawIntent(ctx, &getLockOptions)
+	defer c.leaveInbox(ctx, &getLockOptions)
 
-	getLockOptions.refreshPeriodDuration = defaultBuffer
-	if opt.refreshPeriod > 0 {
-		getLockOptions.refreshPeriodDuration = opt.refreshPeriod
+	key := c.lockKeyOf(ctx, partitionKey, sortKey)
+	if c.coalesceInterval > 0 {
+		defer c.coalescer.join(key)()
 	}
 
+	// The cleanups deferred above are not charged to the call budget.
+	acquireCtx := ctx
+	ctx = withCallBudget(ctx, opt.callBudget)
+
+	var transientFailures int
 	for {
-		l, err := c.storeLock(ctx, &getLockOptions)
+		l, err := c.storeLock(withRetryAttempt(ctx, transientFailures), &getLockOptions)
+		if l == nil && err == nil && getLockOptions.conflicted && getLockOptions.eventuallyConsistent {
+			// The row changed since it was read, which eventually
+			// consistent reads might have missed.
+			c.logger.Info(ctx, "Stale read acquiring ", partitionKey, ", retrying with a consistent read")
+			getLockOptions.eventuallyConsistent = false
+			l, err = c.storeLock(ctx, &getLockOptions)
+			getLockOptions.eventuallyConsistent = true
+		}
+		var errBudget *BudgetExceededError
+		if errors.As(err, &errBudget) {
+			return nil, c.budgetExceeded(&getLockOptions, errBudget)
+		}
 		if err != nil {
-			return nil, err
+			if !c.shouldRetryAcquisition(ctx, &getLockOptions, err) {
+				return nil, err
+			}
+			transientFailures++
+			delay := transientRetryDelay(transientFailures)
+			c.logger.Info(ctx, "Transient failure acquiring ", partitionKey, ", retrying in ", delay, ": ", err)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			continue
 		} else if l != nil {
+			c.coalescer.invalidate(key, false)
+			// The waiter count and the inbox were carried over without
+			// this waiter, unless the row was updated in place.
+			if !getLockOptions.updateItem {
+				getLockOptions.waiterCounted = false
+				getLockOptions.inboxJoined = false
+			}
+			// Acquiring the lock replaced the row, and the intent with it.
+			getLockOptions.intentDeclaredTo = ""
+			l.semaphore.Lock()
+			l.acquiredAt = c.now()
+			l.acquisition = AcquisitionInfo{
+				Attempts:       getLockOptions.attempts,
+				WaitTime:       l.acquiredAt.Sub(getLockOptions.start),
+				Kind:           getLockOptions.acquisitionKind,
+				PreemptedOwner: getLockOptions.preemptedOwner,
+				HandoffFrom:    getLockOptions.handoffFrom,
+			}
+			l.priority = opt.priority
+			l.preemptionCallback = opt.preemptionCallback
+			l.intentCallback = opt.intentCallback
+			l.releaseRequestCallback = opt.releaseRequestCallback
+			l.nonCritical = opt.nonCritical
+			l.ownershipLostCallback = opt.ownershipLostCallback
+			l.leaseExtender = opt.leaseExtender
+			l.maxLeaseDuration = opt.maxLeaseDuration
+			l.done = opt.done
+			l.values = c.propagatedValues(acquireCtx)
+			waited := l.acquisition.WaitTime
+			l.semaphore.Unlock()
+			c.recordMetric(MetricAcquireWait, l.partitionKey, l.sortKey, waited)
+			if getLockOptions.acquisitionKind == AcquisitionExpired {
+				c.recordMetric(MetricSteal, l.partitionKey, l.sortKey, 0)
+			}
+			if opt.immediateHeartbeat {
+				if err := c.sendHeartbeat(ctx, &sendHeartbeatOptions{lockItem: l}); err != nil {
+					var errNotGranted *LockNotGrantedError
+					if errors.As(err, &errNotGranted) {
+						return nil, err
+					}
+					c.logger.Error(ctx, "error sending post-acquire heartbeat to ", l.partitionKey, ":", err)
+				}
+			}
 			return l, nil
 		}
-		c.logger.Info(ctx, "Sleeping for a refresh period of ", getLockOptions.refreshPeriodDuration)
+		transientFailures = 0
+		if observed := getLockOptions.lockTryingToBeAcquired; opt.onWait != nil && observed != nil {
+			opt.onWait(partitionKey, lockInfo(observed), c.now().Sub(getLockOptions.start))
+		}
+		delay := getLockOptions.waitStrategy.NextDelay(getLockOptions.attempts, getLockOptions.lockTryingToBeAcquired)
+		c.logger.Info(ctx, "Sleeping for a refresh period of ", delay)
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		case <-time.After(getLockOptions.refreshPeriodDuration):
+		case <-c.coalescer.wakeup(key):
+		case <-time.After(delay):
 		}
+		if c.isDraining() {
+			return nil, ErrClientDraining
+		}
+	}
+}
+
+// reservedAttributes lists the attributes maintained by the client, which
+// cannot be set as additional attributes.
+func (c *commonClient) reservedAttributes() []string {
+	reservedAttrs := []string{c.partitionKeyName, attrOwnerName, attrLeaseDuration,
+		attrRecordVersionNumber, attrData}
+	if !c.v2Compatibility {
+		reservedAttrs = append(reservedAttrs, attrPriority)
+		reservedAttrs = append(reservedAttrs, internalAttributes...)
+	}
+	if c.sortKeyName != "" {
+		reservedAttrs = append(reservedAttrs, c.sortKeyName)
 	}
+	return reservedAttrs
 }
 
 func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOptions) (*Lock, error) {
+	getLockOptions.attempts++
+	getLockOptions.conflicted = false
+	if getLockOptions.updateItem && !getLockOptions.advisory {
+		return c.storeLockWithUpdate(ctx, getLockOptions)
+	}
 	c.logger.Info(ctx, "Call GetItem to see if the lock for ",
-		c.partitionKeyName, " =", getLockOptions.partitionKey, " exists in the table")
-	existingLock, err := c.getLockFromDynamoDB(ctx, *getLockOptions)
+		c.partitionKeyName, " =", getLockOptions.partitionKey, ", ",
+		c.sortKeyName, " =", getLockOptions.sortKey, " exists in the table")
+	existingLock, err := c.getWaitedLock(ctx, *getLockOptions)
 	if err != nil {
 		return nil, err
 	}
+	if l := c.adoptOwnAcquisition(ctx, getLockOptions, existingLock); l != nil {
+		return l, nil
+	}
 
 	var newLockData []byte
 	if getLockOptions.replaceData {
@@ -354,9 +898,11 @@ func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOpt
 	for k, v := range getLockOptions.additionalAttributes {
 		item[k] = v
 	}
-	item[c.partitionKeyName] = stringAttrValue(getLockOptions.partitionKey)
-	item[attrOwnerName] = stringAttrValue(c.ownerName)
-	item[attrLeaseDuration] = stringAttrValue(c.leaseDuration.String())
+	for k, v := range c.itemKey(getLockOptions.partitionKey, getLockOptions.sortKey) {
+		item[k] = v
+	}
+	item[attrOwnerName] = stringAttrValue(c.currentOwnerName())
+	item[attrLeaseDuration] = c.leaseDurationAttrValue(c.currentLeaseDuration())
 
 	recordVersionNumber := c.generateRecordVersionNumber()
 	item[attrRecordVersionNumber] = stringAttrValue(recordVersionNumber)
@@ -365,12 +911,43 @@ func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOpt
 		item[attrData] = bytesAttrValue(newLockData)
 	}
 
+	if getLockOptions.priority != 0 && !c.v2Compatibility {
+		item[attrPriority] = int64AttrValue(getLockOptions.priority)
+	}
+
+	if !c.v2Compatibility {
+		item[attrExpiresAt] = int64AttrValue(c.expiresAt(c.currentLeaseDuration()))
+		item[attrSchemaVersion] = int64AttrValue(SchemaVersion)
+		item[attrAcquisitionToken] = stringAttrValue(getLockOptions.acquisitionToken)
+		if existingLock != nil {
+			if n := carriedWaiters(existingLock, getLockOptions); n > 0 {
+				item[attrWaiterCount] = int64AttrValue(n)
+			}
+			if inbox := c.carriedInbox(existingLock); inbox != nil {
+				item[attrWaiterInbox] = &types.AttributeValueMemberM{Value: inbox}
+			}
+			if history := c.nextDataHistory(existingLock, newLockData); len(history) > 0 {
+				item[attrDataHistory] = dataHistoryAttrValue(history)
+			}
+		}
+		c.addAcquisitionStats(item, existingLock)
+	}
+
+	if getLockOptions.advisory {
+		return c.storeAdvisoryLock(ctx, getLockOptions, existingLock, newLockData, item, recordVersionNumber)
+	}
+
 	//if the existing lock does not exist or exists and is released
 	if existingLock == nil || existingLock.isReleased {
+		getLockOptions.acquisitionKind = AcquisitionFresh
+		if existingLock != nil {
+			getLockOptions.acquisitionKind = AcquisitionReleased
+		}
 		l, err := c.upsertAndMonitorNewOrReleasedLock(
 			ctx,
 			getLockOptions.additionalAttributes,
 			getLockOptions.partitionKey,
+			getLockOptions.sortKey,
 			getLockOptions.deleteLockOnRelease,
 			newLockData,
 			item,
@@ -379,12 +956,18 @@ func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOpt
 		if err != nil {
 			var errNotGranted *LockNotGrantedError
 			if errors.As(err, &errNotGranted) {
+				c.recordMetric(MetricConditionalFailure, getLockOptions.partitionKey, getLockOptions.sortKey, 0)
+				getLockOptions.conflicted = true
 				return nil, nil
 			}
 		}
 		return l, err
 	}
 
+	if c.isHandedOffTo(existingLock) {
+		return c.acquireHandoff(ctx, getLockOptions, existingLock, newLockData, item, recordVersionNumber)
+	}
+
 	// we know that we didnt enter the if block above because it returns at the end.
 	// we also know that the existingLock.isPresent() is true
 	if getLockOptions.lockTryingToBeAcquired == nil {
@@ -396,22 +979,37 @@ func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOpt
 		 * to wait at least LEASE_DURATION milliseconds before we can try to acquire the lock.
 		 */
 
+		c.reportAcquisitionState(getLockOptions, AcquisitionStateFoundHolder, existingLock)
+		c.tryRequestPreemption(ctx, getLockOptions, existingLock)
+		c.tryRecordWaitsFor(ctx, getLockOptions)
+		c.tryCountWaiter(ctx, getLockOptions)
+		c.tryDeclareIntent(ctx, getLockOptions, existingLock)
+		c.tryJoinInbox(ctx, getLockOptions)
+
 		// If the user has set `FailIfLocked` option, exit after the first attempt to acquire the lock.
 		if getLockOptions.failIfLocked {
-			return nil, &LockNotGrantedError{msg: "Didn't acquire lock because it is locked and request is configured not to retry."}
+			c.cleanupStaleRow(ctx, existingLock)
+			return nil, &LockNotGrantedError{
+				msg:            "Didn't acquire lock because it is locked and request is configured not to retry.",
+				holder:         holderInfo(existingLock),
+				remainingLease: c.remainingLease(existingLock, getLockOptions.expiryGrace),
+			}
 		}
 
 		getLockOptions.lockTryingToBeAcquired = existingLock
-		if !getLockOptions.alreadySleptOnceForOneLeasePeriod {
-			getLockOptions.alreadySleptOnceForOneLeasePeriod = true
-			getLockOptions.millisecondsToWait += existingLock.leaseDuration
-		}
-	} else if getLockOptions.lockTryingToBeAcquired.recordVersionNumber == existingLock.recordVersionNumber && getLockOptions.lockTryingToBeAcquired.isExpired() {
+	} else if getLockOptions.lockTryingToBeAcquired.recordVersionNumber == existingLock.recordVersionNumber && getLockOptions.lockTryingToBeAcquired.isExpiredWithGrace(getLockOptions.expiryGrace) {
 		/* If the version numbers match, then we can acquire the lock, assuming it has already expired */
+		getLockOptions.acquisitionKind = AcquisitionExpired
+		if getLockOptions.preemptionRequestedFrom == existingLock.ownerName {
+			getLockOptions.preemptedOwner = existingLock.ownerName
+		}
+		c.reportAcquisitionState(getLockOptions, AcquisitionStateHolderExpired, existingLock)
+		c.reportAcquisitionState(getLockOptions, AcquisitionStateAttemptingTakeover, existingLock)
 		l, err := c.upsertAndMonitorExpiredLock(
 			ctx,
 			getLockOptions.additionalAttributes,
 			getLockOptions.partitionKey,
+			getLockOptions.sortKey,
 			getLockOptions.deleteLockOnRelease,
 			existingLock, newLockData, item,
 			recordVersionNumber,
@@ -419,6 +1017,8 @@ func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOpt
 		if err != nil {
 			var errNotGranted *LockNotGrantedError
 			if errors.As(err, &errNotGranted) {
+				c.recordMetric(MetricConditionalFailure, getLockOptions.partitionKey, getLockOptions.sortKey, 0)
+				getLockOptions.conflicted = true
 				return nil, nil
 			}
 		}
@@ -429,21 +1029,44 @@ func (c *commonClient) storeLock(ctx context.Context, getLockOptions *getLockOpt
 		 * lockTryingToBeAcquired as the lock has been refreshed since we last checked
 		 */
 		getLockOptions.lockTryingToBeAcquired = existingLock
+		c.reportAcquisitionState(getLockOptions, AcquisitionStateHolderRefreshed, existingLock)
+		c.tryRequestPreemption(ctx, getLockOptions, existingLock)
 	}
 
-	if t := time.Since(getLockOptions.start); t > getLockOptions.millisecondsToWait {
-		return nil, &LockNotGrantedError{
-			msg:   "Didn't acquire lock after sleeping",
-			cause: &TimeoutError{Age: t},
-		}
+	if err := c.checkGiveUp(getLockOptions); err != nil {
+		c.cleanupStaleRow(ctx, existingLock)
+		return nil, err
 	}
 	return nil, nil
 }
 
+// checkGiveUp returns a LockNotGrantedError if the acquisition ran out of
+// attempts or time.
+func (c *commonClient) checkGiveUp(getLockOptions *getLockOptions) error {
+	if getLockOptions.maxAttempts > 0 && getLockOptions.attempts >= getLockOptions.maxAttempts {
+		return &LockNotGrantedError{
+			msg:            "Didn't acquire lock within the maximum number of attempts",
+			cause:          &MaxAttemptsError{Attempts: getLockOptions.attempts},
+			holder:         holderInfo(getLockOptions.lockTryingToBeAcquired),
+			remainingLease: c.remainingLease(getLockOptions.lockTryingToBeAcquired, getLockOptions.expiryGrace),
+		}
+	}
+	if t := c.now().Sub(getLockOptions.start); getLockOptions.waitStrategy.ShouldGiveUp(getLockOptions.attempts, t, getLockOptions.lockTryingToBeAcquired) {
+		return &LockNotGrantedError{
+			msg:            "Didn't acquire lock after sleeping",
+			cause:          &TimeoutError{Age: t},
+			holder:         holderInfo(getLockOptions.lockTryingToBeAcquired),
+			remainingLease: c.remainingLease(getLockOptions.lockTryingToBeAcquired, getLockOptions.expiryGrace),
+		}
+	}
+	return nil
+}
+
 func (c *commonClient) upsertAndMonitorExpiredLock(
 	ctx context.Context,
 	additionalAttributes map[string]types.AttributeValue,
 	partitionKey string,
+	sortKey string,
 	deleteLockOnRelease bool,
 	existingLock *Lock,
 	newLockData []byte,
@@ -451,10 +1074,7 @@ func (c *commonClient) upsertAndMonitorExpiredLock(
 	recordVersionNumber string,
 	sessionMonitor *sessionMonitor,
 ) (*Lock, error) {
-	cond := expression.And(
-		expression.AttributeExists(expression.Name(c.partitionKeyName)),
-		expression.Equal(rvnAttr, expression.Value(existingLock.recordVersionNumber)),
-	)
+	cond := ExpiredLockCondition(c.partitionKeyName, existingLock.recordVersionNumber)
 	putItemExpr, _ := expression.NewBuilder().WithCondition(cond).Build()
 	putItemRequest := &dynamodb.PutItemInput{
 		Item:                      item,
@@ -465,9 +1085,9 @@ func (c *commonClient) upsertAndMonitorExpiredLock(
 	}
 
 	c.logger.Info(ctx, "Acquiring an existing lock whose revisionVersionNumber did not change for ",
-		c.partitionKeyName, " partitionKey=", partitionKey)
+		c.partitionKeyName, " partitionKey=", partitionKey, " sortKey=", sortKey)
 	return c.putLockItemAndStartSessionMonitor(
-		ctx, additionalAttributes, partitionKey, deleteLockOnRelease, newLockData,
+		ctx, additionalAttributes, partitionKey, sortKey, deleteLockOnRelease, newLockData,
 		recordVersionNumber, sessionMonitor, putItemRequest)
 }
 
@@ -475,19 +1095,14 @@ func (c *commonClient) upsertAndMonitorNewOrReleasedLock(
 	ctx context.Context,
 	additionalAttributes map[string]types.AttributeValue,
 	partitionKey string,
+	sortKey string,
 	deleteLockOnRelease bool,
 	newLockData []byte,
 	item map[string]types.AttributeValue,
 	recordVersionNumber string,
 	sessionMonitor *sessionMonitor,
 ) (*Lock, error) {
-	cond := expression.Or(
-		expression.AttributeNotExists(expression.Name(c.partitionKeyName)),
-		expression.And(
-			expression.AttributeExists(expression.Name(c.partitionKeyName)),
-			expression.Equal(isReleasedAttr, isReleasedAttrVal),
-		),
-	)
+	cond := c.newOrReleasedLockCondition()
 	putItemExpr, _ := expression.NewBuilder().WithCondition(cond).Build()
 
 	req := &dynamodb.PutItemInput{
@@ -502,9 +1117,10 @@ func (c *commonClient) upsertAndMonitorNewOrReleasedLock(
 	// lock into DynamoDB should err on the side of thinking the lock will
 	// expire sooner than it actually will, so they start counting towards
 	// its expiration before the Put succeeds
-	c.logger.Info(ctx, "Acquiring a new lock or an existing yet released lock on ", c.partitionKeyName, "=", partitionKey)
+	c.logger.Info(ctx, "Acquiring a new lock or an existing yet released lock on ",
+		c.partitionKeyName, "=", partitionKey, " ", c.sortKeyName, "=", sortKey)
 	return c.putLockItemAndStartSessionMonitor(ctx, additionalAttributes, partitionKey,
-		deleteLockOnRelease, newLockData,
+		sortKey, deleteLockOnRelease, newLockData,
 		recordVersionNumber, sessionMonitor, req)
 }
 
@@ -512,44 +1128,58 @@ func (c *commonClient) putLockItemAndStartSessionMonitor(
 	ctx context.Context,
 	additionalAttributes map[string]types.AttributeValue,
 	partitionKey string,
+	sortKey string,
 	deleteLockOnRelease bool,
 	newLockData []byte,
 	recordVersionNumber string,
 	sessionMonitor *sessionMonitor,
 	putItemRequest *dynamodb.PutItemInput) (*Lock, error) {
 
-	lastUpdatedTime := time.Now()
+	lastUpdatedTime := c.now()
 
 	_, err := c.dynamoDB.PutItem(ctx, putItemRequest)
 	if err != nil {
 		return nil, parseDynamoDBError(err, "cannot store lock item: lock already acquired by other client")
 	}
 
-	releaseLock := func(ctx context.Context, lock *Lock) error {
-		_, err := c.ReleaseLock(ctx, lock)
-		return err
-	}
-
 	lockItem := &Lock{
-		releaseLock:          releaseLock,
+		tableName:            c.routedTable(ctx),
 		partitionKey:         partitionKey,
+		sortKey:              sortKey,
 		data:                 newLockData,
 		deleteLockOnRelease:  deleteLockOnRelease,
-		ownerName:            c.ownerName,
-		leaseDuration:        c.leaseDuration,
 		lookupTime:           lastUpdatedTime,
 		recordVersionNumber:  recordVersionNumber,
 		additionalAttributes: additionalAttributes,
 		sessionMonitor:       sessionMonitor,
+		persistentStats:      readPersistentStats(putItemRequest.Item),
+		dataHistory:          readDataHistory(putItemRequest.Item[attrDataHistory]),
+		acquisitionToken:     readStringAttr(putItemRequest.Item[attrAcquisitionToken]),
 	}
+	c.startOwnedLock(lockItem)
+	return lockItem, nil
+}
+
+// startOwnedLock completes a lock that was just written by this client and
+// starts tracking it.
+func (c *commonClient) startOwnedLock(lockItem *Lock) {
+	lockItem.releaseLock = func(ctx context.Context, lock *Lock) error {
+		_, err := c.ReleaseLock(ctx, lock)
+		return err
+	}
+	lockItem.refreshLock = c.refreshLock
+	lockItem.handoff = c.handoff
+	lockItem.ownerName = c.currentOwnerName()
+	lockItem.leaseDuration = c.currentLeaseDuration()
+	lockItem.clock = c.clock
+	lockItem.serializer = c.serializer
 
 	c.locks.Store(lockItem.uniqueIdentifier(), lockItem)
 	c.tryAddSessionMonitor(lockItem.uniqueIdentifier(), lockItem)
-	return lockItem, nil
 }
 
 func (c *commonClient) getLockFromDynamoDB(ctx context.Context, opt getLockOptions) (*Lock, error) {
-	res, err := c.readFromDynamoDB(ctx, opt.partitionKey)
+	res, err := c.readFromDynamoDB(ctx, opt.partitionKey, opt.sortKey, !opt.eventuallyConsistent)
 	if err != nil {
 		return nil, err
 	}
@@ -562,14 +1192,24 @@ func (c *commonClient) getLockFromDynamoDB(ctx context.Context, opt getLockOptio
 	return c.createLockItem(opt, item)
 }
 
-func (c *commonClient) readFromDynamoDB(ctx context.Context, partitionKey string) (*dynamodb.GetItemOutput, error) {
-	dynamoDBKey := map[string]types.AttributeValue{
-		c.partitionKeyName: stringAttrValue(partitionKey),
+// getWaitedLock is like getLockFromDynamoDB, but the read is shared with the
+// other waiters of the key if waits are coalesced.
+func (c *commonClient) getWaitedLock(ctx context.Context, opt getLockOptions) (*Lock, error) {
+	if c.coalesceInterval <= 0 {
+		return c.getLockFromDynamoDB(ctx, opt)
 	}
+	item, err := c.coalescedRead(ctx, lockKey{tableName: opt.tableName, partitionKey: opt.partitionKey, sortKey: opt.sortKey})
+	if err != nil || item == nil {
+		return nil, err
+	}
+	return c.createLockItem(opt, item)
+}
+
+func (c *commonClient) readFromDynamoDB(ctx context.Context, partitionKey, sortKey string, consistent bool) (*dynamodb.GetItemOutput, error) {
 	return c.dynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
-		ConsistentRead: aws.Bool(true),
+		ConsistentRead: aws.Bool(consistent),
 		TableName:      aws.String(c.tableName),
-		Key:            dynamoDBKey,
+		Key:            c.itemKey(partitionKey, sortKey),
 	})
 }
 
@@ -584,29 +1224,58 @@ func (c *commonClient) createLockItem(opt getLockOptions, item map[string]types.
 	ownerName := readStringAttr(item[attrOwnerName])
 	delete(item, attrOwnerName)
 
-	leaseDuration := readStringAttr(item[attrLeaseDuration])
+	parsedLeaseDuration, err := readLeaseDuration(item[attrLeaseDuration])
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse lease duration: %s", err)
+	}
 	delete(item, attrLeaseDuration)
 
 	recordVersionNumber := readStringAttr(item[attrRecordVersionNumber])
 	delete(item, attrRecordVersionNumber)
-
-	_, isReleased := item[attrIsReleased]
-	delete(item, attrIsReleased)
+	expiresAt := readUnixMillisAttr(item[attrExpiresAt])
+
+	isReleased := c.isReleasedItem(item)
+	releasedAttribute, _ := c.releasedMarker()
+	delete(item, releasedAttribute)
+
+	var (
+		priority          int64
+		preemptionRequest *PreemptionRequest
+		waiters           int64
+		intentOwner       string
+		releaseRequest    *ReleaseRequest
+		inbox             map[string]types.AttributeValue
+		persistentStats   PersistentStats
+		dataHistory       [][]byte
+		acquisitionToken  string
+		handoffTo         string
+	)
+	if !c.v2Compatibility {
+		priority = readInt64Attr(item[attrPriority])
+		delete(item, attrPriority)
+		waiters = readInt64Attr(item[attrWaiterCount])
+		intentOwner = readStringAttr(item[attrIntentOwner])
+		releaseRequest = readReleaseRequest(item)
+		inbox = readInboxAttr(item[attrWaiterInbox])
+		persistentStats = readPersistentStats(item)
+		dataHistory = readDataHistory(item[attrDataHistory])
+		acquisitionToken = readStringAttr(item[attrAcquisitionToken])
+		handoffTo = readStringAttr(item[attrHandoffTo])
+
+		preemptionRequest = readPreemptionRequest(item)
+		for _, attr := range internalAttributes {
+			delete(item, attr)
+		}
+	}
 	delete(item, c.partitionKeyName)
+	if c.sortKeyName != "" {
+		delete(item, c.sortKeyName)
+	}
 
 	// The person retrieving the lock in DynamoDB should err on the side of
 	// not expiring the lock, so they don't start counting until after the
 	// call to DynamoDB succeeds
-	lookupTime := time.Now()
-
-	var parsedLeaseDuration time.Duration
-	if leaseDuration != "" {
-		var err error
-		parsedLeaseDuration, err = time.ParseDuration(leaseDuration)
-		if err != nil {
-			return nil, fmt.Errorf("cannot parse lease duration: %s", err)
-		}
-	}
+	lookupTime := c.now()
 
 	releaseLock := func(ctx context.Context, lock *Lock) error {
 		_, err := c.ReleaseLock(ctx, lock)
@@ -615,55 +1284,174 @@ func (c *commonClient) createLockItem(opt getLockOptions, item map[string]types.
 
 	lockItem := &Lock{
 		releaseLock:          releaseLock,
+		refreshLock:          c.refreshLock,
+		handoff:              c.handoff,
+		tableName:            opt.tableName,
 		partitionKey:         opt.partitionKey,
+		sortKey:              opt.sortKey,
 		data:                 data,
 		deleteLockOnRelease:  opt.deleteLockOnRelease,
 		ownerName:            ownerName,
 		leaseDuration:        parsedLeaseDuration,
 		lookupTime:           lookupTime,
 		recordVersionNumber:  recordVersionNumber,
+		expiresAt:            expiresAt,
 		isReleased:           isReleased,
+		clock:                c.clock,
+		serializer:           c.serializer,
 		additionalAttributes: item,
+		priority:             priority,
+		preemptionRequest:    preemptionRequest,
+		waiters:              waiters,
+		intentOwner:          intentOwner,
+		releaseRequest:       releaseRequest,
+		inbox:                inbox,
+		persistentStats:      persistentStats,
+		dataHistory:          dataHistory,
+		acquisitionToken:     acquisitionToken,
+		handoffTo:            handoffTo,
 	}
 	return lockItem, nil
 }
 
+func (c *commonClient) refreshLock(ctx context.Context, lockItem *Lock) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+
+	ctx = routeToLock(ctx, lockItem)
+	opt := getLockOptions{
+		tableName:           lockItem.tableName,
+		partitionKey:        lockItem.partitionKey,
+		sortKey:             lockItem.sortKey,
+		deleteLockOnRelease: lockItem.deleteLockOnRelease,
+	}
+	currentLock, err := c.getLockFromDynamoDB(ctx, opt)
+	if err != nil {
+		return err
+	}
+
+	lockItem.semaphore.Lock()
+	defer lockItem.semaphore.Unlock()
+
+	switch {
+	case currentLock == nil || currentLock.isReleased:
+		c.forgetLostLock(lockItem)
+		return ErrLockAlreadyReleased
+	case currentLock.ownerName != lockItem.ownerName:
+		c.forgetLostLock(lockItem)
+		return ErrOwnerMismatched
+	}
+
+	// The lookup time is only moved forward when the record version number
+	// changed, otherwise the lease is still counting since the last time
+	// this lock was observed.
+	if currentLock.recordVersionNumber != lockItem.recordVersionNumber {
+		lockItem.updateRVN(currentLock.recordVersionNumber, currentLock.lookupTime, currentLock.leaseDuration)
+	} else {
+		lockItem.leaseDuration = currentLock.leaseDuration
+	}
+	lockItem.data = currentLock.data
+	lockItem.additionalAttributes = currentLock.additionalAttributes
+	lockItem.waiters = currentLock.waiters
+	lockItem.inbox = currentLock.inbox
+	// Locks dropped by a heartbeat of their owner, for example while a
+	// HeartbeatDelegate sent heartbeats to a token from MarshalToken, are
+	// tracked again.
+	if lockItem.ownerName == c.currentOwnerName() {
+		if _, ok := c.locks.Load(lockItem.uniqueIdentifier()); !ok {
+			c.locks.Store(lockItem.uniqueIdentifier(), lockItem)
+		}
+	}
+	return nil
+}
+
+// forgetLostLock marks the given lock as released and stops tracking it, if
+// it is the one currently held by this client. Callers must hold the lock's
+// semaphore.
+func (c *commonClient) forgetLostLock(lockItem *Lock) {
+	lockItem.isReleased = true
+	if v, ok := c.locks.Load(lockItem.uniqueIdentifier()); ok && v == lockItem {
+		c.locks.Delete(lockItem.uniqueIdentifier())
+		c.removeKillSessionMonitor(lockItem.uniqueIdentifier())
+	}
+}
+
 func (c *commonClient) generateRecordVersionNumber() string {
-	// TODO: improve me
-	return randString(32)
+	if c.rvnGenerator != nil {
+		return c.rvnGenerator()
+	}
+	return c.randString(32)
 }
 
 var letterRunes = []rune("1234567890abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func randString(n int) string {
+	return randStringFrom(rand.Reader, n)
+}
+
+// randStringFrom reads a random string from the given source, falling back to
+// crypto/rand if the source fails.
+func randStringFrom(source io.Reader, n int) string {
 	b := make([]rune, n)
 	for i := range b {
-		// ignoring error as the only possible error is for io.ReadFull
-		r, _ := rand.Int(rand.Reader, big.NewInt(int64(len(letterRunes))))
+		r, err := rand.Int(source, big.NewInt(int64(len(letterRunes))))
+		if err != nil {
+			// ignoring error as the only possible error is for io.ReadFull
+			r, _ = rand.Int(rand.Reader, big.NewInt(int64(len(letterRunes))))
+		}
 		b[i] = letterRunes[r.Int64()]
 	}
 	return string(b)
 }
 
 func (c *commonClient) heartbeat(ctx context.Context) {
+	defer c.background.Done()
 	c.logger.Info(ctx, "starting heartbeats")
-	tick := time.NewTicker(c.heartbeatPeriod)
+	tick := time.NewTicker(c.currentHeartbeatPeriod())
 	defer tick.Stop()
-	for range tick.C {
-		c.locks.Range(func(_ interface{}, value interface{}) bool {
-			lockItem := value.(*Lock)
-			if err := c.SendHeartbeat(ctx, lockItem); err != nil {
-				c.logger.Error(ctx, "error sending heartbeat to", lockItem.partitionKey, ":", err)
-			}
-			return true
-		})
-		if ctx.Err() != nil {
-			c.logger.Error(ctx, "client closed, stopping heartbeat")
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info(ctx, "client closed, stopping heartbeat")
 			return
+		case <-c.heartbeatReconfigured:
+			tick.Reset(c.currentHeartbeatPeriod())
+			continue
+		case <-tick.C:
 		}
+		c.heartbeatLocks(ctx)
 	}
 }
 
+// heartbeatLocks runs one cycle of the automatic heartbeats.
+func (c *commonClient) heartbeatLocks(ctx context.Context) {
+	c.locks.Range(func(_ interface{}, value interface{}) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		c.heartbeatLock(ctx, value.(*Lock))
+		return true
+	})
+}
+
+// heartbeatLock sends the automatic heartbeat of a lock, unless it is filtered
+// out or throttled, and runs its periodic checks. It reports whether the
+// heartbeat was sent, and its error.
+func (c *commonClient) heartbeatLock(ctx context.Context, lockItem *Lock) (sent bool, err error) {
+	lockCtx := lockContext(ctx, lockItem)
+	if (c.heartbeatFilter == nil || c.heartbeatFilter(lockItem)) && !lockItem.heartbeatsDelegated() && !c.throttledHeartbeat(lockItem) {
+		sent = true
+		if err = c.sendHeartbeat(lockCtx, c.heartbeatOptions(lockItem)); err != nil && ctx.Err() == nil {
+			c.logger.Error(lockCtx, "error sending heartbeat to", lockItem.partitionKey, ":", err)
+			c.reportHeartbeatError(lockItem, err)
+		}
+	}
+	c.checkIdleLock(lockCtx, lockItem)
+	c.checkLongHold(lockCtx, lockItem)
+	return sent, err
+}
+
 type createTableSchema func() ([]types.KeySchemaElement, []types.AttributeDefinition)
 
 // CreateTable prepares a DynamoDB table with the right schema for it
@@ -707,7 +1495,96 @@ func WithProvisionedThroughput(provisionedThroughput *types.ProvisionedThroughpu
 	}
 }
 
+// WithLocalSecondaryIndex adds a local secondary index to the table, keyed by
+// the partition key and the given attribute, and projecting all attributes.
+// It allows querying the locks of a partition by attributes other than the
+// sort key, for example a status or priority stored with
+// WithAdditionalAttributes. Local secondary indexes can only be added to tables
+// with a sort key.
+func WithLocalSecondaryIndex(indexName, attributeName string, attributeType types.ScalarAttributeType) CreateTableOption {
+	return func(opt *createDynamoDBTableOptions) {
+		opt.localIndexes = append(opt.localIndexes, localIndex{
+			name:          indexName,
+			attributeName: attributeName,
+			attributeType: attributeType,
+		})
+	}
+}
+
+// WithWaitForActive makes CreateTable wait, for up to timeout, until the
+// table is ACTIVE and ready to be used. The returned table description is the
+// one of the active table.
+func WithWaitForActive(timeout time.Duration) CreateTableOption {
+	return func(opt *createDynamoDBTableOptions) {
+		opt.waitForActive = timeout
+	}
+}
+
+const tableActivePollInterval = time.Second
+
+// WithCreateTableIfNotExists makes the client create its lock table on
+// construction if it does not exist yet, and wait until it is active, which
+// is convenient in development and test environments. The table is created
+// as with CreateTable and the given options. Unless WithWaitForActive says
+// otherwise, it waits for up to five minutes. Production tables are better
+// provisioned in advance, leaving this option off.
+func WithCreateTableIfNotExists(opts ...CreateTableOption) ClientOption {
+	return func(c *commonClient) {
+		c.createTableIfNotExists = true
+		c.createTableOptions = opts
+	}
+}
+
+const defaultCreateTableTimeout = 5 * time.Minute
+
+// ensureTable creates the lock table if WithCreateTableIfNotExists is set and
+// the table does not exist, and waits until it is active. The client is closed
+// if it fails.
+func (c *commonClient) ensureTable(cts createTableSchema) error {
+	if !c.createTableIfNotExists {
+		return nil
+	}
+	ctx := context.Background()
+	opt := &createDynamoDBTableOptions{
+		billingMode:   "PAY_PER_REQUEST",
+		waitForActive: defaultCreateTableTimeout,
+	}
+	for _, o := range c.createTableOptions {
+		o(opt)
+	}
+	if err := c.createTableIfMissing(ctx, cts, opt); err != nil {
+		_ = c.Close(ctx)
+		return fmt.Errorf("cannot create lock table %s: %w", c.tableName, err)
+	}
+	return nil
+}
+
+func (c *commonClient) createTableIfMissing(ctx context.Context, cts createTableSchema, opt *createDynamoDBTableOptions) error {
+	_, err := c.describeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(c.tableName),
+	})
+	var errNotFound *types.ResourceNotFoundException
+	if errors.As(err, &errNotFound) {
+		_, err = c.createTable(ctx, cts, opt)
+		var errInUse *types.ResourceInUseException
+		if !errors.As(err, &errInUse) {
+			return err
+		}
+		// Another client created the table in the meantime.
+	} else if err != nil {
+		return err
+	}
+	if opt.waitForActive <= 0 {
+		return nil
+	}
+	_, err = c.waitForActiveTable(ctx, opt.waitForActive)
+	return err
+}
+
 func (c *commonClient) createTable(ctx context.Context, cts createTableSchema, opt *createDynamoDBTableOptions) (*dynamodb.CreateTableOutput, error) {
+	if len(opt.localIndexes) > 0 && c.sortKeyName == "" {
+		return nil, errors.New("local secondary indexes require a table with a sort key")
+	}
 	keySchema, attributeDefinitions := cts()
 
 	createTableInput := &dynamodb.CreateTableInput{
@@ -721,21 +1598,95 @@ func (c *commonClient) createTable(ctx context.Context, cts createTableSchema, o
 		createTableInput.ProvisionedThroughput = opt.provisionedThroughput
 	}
 
+	if c.ownerIndexName != "" {
+		createTableInput.GlobalSecondaryIndexes = append(createTableInput.GlobalSecondaryIndexes, c.ownerIndex(opt))
+		createTableInput.AttributeDefinitions = addAttributeDefinition(createTableInput.AttributeDefinitions, attrOwnerName, types.ScalarAttributeTypeS)
+	}
+
+	for _, idx := range opt.localIndexes {
+		createTableInput.LocalSecondaryIndexes = append(createTableInput.LocalSecondaryIndexes, types.LocalSecondaryIndex{
+			IndexName: aws.String(idx.name),
+			KeySchema: []types.KeySchemaElement{
+				{
+					AttributeName: aws.String(c.partitionKeyName),
+					KeyType:       types.KeyTypeHash,
+				},
+				{
+					AttributeName: aws.String(idx.attributeName),
+					KeyType:       types.KeyTypeRange,
+				},
+			},
+			Projection: &types.Projection{
+				ProjectionType: types.ProjectionTypeAll,
+			},
+		})
+		createTableInput.AttributeDefinitions = addAttributeDefinition(createTableInput.AttributeDefinitions, idx.attributeName, idx.attributeType)
+	}
+
 	if opt.tags != nil {
 		createTableInput.Tags = opt.tags
 	}
 
-	return c.dynamoDB.CreateTable(ctx, createTableInput)
+	out, err := c.dynamoDB.CreateTable(ctx, createTableInput)
+	if err != nil || opt.waitForActive <= 0 {
+		return out, err
+	}
+	table, err := c.waitForActiveTable(ctx, opt.waitForActive)
+	if err != nil {
+		return out, err
+	}
+	out.TableDescription = table
+	return out, nil
+}
+
+func (c *commonClient) waitForActiveTable(ctx context.Context, timeout time.Duration) (*types.TableDescription, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		res, err := c.describeTable(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(c.tableName),
+		})
+		var errNotFound *types.ResourceNotFoundException
+		if err != nil && !errors.As(err, &errNotFound) {
+			return nil, err
+		}
+		if err == nil && res.Table != nil && res.Table.TableStatus == types.TableStatusActive {
+			return res.Table, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("table %s is not active: %w", c.tableName, ctx.Err())
+		case <-time.After(tableActivePollInterval):
+		}
+	}
+}
+
+// addAttributeDefinition appends the definition of an attribute, unless it is
+// already defined.
+func addAttributeDefinition(defs []types.AttributeDefinition, name string, attributeType types.ScalarAttributeType) []types.AttributeDefinition {
+	for _, def := range defs {
+		if aws.ToString(def.AttributeName) == name {
+			return defs
+		}
+	}
+	return append(defs, types.AttributeDefinition{
+		AttributeName: aws.String(name),
+		AttributeType: attributeType,
+	})
 }
 
 // ReleaseLock releases the given lock if the current user still has it,
 // returning true if the lock was successfully released, and false if someone
 // else already stole the lock or a problem happened. Deletes the lock item if
-// it is released and deleteLockItemOnClose is set.
+// it is released and deleteLockItemOnClose is set. Locks acquired more than
+// once under ReacquireRefCount are only released by the last call.
 func (c *commonClient) ReleaseLock(ctx context.Context, lockItem *Lock, opts ...ReleaseLockOption) (bool, error) {
 	if c.isClosed() {
 		return false, ErrClientClosed
 	}
+	if c.releaseReference(lockItem) {
+		return true, nil
+	}
 	err := c.releaseLock(ctx, lockItem, opts...)
 	return err == nil, err
 }
@@ -758,23 +1709,35 @@ func WithDataAfterRelease(data []byte) ReleaseLockOption {
 	}
 }
 
+// WithDataTransformOnRelease derives the data stored after release from the
+// current data of the lock, for example to append a completion record, without
+// a separate read-modify-write cycle. It takes precedence over
+// WithDataAfterRelease, and it is ignored if the lock is deleted on release. If
+// the transform returns empty data, the data is kept as-is.
+func WithDataTransformOnRelease(fn func(old []byte) []byte) ReleaseLockOption {
+	return func(opt *releaseLockOptions) {
+		opt.dataTransform = fn
+	}
+}
+
 // ReleaseLockOption provides options for releasing a lock when calling the
 // releaseLock() method. This class contains the options that may be configured
 // during the act of releasing a lock.
 type ReleaseLockOption func(*releaseLockOptions)
 
-func ownershipLockCondition(partitionKeyName, recordVersionNumber, ownerName string) expression.ConditionBuilder {
-	cond := expression.And(
-		expression.And(
-			expression.AttributeExists(expression.Name(partitionKeyName)),
-			expression.Equal(rvnAttr, expression.Value(recordVersionNumber)),
-		),
-		expression.Equal(ownerNameAttr, expression.Value(ownerName)),
-	)
-	return cond
-}
-
-func (c *commonClient) releaseLock(ctx context.Context, lockItem *Lock, opts ...ReleaseLockOption) error {
+func (c *commonClient) releaseLock(ctx context.Context, lockItem *Lock, opts ...ReleaseLockOption) (err error) {
+	ctx = routeToLock(ctx, lockItem)
+	if lockItem != nil {
+		var endSpan func(error)
+		ctx, endSpan = c.startSpan(ctx, SpanReleaseLock, lockItem.uniqueIdentifier())
+		defer func() { endSpan(err) }()
+	}
+	if c.auditLog != nil && lockItem != nil {
+		start := c.now()
+		defer func() {
+			c.audit(ctx, AuditRelease, lockItem.uniqueIdentifier(), lockItem, start, err)
+		}()
+	}
 	options := &releaseLockOptions{
 		lockItem: lockItem,
 	}
@@ -790,34 +1753,93 @@ func (c *commonClient) releaseLock(ctx context.Context, lockItem *Lock, opts ...
 	}
 	deleteLock := options.deleteLock
 	data := options.data
+	if options.dataValue != nil {
+		var err error
+		if data, err = c.marshalData(options.dataValue); err != nil {
+			return err
+		}
+	}
 
-	if lockItem.ownerName != c.ownerName {
+	if lockItem.ownerName != c.currentOwnerName() {
 		return ErrOwnerMismatched
 	}
 
+	var held time.Duration
+	defer func() {
+		// Recorded once the lock is unlocked, as the metrics hook may
+		// inspect it.
+		if held > 0 {
+			c.recordMetric(MetricHoldDuration, lockItem.partitionKey, lockItem.sortKey, held)
+		}
+	}()
+
 	lockItem.semaphore.Lock()
 	defer lockItem.semaphore.Unlock()
 
+	if options.dataTransform != nil && !deleteLock {
+		data = options.dataTransform(lockItem.data)
+	}
+
 	lockItem.isReleased = true
 	c.locks.Delete(lockItem.uniqueIdentifier())
 
 	key := c.getItemKeys(lockItem)
-	ownershipLockCond := ownershipLockCondition(c.partitionKeyName, lockItem.recordVersionNumber, lockItem.ownerName)
-	if deleteLock {
-		err := c.deleteLock(ctx, ownershipLockCond, key)
-		if err != nil {
-			return err
+	ownershipLockCond := OwnershipCondition(c.partitionKeyName, lockItem.recordVersionNumber, lockItem.ownerName)
+	var history [][]byte
+	if len(data) > 0 {
+		history = c.nextDataHistory(lockItem, data)
+	}
+	release := func(ctx context.Context) error {
+		if deleteLock {
+			return c.deleteLock(ctx, ownershipLockCond, key)
 		}
-	} else {
-		err := c.updateLock(ctx, data, ownershipLockCond, key)
-		if err != nil {
-			return err
+		return c.updateLock(ctx, data, history, ownershipLockCond, key)
+	}
+	for attempt := 0; ; attempt++ {
+		err = release(withRetryAttempt(ctx, attempt))
+		if !isRetryableError(err) || attempt >= c.releaseRetries || ctx.Err() != nil {
+			break
+		}
+		c.logger.Info(ctx, "retrying release of ", lockItem.partitionKey, ": ", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.releaseRetryDelay):
 		}
 	}
+	if err != nil && !isOwnershipLost(err) && ctx.Err() != nil {
+		// The caller gave up, but nobody would be left to release or
+		// heartbeat the lock: try once more on a context of our own.
+		detachedCtx, cancel := detachedContext(ctx, detachedReleaseTimeout)
+		err = release(detachedCtx)
+		cancel()
+	}
+	if isOwnershipLost(err) {
+		return &ownershipLostError{cause: err}
+	} else if isRetryableError(err) {
+		// The lock is still ours in the table: keep heartbeating it
+		// instead of letting it linger until the lease expires.
+		lockItem.isReleased = false
+		c.locks.Store(lockItem.uniqueIdentifier(), lockItem)
+		return err
+	} else if err != nil {
+		// Whether the release was written is unknown, and keeping the
+		// lock would heartbeat it with no handle left to release it:
+		// it expires with its lease.
+		return err
+	}
 	c.removeKillSessionMonitor(lockItem.uniqueIdentifier())
+	c.coalescer.invalidate(lockItem.uniqueIdentifier(), true)
+	if !lockItem.acquiredAt.IsZero() {
+		held = c.now().Sub(lockItem.acquiredAt)
+	}
 	return nil
 }
 
+func isOwnershipLost(err error) bool {
+	var conditionalCheckFailedException *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionalCheckFailedException)
+}
+
 func (c *commonClient) deleteLock(ctx context.Context, ownershipLockCond expression.ConditionBuilder, key map[string]types.AttributeValue) error {
 	delExpr, _ := expression.NewBuilder().WithCondition(ownershipLockCond).Build()
 	deleteItemRequest := &dynamodb.DeleteItemInput{
@@ -834,11 +1856,14 @@ func (c *commonClient) deleteLock(ctx context.Context, ownershipLockCond express
 	return nil
 }
 
-func (c *commonClient) updateLock(ctx context.Context, data []byte, ownershipLockCond expression.ConditionBuilder, key map[string]types.AttributeValue) error {
-	update := expression.Set(isReleasedAttr, isReleasedAttrVal)
+func (c *commonClient) updateLock(ctx context.Context, data []byte, history [][]byte, ownershipLockCond expression.ConditionBuilder, key map[string]types.AttributeValue) error {
+	update := c.addReleaseStats(c.releasedMarkerUpdate())
 	if len(data) > 0 {
 		update = update.Set(dataAttr, expression.Value(data))
 	}
+	if len(history) > 0 {
+		update = update.Set(expression.Name(attrDataHistory), expression.Value(dataHistoryAttrValue(history)))
+	}
 	updateExpr, _ := expression.NewBuilder().WithUpdate(update).WithCondition(ownershipLockCond).Build()
 
 	updateItemRequest := &dynamodb.UpdateItemInput{
@@ -854,23 +1879,95 @@ func (c *commonClient) updateLock(ctx context.Context, data []byte, ownershipLoc
 	return err
 }
 
+// releaseAllLocks releases the locks concurrently, up to the close
+// parallelism, and tries all of them even if some fail.
 func (c *commonClient) releaseAllLocks(ctx context.Context) error {
-	var err error
+	var locks []*Lock
 	c.locks.Range(func(key interface{}, value interface{}) bool {
-		err = c.releaseLock(ctx, value.(*Lock))
-		return err == nil
+		locks = append(locks, value.(*Lock))
+		return true
 	})
-	return err
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(LockErrors)
+	)
+	slots := make(chan struct{}, c.closeParallelism)
+	for _, l := range locks {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(l *Lock) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := c.releaseLock(ctx, l); err != nil {
+				mu.Lock()
+				errs[l] = err
+				mu.Unlock()
+			}
+		}(l)
+	}
+	wg.Wait()
+	return errs.orNil()
+}
+
+// scanTable calls fn for every item in the lock table, handling pagination.
+// It stops at the first error returned by fn.
+func (c *commonClient) scanTable(ctx context.Context, fn func(map[string]types.AttributeValue) error) error {
+	return c.scanTablePaced(ctx, 0, 0, fn)
+}
+
+// scanTablePaced is like scanTable, but reads at most pageSize items per page
+// and waits pageDelay between pages. Zero values mean no limit.
+func (c *commonClient) scanTablePaced(ctx context.Context, pageSize int32, pageDelay time.Duration, fn func(map[string]types.AttributeValue) error) error {
+	var exclusiveStartKey map[string]types.AttributeValue
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:         aws.String(c.tableName),
+			ConsistentRead:    aws.Bool(true),
+			ExclusiveStartKey: exclusiveStartKey,
+		}
+		if pageSize > 0 {
+			input.Limit = aws.Int32(pageSize)
+		}
+		res, err := c.scan(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, item := range res.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(res.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		exclusiveStartKey = res.LastEvaluatedKey
+		if pageDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pageDelay):
+			}
+		}
+	}
 }
 
 func (c *commonClient) getItemKeys(lockItem *Lock) map[string]types.AttributeValue {
+	return c.itemKey(lockItem.partitionKey, lockItem.sortKey)
+}
+
+func (c *commonClient) itemKey(partitionKey, sortKey string) map[string]types.AttributeValue {
 	key := map[string]types.AttributeValue{
-		c.partitionKeyName: stringAttrValue(lockItem.partitionKey),
+		c.partitionKeyName: keyAttrValue(c.partitionKeyType, partitionKey),
+	}
+	if c.sortKeyName != "" {
+		key[c.sortKeyName] = keyAttrValue(c.sortKeyType, sortKey)
 	}
 	return key
 }
 
-func (c *commonClient) get(ctx context.Context, partitionKey string) (*Lock, error) {
+func (c *commonClient) get(ctx context.Context, partitionKey, sortKey string) (*Lock, error) {
 	if c.isClosed() {
 		return nil, ErrClientClosed
 	}
@@ -880,10 +1977,11 @@ func (c *commonClient) get(ctx context.Context, partitionKey string) (*Lock, err
 	}
 
 	getLockOption := getLockOptions{
+		tableName:    c.routedTable(ctx),
 		partitionKey: partitionKey,
+		sortKey:      sortKey,
 	}
-	keyName := getLockOption.partitionKey
-	v, ok := c.locks.Load(keyName)
+	v, ok := c.locks.Load(c.lockKeyOf(ctx, partitionKey, sortKey))
 	if ok {
 		return v.(*Lock), nil
 	}
@@ -913,30 +2011,82 @@ func (c *commonClient) isClosed() bool {
 }
 
 // Close releases all of the locks. The given context is passed down
-// to the underlying dynamoDB calls.
+// to the underlying dynamoDB calls. Once Close returns, the background
+// goroutines of the client have exited and no further writes are made. The
+// locks are released concurrently (see WithCloseParallelism), and a failure
+// to release one of them does not stop the release of the others: the failures
+// are returned as LockErrors.
 func (c *commonClient) Close(ctx context.Context) error {
+	c.runMu.RLock()
+	closeOnce := c.closeOnce
+	stopHeartbeat, stopOrphanDetector := c.stopHeartbeat, c.stopOrphanDetector
+	heartbeatErrors := c.heartbeatErrors
+	c.runMu.RUnlock()
+
 	err := ErrClientClosed
-	c.closeOnce.Do(func() {
+	closeOnce.Do(func() {
+		// Stop the background goroutines first, so no heartbeat races
+		// with the release of the locks. They may call back into the
+		// client, so they must be waited for before holding the lock.
+		stopHeartbeat()
+		stopOrphanDetector()
+		c.background.Wait()
+		close(heartbeatErrors)
+
 		// Hold the write lock for the duration of the close operation
 		// to prevent new locks from being acquired.
 		c.mu.Lock()
 		defer c.mu.Unlock()
 		err = c.releaseAllLocks(ctx)
-		c.stopHeartbeat()
+		c.forgetStaleRows()
+		c.deregisterOwnerName(c.currentOwnerName())
 		c.closed = true
 	})
 	return err
 }
 
-func (c *commonClient) tryAddSessionMonitor(lockName string, lock *Lock) {
-	if lock.sessionMonitor != nil && lock.sessionMonitor.callback != nil {
+const (
+	defaultCloseTimeout     = 30 * time.Second
+	defaultCloseParallelism = 8
+)
+
+// WithCloseParallelism defines how many locks Close releases concurrently.
+// The default is 8.
+func WithCloseParallelism(n int) ClientOption {
+	return func(c *commonClient) { c.closeParallelism = n }
+}
+
+// WithCloseTimeout defines how long the io.Closer returned by Closer waits
+// for the locks to be released. The default is 30 seconds.
+func WithCloseTimeout(d time.Duration) ClientOption {
+	return func(c *commonClient) { c.closeTimeout = d }
+}
+
+// Closer adapts the client to io.Closer, for defer statements, cleanup helpers
+// and resource managers that cannot pass a context. Its Close method closes
+// the client as Close does, giving up on releasing the locks after the close
+// timeout (see WithCloseTimeout).
+func (c *commonClient) Closer() io.Closer {
+	return closerFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), c.closeTimeout)
+		defer cancel()
+		return c.Close(ctx)
+	})
+}
+
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
+
+func (c *commonClient) tryAddSessionMonitor(lockName lockKey, lock *Lock) {
+	if lock.sessionMonitor != nil && lock.sessionMonitor.callback != nil && !c.daemonless {
 		ctx, cancel := context.WithCancel(context.Background())
 		c.lockSessionMonitorChecker(ctx, lockName, lock)
 		c.sessionMonitorCancellations.Store(lockName, cancel)
 	}
 }
 
-func (c *commonClient) removeKillSessionMonitor(monitorName string) {
+func (c *commonClient) removeKillSessionMonitor(monitorName lockKey) {
 	sm, ok := c.sessionMonitorCancellations.Load(monitorName)
 	if !ok {
 		return
@@ -949,7 +2099,7 @@ func (c *commonClient) removeKillSessionMonitor(monitorName string) {
 }
 
 func (c *commonClient) lockSessionMonitorChecker(ctx context.Context,
-	monitorName string, lock *Lock) {
+	monitorName lockKey, lock *Lock) {
 	go func() {
 		defer c.sessionMonitorCancellations.Delete(monitorName)
 		for {
@@ -959,7 +2109,7 @@ func (c *commonClient) lockSessionMonitorChecker(ctx context.Context,
 			default:
 				timeUntilDangerZone, err := lock.timeUntilDangerZoneEntered()
 				if err != nil {
-					c.logger.Error(ctx, "cannot run session monitor because", err)
+					c.logger.Error(lockContext(ctx, lock), "cannot run session monitor because", err)
 					return
 				}
 				if timeUntilDangerZone <= 0 {
@@ -980,6 +2130,54 @@ func bytesAttrValue(b []byte) *types.AttributeValueMemberB {
 	return &types.AttributeValueMemberB{Value: b}
 }
 
+func isValidKeyType(t types.ScalarAttributeType) bool {
+	switch t {
+	case types.ScalarAttributeTypeS, types.ScalarAttributeTypeN, types.ScalarAttributeTypeB:
+		return true
+	}
+	return false
+}
+
+func keyAttrValue(t types.ScalarAttributeType, v string) types.AttributeValue {
+	switch t {
+	case types.ScalarAttributeTypeN:
+		return &types.AttributeValueMemberN{Value: v}
+	case types.ScalarAttributeTypeB:
+		return bytesAttrValue([]byte(v))
+	default:
+		return stringAttrValue(v)
+	}
+}
+
+// keyExpressionValue converts a key into a value that is marshaled with the
+// right type in expressions.
+func keyExpressionValue(t types.ScalarAttributeType, v string) expression.ValueBuilder {
+	switch t {
+	case types.ScalarAttributeTypeN:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return expression.Value(i)
+		}
+		f, _ := strconv.ParseFloat(v, 64)
+		return expression.Value(f)
+	case types.ScalarAttributeTypeB:
+		return expression.Value([]byte(v))
+	default:
+		return expression.Value(v)
+	}
+}
+
+func readKeyAttr(attr types.AttributeValue) string {
+	switch v := attr.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	case *types.AttributeValueMemberB:
+		return string(v.Value)
+	}
+	return ""
+}
+
 func readStringAttr(attr types.AttributeValue) string {
 	if s, ok := attr.(*types.AttributeValueMemberS); ok {
 		return s.Value
@@ -987,6 +2185,18 @@ func readStringAttr(attr types.AttributeValue) string {
 	return ""
 }
 
+func int64AttrValue(i int64) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(i, 10)}
+}
+
+func readInt64Attr(attr types.AttributeValue) int64 {
+	if n, ok := attr.(*types.AttributeValueMemberN); ok {
+		i, _ := strconv.ParseInt(n.Value, 10, 64)
+		return i
+	}
+	return 0
+}
+
 func readBytesAttr(attr types.AttributeValue) []byte {
 	if b, ok := attr.(*types.AttributeValueMemberB); ok {
 		return b.Value
@@ -994,6 +2204,61 @@ func readBytesAttr(attr types.AttributeValue) []byte {
 	return nil
 }
 
+// ErrOperationNotSupported indicates that the DynamoDB client given to the
+// lock client does not implement an operation required by the feature in use.
+// Only the methods of DynamoDBClient are mandatory: the others are looked up
+// when a feature needs them.
+var ErrOperationNotSupported = errors.New("operation not supported by the DynamoDB client")
+
+func unsupportedOperation(name string, client DynamoDBClient) error {
+	return fmt.Errorf("%w: %T does not implement %s", ErrOperationNotSupported, client, name)
+}
+
+// queryClient is implemented by the DynamoDB clients that support Query, as
+// the one of the AWS SDK does. It is needed by QueryLocks and by
+// ReacquireOwnLocks with an owner index.
+type queryClient interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+func (c *commonClient) query(ctx context.Context, params *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	q, ok := c.dynamoDB.(queryClient)
+	if !ok {
+		return nil, unsupportedOperation("Query", c.dynamoDB)
+	}
+	return q.Query(ctx, params)
+}
+
+// scanClient is implemented by the DynamoDB clients that support Scan, as the
+// one of the AWS SDK does. It is needed by the features that read the whole
+// lock table, like DetectDeadlocks and Export.
+type scanClient interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+func (c *commonClient) scan(ctx context.Context, params *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	s, ok := c.dynamoDB.(scanClient)
+	if !ok {
+		return nil, unsupportedOperation("Scan", c.dynamoDB)
+	}
+	return s.Scan(ctx, params)
+}
+
+// describeTableClient is implemented by the DynamoDB clients that support
+// DescribeTable, as the one of the AWS SDK does. It is needed by
+// WithWaitForActive, WithCreateTableIfNotExists and ValidateCostEstimate.
+type describeTableClient interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+}
+
+func (c *commonClient) describeTable(ctx context.Context, params *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	d, ok := c.dynamoDB.(describeTableClient)
+	if !ok {
+		return nil, unsupportedOperation("DescribeTable", c.dynamoDB)
+	}
+	return d.DescribeTable(ctx, params)
+}
+
 // DynamoDBClient defines the public interface that must be fulfilled for
 // testing doubles.
 type DynamoDBClient interface {
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"time"
+)
+
+// detachedReleaseTimeout bounds the last release attempt made after the
+// caller's context is done.
+const detachedReleaseTimeout = 10 * time.Second
+
+// WithBackgroundContext makes the background work done on behalf of a lock,
+// like its automatic heartbeats and session monitor, carry the values of the
+// context returned by fn. fn is called once, when the lock is acquired, with
+// the context given to AcquireLock, so request-scoped values (tenant, trace
+// IDs) reach the logger and the middlewares of the heartbeats. Only the values
+// of the returned context are used: its deadline and cancellation are ignored.
+func WithBackgroundContext(fn func(acquireCtx context.Context) context.Context) ClientOption {
+	return func(c *commonClient) { c.backgroundContext = fn }
+}
+
+// WithContextPropagation is a shortcut for WithBackgroundContext that copies
+// the values of the given keys from the context given to AcquireLock.
+func WithContextPropagation(keys ...interface{}) ClientOption {
+	return WithBackgroundContext(func(acquireCtx context.Context) context.Context {
+		ctx := context.Background()
+		for _, key := range keys {
+			if v := acquireCtx.Value(key); v != nil {
+				ctx = context.WithValue(ctx, key, v)
+			}
+		}
+		return ctx
+	})
+}
+
+// valuesContext is a context whose values are looked up in values if the
+// parent context does not have them.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (v valuesContext) Value(key interface{}) interface{} {
+	if val := v.Context.Value(key); val != nil {
+		return val
+	}
+	return v.values.Value(key)
+}
+
+// propagatedValues captures the values of the acquisition context to be used
+// in the background work of the lock.
+func (c *commonClient) propagatedValues(acquireCtx context.Context) context.Context {
+	if c.backgroundContext == nil {
+		return nil
+	}
+	return c.backgroundContext(acquireCtx)
+}
+
+// lockContext returns ctx with the values propagated from the acquisition of
+// the lock, if any, and routed to the table of the lock.
+func lockContext(ctx context.Context, l *Lock) context.Context {
+	ctx = routeToLock(ctx, l)
+	l.semaphore.Lock()
+	values := l.values
+	l.semaphore.Unlock()
+	if values == nil {
+		return ctx
+	}
+	return valuesContext{Context: ctx, values: values}
+}
+
+// detachedContext returns a context with the values of ctx, but neither its
+// deadline nor its cancellation, that times out after the given duration. It
+// lets cleanups finish after the caller gave up on them.
+func detachedContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	detached, cancel := context.WithTimeout(context.Background(), timeout)
+	return valuesContext{Context: detached, values: ctx}, cancel
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type tenantKey struct{}
+type traceKey struct{}
+
+func TestContextPropagation(t *testing.T) {
+	tenants := make(chan interface{}, 10)
+	c, err := New(&mockDynamoDBClient{}, "locksContextPropagation", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(10*time.Millisecond),
+		WithContextPropagation(tenantKey{}),
+		WithMiddleware(func(next Operation) Operation {
+			return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+				if name == "UpdateItem" {
+					if ctx.Value(traceKey{}) != nil {
+						t.Error("only the selected values should be propagated")
+					}
+					select {
+					case tenants <- ctx.Value(tenantKey{}):
+					default:
+					}
+				}
+				return next(ctx, name, input)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.WithValue(context.Background(), tenantKey{}, "tenant-1"), traceKey{}, "trace-1"))
+	if _, err := c.AcquireLock(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	// The request is over, but its values still identify the heartbeats.
+	cancel()
+	select {
+	case tenant := <-tenants:
+		if tenant != "tenant-1" {
+			t.Fatal("unexpected tenant in heartbeat:", tenant)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat sent")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrThroughputExceeded is returned when a configuration would need more
+// capacity than the table has provisioned.
+var ErrThroughputExceeded = errors.New("provisioned throughput exceeded")
+
+// writeCapacityUnitSize is the item size covered by one write capacity unit.
+const writeCapacityUnitSize = 1024
+
+// CostEstimate is the projected steady-state cost of keeping locks alive with
+// heartbeats. Capacity units are per second, as provisioned throughput is.
+type CostEstimate struct {
+	// RequestsPerSecond is the number of heartbeats sent per second.
+	RequestsPerSecond float64
+	// RequestsPerMonth is the number of heartbeats sent in 30 days, useful
+	// for on-demand tables, which are billed per request.
+	RequestsPerMonth float64
+	// WriteCapacityUnits is the write capacity consumed per second.
+	WriteCapacityUnits float64
+	// ReadCapacityUnits is the read capacity consumed per second. Heartbeats
+	// do not read the table, so it is zero; it is kept so the estimate can be
+	// compared against both sides of the provisioned throughput.
+	ReadCapacityUnits float64
+}
+
+// EstimateCost projects the cost of heartbeating numLocks locks every
+// heartbeatPeriod, given the size of the lock items in bytes.
+func EstimateCost(numLocks int, heartbeatPeriod time.Duration, itemSize int) CostEstimate {
+	if numLocks <= 0 || heartbeatPeriod <= 0 {
+		return CostEstimate{}
+	}
+	requests := float64(numLocks) / heartbeatPeriod.Seconds()
+	unitsPerWrite := math.Ceil(float64(itemSize) / writeCapacityUnitSize)
+	if unitsPerWrite < 1 {
+		unitsPerWrite = 1
+	}
+	return CostEstimate{
+		RequestsPerSecond:  requests,
+		RequestsPerMonth:   requests * (30 * 24 * time.Hour).Seconds(),
+		WriteCapacityUnits: requests * unitsPerWrite,
+	}
+}
+
+// Validate checks the estimate against the given provisioned throughput, in
+// capacity units per second, returning an error wrapping
+// ErrThroughputExceeded if it would not fit.
+func (e CostEstimate) Validate(readCapacityUnits, writeCapacityUnits int64) error {
+	if e.WriteCapacityUnits > float64(writeCapacityUnits) {
+		return fmt.Errorf("%w: heartbeats need %.2f write capacity units, table has %d", ErrThroughputExceeded, e.WriteCapacityUnits, writeCapacityUnits)
+	}
+	if e.ReadCapacityUnits > float64(readCapacityUnits) {
+		return fmt.Errorf("%w: heartbeats need %.2f read capacity units, table has %d", ErrThroughputExceeded, e.ReadCapacityUnits, readCapacityUnits)
+	}
+	return nil
+}
+
+// ValidateCostEstimate checks the estimate against the provisioned throughput
+// of the lock table, returning an error wrapping ErrThroughputExceeded if it
+// would not fit. On-demand tables always pass. The given context is passed
+// down to the underlying dynamoDB call.
+func (c *commonClient) ValidateCostEstimate(ctx context.Context, e CostEstimate) error {
+	res, err := c.describeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(c.tableName),
+	})
+	if err != nil {
+		return err
+	}
+	table := res.Table
+	if table == nil || table.ProvisionedThroughput == nil {
+		return nil
+	}
+	if table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode == types.BillingModePayPerRequest {
+		return nil
+	}
+	throughput := table.ProvisionedThroughput
+	return e.Validate(aws.ToInt64(throughput.ReadCapacityUnits), aws.ToInt64(throughput.WriteCapacityUnits))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type describeTableDynamoDBClient struct {
+	mockDynamoDBClient
+	table *types.TableDescription
+}
+
+func (m *describeTableDynamoDBClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	return &dynamodb.DescribeTableOutput{Table: m.table}, nil
+}
+
+func TestEstimateCost(t *testing.T) {
+	got := EstimateCost(100, 5*time.Second, 1500)
+	want := CostEstimate{
+		RequestsPerSecond:  20,
+		RequestsPerMonth:   20 * 30 * 24 * 3600,
+		WriteCapacityUnits: 40,
+	}
+	if got != want {
+		t.Fatalf("unexpected estimate: %#v", got)
+	}
+	if got := EstimateCost(10, time.Second, 0); got.WriteCapacityUnits != 10 {
+		t.Fatal("writes should consume at least one capacity unit:", got.WriteCapacityUnits)
+	}
+	if got := EstimateCost(10, 0, 100); got != (CostEstimate{}) {
+		t.Fatalf("disabled heartbeats should cost nothing: %#v", got)
+	}
+
+	if err := want.Validate(0, 40); err != nil {
+		t.Fatal("estimate should fit:", err)
+	}
+	if err := want.Validate(0, 39); !errors.Is(err, ErrThroughputExceeded) {
+		t.Fatal("expected throughput exceeded error:", err)
+	}
+}
+
+func TestValidateCostEstimate(t *testing.T) {
+	estimate := EstimateCost(100, 5*time.Second, 100)
+	for name, tc := range map[string]struct {
+		table   *types.TableDescription
+		wantErr bool
+	}{
+		"provisioned": {
+			table: &types.TableDescription{ProvisionedThroughput: &types.ProvisionedThroughputDescription{
+				ReadCapacityUnits:  aws.Int64(5),
+				WriteCapacityUnits: aws.Int64(5),
+			}},
+			wantErr: true,
+		},
+		"enough": {
+			table: &types.TableDescription{ProvisionedThroughput: &types.ProvisionedThroughputDescription{
+				ReadCapacityUnits:  aws.Int64(5),
+				WriteCapacityUnits: aws.Int64(25),
+			}},
+		},
+		"on demand": {
+			table: &types.TableDescription{
+				BillingModeSummary:    &types.BillingModeSummary{BillingMode: types.BillingModePayPerRequest},
+				ProvisionedThroughput: &types.ProvisionedThroughputDescription{ReadCapacityUnits: aws.Int64(0), WriteCapacityUnits: aws.Int64(0)},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(&describeTableDynamoDBClient{table: tc.table}, "locksCost", "key", DisableHeartbeat())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.ValidateCostEstimate(context.Background(), estimate)
+			if got := errors.Is(err, ErrThroughputExceeded); got != tc.wantErr {
+				t.Fatal("unexpected validation result:", err)
+			}
+		})
+	}
+}
+
+func TestValidateCostEstimateUnsupported(t *testing.T) {
+	c, err := New(&mockDynamoDBClient{}, "locksCost", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.ValidateCostEstimate(context.Background(), CostEstimate{}); !errors.Is(err, ErrOperationNotSupported) {
+		t.Fatal("expected unsupported operation error:", err)
+	}
+}
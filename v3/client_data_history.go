@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithDataHistory keeps, in the lock row, the last n data payloads replaced by
+// heartbeats, releases and acquisitions, so the next owner can inspect the
+// recent checkpoints of the previous one, not just the last. The history is
+// carried over when the lock changes hands, and is available with
+// Lock.DataHistory. Acquisitions with WithUpdateItemAcquisition that replace
+// the data add to the history with a second call, as the replaced data is only
+// known once the lock row is updated. It has no effect with
+// WithV2Compatibility.
+func WithDataHistory(n int) ClientOption {
+	return func(c *commonClient) { c.dataHistory = n }
+}
+
+// DataHistory returns the data payloads the lock held before its current data,
+// most recent first, as kept by WithDataHistory.
+func (l *Lock) DataHistory() [][]byte {
+	if l == nil {
+		return nil
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	history := make([][]byte, len(l.dataHistory))
+	for i, data := range l.dataHistory {
+		history[i] = append([]byte(nil), data...)
+	}
+	return history
+}
+
+// nextDataHistory returns the history of the lock row once its data changes
+// from the one of l to data.
+func (c *commonClient) nextDataHistory(l *Lock, data []byte) [][]byte {
+	if c.dataHistory <= 0 || c.v2Compatibility {
+		return nil
+	}
+	history := l.dataHistory
+	if l.data != nil && !bytes.Equal(l.data, data) {
+		history = append([][]byte{l.data}, history...)
+	}
+	if len(history) > c.dataHistory {
+		history = history[:c.dataHistory]
+	}
+	return history
+}
+
+func dataHistoryAttrValue(history [][]byte) types.AttributeValue {
+	values := make([]types.AttributeValue, len(history))
+	for i, data := range history {
+		values[i] = bytesAttrValue(data)
+	}
+	return &types.AttributeValueMemberL{Value: values}
+}
+
+func readDataHistory(attr types.AttributeValue) [][]byte {
+	list, ok := attr.(*types.AttributeValueMemberL)
+	if !ok {
+		return nil
+	}
+	history := make([][]byte, 0, len(list.Value))
+	for _, v := range list.Value {
+		history = append(history, readBytesAttr(v))
+	}
+	return history
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDataHistory(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksHistory", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("leader"),
+		attrOwnerName:           stringAttrValue("previous"),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+		attrIsReleased:          stringAttrValue("1"),
+		attrData:                bytesAttrValue([]byte("checkpoint-2")),
+		attrDataHistory:         dataHistoryAttrValue([][]byte{[]byte("checkpoint-1")}),
+	})
+	c, err := New(svc, "locksHistory", "key",
+		DisableHeartbeat(),
+		WithDataHistory(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	l, err := c.AcquireLock(context.Background(), "leader", WithData([]byte("checkpoint-3")), ReplaceData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDataHistory(t, readDataHistory(svc.row("locksHistory", "leader")[attrDataHistory]), "checkpoint-2", "checkpoint-1")
+	assertDataHistory(t, l.DataHistory(), "checkpoint-2", "checkpoint-1")
+
+	if err := c.SendHeartbeat(context.Background(), l, ReplaceHeartbeatData([]byte("checkpoint-4"))); err != nil {
+		t.Fatal(err)
+	}
+	assertDataHistory(t, l.DataHistory(), "checkpoint-3", "checkpoint-2")
+	if err := c.SendHeartbeat(context.Background(), l, ReplaceHeartbeatData([]byte("checkpoint-4"))); err != nil {
+		t.Fatal(err)
+	}
+	assertDataHistory(t, l.DataHistory(), "checkpoint-3", "checkpoint-2")
+}
+
+func TestDataHistoryUpdateItemAcquisition(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksHistory", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("leader"),
+		attrOwnerName:           stringAttrValue("previous"),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+		attrIsReleased:          stringAttrValue("1"),
+		attrData:                bytesAttrValue([]byte("checkpoint-2")),
+		attrDataHistory:         dataHistoryAttrValue([][]byte{[]byte("checkpoint-1")}),
+	})
+	c, err := New(svc, "locksHistory", "key",
+		DisableHeartbeat(),
+		WithDataHistory(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	l, err := c.AcquireLock(context.Background(), "leader", WithData([]byte("checkpoint-3")), ReplaceData(), WithUpdateItemAcquisition())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(readBytesAttr(svc.row("locksHistory", "leader")[attrData])); got != "checkpoint-3" {
+		t.Fatalf("unexpected data: %q", got)
+	}
+	assertDataHistory(t, readDataHistory(svc.row("locksHistory", "leader")[attrDataHistory]), "checkpoint-2", "checkpoint-1")
+	assertDataHistory(t, l.DataHistory(), "checkpoint-2", "checkpoint-1")
+
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal("the lock must still be held after the history is recorded:", err)
+	}
+}
+
+func assertDataHistory(t *testing.T, got [][]byte, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("unexpected history: %q", got)
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Fatalf("unexpected history: %q", got)
+		}
+	}
+}
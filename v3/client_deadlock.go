@@ -0,0 +1,205 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WaitsForEdge indicates that an owner is waiting for a lock held by another
+// owner.
+type WaitsForEdge struct {
+	Owner        string
+	PartitionKey string
+	SortKey      string
+	Holder       string
+}
+
+func (e WaitsForEdge) String() string {
+	key := e.PartitionKey
+	if e.SortKey != "" {
+		key += "/" + e.SortKey
+	}
+	return fmt.Sprintf("%s waits for %s held by %s", e.Owner, key, e.Holder)
+}
+
+// RecordWaitsFor makes the client, while waiting for the lock, record in all
+// the other locks it currently holds which lock it is waiting for. These
+// waits-for edges are used by DetectDeadlocks to find owners waiting on each
+// other in multi-lock workflows. The edges are removed once the acquisition
+// finishes, successfully or not.
+func RecordWaitsFor() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.recordWaitsFor = true
+	}
+}
+
+func (c *commonClient) tryRecordWaitsFor(ctx context.Context, getLockOptions *getLockOptions) {
+	if c.v2Compatibility || !getLockOptions.recordWaitsFor || getLockOptions.waitsForRecorded != nil {
+		return
+	}
+	getLockOptions.waitsForRecorded = []*Lock{}
+	update := expression.
+		Set(expression.Name(attrWaitsForPartition), expression.Value(getLockOptions.partitionKey)).
+		Set(expression.Name(attrWaitsForSort), expression.Value(getLockOptions.sortKey))
+	c.locks.Range(func(_ interface{}, value interface{}) bool {
+		lockItem := value.(*Lock)
+		if err := c.updateOwnedLock(ctx, lockItem, update); err != nil {
+			c.logger.Error(ctx, "cannot record waits-for edge in ", lockItem.partitionKey, ":", err)
+			return true
+		}
+		getLockOptions.waitsForRecorded = append(getLockOptions.waitsForRecorded, lockItem)
+		return true
+	})
+}
+
+func (c *commonClient) clearWaitsFor(ctx context.Context, getLockOptions *getLockOptions) {
+	if len(getLockOptions.waitsForRecorded) == 0 {
+		return
+	}
+	// Stale edges lead to false deadlock suspicions, so they are cleared
+	// even if the acquisition was canceled.
+	clearCtx := ctx
+	if ctx.Err() != nil {
+		clearCtx = context.Background()
+	}
+	update := expression.
+		Remove(expression.Name(attrWaitsForPartition)).
+		Remove(expression.Name(attrWaitsForSort))
+	for _, lockItem := range getLockOptions.waitsForRecorded {
+		if err := c.updateOwnedLock(clearCtx, lockItem, update); err != nil {
+			c.logger.Error(ctx, "cannot clear waits-for edge in ", lockItem.partitionKey, ":", err)
+		}
+	}
+}
+
+// updateOwnedLock changes attributes that are not relevant for the lock
+// lifecycle, and therefore does not change the record version number.
+func (c *commonClient) updateOwnedLock(ctx context.Context, lockItem *Lock, update expression.UpdateBuilder) error {
+	ctx = routeToLock(ctx, lockItem)
+	cond := expression.And(
+		expression.AttributeExists(expression.Name(c.partitionKeyName)),
+		expression.Equal(ownerNameAttr, expression.Value(c.currentOwnerName())),
+	)
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.getItemKeys(lockItem),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	return parseOwnershipError(err, "lock is not owned by this client")
+}
+
+// DetectDeadlocks scans the lock table looking for owners waiting on locks
+// held by each other, as recorded by acquisitions using RecordWaitsFor. It
+// returns a DeadlockSuspectedError if a cycle is found. As the scan is not a
+// consistent snapshot of the table, the result is a suspicion that should be
+// confirmed by the involved owners. The given context is passed down to the
+// underlying dynamoDB calls.
+func (c *commonClient) DetectDeadlocks(ctx context.Context) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	var items []map[string]types.AttributeValue
+	err := c.scanTable(ctx, func(item map[string]types.AttributeValue) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cycle := c.findWaitsForCycle(items); cycle != nil {
+		return &DeadlockSuspectedError{Cycle: cycle}
+	}
+	return nil
+}
+
+func (c *commonClient) findWaitsForCycle(items []map[string]types.AttributeValue) []WaitsForEdge {
+	holders := make(map[lockKey]string)
+	waitsFor := make(map[string]lockKey)
+	for _, item := range items {
+		if c.isReleasedItem(item) {
+			continue
+		}
+		owner := readStringAttr(item[attrOwnerName])
+		key := lockKey{
+			partitionKey: readKeyAttr(item[c.partitionKeyName]),
+			sortKey:      readKeyAttr(item[c.sortKeyName]),
+		}
+		holders[key] = owner
+		if _, ok := item[attrWaitsForPartition]; ok {
+			waitsFor[owner] = lockKey{
+				partitionKey: readStringAttr(item[attrWaitsForPartition]),
+				sortKey:      readStringAttr(item[attrWaitsForSort]),
+			}
+		}
+	}
+
+	edge := func(owner string) (WaitsForEdge, bool) {
+		key, ok := waitsFor[owner]
+		if !ok {
+			return WaitsForEdge{}, false
+		}
+		holder, ok := holders[key]
+		if !ok || holder == owner {
+			return WaitsForEdge{}, false
+		}
+		return WaitsForEdge{
+			Owner:        owner,
+			PartitionKey: key.partitionKey,
+			SortKey:      key.sortKey,
+			Holder:       holder,
+		}, true
+	}
+
+	// Each owner waits for at most one lock, so following the edges from
+	// every owner is enough to find any cycle.
+	visited := make(map[string]bool)
+	for start := range waitsFor {
+		if visited[start] {
+			continue
+		}
+		var path []WaitsForEdge
+		position := make(map[string]int)
+		for owner := start; ; {
+			if i, ok := position[owner]; ok {
+				return path[i:]
+			}
+			if visited[owner] {
+				break
+			}
+			visited[owner] = true
+			position[owner] = len(path)
+			e, ok := edge(owner)
+			if !ok {
+				break
+			}
+			path = append(path, e)
+			owner = e.Holder
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestFindWaitsForCycle(t *testing.T) {
+	c := &commonClient{partitionKeyName: "key"}
+	row := func(key, owner, waitsFor string) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			"key":         stringAttrValue(key),
+			attrOwnerName: stringAttrValue(owner),
+		}
+		if waitsFor != "" {
+			item[attrWaitsForPartition] = stringAttrValue(waitsFor)
+			item[attrWaitsForSort] = stringAttrValue("")
+		}
+		return item
+	}
+
+	noCycle := []map[string]types.AttributeValue{
+		row("a", "owner1", "b"),
+		row("b", "owner2", "c"),
+		row("c", "owner3", ""),
+	}
+	if cycle := c.findWaitsForCycle(noCycle); cycle != nil {
+		t.Fatalf("unexpected cycle: %v", cycle)
+	}
+
+	cycleRows := []map[string]types.AttributeValue{
+		row("x", "owner0", "a"),
+		row("a", "owner1", "b"),
+		row("b", "owner2", "c"),
+		row("c", "owner3", "a"),
+	}
+	cycle := c.findWaitsForCycle(cycleRows)
+	if len(cycle) != 3 {
+		t.Fatalf("unexpected cycle: %v", cycle)
+	}
+	err := &DeadlockSuspectedError{Cycle: cycle}
+	owners := make(map[string]bool)
+	for _, o := range err.Owners() {
+		owners[o] = true
+	}
+	if owners["owner0"] || !owners["owner1"] || !owners["owner2"] || !owners["owner3"] {
+		t.Fatalf("unexpected owners in cycle: %v", err.Owners())
+	}
+
+	released := row("c", "owner3", "a")
+	released[attrIsReleased] = stringAttrValue("1")
+	cycleRows[3] = released
+	if cycle := c.findWaitsForCycle(cycleRows); cycle != nil {
+		t.Fatalf("released locks should not be part of cycles: %v", cycle)
+	}
+}
+
+func TestDetectDeadlocksUnsupported(t *testing.T) {
+	c, err := New(&mockDynamoDBClient{}, "locksDeadlock", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.DetectDeadlocks(context.Background()); !errors.Is(err, ErrOperationNotSupported) {
+		t.Fatal("expected unsupported operation error:", err)
+	}
+}
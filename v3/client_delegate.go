@@ -0,0 +1,159 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// ErrLockDelegated is returned by the heartbeats of a lock whose heartbeats
+// were handed over to a HeartbeatDelegate with Lock.StartDelegation.
+var ErrLockDelegated = errors.New("lock heartbeats are delegated")
+
+// HeartbeatDelegate sends heartbeats on behalf of the owner of a lock, without
+// taking it over. It cannot release the lock nor change its data. It is meant
+// to keep the lease of a lock from lapsing while the process holding it is
+// paused, for example during a live migration.
+//
+// Each heartbeat changes the record version number of the lock, as the
+// heartbeats of its owner do, so waiters keep seeing the lock alive. The owner
+// therefore does not heartbeat the lock while it is delegated: it hands the
+// token out with Lock.StartDelegation, and calls Lock.EndDelegation once the
+// delegate stopped, to adopt the record version number the delegate wrote.
+type HeartbeatDelegate struct {
+	c   *commonClient
+	mu  sync.Mutex
+	tok lockToken
+
+	leaseDuration time.Duration
+}
+
+// StartDelegation stops the heartbeats this client sends to the lock and
+// returns a token for DelegateHeartbeats, so another process keeps the lock
+// alive in its place. Until EndDelegation is called, the heartbeats of the
+// lock fail with ErrLockDelegated and the lock is skipped by the automatic
+// heartbeats. As the heartbeats of the delegate are not seen by this client,
+// the lock does not expire and its session monitor does not fire meanwhile.
+func (l *Lock) StartDelegation() ([]byte, error) {
+	if l == nil {
+		return nil, ErrCannotReleaseNullLock
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	token, err := l.marshalToken()
+	if err != nil {
+		return nil, err
+	}
+	l.delegated = true
+	return token, nil
+}
+
+// EndDelegation takes the heartbeats of the lock back from the
+// HeartbeatDelegate, which must have stopped sending them. The lock is
+// refreshed from DynamoDB, adopting the record version number written by the
+// delegate, as long as it still belongs to this client; it fails as
+// Lock.Refresh does otherwise. The given context is passed down to the
+// underlying dynamoDB call.
+func (l *Lock) EndDelegation(ctx context.Context) error {
+	if l == nil || l.refreshLock == nil {
+		return ErrCannotRefreshNullLock
+	}
+	if err := l.refreshLock(ctx, l); err != nil {
+		return err
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	l.delegated = false
+	return nil
+}
+
+// heartbeatsDelegated tells whether the heartbeats of the lock are sent by a
+// HeartbeatDelegate.
+func (l *Lock) heartbeatsDelegated() bool {
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.delegated
+}
+
+// DelegateHeartbeats decodes a token created with Lock.StartDelegation and
+// returns a HeartbeatDelegate that sends heartbeats to the lock on behalf of
+// its owner.
+func (c *commonClient) DelegateHeartbeats(token []byte) (*HeartbeatDelegate, error) {
+	var t lockToken
+	if err := json.Unmarshal(token, &t); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLockToken, err)
+	}
+	leaseDuration, err := time.ParseDuration(t.LeaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLockToken, err)
+	}
+	return &HeartbeatDelegate{c: c, tok: t, leaseDuration: leaseDuration}, nil
+}
+
+// RecordVersionNumber returns the record version number written by the last
+// heartbeat sent by the delegate.
+func (d *HeartbeatDelegate) RecordVersionNumber() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tok.RecordVersionNumber
+}
+
+// SendHeartbeat refreshes the lease of the lock, as long as it is still held
+// by the owner named in the token and it was not updated by anyone else since
+// the token was created or since the previous heartbeat of the delegate. The
+// given context is passed down to the underlying dynamoDB call.
+func (d *HeartbeatDelegate) SendHeartbeat(ctx context.Context) error {
+	c := d.c
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ctx = routeToToken(ctx, d.tok)
+	newRvn := c.generateRecordVersionNumber()
+	cond := OwnershipCondition(c.partitionKeyName, d.tok.RecordVersionNumber, d.tok.OwnerName)
+	update := expression.
+		Set(leaseDurationAttr, expression.Value(c.leaseDurationValue(d.leaseDuration))).
+		Set(rvnAttr, expression.Value(newRvn))
+	if !c.v2Compatibility {
+		update = update.Set(expiresAtAttr, expression.Value(c.expiresAt(d.leaseDuration)))
+	}
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(d.tok.PartitionKey, d.tok.SortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	if err != nil {
+		return parseOwnershipError(err, "cannot send delegated heartbeat: lock was updated or taken over")
+	}
+	d.tok.RecordVersionNumber = newRvn
+	return nil
+}
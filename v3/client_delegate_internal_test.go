@@ -0,0 +1,140 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDelegateHeartbeats(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	owner, err := New(svc, "locksDelegate", "key", DisableHeartbeat(), WithOwnerName("owner"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delegator, err := New(svc, "locksDelegate", "key", DisableHeartbeat(), WithOwnerName("delegate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	lost := make(chan error, 1)
+	l, err := owner.AcquireLock(ctx, "delegated", WithData([]byte("payload")), WithOwnershipLostCallback(func(_ *Lock, err error) {
+		lost <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := l.StartDelegation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := delegator.DelegateHeartbeats([]byte("garbage")); err == nil {
+		t.Fatal("invalid tokens must be rejected")
+	}
+	d, err := delegator.DelegateHeartbeats(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvn := l.RecordVersionNumber()
+	for i := 0; i < 2; i++ {
+		if err := d.SendHeartbeat(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if d.RecordVersionNumber() == rvn {
+			t.Fatal("delegated heartbeat must change the record version number")
+		}
+		rvn = d.RecordVersionNumber()
+	}
+
+	// The owner does not heartbeat the lock while it is delegated, so
+	// it does not lose it.
+	if err := owner.SendHeartbeat(ctx, l); !errors.Is(err, ErrLockDelegated) {
+		t.Fatal("expected the heartbeats of the owner to be delegated:", err)
+	}
+	if err := owner.SendHeartbeats(ctx, nil); err != nil {
+		t.Fatal("delegated locks should be skipped:", err)
+	}
+	if _, ok := owner.locks.Load(l.uniqueIdentifier()); !ok {
+		t.Fatal("delegated lock should still be tracked")
+	}
+	if got := readStringAttr(svc.row("locksDelegate", "delegated")[attrRecordVersionNumber]); got != rvn {
+		t.Fatal("the owner must not change the record version number while delegated:", got)
+	}
+
+	if err := l.EndDelegation(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if l.RecordVersionNumber() != rvn {
+		t.Fatal("owner should adopt the record version number of the delegate:", l.RecordVersionNumber())
+	}
+	if err := owner.SendHeartbeat(ctx, l); err != nil {
+		t.Fatal("the owner should heartbeat the lock again:", err)
+	}
+	if string(l.Data()) != "payload" {
+		t.Fatal("delegated heartbeats must not change the data:", string(l.Data()))
+	}
+	if err := d.SendHeartbeat(ctx); !IsOwnershipLost(err) {
+		t.Fatal("the delegate should stop once the owner took the heartbeats back:", err)
+	}
+	select {
+	case err := <-lost:
+		t.Fatal("the owner should not lose the lock:", err)
+	default:
+	}
+}
+
+func TestDelegatedLockExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c, err := New(newMemoryDynamoDBClient(), "locksDelegate", "key",
+		DisableHeartbeat(),
+		WithOwnerName("owner"),
+		WithLeaseDuration(10*time.Second),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	l, err := c.AcquireLock(context.Background(), "delegated", WithSessionMonitor(time.Second, func() {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.StartDelegation(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The heartbeats of the delegate are not seen by the owner, which
+	// must not consider the lock lost because of them.
+	clock.Advance(time.Hour)
+	if l.IsExpired() {
+		t.Fatal("a delegated lock should not expire")
+	}
+	if almostExpired, err := l.IsAlmostExpired(); almostExpired || err != nil {
+		t.Fatal("the session monitor should not fire for a delegated lock:", almostExpired, err)
+	}
+
+	if err := l.EndDelegation(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if !l.IsExpired() {
+		t.Fatal("the lock should expire once the delegation ends")
+	}
+}
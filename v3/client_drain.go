@@ -0,0 +1,99 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClientDraining reports the client is not accepting new acquisitions
+// because it is being drained.
+var ErrClientDraining = errors.New("client is draining")
+
+func (c *commonClient) doWithLock(ctx context.Context, partitionKey, sortKey string, fn func(context.Context, *Lock) error, opts ...AcquireLockOption) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	// Register the critical section while holding drainMu, so Drain never
+	// starts waiting before all in-flight sections are accounted for.
+	c.drainMu.Lock()
+	if c.draining {
+		c.drainMu.Unlock()
+		return ErrClientDraining
+	}
+	c.inFlight.Add(1)
+	c.drainMu.Unlock()
+	defer c.inFlight.Done()
+
+	l, err := c.acquireLock(ctx, partitionKey, sortKey, opts...)
+	if err != nil {
+		return err
+	}
+	fnErr := fn(ctx, l)
+	// The lock is released even if fn outlived ctx, as nothing else holds
+	// a handle to it.
+	releaseCtx, cancel := detachedContext(ctx, detachedReleaseTimeout)
+	defer cancel()
+	if _, err := c.ReleaseLock(releaseCtx, l); err != nil && fnErr == nil {
+		return err
+	}
+	return fnErr
+}
+
+// Drain stops the client from accepting new acquisitions, waits for the
+// critical sections started with DoWithLock to finish and then closes the
+// client, releasing all remaining locks. Acquisitions still waiting for their
+// lock give up with ErrClientDraining. It is meant to be used in graceful
+// shutdown procedures, like Kubernetes' preStop hooks.
+//
+// The wait is bounded by the given context. Once the context is done, Drain
+// stops waiting and closes the client anyway, returning the context error.
+// Remaining locks are released even if the context is already done.
+func (c *commonClient) Drain(ctx context.Context) error {
+	c.drainMu.Lock()
+	c.draining = true
+	c.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(done)
+	}()
+
+	var waitErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+
+	releaseCtx := ctx
+	if waitErr != nil {
+		releaseCtx = context.Background()
+	}
+	if err := c.Close(releaseCtx); err != nil && !errors.Is(err, ErrClientClosed) {
+		return err
+	}
+	return waitErr
+}
+
+func (c *commonClient) isDraining() bool {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	return c.draining
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const attrFailoverEpoch = "failoverEpoch"
+
+// ErrSplitOwnership indicates that the same lock is held both in the primary
+// and in the standby tables of a FailoverClient.
+var ErrSplitOwnership = errors.New("lock held in both primary and standby tables")
+
+// FailoverClient acquires locks on a primary table and, once the primary has
+// been unavailable for longer than a threshold, switches the acquisitions to a
+// standby table. Every lock row carries the failover epoch in which it was
+// acquired, so split ownership across both tables can be detected with
+// CheckSplitOwnership.
+//
+// On failover, the locks held in the primary table are acquired in the standby
+// table, from where they are heartbeated and released from then on. Their rows
+// in the primary table are left behind, as the primary is unreachable, and
+// they expire once their lease runs out. Locks that are held by someone else
+// in the standby table are not moved and stay in the primary table. FailBack switches back to the primary
+// table. The epoch is kept in memory, so it only orders the failovers of this
+// FailoverClient.
+type FailoverClient struct {
+	primary   *Client
+	standby   *Client
+	threshold time.Duration
+
+	mu               sync.Mutex
+	epoch            int64
+	failedOver       bool
+	unavailableSince time.Time
+}
+
+// NewFailoverClient creates a failover wrapper around the primary and standby
+// clients. Each client should point to an independent table, usually in
+// different regions.
+func NewFailoverClient(primary, standby *Client, threshold time.Duration) *FailoverClient {
+	return &FailoverClient{
+		primary:   primary,
+		standby:   standby,
+		threshold: threshold,
+	}
+}
+
+// Epoch returns the current failover epoch. It starts at zero when the
+// FailoverClient is created and it is incremented every time the client
+// switches tables.
+func (f *FailoverClient) Epoch() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.epoch
+}
+
+// FailedOver reports whether acquisitions are going to the standby table.
+func (f *FailoverClient) FailedOver() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failedOver
+}
+
+// AcquireLock holds the defined lock on the active table. If the primary table
+// fails with a network, throttling or server error, and it has been failing
+// for longer than the threshold, the client fails over and retries the
+// acquisition on the standby table. The given context is passed down to the
+// underlying dynamoDB calls.
+func (f *FailoverClient) AcquireLock(ctx context.Context, key string, opts ...AcquireLockOption) (*Lock, error) {
+	c, epoch, failedOver := f.active()
+	l, err := c.AcquireLock(ctx, key, withFailoverEpoch(opts, epoch)...)
+	if failedOver || !isAvailabilityError(ctx, err) {
+		if !failedOver && err == nil {
+			f.markAvailable()
+		}
+		f.track(l, failedOver)
+		return l, err
+	}
+	failedOver, switched := f.markUnavailable(err)
+	if !failedOver {
+		return nil, err
+	}
+	c, epoch, _ = f.active()
+	if switched {
+		if err := f.moveLocks(ctx, f.primary, f.standby, epoch); err != nil {
+			f.primary.logger.Error(ctx, "cannot move locks to the standby table: ", err)
+		}
+	}
+	l, err = c.AcquireLock(ctx, key, withFailoverEpoch(opts, epoch)...)
+	f.track(l, true)
+	return l, err
+}
+
+// FailBack switches the acquisitions back to the primary table, once it is
+// available again. The locks held in the standby table are acquired in the
+// primary table, waiting for the rows left there by the failover to expire,
+// and they are released from the standby table. The locks that could not be
+// moved are returned as LockErrors; they stay in the standby table, where they
+// are still heartbeated. The given context is passed down to the underlying
+// dynamoDB calls.
+func (f *FailoverClient) FailBack(ctx context.Context) error {
+	f.mu.Lock()
+	if !f.failedOver {
+		f.mu.Unlock()
+		return nil
+	}
+	f.failedOver = false
+	f.epoch++
+	f.unavailableSince = time.Time{}
+	epoch := f.epoch
+	f.mu.Unlock()
+	f.primary.logger.Info(ctx, "failing back to the primary lock table (epoch ", epoch, ")")
+	return f.moveLocks(ctx, f.standby, f.primary, epoch)
+}
+
+// ReleaseLock releases the given lock with the client that holds it. The
+// given context is passed down to the underlying dynamoDB calls.
+func (f *FailoverClient) ReleaseLock(ctx context.Context, lock *Lock, opts ...ReleaseLockOption) (bool, error) {
+	if lock == nil {
+		return false, ErrCannotReleaseNullLock
+	}
+	lock.semaphore.Lock()
+	inStandby := lock.inFailoverStandby
+	lock.semaphore.Unlock()
+	if inStandby {
+		return f.standby.ReleaseLock(ctx, lock, opts...)
+	}
+	return f.primary.ReleaseLock(ctx, lock, opts...)
+}
+
+// CheckSplitOwnership reads the lock from both tables and returns
+// ErrSplitOwnership if it is held in both of them. The given context is passed
+// down to the underlying dynamoDB calls.
+func (f *FailoverClient) CheckSplitOwnership(ctx context.Context, key string) error {
+	primary, err := f.primary.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("cannot read lock from primary table: %w", err)
+	}
+	standby, err := f.standby.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("cannot read lock from standby table: %w", err)
+	}
+	if !isHeldRow(primary) || !isHeldRow(standby) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q owned by %q (epoch %d) and by %q (epoch %d)",
+		ErrSplitOwnership, key,
+		primary.OwnerName(), readInt64Attr(primary.AdditionalAttributes()[attrFailoverEpoch]),
+		standby.OwnerName(), readInt64Attr(standby.AdditionalAttributes()[attrFailoverEpoch]))
+}
+
+// Close closes both the primary and the standby clients.
+func (f *FailoverClient) Close(ctx context.Context) error {
+	errPrimary := f.primary.Close(ctx)
+	errStandby := f.standby.Close(ctx)
+	if errPrimary != nil {
+		return errPrimary
+	}
+	return errStandby
+}
+
+func (f *FailoverClient) active() (*Client, int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedOver {
+		return f.standby, f.epoch, true
+	}
+	return f.primary, f.epoch, false
+}
+
+func (f *FailoverClient) markAvailable() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unavailableSince = time.Time{}
+}
+
+// markUnavailable records the primary failure and reports whether the client
+// has failed over to the standby table, and whether this failure is the one
+// that switched it.
+func (f *FailoverClient) markUnavailable(err error) (failedOver, switched bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedOver {
+		return true, false
+	}
+	now := f.primary.now()
+	if f.unavailableSince.IsZero() {
+		f.unavailableSince = now
+	}
+	if now.Sub(f.unavailableSince) < f.threshold {
+		return false, false
+	}
+	f.failedOver = true
+	f.epoch++
+	f.primary.logger.Error(context.Background(), "primary lock table unavailable, failing over to standby (epoch ", f.epoch, "): ", err)
+	return true, true
+}
+
+// track records in the lock which table holds it, so ReleaseLock goes to the
+// right client.
+func (f *FailoverClient) track(l *Lock, inStandby bool) {
+	if l == nil {
+		return
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	l.inFailoverStandby = inStandby
+}
+
+// moveLocks acquires the locks held by from in the table of to, and hands them
+// over to to. The failures are returned as LockErrors.
+func (f *FailoverClient) moveLocks(ctx context.Context, from, to *Client, epoch int64) error {
+	var locks []*Lock
+	from.locks.Range(func(_, value interface{}) bool {
+		locks = append(locks, value.(*Lock))
+		return true
+	})
+	errs := make(LockErrors)
+	for _, l := range locks {
+		if err := f.moveLock(ctx, l, from, to, epoch); err != nil {
+			errs[l] = err
+		}
+	}
+	return errs.orNil()
+}
+
+// moveLock acquires l in the table of to, and makes to heartbeat and release
+// it from then on. The row left in the table of from is released if from is
+// the standby table; the primary table is unreachable when the locks are
+// moved away from it.
+func (f *FailoverClient) moveLock(ctx context.Context, l *Lock, from, to *Client, epoch int64) error {
+	l.semaphore.Lock()
+	previous := &Lock{
+		tableName:           l.tableName,
+		partitionKey:        l.partitionKey,
+		sortKey:             l.sortKey,
+		ownerName:           l.ownerName,
+		recordVersionNumber: l.recordVersionNumber,
+		deleteLockOnRelease: l.deleteLockOnRelease,
+	}
+	opts := []AcquireLockOption{
+		WithData(l.data),
+		ReplaceData(),
+		WithAdditionalAttributes(l.additionalAttributes),
+	}
+	if l.deleteLockOnRelease {
+		opts = append(opts, WithDeleteLockOnRelease())
+	}
+	if to == f.standby {
+		// Anyone else holding the lock in the standby table also
+		// failed over; waiting for them would only stall the failover.
+		opts = append(opts, FailIfLocked())
+	}
+	l.semaphore.Unlock()
+
+	moved, err := to.AcquireLock(ctx, previous.partitionKey, withFailoverEpoch(opts, epoch)...)
+	if err != nil {
+		return err
+	}
+	id := moved.uniqueIdentifier()
+	to.locks.Delete(id)
+	to.removeKillSessionMonitor(id)
+	from.locks.Delete(previous.uniqueIdentifier())
+	from.removeKillSessionMonitor(previous.uniqueIdentifier())
+	if from == f.standby {
+		if _, err := from.ReleaseLock(ctx, previous); err != nil {
+			from.logger.Error(ctx, "cannot release moved lock ", previous.partitionKey, " from the standby table: ", err)
+		}
+	}
+
+	moved.semaphore.Lock()
+	l.semaphore.Lock()
+	l.tableName = moved.tableName
+	l.recordVersionNumber = moved.recordVersionNumber
+	l.lookupTime = moved.lookupTime
+	l.expiresAt = moved.expiresAt
+	l.additionalAttributes = moved.additionalAttributes
+	l.acquisitionToken = moved.acquisitionToken
+	l.inFailoverStandby = to == f.standby
+	l.semaphore.Unlock()
+	moved.semaphore.Unlock()
+	to.startOwnedLock(l)
+	return nil
+}
+
+func withFailoverEpoch(opts []AcquireLockOption, epoch int64) []AcquireLockOption {
+	opts = append(opts[:len(opts):len(opts)], func(opt *acquireLockOptions) {
+		attrs := make(map[string]types.AttributeValue, len(opt.additionalAttributes)+1)
+		for k, v := range opt.additionalAttributes {
+			attrs[k] = v
+		}
+		attrs[attrFailoverEpoch] = int64AttrValue(epoch)
+		opt.additionalAttributes = attrs
+	})
+	return opts
+}
+
+// isAvailabilityError reports whether err tells that the table cannot be
+// reached: a network failure, a throttled request or a server error. Errors
+// about the request itself, or about the lock, do not trigger a failover.
+func isAvailabilityError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if isRetryableError(err) {
+		return true
+	}
+	var httpErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &httpErr) && httpErr.HTTPStatusCode() >= 500
+}
+
+func isHeldRow(l *Lock) bool {
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.ownerName != "" && !l.isReleased
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// heldLockRow is the row of the "leader" lock, held by owner.
+func heldLockRow(owner string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key":                   stringAttrValue("leader"),
+		attrOwnerName:           stringAttrValue(owner),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	}
+}
+
+// newHeldLockDynamoDBClient returns an in-memory DynamoDB in which the
+// "leader" lock of the given table is held by owner.
+func newHeldLockDynamoDBClient(tableName, owner string) *memoryDynamoDBClient {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow(tableName, heldLockRow(owner))
+	return svc
+}
+
+// newFlakyDynamoDBClient returns an in-memory DynamoDB that fails every call
+// with a server error while *down is set.
+func newFlakyDynamoDBClient(down *int32) *memoryDynamoDBClient {
+	svc := newMemoryDynamoDBClient()
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if atomic.LoadInt32(down) == 1 {
+			return nil, &types.InternalServerError{Message: aws.String("region down")}
+		}
+		return next()
+	})
+	return svc
+}
+
+func TestFailoverClient(t *testing.T) {
+	newClient := func(svc DynamoDBClient, opts ...ClientOption) *Client {
+		c, err := New(svc, "locksFailover", "key", append([]ClientOption{DisableHeartbeat()}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	var internalServerError *types.InternalServerError
+
+	t.Run("below threshold", func(t *testing.T) {
+		down := int32(1)
+		f := NewFailoverClient(newClient(newFlakyDynamoDBClient(&down)), newClient(newMemoryDynamoDBClient()), time.Hour)
+		defer f.Close(context.Background())
+		if _, err := f.AcquireLock(context.Background(), "leader", WithMaxAttempts(1)); !errors.As(err, &internalServerError) {
+			t.Fatal("expected primary error:", err)
+		}
+		if f.FailedOver() || f.Epoch() != 0 {
+			t.Fatal("should not have failed over yet")
+		}
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		down := int32(1)
+		clock := &fakeClock{now: time.Unix(1000, 0)}
+		f := NewFailoverClient(newClient(newFlakyDynamoDBClient(&down), WithClock(clock)), newClient(newMemoryDynamoDBClient()), time.Minute)
+		defer f.Close(context.Background())
+		if _, err := f.AcquireLock(context.Background(), "leader", WithMaxAttempts(1)); err == nil || f.FailedOver() {
+			t.Fatal("should not have failed over yet:", err)
+		}
+		clock.Advance(2 * time.Minute)
+		if _, err := f.AcquireLock(context.Background(), "leader", WithMaxAttempts(1)); err != nil || !f.FailedOver() {
+			t.Fatal("expected failover to the standby table:", err)
+		}
+	})
+
+	t.Run("request errors", func(t *testing.T) {
+		f := NewFailoverClient(
+			newClient(newMemoryDynamoDBClient(), WithReacquirePolicy(ReacquireFail)),
+			newClient(newMemoryDynamoDBClient()),
+			0,
+		)
+		defer f.Close(context.Background())
+		if _, err := f.AcquireLock(context.Background(), "leader"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.AcquireLock(context.Background(), "leader"); !errors.Is(err, ErrAlreadyHeldLocally) {
+			t.Fatal("expected lock held locally error:", err)
+		}
+		if _, err := f.AcquireLock(context.Background(), "reserved",
+			WithAdditionalAttributes(map[string]types.AttributeValue{attrOwnerName: stringAttrValue("owner")})); err == nil {
+			t.Fatal("expected reserved attribute error")
+		}
+		if f.FailedOver() {
+			t.Fatal("request errors should not fail over")
+		}
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		down := int32(1)
+		standby := newMemoryDynamoDBClient()
+		f := NewFailoverClient(newClient(newFlakyDynamoDBClient(&down)), newClient(standby), 0)
+		defer f.Close(context.Background())
+		l, err := f.AcquireLock(context.Background(), "leader",
+			WithMaxAttempts(1),
+			WithAdditionalAttributes(map[string]types.AttributeValue{"custom": stringAttrValue("value")}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.FailedOver() || f.Epoch() != 1 {
+			t.Fatal("expected failover to the standby table")
+		}
+		row := standby.row("locksFailover", "leader")
+		if row == nil {
+			t.Fatal("lock not stored in the standby table")
+		}
+		if got := readInt64Attr(row[attrFailoverEpoch]); got != 1 {
+			t.Fatal("epoch not recorded in the lock row:", got)
+		}
+		if _, ok := row["custom"]; !ok {
+			t.Fatal("additional attributes lost")
+		}
+		if released, err := f.ReleaseLock(context.Background(), l); !released || err != nil {
+			t.Fatal("cannot release lock through the standby client:", released, err)
+		}
+	})
+
+	t.Run("failover moves held locks", func(t *testing.T) {
+		var down int32
+		primary := newFlakyDynamoDBClient(&down)
+		standby := newMemoryDynamoDBClient()
+		f := NewFailoverClient(newClient(primary), newClient(standby), 0)
+		defer f.Close(context.Background())
+		before, err := f.AcquireLock(context.Background(), "before", WithData([]byte("data")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		atomic.StoreInt32(&down, 1)
+		if _, err := f.AcquireLock(context.Background(), "after", WithMaxAttempts(1)); err != nil {
+			t.Fatal(err)
+		}
+		if !f.FailedOver() {
+			t.Fatal("expected failover to the standby table")
+		}
+		row := standby.row("locksFailover", "before")
+		if row == nil || readStringAttr(row[attrRecordVersionNumber]) != before.RecordVersionNumber() {
+			t.Fatal("held lock not moved to the standby table:", row)
+		}
+		if string(row[attrData].(*types.AttributeValueMemberB).Value) != "data" {
+			t.Fatal("data of the held lock not moved")
+		}
+		if _, ok := f.primary.locks.Load(before.uniqueIdentifier()); ok {
+			t.Fatal("the primary client should not heartbeat the moved lock")
+		}
+		if _, ok := f.standby.locks.Load(before.uniqueIdentifier()); !ok {
+			t.Fatal("the standby client should heartbeat the moved lock")
+		}
+		if err := f.standby.SendHeartbeat(context.Background(), before); err != nil {
+			t.Fatal("cannot heartbeat the moved lock:", err)
+		}
+		if released, err := f.ReleaseLock(context.Background(), before); !released || err != nil {
+			t.Fatal("cannot release the moved lock:", released, err)
+		}
+		if row := standby.row("locksFailover", "before"); row[attrIsReleased] == nil {
+			t.Fatal("lock not released in the standby table:", row)
+		}
+	})
+
+	t.Run("fail back", func(t *testing.T) {
+		var down int32
+		primary := newFlakyDynamoDBClient(&down)
+		standby := newMemoryDynamoDBClient()
+		f := NewFailoverClient(
+			newClient(primary, WithLeaseDuration(50*time.Millisecond)),
+			newClient(standby, WithLeaseDuration(50*time.Millisecond)),
+			0,
+		)
+		defer f.Close(context.Background())
+		if err := f.FailBack(context.Background()); err != nil {
+			t.Fatal("failing back without a failover should be a no-op:", err)
+		}
+		before, err := f.AcquireLock(context.Background(), "before")
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&down, 1)
+		if _, err := f.AcquireLock(context.Background(), "after", WithMaxAttempts(1)); err != nil {
+			t.Fatal(err)
+		}
+
+		atomic.StoreInt32(&down, 0)
+		if err := f.FailBack(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if f.FailedOver() || f.Epoch() != 2 {
+			t.Fatal("expected fail-back to the primary table")
+		}
+		for _, key := range []string{"before", "after"} {
+			if row := primary.row("locksFailover", key); row == nil || row[attrIsReleased] != nil || readInt64Attr(row[attrFailoverEpoch]) != 2 {
+				t.Fatal("lock not moved back to the primary table:", key, row)
+			}
+			if row := standby.row("locksFailover", key); row[attrIsReleased] == nil {
+				t.Fatal("lock not released in the standby table:", key, row)
+			}
+		}
+		if released, err := f.ReleaseLock(context.Background(), before); !released || err != nil {
+			t.Fatal("cannot release lock through the primary client:", released, err)
+		}
+		if row := primary.row("locksFailover", "before"); row[attrIsReleased] == nil {
+			t.Fatal("lock not released in the primary table:", row)
+		}
+		if _, err := f.AcquireLock(context.Background(), "recovered"); err != nil {
+			t.Fatal(err)
+		}
+		if primary.row("locksFailover", "recovered") == nil {
+			t.Fatal("acquisitions should go to the primary table again")
+		}
+	})
+
+	t.Run("split ownership", func(t *testing.T) {
+		newStandby := func() DynamoDBClient {
+			svc := newMemoryDynamoDBClient()
+			row := heldLockRow("new-leader")
+			row[attrFailoverEpoch] = int64AttrValue(1)
+			svc.putRow("locksFailover", row)
+			return svc
+		}
+		f := NewFailoverClient(
+			newClient(newHeldLockDynamoDBClient("locksFailover", "old-leader")),
+			newClient(newStandby()),
+			time.Minute,
+		)
+		defer f.Close(context.Background())
+		if err := f.CheckSplitOwnership(context.Background(), "leader"); !errors.Is(err, ErrSplitOwnership) {
+			t.Fatal("expected split ownership error:", err)
+		}
+		f = NewFailoverClient(
+			newClient(newMemoryDynamoDBClient()),
+			newClient(newStandby()),
+			time.Minute,
+		)
+		defer f.Close(context.Background())
+		if err := f.CheckSplitOwnership(context.Background(), "leader"); err != nil {
+			t.Fatal("unexpected split ownership error:", err)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func (c *commonClient) handoff(ctx context.Context, lockItem *Lock, successorOwner string) error {
+	if successorOwner == "" {
+		return errors.New("successor owner name cannot be empty")
+	}
+	if c.v2Compatibility {
+		return errors.New("handoffs are not supported with cirello.io/dynamolock/v2")
+	}
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if lockItem.ownerName != c.currentOwnerName() {
+		return ErrOwnerMismatched
+	}
+	ctx = routeToLock(ctx, lockItem)
+
+	lockItem.semaphore.Lock()
+	defer lockItem.semaphore.Unlock()
+
+	if lockItem.isExpired() {
+		return ErrLockAlreadyReleased
+	}
+
+	// The record version number changes, so other waiters start counting
+	// the lease from the handoff.
+	newRvn := c.generateRecordVersionNumber()
+	cond := OwnershipCondition(c.partitionKeyName, lockItem.recordVersionNumber, lockItem.ownerName)
+	update := expression.
+		Set(expression.Name(attrHandoffTo), expression.Value(successorOwner)).
+		Set(rvnAttr, expression.Value(newRvn))
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.getItemKeys(lockItem),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	if err != nil {
+		return parseOwnershipError(err, "cannot hand off lock because it is not owned by this client")
+	}
+	c.logger.Info(ctx, "Handed off ", lockItem.partitionKey, " to ", successorOwner)
+	lockItem.isReleased = true
+	lockItem.recordVersionNumber = newRvn
+	lockItem.handoffTo = successorOwner
+	c.locks.Delete(lockItem.uniqueIdentifier())
+	c.removeKillSessionMonitor(lockItem.uniqueIdentifier())
+	c.coalescer.invalidate(lockItem.uniqueIdentifier(), true)
+	return nil
+}
+
+// isHandedOffTo reports whether the lock row was handed over to this client.
+func (c *commonClient) isHandedOffTo(existingLock *Lock) bool {
+	return !c.v2Compatibility && existingLock.handoffTo != "" && existingLock.handoffTo == c.currentOwnerName()
+}
+
+// acquireHandoff takes over the lock row handed over to this client, without
+// waiting for the lease of the previous owner.
+func (c *commonClient) acquireHandoff(ctx context.Context, getLockOptions *getLockOptions, existingLock *Lock, newLockData []byte, item map[string]types.AttributeValue, recordVersionNumber string) (*Lock, error) {
+	getLockOptions.acquisitionKind = AcquisitionHandoff
+	getLockOptions.handoffFrom = existingLock.ownerName
+	c.logger.Info(ctx, "Acquiring ", getLockOptions.partitionKey, " handed off by ", existingLock.ownerName)
+	l, err := c.upsertAndMonitorExpiredLock(
+		ctx,
+		getLockOptions.additionalAttributes,
+		getLockOptions.partitionKey,
+		getLockOptions.sortKey,
+		getLockOptions.deleteLockOnRelease,
+		existingLock, newLockData, item,
+		recordVersionNumber,
+		getLockOptions.sessionMonitor)
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		c.recordMetric(MetricConditionalFailure, getLockOptions.partitionKey, getLockOptions.sortKey, 0)
+		getLockOptions.conflicted = true
+		return nil, nil
+	}
+	return l, err
+}
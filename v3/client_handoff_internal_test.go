@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestHandoff(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	newClient := func(owner string) *Client {
+		c, err := New(svc, "locksHandoff", "key", DisableHeartbeat(), WithOwnerName(owner))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	holder, successor, other := newClient("old"), newClient("new"), newClient("other")
+
+	l, err := holder.AcquireLock(context.Background(), "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Handoff(context.Background(), "new"); err != nil {
+		t.Fatal(err)
+	}
+	if updates := svc.updateInputs(); len(updates) != 1 || !hasStringValue(updates[0].ExpressionAttributeValues, "new") {
+		t.Fatalf("unexpected handoff update: %#v", updates)
+	}
+	if got := readStringAttr(svc.row("locksHandoff", "leader")[attrHandoffTo]); got != "new" {
+		t.Fatal("handoff mark not written:", got)
+	}
+	if !l.IsExpired() {
+		t.Fatal("handed off lock should be expired")
+	}
+	if _, ok := holder.locks.Load(l.uniqueIdentifier()); ok {
+		t.Fatal("handed off lock should no longer be tracked")
+	}
+	if err := l.Handoff(context.Background(), "new"); err != ErrLockAlreadyReleased {
+		t.Fatal("locks can be handed off only once:", err)
+	}
+	var nilLock *Lock
+	if err := nilLock.Handoff(context.Background(), "new"); err != ErrCannotHandoffNullLock {
+		t.Fatal("unexpected error handing off a nil lock:", err)
+	}
+
+	if _, err := other.AcquireLock(context.Background(), "leader", FailIfLocked()); !IsContention(err) {
+		t.Fatal("only the successor should skip the lease wait:", err)
+	}
+	successorLock, err := successor.AcquireLock(context.Background(), "leader", FailIfLocked())
+	if err != nil {
+		t.Fatal("successor should acquire the lock right away:", err)
+	}
+	if got := successorLock.Acquisition(); got.Kind != AcquisitionHandoff || got.HandoffFrom != "old" {
+		t.Fatalf("handoff should be recorded: %#v", got)
+	}
+	if _, ok := svc.row("locksHandoff", "leader")[attrHandoffTo]; ok {
+		t.Fatal("handoff mark should be cleared by the successor")
+	}
+}
+
+func hasStringValue(values map[string]types.AttributeValue, value string) bool {
+	for _, v := range values {
+		if readStringAttr(v) == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const healthRecentFailures = 10
+
+// Possible values of Health.Status.
+const (
+	HealthStatusOpen     = "open"
+	HealthStatusDraining = "draining"
+	HealthStatusClosed   = "closed"
+)
+
+// Health is a snapshot of the client status, meant for readiness and liveness
+// probes.
+type Health struct {
+	// Status is one of HealthStatusOpen, HealthStatusDraining or
+	// HealthStatusClosed.
+	Status string
+	// HeldLocks is the number of locks held by this client.
+	HeldLocks int
+	// LastHeartbeat is the moment of the last successful heartbeat. It is
+	// zero if no heartbeat was sent yet.
+	LastHeartbeat time.Time
+	// LastHeartbeatAge is the time elapsed since the last successful
+	// heartbeat.
+	LastHeartbeatAge time.Duration
+	// RecentFailures lists the most recent failures of the automatic
+	// heartbeats, oldest first.
+	RecentFailures []HealthFailure
+}
+
+// Healthy reports whether the client is open and its last heartbeat, if any
+// failed, has succeeded since.
+func (h Health) Healthy() bool {
+	if h.Status != HealthStatusOpen {
+		return false
+	}
+	if len(h.RecentFailures) == 0 {
+		return true
+	}
+	return h.LastHeartbeat.After(h.RecentFailures[len(h.RecentFailures)-1].Time)
+}
+
+// HealthFailure describes a failure of the automatic heartbeats.
+type HealthFailure struct {
+	Time         time.Time `json:"time"`
+	PartitionKey string    `json:"partitionKey"`
+	SortKey      string    `json:"sortKey,omitempty"`
+	Error        string    `json:"error"`
+}
+
+type healthState struct {
+	mu             sync.Mutex
+	lastHeartbeat  time.Time
+	recentFailures []HealthFailure
+}
+
+func (c *commonClient) recordHeartbeat(t time.Time) {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	if t.After(c.health.lastHeartbeat) {
+		c.health.lastHeartbeat = t
+	}
+}
+
+func (c *commonClient) recordHeartbeatFailure(lockItem *Lock, err error) {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	if len(c.health.recentFailures) == healthRecentFailures {
+		c.health.recentFailures = c.health.recentFailures[1:]
+	}
+	c.health.recentFailures = append(c.health.recentFailures, HealthFailure{
+		Time:         c.now(),
+		PartitionKey: lockItem.partitionKey,
+		SortKey:      lockItem.sortKey,
+		Error:        err.Error(),
+	})
+}
+
+// Health returns a snapshot of the client status.
+func (c *commonClient) Health() Health {
+	var h Health
+	c.mu.RLock()
+	switch {
+	case c.closed:
+		h.Status = HealthStatusClosed
+	case c.isDraining():
+		h.Status = HealthStatusDraining
+	default:
+		h.Status = HealthStatusOpen
+	}
+	c.mu.RUnlock()
+	c.locks.Range(func(_, _ interface{}) bool {
+		h.HeldLocks++
+		return true
+	})
+	c.health.mu.Lock()
+	h.LastHeartbeat = c.health.lastHeartbeat
+	h.RecentFailures = append([]HealthFailure(nil), c.health.recentFailures...)
+	c.health.mu.Unlock()
+	if !h.LastHeartbeat.IsZero() {
+		h.LastHeartbeatAge = c.now().Sub(h.LastHeartbeat)
+	}
+	return h
+}
+
+// HealthReporter is implemented by the lock clients.
+type HealthReporter interface {
+	Health() Health
+}
+
+// HealthHandler returns a http.Handler that reports the status of the client
+// as JSON. It responds with 200 OK if the client is healthy, and with 503
+// Service Unavailable otherwise, so it can be used directly as a readiness or
+// liveness probe.
+func HealthHandler(c HealthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := c.Health()
+		body := struct {
+			Status                  string          `json:"status"`
+			Healthy                 bool            `json:"healthy"`
+			HeldLocks               int             `json:"heldLocks"`
+			LastHeartbeat           *time.Time      `json:"lastHeartbeat,omitempty"`
+			LastHeartbeatAgeSeconds float64         `json:"lastHeartbeatAgeSeconds,omitempty"`
+			RecentFailures          []HealthFailure `json:"recentFailures,omitempty"`
+		}{
+			Status:         h.Status,
+			Healthy:        h.Healthy(),
+			HeldLocks:      h.HeldLocks,
+			RecentFailures: h.RecentFailures,
+		}
+		if !h.LastHeartbeat.IsZero() {
+			body.LastHeartbeat = &h.LastHeartbeat
+			body.LastHeartbeatAgeSeconds = h.LastHeartbeatAge.Seconds()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !body.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
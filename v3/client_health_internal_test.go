@@ -0,0 +1,85 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c, err := New(&mockDynamoDBClient{}, "locksHealth", "key", DisableHeartbeat(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type response struct {
+		Status                  string          `json:"status"`
+		Healthy                 bool            `json:"healthy"`
+		HeldLocks               int             `json:"heldLocks"`
+		LastHeartbeatAgeSeconds float64         `json:"lastHeartbeatAgeSeconds"`
+		RecentFailures          []HealthFailure `json:"recentFailures"`
+	}
+	probe := func() (int, response) {
+		rec := httptest.NewRecorder()
+		HealthHandler(c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var r response
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, r
+	}
+
+	l, err := c.AcquireLock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(3 * time.Second)
+	code, r := probe()
+	if code != http.StatusOK || r.Status != HealthStatusOpen || !r.Healthy || r.HeldLocks != 1 || r.LastHeartbeatAgeSeconds != 3 {
+		t.Fatalf("unexpected healthy response: %d %#v", code, r)
+	}
+
+	c.reportHeartbeatError(l, errors.New("network down"))
+	code, r = probe()
+	if code != http.StatusServiceUnavailable || r.Healthy || len(r.RecentFailures) != 1 || r.RecentFailures[0].PartitionKey != "key" {
+		t.Fatalf("unexpected response after failure: %d %#v", code, r)
+	}
+
+	clock.Advance(time.Second)
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if code, r = probe(); code != http.StatusOK || !r.Healthy {
+		t.Fatalf("unexpected response after recovery: %d %#v", code, r)
+	}
+
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, r = probe(); code != http.StatusServiceUnavailable || r.Status != HealthStatusClosed {
+		t.Fatalf("unexpected response after close: %d %#v", code, r)
+	}
+}
@@ -19,11 +19,13 @@ package dynamolock
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 // SendHeartbeatOption allows to proceed with Lock content changes in the
@@ -33,6 +35,7 @@ type SendHeartbeatOption func(*sendHeartbeatOptions)
 type sendHeartbeatOptions struct {
 	lockItem   *Lock
 	data       []byte
+	dataValue  interface{}
 	deleteData bool
 }
 
@@ -51,6 +54,149 @@ func ReplaceHeartbeatData(data []byte) SendHeartbeatOption {
 	}
 }
 
+const heartbeatErrorsBuffer = 64
+
+// HeartbeatError reports a failure of the automatic heartbeats.
+type HeartbeatError struct {
+	// Lock is the lock whose heartbeat failed.
+	Lock *Lock
+	// Err is the cause of the failure. If it is a LockNotGrantedError, the
+	// lock was lost and it is no longer heartbeated.
+	Err error
+}
+
+func (e HeartbeatError) Error() string {
+	return "cannot send heartbeat to " + e.Lock.PartitionKey() + ": " + e.Err.Error()
+}
+
+// Unwrap reveals the underlying cause of the heartbeat failure.
+func (e HeartbeatError) Unwrap() error {
+	return e.Err
+}
+
+// HeartbeatErrors returns a channel that receives the failures of the
+// automatic heartbeats. The channel is bounded and never blocks the
+// heartbeats: errors are dropped when it is full. It is closed when the client
+// is closed.
+func (c *commonClient) HeartbeatErrors() <-chan HeartbeatError {
+	c.runMu.RLock()
+	defer c.runMu.RUnlock()
+	return c.heartbeatErrors
+}
+
+func (c *commonClient) reportHeartbeatError(lockItem *Lock, err error) {
+	c.recordHeartbeatFailure(lockItem, err)
+	c.runMu.RLock()
+	heartbeatErrors := c.heartbeatErrors
+	c.runMu.RUnlock()
+	select {
+	case heartbeatErrors <- HeartbeatError{Lock: lockItem, Err: err}:
+	default:
+	}
+}
+
+// ErrOwnershipLost is the cause of the heartbeat failures that prove the lock
+// no longer belongs to this client: the conditional update of the lock row
+// failed, or the updated row carries another owner or record version number.
+// Other heartbeat failures are transient, and the lock is still heartbeated.
+var ErrOwnershipLost = errors.New("lock ownership lost")
+
+type ownershipLostError struct {
+	cause error
+}
+
+func (e *ownershipLostError) Error() string {
+	if e.cause == nil {
+		return ErrOwnershipLost.Error()
+	}
+	return ErrOwnershipLost.Error() + ": " + e.cause.Error()
+}
+
+func (e *ownershipLostError) Is(target error) bool {
+	return target == ErrOwnershipLost
+}
+
+func (e *ownershipLostError) Unwrap() error {
+	return e.cause
+}
+
+// WithOwnershipLostCallback registers a callback that is called, on its own
+// goroutine, when a heartbeat proves that the lock was lost, with the error
+// the heartbeat failed with. Unlike WithSessionMonitor, which only knows that
+// the lease is running out, it is only called once the lock row is known to
+// belong to someone else or to no longer exist.
+func WithOwnershipLostCallback(fn func(*Lock, error)) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.ownershipLostCallback = fn
+	}
+}
+
+// loseOwnership forgets a lock that is known to be lost. Callers must hold
+// lockItem.semaphore.
+func (c *commonClient) loseOwnership(lockItem *Lock, err error) {
+	c.locks.Delete(lockItem.uniqueIdentifier())
+	if fn := lockItem.ownershipLostCallback; fn != nil {
+		c.runCallback(func() { fn(lockItem, err) })
+	}
+}
+
+// checkOwnership confirms that the lock row returned by a heartbeat is the one
+// it wrote, as the update is conditioned on it.
+func (c *commonClient) checkOwnership(attributes map[string]types.AttributeValue, rvn string) error {
+	owner, hasOwner := attributes[attrOwnerName]
+	storedRvn, hasRvn := attributes[attrRecordVersionNumber]
+	if hasOwner && readStringAttr(owner) != c.currentOwnerName() || hasRvn && readStringAttr(storedRvn) != rvn {
+		return &LockNotGrantedError{
+			msg: "lock row changed hands, stopping heartbeats",
+			cause: &ownershipLostError{
+				cause: fmt.Errorf("owned by %q with record version number %q", readStringAttr(owner), readStringAttr(storedRvn)),
+			},
+		}
+	}
+	return nil
+}
+
+// WithHeartbeatData makes the automatic heartbeats publish a new data payload
+// atomically with the record version number refresh, so leaders can share
+// their progress with followers. fn is called before each heartbeat; if it
+// returns nil, the data of the lock is kept as-is.
+func WithHeartbeatData(fn func(*Lock) []byte) ClientOption {
+	return func(c *commonClient) { c.heartbeatData = fn }
+}
+
+// WithLeaseExtender makes every heartbeat of the lock consult fn, which reports
+// how long the workload still needs the lock for. If it needs more than the
+// lease duration of the client, the lease is extended accordingly, up to
+// maxLeaseDuration. fn is called without holding any internal locks.
+func WithLeaseExtender(fn func() time.Duration, maxLeaseDuration time.Duration) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.leaseExtender = fn
+		opt.maxLeaseDuration = maxLeaseDuration
+	}
+}
+
+func (c *commonClient) extendedLeaseDuration(lockItem *Lock) time.Duration {
+	lockItem.semaphore.Lock()
+	extender, maxLeaseDuration := lockItem.leaseExtender, lockItem.maxLeaseDuration
+	lockItem.semaphore.Unlock()
+
+	minLeaseDuration := c.currentLeaseDuration()
+	leaseDuration := minLeaseDuration
+	if extender == nil {
+		return leaseDuration
+	}
+	if needed := extender(); needed > leaseDuration {
+		leaseDuration = needed
+		if leaseDuration > maxLeaseDuration {
+			leaseDuration = maxLeaseDuration
+		}
+	}
+	if leaseDuration < minLeaseDuration {
+		return minLeaseDuration
+	}
+	return leaseDuration
+}
+
 // SendHeartbeat indicates that the given lock is still being worked
 // on. If using WithHeartbeatPeriod > 0 when setting up this object, then this
 // method is unnecessary, because the background thread will be periodically
@@ -67,19 +213,89 @@ func (c *commonClient) SendHeartbeat(ctx context.Context, lockItem *Lock, opts .
 	for _, opt := range opts {
 		opt(sho)
 	}
+	if sho.dataValue != nil {
+		data, err := c.marshalData(sho.dataValue)
+		if err != nil {
+			return err
+		}
+		sho.data = data
+	}
 	return c.sendHeartbeat(ctx, sho)
 }
 
-func (c *commonClient) sendHeartbeat(ctx context.Context, options *sendHeartbeatOptions) error {
-	leaseDuration := c.leaseDuration
+// SendHeartbeats sends a heartbeat to each lock held by this client for which
+// filter returns true, so a subset of the locks can be kept fresh while others
+// are intentionally let lapse. A nil filter selects all locks; locks whose
+// heartbeats are delegated (see Lock.StartDelegation) are skipped. The data of the
+// locks is refreshed as the automatic heartbeats do (see WithHeartbeatData).
+// The failed heartbeats are returned as LockErrors. The given context is
+// passed down to the underlying dynamoDB calls.
+func (c *commonClient) SendHeartbeats(ctx context.Context, filter func(*Lock) bool) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	errs := make(LockErrors)
+	c.locks.Range(func(_ interface{}, value interface{}) bool {
+		lockItem := value.(*Lock)
+		if filter != nil && !filter(lockItem) || lockItem.heartbeatsDelegated() {
+			return true
+		}
+		if err := c.sendHeartbeat(ctx, c.heartbeatOptions(lockItem)); err != nil {
+			errs[lockItem] = err
+		}
+		return ctx.Err() == nil
+	})
+	return errs.orNil()
+}
+
+// WithHeartbeatFilter restricts the automatic heartbeats to the locks for
+// which filter returns true. The other locks are not heartbeated, and expire
+// once their lease duration elapses unless heartbeats are sent to them by
+// other means. filter is called before every heartbeat, so it can change its
+// selection over time, for example to let go of the locks of a subsystem
+// being drained.
+func WithHeartbeatFilter(filter func(*Lock) bool) ClientOption {
+	return func(c *commonClient) { c.heartbeatFilter = filter }
+}
 
+func (c *commonClient) heartbeatOptions(lockItem *Lock) *sendHeartbeatOptions {
+	opts := &sendHeartbeatOptions{lockItem: lockItem}
+	if c.heartbeatData != nil {
+		if data := c.heartbeatData(lockItem); data != nil {
+			opts.data = data
+		}
+	}
+	return opts
+}
+
+func (c *commonClient) sendHeartbeat(ctx context.Context, options *sendHeartbeatOptions) (err error) {
 	lockItem := options.lockItem
+	ctx, endSpan := c.startSpan(ctx, SpanSendHeartbeat, lockItem.uniqueIdentifier())
+	defer func() { endSpan(err) }()
+	if c.auditLog != nil {
+		start := c.now()
+		defer func() {
+			c.audit(ctx, AuditHeartbeat, lockItem.uniqueIdentifier(), lockItem, start, err)
+		}()
+	}
+	leaseDuration := c.extendedLeaseDuration(lockItem)
+	ctx = routeToLock(ctx, lockItem)
+
 	lockItem.semaphore.Lock()
 	defer lockItem.semaphore.Unlock()
 
-	if lockItem.isExpired() || lockItem.ownerName != c.ownerName || lockItem.isReleased {
+	// The lease of a delegated lock is kept by its delegate, even if it
+	// looks expired from here.
+	if lockItem.delegated {
+		return ErrLockDelegated
+	}
+	if lockItem.isExpired() || lockItem.ownerName != c.currentOwnerName() || lockItem.isReleased {
+		c.locks.Delete(lockItem.uniqueIdentifier())
+		return &LockNotGrantedError{msg: "cannot send heartbeat because lock is not granted", cause: &ownershipLostError{}}
+	}
+	if lockItem.preemptionNoticeElapsed() {
 		c.locks.Delete(lockItem.uniqueIdentifier())
-		return &LockNotGrantedError{msg: "cannot send heartbeat because lock is not granted"}
+		return &LockNotGrantedError{msg: "cannot send heartbeat because notice period is over", cause: ErrLockPreempted}
 	}
 
 	// Set up condition for UpdateItem. Basically any changes require:
@@ -89,15 +305,24 @@ func (c *commonClient) sendHeartbeat(ctx context.Context, options *sendHeartbeat
 
 	newRvn := c.generateRecordVersionNumber()
 
-	cond := ownershipLockCondition(c.partitionKeyName, lockItem.recordVersionNumber, lockItem.ownerName)
+	cond := OwnershipCondition(c.partitionKeyName, lockItem.recordVersionNumber, lockItem.ownerName)
 	update := expression.
-		Set(leaseDurationAttr, expression.Value(leaseDuration.String())).
+		Set(leaseDurationAttr, expression.Value(c.leaseDurationValue(leaseDuration))).
 		Set(rvnAttr, expression.Value(newRvn))
+	if !c.v2Compatibility {
+		update = update.Set(expiresAtAttr, expression.Value(c.expiresAt(leaseDuration)))
+	}
 
+	var history [][]byte
 	if options.deleteData {
-		update.Remove(dataAttr)
+		update = update.Remove(dataAttr)
+		history = c.nextDataHistory(lockItem, nil)
 	} else if len(options.data) > 0 {
-		update.Set(dataAttr, expression.Value(options.data))
+		update = update.Set(dataAttr, expression.Value(options.data))
+		history = c.nextDataHistory(lockItem, options.data)
+	}
+	if len(history) > 0 {
+		update = update.Set(expression.Name(attrDataHistory), expression.Value(dataHistoryAttrValue(history)))
 	}
 	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
 
@@ -108,20 +333,45 @@ func (c *commonClient) sendHeartbeat(ctx context.Context, options *sendHeartbeat
 		UpdateExpression:          updateExpr.Update(),
 		ExpressionAttributeNames:  updateExpr.Names(),
 		ExpressionAttributeValues: updateExpr.Values(),
+		ReturnValues:              types.ReturnValueAllNew,
 	}
 
-	lastUpdateOfLock := time.Now()
+	lastUpdateOfLock := c.now()
 
-	_, err := c.dynamoDB.UpdateItem(ctx, updateItemInput)
-	if err != nil {
-		err := parseDynamoDBError(err, "already acquired lock, stopping heartbeats")
-		var errNotGranted *LockNotGrantedError
-		if errors.As(err, &errNotGranted) {
-			c.locks.Delete(lockItem.uniqueIdentifier())
+	updateItemOutput, err := c.dynamoDB.UpdateItem(ctx, updateItemInput)
+	if isOwnershipLost(err) {
+		err := &LockNotGrantedError{
+			msg:   "already acquired lock, stopping heartbeats",
+			cause: &ownershipLostError{cause: err},
 		}
+		c.loseOwnership(lockItem, err)
+		return err
+	} else if err != nil {
 		return err
 	}
+	if updateItemOutput != nil {
+		if err := c.checkOwnership(updateItemOutput.Attributes, newRvn); err != nil {
+			c.loseOwnership(lockItem, err)
+			return err
+		}
+	}
 
 	lockItem.updateRVN(newRvn, lastUpdateOfLock, leaseDuration)
+	c.recordHeartbeat(lastUpdateOfLock)
+	if options.deleteData {
+		lockItem.data = nil
+	} else if len(options.data) > 0 {
+		lockItem.data = options.data
+	}
+	if len(history) > 0 {
+		lockItem.dataHistory = history
+	}
+	if updateItemOutput != nil && !c.v2Compatibility {
+		c.checkPreemptionRequest(lockItem, updateItemOutput.Attributes)
+		c.checkIntent(lockItem, updateItemOutput.Attributes)
+		c.checkReleaseRequest(lockItem, updateItemOutput.Attributes)
+		lockItem.waiters = readInt64Attr(updateItemOutput.Attributes[attrWaiterCount])
+		lockItem.inbox = readInboxAttr(updateItemOutput.Attributes[attrWaiterInbox])
+	}
 	return nil
 }
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestHeartbeatOwnershipLost(t *testing.T) {
+	for name, tc := range map[string]struct {
+		// afterAcquire changes the lock row once the lock is acquired.
+		afterAcquire func(svc *memoryDynamoDBClient)
+		lost         bool
+	}{
+		"conditional failure": {
+			afterAcquire: func(svc *memoryDynamoDBClient) {
+				svc.deleteRow("locksOwnershipLost", "lost")
+			},
+			lost: true,
+		},
+		"changed hands": {
+			afterAcquire: func(svc *memoryDynamoDBClient) {
+				svc.putRow("locksOwnershipLost", map[string]types.AttributeValue{
+					"key":                   stringAttrValue("lost"),
+					attrOwnerName:           stringAttrValue("thief"),
+					attrLeaseDuration:       stringAttrValue("20s"),
+					attrRecordVersionNumber: stringAttrValue("rvn"),
+				})
+			},
+			lost: true,
+		},
+		"transient": {
+			afterAcquire: func(svc *memoryDynamoDBClient) {
+				svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+					if op == "UpdateItem" {
+						return nil, &types.ProvisionedThroughputExceededException{}
+					}
+					return next()
+				})
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			svc := newMemoryDynamoDBClient()
+			c, err := New(svc, "locksOwnershipLost", "key", DisableHeartbeat())
+			if err != nil {
+				t.Fatal(err)
+			}
+			lost := make(chan error, 1)
+			l, err := c.AcquireLock(context.Background(), "lost", WithOwnershipLostCallback(func(_ *Lock, err error) {
+				lost <- err
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.afterAcquire(svc)
+			err = c.SendHeartbeat(context.Background(), l)
+			if err == nil {
+				t.Fatal("expected heartbeat error")
+			}
+			if got := errors.Is(err, ErrOwnershipLost); got != tc.lost {
+				t.Fatal("unexpected heartbeat error classification:", err)
+			}
+			_, tracked := c.locks.Load(l.uniqueIdentifier())
+			if tracked == tc.lost {
+				t.Fatal("only lost locks should be forgotten:", tracked)
+			}
+			select {
+			case got := <-lost:
+				if !tc.lost || got != err {
+					t.Fatal("unexpected ownership lost report:", got)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tc.lost {
+					t.Fatal("ownership lost callback not called")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithPreWriteHook registers a function invoked right before every PutItem
+// and UpdateItem the client makes. The attributes it adds to the given map
+// are written along with the lock, so fleet-wide metadata (hostname, deploy
+// version, trace ID) does not have to be passed with WithAdditionalAttributes
+// at every call site. For PutItem, the map holds the additional attributes of
+// the lock, which can be adjusted. Attributes maintained by the client itself
+// are never overwritten.
+func WithPreWriteHook(fn func(ctx context.Context, attrs map[string]types.AttributeValue)) ClientOption {
+	return func(c *commonClient) { c.preWriteHook = fn }
+}
+
+func (c *commonClient) preWriteMiddleware(next Operation) Operation {
+	return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		switch in := input.(type) {
+		case *dynamodb.PutItemInput:
+			c.hookPutItem(ctx, in)
+		case *dynamodb.UpdateItemInput:
+			c.hookUpdateItem(ctx, in)
+		}
+		return next(ctx, name, input)
+	}
+}
+
+func (c *commonClient) isReservedAttribute(name string) bool {
+	if releasedAttribute, _ := c.releasedMarker(); name == releasedAttribute {
+		return true
+	}
+	for _, k := range c.reservedAttributes() {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *commonClient) hookPutItem(ctx context.Context, in *dynamodb.PutItemInput) {
+	attrs := make(map[string]types.AttributeValue)
+	for k, v := range in.Item {
+		if !c.isReservedAttribute(k) {
+			attrs[k] = v
+		}
+	}
+	c.preWriteHook(ctx, attrs)
+	item := make(map[string]types.AttributeValue, len(in.Item)+len(attrs))
+	for k, v := range in.Item {
+		if c.isReservedAttribute(k) {
+			item[k] = v
+		}
+	}
+	for k, v := range attrs {
+		if !c.isReservedAttribute(k) {
+			item[k] = v
+		}
+	}
+	in.Item = item
+}
+
+func (c *commonClient) hookUpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput) {
+	attrs := make(map[string]types.AttributeValue)
+	c.preWriteHook(ctx, attrs)
+	updated := make(map[string]bool)
+	for _, name := range in.ExpressionAttributeNames {
+		updated[name] = true
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		if !c.isReservedAttribute(k) && !updated[k] {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 || in.UpdateExpression == nil {
+		return
+	}
+	sort.Strings(keys)
+
+	names := make(map[string]string, len(in.ExpressionAttributeNames)+len(keys))
+	for k, v := range in.ExpressionAttributeNames {
+		names[k] = v
+	}
+	values := make(map[string]types.AttributeValue, len(in.ExpressionAttributeValues)+len(keys))
+	for k, v := range in.ExpressionAttributeValues {
+		values[k] = v
+	}
+	assignments := make([]string, len(keys))
+	for i, k := range keys {
+		name, value := fmt.Sprintf("#hook%d", i), fmt.Sprintf(":hook%d", i)
+		names[name] = k
+		values[value] = attrs[k]
+		assignments[i] = name + " = " + value
+	}
+	in.ExpressionAttributeNames = names
+	in.ExpressionAttributeValues = values
+	in.UpdateExpression = injectSetAssignments(*in.UpdateExpression, strings.Join(assignments, ", "))
+}
+
+// injectSetAssignments adds the assignments to the SET clause of the update
+// expression, creating it if needed, as DynamoDB does not allow repeated
+// clauses.
+func injectSetAssignments(update, assignments string) *string {
+	for i := 0; i+4 <= len(update); i++ {
+		if (i == 0 || update[i-1] == '\n' || update[i-1] == ' ') && update[i:i+4] == "SET " {
+			s := update[:i+4] + assignments + ", " + update[i+4:]
+			return &s
+		}
+	}
+	s := "SET " + assignments + "\n" + update
+	return &s
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestPreWriteHook(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksPreWriteHook", "key",
+		DisableHeartbeat(),
+		WithOwnerName("owner"),
+		WithPreWriteHook(func(ctx context.Context, attrs map[string]types.AttributeValue) {
+			attrs["hostname"] = stringAttrValue("host-1")
+			attrs[attrOwnerName] = stringAttrValue("impostor")
+			delete(attrs, "toRemove")
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	_, err = c.AcquireLock(context.Background(), "key", WithAdditionalAttributes(map[string]types.AttributeValue{
+		"toRemove": stringAttrValue("value"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := svc.putInputs()[0].Item
+	if got := readStringAttr(item["hostname"]); got != "host-1" {
+		t.Error("hook attribute not written:", got)
+	}
+	if got := readStringAttr(item[attrOwnerName]); got != "owner" {
+		t.Error("hook must not overwrite reserved attributes:", got)
+	}
+	if _, ok := item["toRemove"]; ok {
+		t.Error("hook could not adjust additional attributes")
+	}
+
+	in := &dynamodb.UpdateItemInput{
+		UpdateExpression:          aws.String("REMOVE #0\nSET #1 = :0\n"),
+		ExpressionAttributeNames:  map[string]string{"#0": attrData, "#1": attrLeaseDuration},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":0": stringAttrValue("20s")},
+	}
+	c.hookUpdateItem(context.Background(), in)
+	if got, want := aws.ToString(in.UpdateExpression), "REMOVE #0\nSET #hook0 = :hook0, #1 = :0\n"; got != want {
+		t.Errorf("unexpected update expression: got %q, want %q", got, want)
+	}
+	if in.ExpressionAttributeNames["#hook0"] != "hostname" || readStringAttr(in.ExpressionAttributeValues[":hook0"]) != "host-1" {
+		t.Error("hook attribute not added to the update")
+	}
+	if len(in.ExpressionAttributeNames) != 3 {
+		t.Error("reserved attributes must not be added to the update:", in.ExpressionAttributeNames)
+	}
+
+	in = &dynamodb.UpdateItemInput{UpdateExpression: aws.String("REMOVE #0\n")}
+	c.hookUpdateItem(context.Background(), in)
+	if got, want := aws.ToString(in.UpdateExpression), "SET #hook0 = :hook0\nREMOVE #0\n"; got != want {
+		t.Errorf("unexpected update expression: got %q, want %q", got, want)
+	}
+}
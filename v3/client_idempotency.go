@@ -0,0 +1,65 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "context"
+
+// WithAcquisitionToken sets the idempotency token written with the lock row by
+// the acquisition. The acquisition recognizes a row carrying its token and the
+// owner name of the client as its own write, and returns the lock instead of
+// waiting for it to expire. It covers writes that succeeded even though they
+// were reported as failed, like timeouts or retries of the AWS SDK that hit the
+// condition set by the first attempt.
+//
+// By default, each AcquireLock call generates its own token, which covers the
+// attempts made within the call. Callers that retry AcquireLock after an
+// ambiguous failure should pass the same token to all the calls, so a retry
+// returns the lock the previous call acquired. Tokens are not written with
+// WithV2Compatibility.
+func WithAcquisitionToken(token string) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.acquisitionToken = token
+	}
+}
+
+// adoptOwnAcquisition starts tracking existingLock if it carries the
+// acquisition token and the owner name of the client and its lease has not
+// run out. Otherwise, it returns nil.
+func (c *commonClient) adoptOwnAcquisition(ctx context.Context, getLockOptions *getLockOptions, existingLock *Lock) *Lock {
+	if existingLock == nil || existingLock.isReleased || getLockOptions.acquisitionToken == "" ||
+		existingLock.acquisitionToken != getLockOptions.acquisitionToken || existingLock.ownerName != c.currentOwnerName() {
+		return nil
+	}
+	// The lease started when the row was written, which might be long
+	// before it was read.
+	leaseStart := existingLock.lookupTime
+	if !existingLock.expiresAt.IsZero() {
+		leaseStart = existingLock.expiresAt.Add(-existingLock.leaseDuration)
+	}
+	if existingLock.now().Sub(leaseStart) > existingLock.leaseDuration+getLockOptions.expiryGrace {
+		// The lease of the own write ran out: the row is taken over like
+		// any other expired lock, which writes a new record version
+		// number.
+		return nil
+	}
+	c.logger.Info(ctx, "Found own acquisition of ",
+		c.partitionKeyName, "=", getLockOptions.partitionKey, " ", c.sortKeyName, "=", getLockOptions.sortKey)
+	existingLock.lookupTime = leaseStart
+	existingLock.sessionMonitor = getLockOptions.sessionMonitor
+	c.startOwnedLock(existingLock)
+	return existingLock
+}
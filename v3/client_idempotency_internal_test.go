@@ -0,0 +1,146 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// newAmbiguousWriteDynamoDBClient returns an in-memory DynamoDB that stores
+// the lock rows but reports the writes as failed with *err, as if the
+// responses were lost.
+func newAmbiguousWriteDynamoDBClient(err *error) *memoryDynamoDBClient {
+	svc := newMemoryDynamoDBClient()
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if op != "PutItem" {
+			return next()
+		}
+		if _, nextErr := next(); nextErr != nil {
+			return nil, nextErr
+		}
+		return nil, *err
+	})
+	return svc
+}
+
+func TestAcquisitionToken(t *testing.T) {
+	t.Run("retried write", func(t *testing.T) {
+		var writeErr error = &types.ConditionalCheckFailedException{}
+		svc := newAmbiguousWriteDynamoDBClient(&writeErr)
+		c, err := New(svc, "locksIdempotency", "key", DisableHeartbeat(), WithOwnerName("owner"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "token",
+			WithRefreshPeriod(time.Millisecond),
+			WithAdditionalTimeToWaitForLock(time.Millisecond),
+		)
+		if err != nil {
+			t.Fatal("own write should be recognized:", err)
+		}
+		if l.recordVersionNumber != readStringAttr(svc.row("locksIdempotency", "token")[attrRecordVersionNumber]) {
+			t.Fatal("unexpected record version number")
+		}
+		if _, ok := l.AdditionalAttributes()[attrAcquisitionToken]; ok {
+			t.Fatal("acquisition token should not be exposed")
+		}
+	})
+	t.Run("retried call", func(t *testing.T) {
+		writeErr := errors.New("timeout")
+		svc := newAmbiguousWriteDynamoDBClient(&writeErr)
+		c, err := New(svc, "locksIdempotency", "key", DisableHeartbeat(), WithOwnerName("owner"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.AcquireLock(context.Background(), "token", WithAcquisitionToken("token-1"), FailIfLocked()); err == nil {
+			t.Fatal("expected failure")
+		}
+		writeErr = &types.ConditionalCheckFailedException{}
+		if _, err := c.AcquireLock(context.Background(), "token", WithAcquisitionToken("token-2"), FailIfLocked()); !IsContention(err) {
+			t.Fatal("other tokens should not adopt the lock:", err)
+		}
+		l, err := c.AcquireLock(context.Background(), "token", WithAcquisitionToken("token-1"), FailIfLocked())
+		if err != nil {
+			t.Fatal("retried call should return the lock:", err)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); !ok {
+			t.Fatal("adopted lock should be tracked")
+		}
+	})
+}
+
+func TestAcquisitionTokenExpiredLease(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	var puts int32
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if op != "PutItem" || atomic.AddInt32(&puts, 1) > 1 {
+			return next()
+		}
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("timeout")
+	})
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	c, err := New(svc, "locksIdempotency", "key",
+		DisableHeartbeat(),
+		WithOwnerName("owner"),
+		WithLeaseDuration(10*time.Second),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "token", WithAcquisitionToken("token-1"), FailIfLocked()); err == nil {
+		t.Fatal("expected failure")
+	}
+	written := readStringAttr(svc.row("locksIdempotency", "token")[attrRecordVersionNumber])
+	clock.Advance(time.Minute)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				clock.Advance(time.Second)
+			}
+		}
+	}()
+	l, err := c.AcquireLock(context.Background(), "token",
+		WithAcquisitionToken("token-1"),
+		WithRefreshPeriod(time.Millisecond),
+		WithAdditionalTimeToWaitForLock(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.recordVersionNumber == written {
+		t.Fatal("the expired own write should not be adopted")
+	}
+	if l.recordVersionNumber != readStringAttr(svc.row("locksIdempotency", "token")[attrRecordVersionNumber]) {
+		t.Fatal("the expired own write should be taken over")
+	}
+}
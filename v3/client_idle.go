@@ -0,0 +1,77 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"time"
+)
+
+// WithDone ties the lock to the lifecycle of the critical section that uses
+// it: done must be closed when the critical section finishes, for example by
+// passing the Done channel of the job's context. Combined with
+// WithIdleLockHook, locks that are still held long after their critical
+// section finished are reported.
+func WithDone(done <-chan struct{}) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.done = done
+	}
+}
+
+// WithIdleLockHook reports, once per lock, the locks that are still held by
+// this client for longer than threshold after their critical section finished
+// (see WithDone). It catches the common bug of acquiring a lock and forgetting
+// to release it. Idle locks are checked on every automatic heartbeat, so the
+// hook is never called if heartbeats are disabled.
+func WithIdleLockHook(threshold time.Duration, hook func(l *Lock, idle time.Duration)) ClientOption {
+	return func(c *commonClient) {
+		c.idleThreshold = threshold
+		c.idleHook = hook
+	}
+}
+
+func (c *commonClient) checkIdleLock(ctx context.Context, lockItem *Lock) {
+	if c.idleHook == nil {
+		return
+	}
+	lockItem.semaphore.Lock()
+	if lockItem.done == nil || lockItem.idleReported || lockItem.isReleased {
+		lockItem.semaphore.Unlock()
+		return
+	}
+	if lockItem.doneAt.IsZero() {
+		select {
+		case <-lockItem.done:
+			lockItem.doneAt = c.now()
+		default:
+		}
+	}
+	idle := time.Duration(0)
+	if !lockItem.doneAt.IsZero() {
+		idle = c.now().Sub(lockItem.doneAt)
+	}
+	report := idle > c.idleThreshold
+	if report {
+		lockItem.idleReported = true
+	}
+	lockItem.semaphore.Unlock()
+
+	if report {
+		c.logger.Error(ctx, "lock ", lockItem.partitionKey, " held for ", idle, " after its critical section finished")
+		c.idleHook(lockItem, idle)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// PendingWaiter describes a waiter registered in the inbox of a lock. See
+// JoinWaiterInbox.
+type PendingWaiter struct {
+	OwnerName string
+	// Priority is the priority of the acquisition, see WithPriority.
+	Priority int64
+	// Since is when the waiter joined the inbox, according to its clock.
+	Since time.Time
+}
+
+// JoinWaiterInbox makes the client, while waiting for the lock, register
+// itself in the waiterInbox attribute of the lock row, with the priority of
+// the acquisition and the moment it started waiting, and unregister once the
+// acquisition finishes, successfully or not. Holders see the registered
+// waiters with Lock.PendingWaiters, so they can decide when to step down based
+// on the actual demand for the lock. The inbox is carried over when the lock
+// changes hands.
+func JoinWaiterInbox() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.joinInbox = true
+	}
+}
+
+// PendingWaiters returns the waiters registered in the inbox of the lock, as
+// seen in the last heartbeat or read, ordered by decreasing priority and then
+// by how long they have been waiting.
+func (l *Lock) PendingWaiters() []PendingWaiter {
+	if l == nil {
+		return nil
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return readWaiterInbox(l.inbox)
+}
+
+func readWaiterInbox(inbox map[string]types.AttributeValue) []PendingWaiter {
+	var waiters []PendingWaiter
+	for _, v := range inbox {
+		entry, ok := v.(*types.AttributeValueMemberM)
+		if !ok {
+			continue
+		}
+		waiters = append(waiters, PendingWaiter{
+			OwnerName: readStringAttr(entry.Value[attrOwnerName]),
+			Priority:  readInt64Attr(entry.Value[attrPriority]),
+			Since:     time.Unix(0, readInt64Attr(entry.Value[inboxSinceAttr])*int64(time.Millisecond)),
+		})
+	}
+	sort.Slice(waiters, func(i, j int) bool {
+		if waiters[i].Priority != waiters[j].Priority {
+			return waiters[i].Priority > waiters[j].Priority
+		}
+		return waiters[i].Since.Before(waiters[j].Since)
+	})
+	return waiters
+}
+
+func readInboxAttr(attr types.AttributeValue) map[string]types.AttributeValue {
+	if m, ok := attr.(*types.AttributeValueMemberM); ok {
+		return m.Value
+	}
+	return nil
+}
+
+const inboxSinceAttr = "since"
+
+// inboxKey is the key of the client in the inbox. Owner names are encoded,
+// as they may contain dots, which separate the elements of document paths.
+func (c *commonClient) inboxKey() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.currentOwnerName()))
+}
+
+func (c *commonClient) tryJoinInbox(ctx context.Context, getLockOptions *getLockOptions) {
+	if c.v2Compatibility || !getLockOptions.joinInbox || getLockOptions.inboxJoined {
+		return
+	}
+	entry := &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		attrOwnerName:  stringAttrValue(c.currentOwnerName()),
+		attrPriority:   int64AttrValue(getLockOptions.priority),
+		inboxSinceAttr: int64AttrValue(c.now().UnixNano() / int64(time.Millisecond)),
+	}}
+	inboxAttr := expression.Name(attrWaiterInbox)
+	rowExists := expression.AttributeExists(expression.Name(c.partitionKeyName))
+	addEntry := func() error {
+		return c.updateInbox(ctx, getLockOptions,
+			expression.And(rowExists, expression.AttributeExists(inboxAttr)),
+			expression.Set(expression.Name(attrWaiterInbox+"."+c.inboxKey()), expression.Value(entry)))
+	}
+	createInbox := func() error {
+		inbox := &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{c.inboxKey(): entry}}
+		return c.updateInbox(ctx, getLockOptions,
+			expression.And(rowExists, expression.AttributeNotExists(inboxAttr)),
+			expression.Set(inboxAttr, expression.Value(inbox)))
+	}
+	// Nested attributes can only be set in existing maps, so the inbox is
+	// created by the first waiter. If someone else creates it first, the
+	// entry is added to theirs.
+	err := addEntry()
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		if err = createInbox(); errors.As(err, &errNotGranted) {
+			err = addEntry()
+		}
+	}
+	if errors.As(err, &errNotGranted) {
+		c.logger.Info(ctx, "waiter inbox not joined for ", getLockOptions.partitionKey, ":", err)
+		return
+	} else if err != nil {
+		c.logger.Error(ctx, "cannot join waiter inbox of ", getLockOptions.partitionKey, ":", err)
+		return
+	}
+	getLockOptions.inboxJoined = true
+}
+
+func (c *commonClient) leaveInbox(ctx context.Context, getLockOptions *getLockOptions) {
+	if !getLockOptions.inboxJoined {
+		return
+	}
+	// Stale entries overstate the demand for the lock, so they are removed
+	// even if the acquisition was canceled.
+	leaveCtx := ctx
+	if ctx.Err() != nil {
+		leaveCtx = context.Background()
+	}
+	entryAttr := expression.Name(attrWaiterInbox + "." + c.inboxKey())
+	err := c.updateInbox(leaveCtx, getLockOptions, expression.AttributeExists(entryAttr), expression.Remove(entryAttr))
+	var errNotGranted *LockNotGrantedError
+	if err != nil && !errors.As(err, &errNotGranted) {
+		c.logger.Error(ctx, "cannot leave waiter inbox of ", getLockOptions.partitionKey, ":", err)
+	}
+}
+
+// updateInbox changes the inbox of the lock row without changing its record
+// version number.
+func (c *commonClient) updateInbox(ctx context.Context, getLockOptions *getLockOptions, cond expression.ConditionBuilder, update expression.UpdateBuilder) error {
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(getLockOptions.partitionKey, getLockOptions.sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	return parseDynamoDBError(err, "waiter inbox changed")
+}
+
+// carriedInbox is the inbox to store in the lock row when it changes hands,
+// without the acquiring waiter itself.
+func (c *commonClient) carriedInbox(existingLock *Lock) map[string]types.AttributeValue {
+	key := c.inboxKey()
+	var inbox map[string]types.AttributeValue
+	for k, v := range existingLock.inbox {
+		if k == key {
+			continue
+		}
+		if inbox == nil {
+			inbox = make(map[string]types.AttributeValue)
+		}
+		inbox[k] = v
+	}
+	return inbox
+}
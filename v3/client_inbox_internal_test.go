@@ -0,0 +1,129 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func inboxEntry(owner string, priority int64, since time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		attrOwnerName:  stringAttrValue(owner),
+		attrPriority:   int64AttrValue(priority),
+		inboxSinceAttr: int64AttrValue(since.UnixNano() / int64(time.Millisecond)),
+	}}
+}
+
+func TestJoinWaiterInbox(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksInbox", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("inbox"),
+		attrOwnerName:           stringAttrValue("holder"),
+		attrLeaseDuration:       stringAttrValue("1h"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	})
+	c, err := New(svc, "locksInbox", "key", DisableHeartbeat(), WithOwnerName("web-1.4242"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.AcquireLock(context.Background(), "inbox", JoinWaiterInbox(), FailIfLocked())
+	var errNotGranted *LockNotGrantedError
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not to be granted:", err)
+	}
+	if got := svc.callCount("UpdateItem"); got != 3 {
+		t.Fatal("expected the inbox to be created, joined and left:", got)
+	}
+	if inbox := readWaiterInbox(readInboxAttr(svc.row("locksInbox", "inbox")[attrWaiterInbox])); len(inbox) != 0 {
+		t.Fatalf("waiter should have left the inbox: %#v", inbox)
+	}
+}
+
+func TestPendingWaiters(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksInbox", "key", DisableHeartbeat(), WithOwnerName("holder"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waiters := l.PendingWaiters(); len(waiters) != 0 {
+		t.Fatal("unexpected waiters:", waiters)
+	}
+
+	early, late := time.Unix(100, 0), time.Unix(200, 0)
+	row := svc.row("locksInbox", "inbox")
+	row[attrWaiterInbox] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		"a": inboxEntry("batch", 1, early),
+		"b": inboxEntry("interactive-late", 10, late),
+		"c": inboxEntry("interactive-early", 10, early),
+	}}
+	svc.putRow("locksInbox", row)
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	want := []PendingWaiter{
+		{OwnerName: "interactive-early", Priority: 10, Since: early},
+		{OwnerName: "interactive-late", Priority: 10, Since: late},
+		{OwnerName: "batch", Priority: 1, Since: early},
+	}
+	got := l.PendingWaiters()
+	if len(got) != len(want) {
+		t.Fatalf("unexpected waiters: %#v", got)
+	}
+	for i := range want {
+		if got[i].OwnerName != want[i].OwnerName || got[i].Priority != want[i].Priority || !got[i].Since.Equal(want[i].Since) {
+			t.Fatalf("unexpected waiters: %#v", got)
+		}
+	}
+}
+
+func TestWaiterInboxCarriedOver(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksInbox", "key", DisableHeartbeat(), WithOwnerName("next"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.putRow("locksInbox", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("inbox"),
+		attrOwnerName:           stringAttrValue("holder"),
+		attrLeaseDuration:       stringAttrValue("1h"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+		attrIsReleased:          stringAttrValue("1"),
+		attrWaiterInbox: &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			c.inboxKey(): inboxEntry("next", 0, time.Unix(100, 0)),
+			"other":      inboxEntry("other", 0, time.Unix(200, 0)),
+		}},
+	})
+	if _, err := c.AcquireLock(context.Background(), "inbox"); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.callCount("PutItem"); got != 1 {
+		t.Fatal("unexpected number of puts:", got)
+	}
+	inbox := readWaiterInbox(readInboxAttr(svc.row("locksInbox", "inbox")[attrWaiterInbox]))
+	if len(inbox) != 1 || inbox[0].OwnerName != "other" {
+		t.Fatalf("only the other waiters should be carried over: %#v", inbox)
+	}
+}
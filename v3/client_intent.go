@@ -0,0 +1,139 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DeclareIntent makes the client, while waiting for a lock held by someone
+// else, record in the lock row that it intends to take the lock next. Only one
+// intent is recorded at a time: the first waiter to declare it keeps it until
+// it acquires the lock or gives up waiting. The holder gets to know about it
+// in its next heartbeat, and may hand the lock over by releasing it early. See
+// WithIntentCallback. Intents are advisory: they do not stop other waiters
+// from acquiring a released lock.
+func DeclareIntent() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.declareIntent = true
+	}
+}
+
+// WithIntentCallback registers a callback that is called when a waiter
+// declares its intent to take the lock next, once per waiter. The callback is
+// not expected to release the lock, but it is a hint that it can be handed
+// over as soon as it is convenient.
+func WithIntentCallback(callback func(l *Lock, ownerName string)) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.intentCallback = callback
+	}
+}
+
+// Intent returns the owner name of the waiter that declared its intent to
+// take this lock next, as seen in the last heartbeat or read.
+func (l *Lock) Intent() (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.intentOwner, l.intentOwner != ""
+}
+
+func (c *commonClient) tryDeclareIntent(ctx context.Context, getLockOptions *getLockOptions, existingLock *Lock) {
+	if c.v2Compatibility || !getLockOptions.declareIntent ||
+		getLockOptions.intentDeclaredTo == existingLock.ownerName ||
+		(existingLock.intentOwner != "" && existingLock.intentOwner != c.currentOwnerName()) {
+		return
+	}
+	intentOwnerAttr := expression.Name(attrIntentOwner)
+	cond := expression.And(
+		expression.AttributeExists(expression.Name(c.partitionKeyName)),
+		expression.Equal(ownerNameAttr, expression.Value(existingLock.ownerName)),
+		expression.Or(
+			expression.AttributeNotExists(intentOwnerAttr),
+			expression.Equal(intentOwnerAttr, expression.Value(c.currentOwnerName())),
+		),
+	)
+	update := expression.Set(intentOwnerAttr, expression.Value(c.currentOwnerName()))
+	err := c.updateIntent(ctx, getLockOptions, cond, update)
+	err = parseDynamoDBError(err, "cannot declare intent")
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		c.logger.Info(ctx, "intent not declared for ", getLockOptions.partitionKey, ":", err)
+		return
+	} else if err != nil {
+		c.logger.Error(ctx, "error declaring intent for ", getLockOptions.partitionKey, ":", err)
+		return
+	}
+	getLockOptions.intentDeclaredTo = existingLock.ownerName
+}
+
+func (c *commonClient) withdrawIntent(ctx context.Context, getLockOptions *getLockOptions) {
+	if getLockOptions.intentDeclaredTo == "" {
+		return
+	}
+	// Stale intents keep other waiters from declaring theirs, so they are
+	// withdrawn even if the acquisition was canceled.
+	withdrawCtx := ctx
+	if ctx.Err() != nil {
+		withdrawCtx = context.Background()
+	}
+	intentOwnerAttr := expression.Name(attrIntentOwner)
+	cond := expression.Equal(intentOwnerAttr, expression.Value(c.currentOwnerName()))
+	update := expression.Remove(intentOwnerAttr)
+	err := parseDynamoDBError(c.updateIntent(withdrawCtx, getLockOptions, cond, update), "intent already withdrawn")
+	var errNotGranted *LockNotGrantedError
+	if err != nil && !errors.As(err, &errNotGranted) {
+		c.logger.Error(ctx, "cannot withdraw intent for ", getLockOptions.partitionKey, ":", err)
+	}
+}
+
+// updateIntent changes the intent of the lock row without changing its record
+// version number.
+func (c *commonClient) updateIntent(ctx context.Context, getLockOptions *getLockOptions, cond expression.ConditionBuilder, update expression.UpdateBuilder) error {
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(getLockOptions.partitionKey, getLockOptions.sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	return err
+}
+
+// checkIntent inspects the lock row returned by a heartbeat and notifies the
+// holder, once per waiter, about declared intents. Callers must hold the
+// lock's semaphore.
+func (c *commonClient) checkIntent(lockItem *Lock, attributes map[string]types.AttributeValue) {
+	owner := readStringAttr(attributes[attrIntentOwner])
+	lockItem.intentOwner = owner
+	if owner == "" || lockItem.intentNotified == owner || lockItem.intentCallback == nil {
+		return
+	}
+	lockItem.intentNotified = owner
+	fn := lockItem.intentCallback
+	c.runCallback(func() { fn(lockItem, owner) })
+}
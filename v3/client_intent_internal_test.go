@@ -0,0 +1,114 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDeclareIntent(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksIntent", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("intent"),
+		attrOwnerName:           stringAttrValue("holder"),
+		attrLeaseDuration:       stringAttrValue("1h"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	})
+	c, err := New(svc, "locksIntent", "key",
+		DisableHeartbeat(),
+		WithOwnerName("next"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.AcquireLock(context.Background(), "intent", DeclareIntent(), FailIfLocked())
+	var errNotGranted *LockNotGrantedError
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not to be granted:", err)
+	}
+	if got := svc.callCount("UpdateItem"); got != 2 {
+		t.Fatal("expected intent to be declared and withdrawn:", got)
+	}
+	if _, ok := svc.row("locksIntent", "intent")[attrIntentOwner]; ok {
+		t.Fatal("intent should be withdrawn")
+	}
+
+	svc.setAttributes("locksIntent", map[string]types.AttributeValue{
+		attrIntentOwner: stringAttrValue("someone else"),
+	}, "intent")
+	_, err = c.AcquireLock(context.Background(), "intent", DeclareIntent(), FailIfLocked())
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not to be granted:", err)
+	}
+	if got := readStringAttr(svc.row("locksIntent", "intent")[attrIntentOwner]); got != "someone else" {
+		t.Fatal("intents declared by other waiters must be kept:", got)
+	}
+}
+
+func TestIntentCallback(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksIntent", "key",
+		DisableHeartbeat(),
+		WithOwnerName("holder"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intents := make(chan string, 2)
+	l, err := c.AcquireLock(context.Background(), "intent",
+		WithIntentCallback(func(_ *Lock, ownerName string) {
+			intents <- ownerName
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.Intent(); ok {
+		t.Fatal("unexpected intent")
+	}
+
+	svc.setAttributes("locksIntent", map[string]types.AttributeValue{
+		attrIntentOwner: stringAttrValue("next"),
+	}, "intent")
+	for i := 0; i < 2; i++ {
+		if err := c.SendHeartbeat(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case got := <-intents:
+		if got != "next" {
+			t.Fatal("unexpected intent:", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("intent callback not called")
+	}
+	if got, ok := l.Intent(); !ok || got != "next" {
+		t.Fatal("unexpected intent:", got)
+	}
+	select {
+	case <-intents:
+		t.Fatal("intent callback should be called only once per waiter")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
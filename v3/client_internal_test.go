@@ -18,12 +18,18 @@ package dynamolock
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
@@ -44,6 +50,10 @@ func (m *mockDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.Up
 	return &dynamodb.UpdateItemOutput{}, nil
 }
 
+func (m *mockDynamoDBClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
 /*
 This test checks for lock leaks during closing, that is, to make sure that no locks
 are able to be acquired while the client is closing, and to ensure that we don't have
@@ -103,3 +113,1075 @@ func TestBadCreateLockItem(t *testing.T) {
 		t.Fatal("bad duration should prevent the creation of the lock")
 	}
 }
+
+func TestDrain(t *testing.T) {
+	lockClient, err := New(&mockDynamoDBClient{}, "locksDrain", "key",
+		WithLeaseDuration(3*time.Second),
+		DisableHeartbeat(),
+		WithOwnerName("Drain"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inCriticalSection := make(chan struct{})
+	finishCriticalSection := make(chan struct{})
+	doWithLockErr := make(chan error, 1)
+	go func() {
+		doWithLockErr <- lockClient.DoWithLock(context.Background(), "drain", func(context.Context, *Lock) error {
+			close(inCriticalSection)
+			<-finishCriticalSection
+			return nil
+		})
+	}()
+	<-inCriticalSection
+
+	drainErr := make(chan error, 1)
+	go func() {
+		drainErr <- lockClient.Drain(context.Background())
+	}()
+
+	for {
+		_, err := lockClient.AcquireLock(context.Background(), "drain-other")
+		if err == ErrClientDraining {
+			break
+		} else if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-drainErr:
+		t.Fatal("drain should wait for the in-flight critical section")
+	default:
+	}
+
+	close(finishCriticalSection)
+	if err := <-doWithLockErr; err != nil {
+		t.Fatal("unexpected critical section error:", err)
+	}
+	if err := <-drainErr; err != nil {
+		t.Fatal("unexpected drain error:", err)
+	}
+	if !lockClient.isClosed() {
+		t.Fatal("drain should close the client")
+	}
+}
+
+func TestDoWithLockRelease(t *testing.T) {
+	t.Run("reference counted", func(t *testing.T) {
+		c, err := New(newMemoryDynamoDBClient(), "locksDoWithLock", "key", DisableHeartbeat(), WithReacquirePolicy(ReacquireRefCount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outer, err := c.AcquireLock(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.DoWithLock(context.Background(), "key", func(_ context.Context, l *Lock) error {
+			if l != outer {
+				t.Error("the lock held by the client should have been reused")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if outer.IsExpired() {
+			t.Fatal("the outer acquisition should still hold the lock")
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		svc := &flakyReleaseDynamoDBClient{}
+		c, err := New(svc, "locksDoWithLock", "key", DisableHeartbeat(), WithReleaseRetries(0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		var l *Lock
+		err = c.DoWithLock(ctx, "key", func(_ context.Context, lock *Lock) error {
+			l = lock
+			cancel()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(&svc.calls); got != 1 {
+			t.Fatal("release should have been attempted once, on a detached context:", got)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); ok {
+			t.Fatal("lock should have been released")
+		}
+	})
+}
+
+func TestDrainTimeout(t *testing.T) {
+	lockClient, err := New(&mockDynamoDBClient{}, "locksDrain", "key",
+		WithLeaseDuration(3*time.Second),
+		DisableHeartbeat(),
+		WithOwnerName("DrainTimeout"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inCriticalSection := make(chan struct{})
+	finishCriticalSection := make(chan struct{})
+	defer close(finishCriticalSection)
+	go lockClient.DoWithLock(context.Background(), "drain", func(context.Context, *Lock) error {
+		close(inCriticalSection)
+		<-finishCriticalSection
+		return nil
+	})
+	<-inCriticalSection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := lockClient.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected drain to time out:", err)
+	}
+	if !lockClient.isClosed() {
+		t.Fatal("drain should close the client even after timing out")
+	}
+}
+
+func TestDrainWaitingAcquisition(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksDrain", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("busy"),
+		attrOwnerName:           stringAttrValue("other"),
+		attrLeaseDuration:       stringAttrValue("1m"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	})
+	lockClient, err := New(svc, "locksDrain", "key",
+		WithLeaseDuration(time.Minute),
+		DisableHeartbeat(),
+		WithOwnerName("DrainWaitingAcquisition"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquireErr := make(chan error, 1)
+	go func() {
+		_, err := lockClient.AcquireLock(context.Background(), "busy", WithRefreshPeriod(10*time.Millisecond))
+		acquireErr <- err
+	}()
+	for svc.callCount("GetItem") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	drainErr := make(chan error, 1)
+	go func() {
+		drainErr <- lockClient.Drain(ctx)
+	}()
+	select {
+	case err := <-drainErr:
+		if err != nil {
+			t.Fatal("unexpected drain error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain should not wait for the acquisitions waiting for their lock")
+	}
+	if err := <-acquireErr; !errors.Is(err, ErrClientDraining) {
+		t.Fatal("the waiting acquisition should give up:", err)
+	}
+}
+
+func TestImmediateHeartbeat(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	lockClient, err := New(svc, "locksImmediateHeartbeat", "key",
+		DisableHeartbeat(),
+		WithOwnerName("ImmediateHeartbeat"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockClient.AcquireLock(context.Background(), "immediate"); err != nil {
+		t.Fatal(err)
+	}
+	if svc.callCount("UpdateItem") != 0 {
+		t.Fatal("unexpected heartbeat")
+	}
+	if _, err := lockClient.AcquireLock(context.Background(), "immediate2", WithImmediateHeartbeat()); err != nil {
+		t.Fatal(err)
+	}
+	if svc.callCount("UpdateItem") != 1 {
+		t.Fatal("expected heartbeat right after acquisition")
+	}
+}
+
+func TestV2Compatibility(t *testing.T) {
+	if _, err := NewWithSortKey(&mockDynamoDBClient{}, "locksV2", "key", "sortKey", WithV2Compatibility()); err == nil {
+		t.Fatal("v2 compatible clients must not accept sort keys")
+	}
+
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksV2", "key",
+		DisableHeartbeat(),
+		WithOwnerName("V2Compatibility"),
+		WithV2Compatibility(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "v2", WithPriority(10)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"key":                   true,
+		attrOwnerName:           true,
+		attrLeaseDuration:       true,
+		attrRecordVersionNumber: true,
+	}
+	for k := range svc.putInputs()[0].Item {
+		if !want[k] {
+			t.Error("unexpected attribute in v2 compatible lock:", k)
+		}
+	}
+
+	l, err := c.createLockItem(getLockOptions{}, map[string]types.AttributeValue{
+		attrPriority:        int64AttrValue(10),
+		attrPreemptionOwner: stringAttrValue("someone"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.AdditionalAttributes()) != 2 || l.Priority() != 0 {
+		t.Fatal("v2 compatible clients should handle unknown attributes as additional attributes")
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	if _, err := New(&mockDynamoDBClient{}, "locksKeyTypes", "key", WithPartitionKeyType("BOOL")); err == nil {
+		t.Fatal("invalid key types must be rejected")
+	}
+
+	c, err := NewWithSortKey(&mockDynamoDBClient{}, "locksKeyTypes", "key", "sortKey",
+		DisableHeartbeat(),
+		WithPartitionKeyType(types.ScalarAttributeTypeN),
+		WithSortKeyType(types.ScalarAttributeTypeB),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := c.itemKey("42", "\x00\x01")
+	if n, ok := key["key"].(*types.AttributeValueMemberN); !ok || n.Value != "42" {
+		t.Fatalf("unexpected partition key encoding: %#v", key["key"])
+	}
+	if b, ok := key["sortKey"].(*types.AttributeValueMemberB); !ok || string(b.Value) != "\x00\x01" {
+		t.Fatalf("unexpected sort key encoding: %#v", key["sortKey"])
+	}
+	if readKeyAttr(key["key"]) != "42" || readKeyAttr(key["sortKey"]) != "\x00\x01" {
+		t.Fatal("keys do not round trip")
+	}
+
+	_, attributeDefinitions := c.createTableSchema()
+	if attributeDefinitions[0].AttributeType != types.ScalarAttributeTypeN ||
+		attributeDefinitions[1].AttributeType != types.ScalarAttributeTypeB {
+		t.Fatalf("unexpected table schema: %#v", attributeDefinitions)
+	}
+}
+
+type flakyReleaseDynamoDBClient struct {
+	mockDynamoDBClient
+	failures int32
+	calls    int32
+	err      error
+}
+
+func (m *flakyReleaseDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		atomic.AddInt32(&m.calls, 1)
+		return nil, err
+	}
+	if atomic.AddInt32(&m.calls, 1) <= m.failures {
+		if m.err != nil {
+			return nil, m.err
+		}
+		return nil, &types.ProvisionedThroughputExceededException{}
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestReleaseRetries(t *testing.T) {
+	t.Run("recovered", func(t *testing.T) {
+		svc := &flakyReleaseDynamoDBClient{failures: 2}
+		c, err := New(svc, "locksReleaseRetries", "key", DisableHeartbeat(), WithReleaseRetries(2, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released, err := c.ReleaseLock(context.Background(), l); !released || err != nil {
+			t.Fatal("release should have been retried:", released, err)
+		}
+		if got := atomic.LoadInt32(&svc.calls); got != 3 {
+			t.Fatal("unexpected number of release attempts:", got)
+		}
+	})
+	t.Run("exhausted", func(t *testing.T) {
+		svc := &flakyReleaseDynamoDBClient{failures: 10}
+		c, err := New(svc, "locksReleaseRetries", "key", DisableHeartbeat(), WithReleaseRetries(1, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released, err := c.ReleaseLock(context.Background(), l); released || err == nil {
+			t.Fatal("release should have failed:", released, err)
+		}
+		if l.IsExpired() {
+			t.Fatal("lock should still be held after a failed release")
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); !ok {
+			t.Fatal("lock should still be heartbeated after a failed release")
+		}
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		svc := &flakyReleaseDynamoDBClient{failures: 10, err: errors.New("validation error")}
+		c, err := New(svc, "locksReleaseRetries", "key", DisableHeartbeat(), WithReleaseRetries(2, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released, err := c.ReleaseLock(context.Background(), l); released || err == nil {
+			t.Fatal("release should have failed:", released, err)
+		}
+		if got := atomic.LoadInt32(&svc.calls); got != 1 {
+			t.Fatal("non-retryable errors should not be retried:", got)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); ok {
+			t.Fatal("lock should not be heartbeated after a non-retryable failure")
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		svc := &flakyReleaseDynamoDBClient{}
+		c, err := New(svc, "locksReleaseRetries", "key", DisableHeartbeat(), WithReleaseRetries(2, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&svc.calls, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if released, err := c.ReleaseLock(ctx, l); !released || err != nil {
+			t.Fatal("release should have been completed on a detached context:", released, err)
+		}
+		if got := atomic.LoadInt32(&svc.calls); got != 2 {
+			t.Fatal("unexpected number of release attempts:", got)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); ok {
+			t.Fatal("released lock should not be heartbeated")
+		}
+	})
+}
+
+func TestDataTransformOnRelease(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksDataTransform", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "key", WithData([]byte("step 1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ReleaseLock(context.Background(), l, WithDataTransformOnRelease(func(old []byte) []byte {
+		return append(old, []byte(", done")...)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.updateInputs()) != 1 {
+		t.Fatal("unexpected number of updates:", len(svc.updateInputs()))
+	}
+	var found bool
+	for _, v := range svc.updateInputs()[0].ExpressionAttributeValues {
+		if b, ok := v.(*types.AttributeValueMemberB); ok && string(b.Value) == "step 1, done" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("transformed data not written: %#v", svc.updateInputs()[0].ExpressionAttributeValues)
+	}
+}
+
+func TestHeartbeatData(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	var beats int32
+	c, err := New(svc, "locksHeartbeatData", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(50*time.Millisecond),
+		WithHeartbeatData(func(l *Lock) []byte {
+			return []byte(fmt.Sprint("progress ", atomic.AddInt32(&beats, 1)))
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	l, err := c.AcquireLock(context.Background(), "key", WithData([]byte("progress 0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if string(l.Data()) == "progress 0" {
+		t.Fatal("heartbeats did not update the lock data")
+	}
+	if len(svc.updateInputs()) == 0 {
+		t.Fatal("no heartbeats sent")
+	}
+	var found bool
+	for _, v := range svc.updateInputs()[0].ExpressionAttributeValues {
+		if b, ok := v.(*types.AttributeValueMemberB); ok && string(b.Value) == "progress 1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("heartbeat data not written: %#v", svc.updateInputs()[0].ExpressionAttributeValues)
+	}
+}
+
+func TestLeaseExtender(t *testing.T) {
+	c, err := New(&mockDynamoDBClient{}, "locksLeaseExtender", "key",
+		WithLeaseDuration(time.Second),
+		DisableHeartbeat(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	var needed int64
+	l, err := c.AcquireLock(context.Background(), "key",
+		WithLeaseExtender(func() time.Duration {
+			return time.Duration(atomic.LoadInt64(&needed))
+		}, 5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		needed time.Duration
+		want   time.Duration
+	}{
+		{needed: 3 * time.Second, want: 3 * time.Second},
+		{needed: time.Minute, want: 5 * time.Second},
+		{needed: 0, want: time.Second},
+	} {
+		atomic.StoreInt64(&needed, int64(tc.needed))
+		if err := c.SendHeartbeat(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+		if got := l.LeaseDuration(); got != tc.want {
+			t.Errorf("needed %v: got lease %v, want %v", tc.needed, got, tc.want)
+		}
+	}
+}
+
+func TestIdleLockHook(t *testing.T) {
+	reported := make(chan time.Duration, 10)
+	c, err := New(&mockDynamoDBClient{}, "locksIdle", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(20*time.Millisecond),
+		WithIdleLockHook(50*time.Millisecond, func(l *Lock, idle time.Duration) {
+			reported <- idle
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	done := make(chan struct{})
+	if _, err := c.AcquireLock(context.Background(), "key", WithDone(done)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "other"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reported:
+		t.Fatal("lock reported idle while its critical section is running")
+	case <-time.After(150 * time.Millisecond):
+	}
+	close(done)
+	select {
+	case idle := <-reported:
+		if idle < 50*time.Millisecond {
+			t.Fatal("lock reported too early:", idle)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle lock not reported")
+	}
+	select {
+	case <-reported:
+		t.Fatal("idle lock reported more than once")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestRVNGenerator(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksRVN", "key", DisableHeartbeat(), WithRVNGenerator(func() string { return "custom-rvn" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	l, err := c.AcquireLock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.RecordVersionNumber(); got != "custom-rvn" {
+		t.Fatal("custom record version number generator not used:", got)
+	}
+	if got := readStringAttr(svc.putInputs()[0].Item[attrRecordVersionNumber]); got != "custom-rvn" {
+		t.Fatal("custom record version number not stored:", got)
+	}
+}
+
+func TestCloseStopsHeartbeats(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksCloseHeartbeats", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := c.AcquireLock(context.Background(), strconv.Itoa(i), WithDeleteLockOnRelease()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	afterClose := svc.callCount("UpdateItem")
+	time.Sleep(50 * time.Millisecond)
+	if got := svc.callCount("UpdateItem"); got != afterClose {
+		t.Fatalf("heartbeats sent after Close returned: %d != %d", got, afterClose)
+	}
+}
+
+func TestHeartbeatErrors(t *testing.T) {
+	svc := &flakyReleaseDynamoDBClient{failures: math.MaxInt32}
+	c, err := New(svc, "locksHeartbeatErrors", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(10*time.Millisecond),
+		WithReleaseRetries(0, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case hbErr := <-c.HeartbeatErrors():
+		if hbErr.Lock != l || hbErr.Err == nil {
+			t.Fatalf("unexpected heartbeat error: %#v", hbErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat error not reported")
+	}
+	_ = c.Close(context.Background())
+	for range c.HeartbeatErrors() {
+	}
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func TestClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c, err := New(&mockDynamoDBClient{}, "locksClock", "key",
+		WithLeaseDuration(10*time.Second),
+		DisableHeartbeat(),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "key", WithSessionMonitor(8*time.Second, func() {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(7 * time.Second)
+	if l.IsExpired() {
+		t.Fatal("lock expired before its lease")
+	}
+	if almost, err := l.IsAlmostExpired(); err != nil || almost {
+		t.Fatal("lock should not be in the danger zone yet:", almost, err)
+	}
+	clock.Advance(2 * time.Second)
+	if almost, err := l.IsAlmostExpired(); err != nil || !almost {
+		t.Fatal("lock should be in the danger zone:", almost, err)
+	}
+	clock.Advance(2 * time.Second)
+	if !l.IsExpired() {
+		t.Fatal("lock should have expired")
+	}
+
+	if _, err := New(&mockDynamoDBClient{}, "locksClock", "key", WithClock(nil)); err == nil {
+		t.Fatal("expected error missing")
+	}
+}
+
+type slowGetDynamoDBClient struct {
+	mockDynamoDBClient
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (m *slowGetDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	n := atomic.AddInt32(&m.inFlight, 1)
+	defer atomic.AddInt32(&m.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&m.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&m.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func TestMaxConcurrentAcquisitions(t *testing.T) {
+	svc := &slowGetDynamoDBClient{}
+	c, err := New(svc, "locksMaxConcurrentAcquisitions", "key",
+		DisableHeartbeat(),
+		WithMaxConcurrentAcquisitions(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := c.AcquireLock(context.Background(), strconv.Itoa(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if max := atomic.LoadInt32(&svc.maxInFlight); max > 2 {
+		t.Fatal("too many concurrent acquisitions:", max)
+	}
+
+	c.acquisitionSlots <- struct{}{}
+	c.acquisitionSlots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.AcquireLock(ctx, "blocked"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded error:", err)
+	}
+}
+
+type capturingCreateTableDynamoDBClient struct {
+	mockDynamoDBClient
+	input     *dynamodb.CreateTableInput
+	describes int
+	activeAt  int
+}
+
+func (m *capturingCreateTableDynamoDBClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	m.describes++
+	status := types.TableStatusCreating
+	if m.activeAt > 0 && m.describes >= m.activeAt {
+		status = types.TableStatusActive
+	}
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{
+		TableName:   params.TableName,
+		TableStatus: status,
+	}}, nil
+}
+
+func (m *capturingCreateTableDynamoDBClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	m.input = params
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func TestLocalSecondaryIndex(t *testing.T) {
+	svc := &capturingCreateTableDynamoDBClient{}
+	c, err := NewWithSortKey(svc, "locksLocalIndex", "key", "sortKey", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateTable(context.Background(),
+		WithLocalSecondaryIndex("byPriority", attrPriority, types.ScalarAttributeTypeN),
+		WithLocalSecondaryIndex("byStatus", "status", types.ScalarAttributeTypeS),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := svc.input.LocalSecondaryIndexes
+	if len(indexes) != 2 || aws.ToString(indexes[0].IndexName) != "byPriority" ||
+		aws.ToString(indexes[1].KeySchema[1].AttributeName) != "status" {
+		t.Fatalf("unexpected local secondary indexes: %#v", indexes)
+	}
+	if len(svc.input.AttributeDefinitions) != 4 {
+		t.Fatalf("unexpected attribute definitions: %#v", svc.input.AttributeDefinitions)
+	}
+
+	pkOnly, err := New(svc, "locksLocalIndex", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkOnly.CreateTable(context.Background(), WithLocalSecondaryIndex("byStatus", "status", types.ScalarAttributeTypeS)); err == nil {
+		t.Fatal("expected error missing")
+	}
+}
+
+func TestWaitForActive(t *testing.T) {
+	svc := &capturingCreateTableDynamoDBClient{activeAt: 2}
+	c, err := New(svc, "locksWaitForActive", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.CreateTable(context.Background(), WithWaitForActive(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.describes != 2 || out.TableDescription == nil || out.TableDescription.TableStatus != types.TableStatusActive {
+		t.Fatalf("unexpected table description after %d calls: %#v", svc.describes, out.TableDescription)
+	}
+
+	svc = &capturingCreateTableDynamoDBClient{}
+	c, err = New(svc, "locksWaitForActive", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateTable(context.Background(), WithWaitForActive(10*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline exceeded error:", err)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksExpiresAt", "key",
+		WithLeaseDuration(10*time.Second),
+		DisableHeartbeat(),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readInt64Attr(svc.putInputs()[0].Item[attrExpiresAt]); got != 1010000 {
+		t.Fatal("unexpected expiresAt on acquire:", got)
+	}
+	if _, ok := l.AdditionalAttributes()[attrExpiresAt]; ok {
+		t.Fatal("expiresAt must not be exposed as an additional attribute")
+	}
+
+	clock.Advance(5 * time.Second)
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, v := range svc.updateInputs()[0].ExpressionAttributeValues {
+		if readInt64Attr(v) == 1015000 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expiresAt not refreshed by the heartbeat: %#v", svc.updateInputs()[0].ExpressionAttributeValues)
+	}
+}
+
+func TestCloser(t *testing.T) {
+	c, err := New(&mockDynamoDBClient{}, "locksCloser", "key", DisableHeartbeat(), WithCloseTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closer io.Closer = c.Closer()
+	if _, err := c.AcquireLock(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := closer.Close(); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("expected client closed error:", err)
+	}
+}
+
+func TestSelectiveHeartbeats(t *testing.T) {
+	critical := func(l *Lock) bool { return l.PartitionKey() == "critical" }
+	heartbeatedKeys := func(svc *memoryDynamoDBClient) map[string]int {
+		keys := make(map[string]int)
+		for _, u := range svc.updateInputs() {
+			keys[readStringAttr(u.Key["key"])]++
+		}
+		return keys
+	}
+
+	t.Run("manual", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c, err := New(svc, "locksSelectiveHeartbeats", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"critical", "other"} {
+			if _, err := c.AcquireLock(context.Background(), key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.SendHeartbeats(context.Background(), critical); err != nil {
+			t.Fatal(err)
+		}
+		if keys := heartbeatedKeys(svc); len(keys) != 1 || keys["critical"] != 1 {
+			t.Fatal("unexpected heartbeats:", keys)
+		}
+		if err := c.SendHeartbeats(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		if keys := heartbeatedKeys(svc); keys["critical"] != 2 || keys["other"] != 1 {
+			t.Fatal("unexpected heartbeats:", keys)
+		}
+	})
+
+	t.Run("background", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c, err := New(svc, "locksSelectiveHeartbeats", "key",
+			WithLeaseDuration(time.Second),
+			WithHeartbeatPeriod(10*time.Millisecond),
+			WithHeartbeatFilter(critical),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"critical", "other"} {
+			if _, err := c.AcquireLock(context.Background(), key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if keys := heartbeatedKeys(svc); keys["critical"] == 0 || keys["other"] != 1 {
+			t.Fatal("unexpected heartbeats (other is only updated on release):", keys)
+		}
+	})
+}
+
+// slowReleaseDynamoDBClient takes a while to release locks, failing for the
+// "broken" key, and tracks how many releases run concurrently.
+type slowReleaseDynamoDBClient struct {
+	mockDynamoDBClient
+	mu           sync.Mutex
+	inFlight     int
+	maxInFlight  int
+	releasedKeys []string
+}
+
+func (m *slowReleaseDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	key := readStringAttr(params.Key["key"])
+	if strings.HasPrefix(key, "broken") {
+		return nil, errors.New("cannot release " + key)
+	}
+	m.releasedKeys = append(m.releasedKeys, key)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestCloseReleasesInParallel(t *testing.T) {
+	svc := &slowReleaseDynamoDBClient{}
+	c, err := New(svc, "locksCloseParallel", "key",
+		DisableHeartbeat(),
+		WithReleaseRetries(0, 0),
+		WithCloseParallelism(3),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"broken-1", "a", "b", "c", "broken-2", "d", "e", "f"}
+	for _, key := range keys {
+		if _, err := c.AcquireLock(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var lockErrs LockErrors
+	if err := c.Close(context.Background()); !errors.As(err, &lockErrs) {
+		t.Fatal("expected the release failures to be reported:", err)
+	}
+	if len(lockErrs) != 2 {
+		t.Fatal("all release failures should be reported:", lockErrs)
+	}
+	for l, err := range lockErrs {
+		if want := "cannot release " + l.PartitionKey(); !strings.Contains(err.Error(), want) {
+			t.Fatal("release failure reported for the wrong lock:", l.PartitionKey(), err)
+		}
+	}
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.releasedKeys) != len(keys)-2 {
+		t.Fatal("a release failure should not stop the release of other locks:", svc.releasedKeys)
+	}
+	if svc.maxInFlight < 2 || svc.maxInFlight > 3 {
+		t.Fatal("unexpected release parallelism:", svc.maxInFlight)
+	}
+}
+
+func TestTakeoverOnlyIfExpiredBy(t *testing.T) {
+	const grace = 300 * time.Millisecond
+	acquire := func(opts ...AcquireLockOption) time.Duration {
+		c, err := New(newAbandonedLockDynamoDBClient("locksTakeoverGrace"), "locksTakeoverGrace", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, WithRefreshPeriod(10*time.Millisecond))
+		start := time.Now()
+		l, err := c.AcquireLock(context.Background(), "status", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := l.Acquisition().Kind; kind != AcquisitionExpired {
+			t.Fatal("unexpected acquisition kind:", kind)
+		}
+		return time.Since(start)
+	}
+	if elapsed := acquire(); elapsed >= grace {
+		t.Fatal("takeover without grace took too long:", elapsed)
+	}
+	if elapsed := acquire(WithTakeoverOnlyIfExpiredBy(grace)); elapsed < grace {
+		t.Fatal("lock taken over before the grace window elapsed:", elapsed)
+	}
+}
+
+type staleReadDynamoDBClient struct {
+	mockDynamoDBClient
+	mu    sync.Mutex
+	reads []bool
+	puts  int
+}
+
+func (m *staleReadDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads = append(m.reads, aws.ToBool(params.ConsistentRead))
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (m *staleReadDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.puts++
+	if m.puts == 1 {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestEventuallyConsistentRead(t *testing.T) {
+	svc := &staleReadDynamoDBClient{}
+	c, err := New(svc, "locksEventuallyConsistentRead", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "stale", WithEventuallyConsistentRead(), FailIfLocked()); err != nil {
+		t.Fatal("stale read should be retried with a consistent read:", err)
+	}
+	if len(svc.reads) != 2 || svc.reads[0] || !svc.reads[1] {
+		t.Fatal("unexpected read consistency:", svc.reads)
+	}
+
+	svc.reads, svc.puts = nil, 0
+	if _, err := c.AcquireLock(context.Background(), "consistent", WithRefreshPeriod(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.reads) != 2 || !svc.reads[0] || !svc.reads[1] {
+		t.Fatal("unexpected read consistency:", svc.reads)
+	}
+}
+
+type missingTableDynamoDBClient struct {
+	mockDynamoDBClient
+	creates int
+}
+
+func (m *missingTableDynamoDBClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	if m.creates == 0 {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{
+		TableName:   params.TableName,
+		TableStatus: types.TableStatusActive,
+	}}, nil
+}
+
+func (m *missingTableDynamoDBClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	m.creates++
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func TestCreateTableIfNotExists(t *testing.T) {
+	svc := &missingTableDynamoDBClient{}
+	if _, err := NewWithSortKey(svc, "locksAutoCreate", "key", "sortKey", DisableHeartbeat(), WithCreateTableIfNotExists()); err != nil {
+		t.Fatal(err)
+	}
+	if svc.creates != 1 {
+		t.Fatal("missing table should be created:", svc.creates)
+	}
+	if _, err := New(svc, "locksAutoCreate", "key", DisableHeartbeat(), WithCreateTableIfNotExists()); err != nil {
+		t.Fatal(err)
+	}
+	if svc.creates != 1 {
+		t.Fatal("existing table should not be created again:", svc.creates)
+	}
+	if _, err := New(&missingTableDynamoDBClient{}, "locksAutoCreate", "key", DisableHeartbeat()); err != nil {
+		t.Fatal("table should not be checked without the option:", err)
+	}
+
+	_, err := New(&missingTableDynamoDBClient{}, "locksAutoCreate", "key", DisableHeartbeat(),
+		WithCreateTableIfNotExists(WithLocalSecondaryIndex("byStatus", "status", types.ScalarAttributeTypeS)))
+	if err == nil {
+		t.Fatal("table creation failures should be reported")
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "context"
+
+// LookupResult describes a lock as seen by Lookup.
+type LookupResult struct {
+	// Found reports whether the key has a lock row in the table.
+	Found bool
+	// Released reports whether the lock row was released by its last
+	// owner, so the lock is free to be acquired.
+	Released bool
+	// OwnedByMe reports whether the lock is currently held by this client.
+	// A lock row stored with the owner name of this client, but not
+	// acquired by this client instance, is not owned by it.
+	OwnedByMe bool
+	// Info describes the lock row, if found.
+	Info LockInfo
+	// Lock is the lock held by this client, set only if OwnedByMe is true.
+	// It can be used to release the lock or send heartbeats to it.
+	Lock *Lock
+}
+
+func (c *commonClient) lookup(ctx context.Context, partitionKey, sortKey string) (LookupResult, error) {
+	if c.isClosed() {
+		return LookupResult{}, ErrClientClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return LookupResult{}, err
+	}
+
+	if v, ok := c.locks.Load(c.lockKeyOf(ctx, partitionKey, sortKey)); ok {
+		l := v.(*Lock)
+		l.semaphore.Lock()
+		owned := !l.isExpired() && l.ownerName == c.currentOwnerName()
+		l.semaphore.Unlock()
+		if owned {
+			return LookupResult{Found: true, OwnedByMe: true, Info: lockInfo(l), Lock: l}, nil
+		}
+	}
+
+	lockItem, err := c.getLockFromDynamoDB(ctx, getLockOptions{
+		partitionKey: partitionKey,
+		sortKey:      sortKey,
+	})
+	if err != nil {
+		return LookupResult{}, err
+	}
+	if lockItem == nil {
+		return LookupResult{}, nil
+	}
+	return LookupResult{
+		Found:    true,
+		Released: lockItem.isReleased,
+		Info:     lockInfo(lockItem),
+	}, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	newClient := func(owner string) *Client {
+		c, err := New(svc, "locksLookup", "key", DisableHeartbeat(), WithOwnerName(owner))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	me, other := newClient("me"), newClient("other")
+	ctx := context.Background()
+
+	if r, err := me.Lookup(ctx, "key"); err != nil || r.Found || r.OwnedByMe || r.Lock != nil {
+		t.Fatalf("missing lock should not be found: %#v %v", r, err)
+	}
+
+	l, err := me.AcquireLock(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := me.Lookup(ctx, "key"); err != nil || !r.Found || !r.OwnedByMe || r.Lock != l {
+		t.Fatalf("lock should be owned by its holder: %#v %v", r, err)
+	}
+	r, err := other.Lookup(ctx, "key")
+	if err != nil || !r.Found || r.Released || r.OwnedByMe || r.Lock != nil || r.Info.OwnerName != "me" {
+		t.Fatalf("lock should be seen as held by someone else: %#v %v", r, err)
+	}
+
+	if _, err := me.ReleaseLock(ctx, l); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := me.Lookup(ctx, "key"); err != nil || !r.Found || !r.Released || r.OwnedByMe {
+		t.Fatalf("released lock should be free: %#v %v", r, err)
+	}
+}
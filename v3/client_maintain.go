@@ -0,0 +1,135 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "context"
+
+// Daemonless makes the client run no background goroutines of its own, for
+// environments like AWS Lambda where they are unreliable between invocations.
+// Instead, the caller must periodically call Maintain, which sends the due
+// heartbeats, runs the session monitors and the lock callbacks, like
+// WithOwnershipLostCallback. The orphan detector does not run. Daemonless
+// clients cannot be part of a TableManager.
+func Daemonless() ClientOption {
+	return func(c *commonClient) { c.daemonless = true }
+}
+
+// MaintenanceReport summarizes a call to Maintain.
+type MaintenanceReport struct {
+	// Locks is the number of locks held by the client.
+	Locks int
+	// Heartbeats is the number of heartbeats sent.
+	Heartbeats int
+	// HeartbeatErrors are the heartbeats that failed.
+	HeartbeatErrors []HeartbeatError
+	// SessionMonitors is the number of session monitor callbacks run.
+	SessionMonitors int
+	// Callbacks is the number of lock callbacks run.
+	Callbacks int
+}
+
+// Maintain does, synchronously, the work of the background goroutines of
+// clients created with Daemonless. The locks whose last heartbeat is older
+// than the heartbeat period are heartbeated, so Maintain must be called more
+// often than the heartbeat period, and well within the lease duration. No
+// heartbeats are sent if the heartbeat period is zero. Then, it runs the
+// session monitors of the locks that entered the danger zone, and the lock
+// callbacks queued since the previous call. It can also be used with regular
+// clients, in which case only the heartbeats are sent. The given context is
+// passed down to the underlying dynamoDB calls.
+func (c *commonClient) Maintain(ctx context.Context) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	if c.isClosed() {
+		return report, ErrClientClosed
+	}
+	period := c.currentHeartbeatPeriod()
+	var monitored []*Lock
+	c.locks.Range(func(_ interface{}, value interface{}) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		lockItem := value.(*Lock)
+		if period > 0 && c.now().Sub(lockItem.LastHeartbeat()) >= period {
+			sent, err := c.heartbeatLock(ctx, lockItem)
+			if sent {
+				report.Heartbeats++
+			}
+			if err != nil {
+				report.HeartbeatErrors = append(report.HeartbeatErrors, HeartbeatError{Lock: lockItem, Err: err})
+			}
+		}
+		if c.daemonless && lockItem.sessionMonitor != nil && lockItem.sessionMonitor.callback != nil {
+			monitored = append(monitored, lockItem)
+		}
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	for _, lockItem := range monitored {
+		if c.sessionMonitorDue(lockItem) {
+			lockItem.sessionMonitor.callback()
+			report.SessionMonitors++
+		}
+	}
+	report.Callbacks = c.runPendingCallbacks()
+	c.locks.Range(func(_ interface{}, _ interface{}) bool {
+		report.Locks++
+		return true
+	})
+	return report, nil
+}
+
+// sessionMonitorDue reports, once, whether the lock entered the danger zone of
+// its session monitor.
+func (c *commonClient) sessionMonitorDue(lockItem *Lock) bool {
+	timeUntilDangerZone, err := lockItem.timeUntilDangerZoneEntered()
+	if err != nil || timeUntilDangerZone > 0 {
+		return false
+	}
+	lockItem.semaphore.Lock()
+	defer lockItem.semaphore.Unlock()
+	if lockItem.sessionMonitorRun {
+		return false
+	}
+	lockItem.sessionMonitorRun = true
+	return true
+}
+
+// runCallback runs a lock callback on its own goroutine, or queues it for
+// Maintain in daemonless clients. Callbacks are often fired while holding the
+// semaphore of the lock, so they cannot run synchronously.
+func (c *commonClient) runCallback(fn func()) {
+	if !c.daemonless {
+		go fn()
+		return
+	}
+	c.pendingCallbacksMu.Lock()
+	defer c.pendingCallbacksMu.Unlock()
+	c.pendingCallbacks = append(c.pendingCallbacks, fn)
+}
+
+func (c *commonClient) runPendingCallbacks() int {
+	c.pendingCallbacksMu.Lock()
+	pending := c.pendingCallbacks
+	c.pendingCallbacks = nil
+	c.pendingCallbacksMu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+	return len(pending)
+}
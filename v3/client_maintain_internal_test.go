@@ -0,0 +1,112 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaintain(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	var (
+		mu      sync.Mutex
+		failure error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failure = err
+	}
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		mu.Lock()
+		err := failure
+		mu.Unlock()
+		if op == "UpdateItem" && err != nil {
+			return nil, err
+		}
+		return next()
+	})
+	c, err := New(svc, "locksMaintain", "key",
+		Daemonless(),
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var monitored, lost int
+	_, err = c.AcquireLock(context.Background(), "maintain",
+		WithSessionMonitor(30*time.Millisecond, func() { monitored++ }),
+		WithOwnershipLostCallback(func(*Lock, error) { lost++ }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := c.Maintain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Locks != 1 || report.Heartbeats != 0 {
+		t.Fatalf("heartbeats should not be due yet: %#v", report)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if n := svc.callCount("UpdateItem"); n != 0 {
+		t.Fatal("daemonless clients should not heartbeat in the background:", n)
+	}
+	if report, err = c.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report.Heartbeats != 1 || len(report.HeartbeatErrors) != 0 || report.SessionMonitors != 0 {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+
+	fail(errors.New("network down"))
+	time.Sleep(40 * time.Millisecond)
+	if report, err = c.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report.Heartbeats != 1 || len(report.HeartbeatErrors) != 1 || report.SessionMonitors != 1 || monitored != 1 {
+		t.Fatalf("session monitor should run once the heartbeats fail: %#v", report)
+	}
+	if report, err = c.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report.SessionMonitors != 0 || monitored != 1 {
+		t.Fatalf("session monitor should run once: %#v", report)
+	}
+
+	fail(nil)
+	svc.deleteRow("locksMaintain", "maintain")
+	if report, err = c.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if report.Callbacks != 1 || lost != 1 || report.Locks != 0 {
+		t.Fatalf("ownership loss should be reported by Maintain: %#v", report)
+	}
+
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Maintain(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("closed clients cannot be maintained:", err)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TableManager holds locks across several lock tables, for example one table
+// per domain, on behalf of a single owner. The clients of the tables share the
+// owner name, the client options (such as the logger and the metrics hook) and
+// a single heartbeat goroutine, instead of running one per table, and they are
+// all closed together.
+type TableManager struct {
+	dynamoDB DynamoDBClient
+	opts     []ClientOption
+
+	ownerName       string
+	deregisterOwner func(string)
+	heartbeatPeriod time.Duration
+	stopHeartbeat   func()
+	background      sync.WaitGroup
+
+	mu      sync.RWMutex
+	closed  bool
+	tables  map[string]*commonClient
+	clients []*commonClient
+	// ownerRegistered tells whether the shared owner name was checked
+	// with the owner registry, when the first table was added.
+	ownerRegistered bool
+}
+
+// NewTableManager creates a manager whose tables are accessed with dynamoDB
+// and configured with opts. Per-table options are given to AddTable and
+// AddTableWithSortKey, but the owner name and the heartbeat period are shared
+// by all tables.
+func NewTableManager(dynamoDB DynamoDBClient, opts ...ClientOption) *TableManager {
+	shared := &commonClient{
+		ownerName:       randString(32),
+		heartbeatPeriod: defaultHeartbeatPeriod,
+	}
+	for _, opt := range opts {
+		opt(shared)
+	}
+	if shared.randSource != nil && !shared.ownerNameSet {
+		shared.ownerName = shared.randString(32)
+	}
+	m := &TableManager{
+		dynamoDB:        dynamoDB,
+		opts:            opts,
+		ownerName:       shared.ownerName,
+		deregisterOwner: shared.ownerDeregistry,
+		heartbeatPeriod: shared.heartbeatPeriod,
+		stopHeartbeat:   func() {},
+		tables:          make(map[string]*commonClient),
+	}
+	if m.heartbeatPeriod > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.stopHeartbeat = cancel
+		m.background.Add(1)
+		go m.heartbeat(ctx)
+	}
+	return m
+}
+
+// OwnerName returns the owner name shared by the clients of all tables.
+func (m *TableManager) OwnerName() string {
+	return m.ownerName
+}
+
+// AddTable creates the client of a lock table with a partition key only.
+func (m *TableManager) AddTable(tableName, partitionKeyName string, opts ...ClientOption) (*Client, error) {
+	c, err := m.addTable(tableName, partitionKeyName, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{c}
+	if err := client.ensureTable(client.createTableSchema); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// AddTableWithSortKey creates the client of a lock table with both partition
+// and sort keys.
+func (m *TableManager) AddTableWithSortKey(tableName, partitionKeyName, sortKeyName string, opts ...ClientOption) (*ClientWithSortKey, error) {
+	c, err := m.addTable(tableName, partitionKeyName, sortKeyName, opts)
+	if err != nil {
+		return nil, err
+	}
+	client := &ClientWithSortKey{c}
+	if err := client.ensureTable(client.createTableSchema); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (m *TableManager) addTable(tableName, partitionKeyName, sortKeyName string, opts []ClientOption) (*commonClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClientClosed
+	}
+	if _, ok := m.tables[tableName]; ok {
+		return nil, fmt.Errorf("table %q is already managed", tableName)
+	}
+	registered := m.ownerRegistered
+	allOpts := append(append(append([]ClientOption(nil), m.opts...), opts...), func(c *commonClient) {
+		c.ownerName = m.ownerName
+		c.heartbeatPeriod = m.heartbeatPeriod
+		c.heartbeatScheduler = m
+		c.stopHeartbeat = func() { m.unregister(c) }
+		// The shared owner name is deregistered by Close of the
+		// manager, as other tables may still use it.
+		c.ownerDeregistry = nil
+		if registered {
+			// The shared owner name was checked with the first table.
+			c.ownerRegistry = nil
+		}
+	})
+	c, err := newCommon(m.dynamoDB, tableName, partitionKeyName, sortKeyName, allOpts...)
+	if err != nil {
+		return nil, err
+	}
+	m.tables[tableName] = c
+	m.clients = append(m.clients, c)
+	m.ownerRegistered = true
+	return c, nil
+}
+
+// unregister stops heartbeating the locks of the given client. It waits for
+// the heartbeat cycle in progress, if any.
+func (m *TableManager) unregister(c *commonClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tables[c.tableName] == c {
+		delete(m.tables, c.tableName)
+	}
+	for i, registered := range m.clients {
+		if registered == c {
+			m.clients = append(m.clients[:i], m.clients[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *TableManager) heartbeat(ctx context.Context) {
+	defer m.background.Done()
+	tick := time.NewTicker(m.heartbeatPeriod)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		m.mu.RLock()
+		for _, c := range m.clients {
+			c.heartbeatLocks(ctx)
+		}
+		m.mu.RUnlock()
+	}
+}
+
+// Close stops the heartbeats and closes the clients of all tables, releasing
+// their locks, and deregisters the shared owner name (see WithOwnerRegistry).
+// It returns the errors of all clients that failed to close.
+func (m *TableManager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClientClosed
+	}
+	m.closed = true
+	clients := append([]*commonClient(nil), m.clients...)
+	m.mu.Unlock()
+
+	m.stopHeartbeat()
+	m.background.Wait()
+
+	var errs []error
+	for _, c := range clients {
+		if err := c.Close(ctx); err != nil && err != ErrClientClosed {
+			errs = append(errs, err)
+		}
+	}
+	m.mu.RLock()
+	registered := m.ownerRegistered
+	m.mu.RUnlock()
+	if registered && m.deregisterOwner != nil {
+		m.deregisterOwner(m.ownerName)
+	}
+	return joinMultiErrors(errs)
+}
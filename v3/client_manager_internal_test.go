@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestTableManager(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	m := NewTableManager(svc,
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(50*time.Millisecond),
+	)
+	orders, err := m.AddTable("orders", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments, err := m.AddTableWithSortKey("payments", "key", "sortKey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddTable("orders", "key"); err == nil {
+		t.Fatal("tables cannot be added twice")
+	}
+	if orders.ownerName != m.OwnerName() || payments.ownerName != m.OwnerName() {
+		t.Fatal("owner name must be shared:", orders.ownerName, payments.ownerName, m.OwnerName())
+	}
+	if orders.heartbeatScheduler != m || payments.heartbeatScheduler != m {
+		t.Fatal("tables must not run their own heartbeats")
+	}
+
+	ctx := context.Background()
+	if _, err := orders.AcquireLock(ctx, "order-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payments.AcquireLock(ctx, "payment-1", "2021"); err != nil {
+		t.Fatal(err)
+	}
+	heartbeated := func() map[string]bool {
+		tables := make(map[string]bool)
+		for _, u := range svc.updateInputs() {
+			tables[aws.ToString(u.TableName)] = true
+		}
+		return tables
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tables := heartbeated(); !tables["orders"] || !tables["payments"]; tables = heartbeated() {
+		if time.Now().After(deadline) {
+			t.Fatal("locks of all tables should be heartbeated:", tables)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := m.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orders.AcquireLock(ctx, "order-2"); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("tables should be closed with the manager:", err)
+	}
+	if _, err := payments.AcquireLock(ctx, "payment-2", "2021"); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("tables should be closed with the manager:", err)
+	}
+	if _, err := m.AddTable("refunds", "key"); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("tables cannot be added to a closed manager:", err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SchemaVersion is the version of the lock row format written by this
+// client. Rows written before the schemaVersion attribute was introduced are
+// considered to be version 0.
+const SchemaVersion = 1
+
+// MigrationStep transforms, in place, a lock row written with an older
+// schema version.
+type MigrationStep func(item map[string]types.AttributeValue) error
+
+// RenameAttribute is a MigrationStep that renames the attribute from into to.
+// Rows without the attribute are left untouched.
+func RenameAttribute(from, to string) MigrationStep {
+	return func(item map[string]types.AttributeValue) error {
+		if v, ok := item[from]; ok {
+			delete(item, from)
+			item[to] = v
+		}
+		return nil
+	}
+}
+
+// MigrateTable upgrades, in place, the lock rows stamped with a schema
+// version older than SchemaVersion: the steps are applied in order to each of
+// them, and the row is stamped with the current version. Rows that change
+// while being migrated, because they were heartbeated or acquired, are left
+// alone as their new owner already writes the current version. It returns the
+// number of migrated rows. The given context is passed down to the underlying
+// dynamoDB calls.
+func (c *commonClient) MigrateTable(ctx context.Context, steps ...MigrationStep) (int, error) {
+	if c.isClosed() {
+		return 0, ErrClientClosed
+	}
+	if c.v2Compatibility {
+		return 0, errors.New("tables shared with cirello.io/dynamolock/v2 cannot be migrated")
+	}
+	migrated := 0
+	err := c.scanTable(ctx, func(item map[string]types.AttributeValue) error {
+		if readInt64Attr(item[attrSchemaVersion]) >= SchemaVersion {
+			return nil
+		}
+		ok, err := c.migrateItem(ctx, item, steps)
+		if ok {
+			migrated++
+		}
+		return err
+	})
+	return migrated, err
+}
+
+func (c *commonClient) migrateItem(ctx context.Context, item map[string]types.AttributeValue, steps []MigrationStep) (bool, error) {
+	newItem := make(map[string]types.AttributeValue, len(item)+1)
+	for k, v := range item {
+		newItem[k] = v
+	}
+	for _, step := range steps {
+		if err := step(newItem); err != nil {
+			return false, err
+		}
+	}
+	newItem[attrSchemaVersion] = int64AttrValue(SchemaVersion)
+
+	cond := rvnAttr.AttributeNotExists()
+	if rvn, ok := item[attrRecordVersionNumber]; ok {
+		cond = rvnAttr.Equal(expression.Value(readStringAttr(rvn)))
+	}
+	putItemExpr, _ := expression.NewBuilder().WithCondition(cond).Build()
+	_, err := c.dynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(c.tableName),
+		Item:                      newItem,
+		ConditionExpression:       putItemExpr.Condition(),
+		ExpressionAttributeNames:  putItemExpr.Names(),
+		ExpressionAttributeValues: putItemExpr.Values(),
+	})
+	if isOwnershipLost(err) {
+		c.logger.Info(ctx, "skipping migration of changed lock ", readKeyAttr(item[c.partitionKeyName]))
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestMigrateTable(t *testing.T) {
+	svc := newPagedScanDynamoDBClient("locksMigrate",
+		map[string]types.AttributeValue{
+			"key":                   stringAttrValue("old"),
+			attrOwnerName:           stringAttrValue("owner"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+			"legacyStatus":          stringAttrValue("running"),
+		},
+		map[string]types.AttributeValue{
+			"key":                   stringAttrValue("current"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+			attrSchemaVersion:       int64AttrValue(SchemaVersion),
+		},
+		map[string]types.AttributeValue{
+			"key":                   stringAttrValue("changed"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+		},
+	)
+	paged := svc.intercept
+	var scanned []map[string]types.AttributeValue
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if put, ok := input.(*dynamodb.PutItemInput); ok && readStringAttr(put.Item["key"]) == "changed" {
+			// The row changes between the scan and the migration.
+			svc.setAttributes("locksMigrate", map[string]types.AttributeValue{
+				attrRecordVersionNumber: stringAttrValue("new-rvn"),
+			}, "changed")
+		}
+		out, err := paged(ctx, op, input, next)
+		if scan, ok := out.(*dynamodb.ScanOutput); ok {
+			scanned = append(scanned, scan.Items...)
+		}
+		return out, err
+	})
+	c, err := New(svc, "locksMigrate", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := c.MigrateTable(context.Background(), RenameAttribute("legacyStatus", "status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 1 || svc.callCount("PutItem") != 2 {
+		t.Fatal("unexpected number of migrated rows:", migrated, svc.callCount("PutItem"))
+	}
+	item := svc.row("locksMigrate", "old")
+	if readStringAttr(item["status"]) != "running" || item["legacyStatus"] != nil {
+		t.Fatalf("attribute not renamed: %#v", item)
+	}
+	if readInt64Attr(item[attrSchemaVersion]) != SchemaVersion || readStringAttr(item[attrOwnerName]) != "owner" {
+		t.Fatalf("unexpected migrated row: %#v", item)
+	}
+	if changed := svc.row("locksMigrate", "changed"); changed[attrSchemaVersion] != nil {
+		t.Fatalf("a row changed since the scan must not be migrated: %#v", changed)
+	}
+	for _, row := range scanned {
+		if readStringAttr(row["key"]) == "old" && readStringAttr(row["legacyStatus"]) != "running" {
+			t.Fatal("scanned row must not be modified")
+		}
+	}
+}
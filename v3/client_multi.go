@@ -0,0 +1,223 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidQuorum is returned by NewMultiClient when the quorum cannot be
+// reached with the given clients.
+var ErrInvalidQuorum = errors.New("quorum must be between 1 and the number of clients")
+
+// MultiClient acquires the same lock on several independent lock tables
+// (possibly in different regions) and only declares success once a quorum of
+// them granted it. It is meant for critical leadership elections that cannot
+// depend on a single DynamoDB table or region.
+type MultiClient struct {
+	clients []*Client
+	quorum  int
+}
+
+// NewMultiClient creates a client that requires quorum out of the given
+// clients to grant a lock. Each client should point to an independent table.
+func NewMultiClient(quorum int, clients ...*Client) (*MultiClient, error) {
+	if quorum < 1 || quorum > len(clients) {
+		return nil, ErrInvalidQuorum
+	}
+	return &MultiClient{clients: clients, quorum: quorum}, nil
+}
+
+// MultiLock is a lock granted by a quorum of the clients of a MultiClient.
+type MultiLock struct {
+	quorum  int
+	locks   []*Lock
+	clients []*Client
+}
+
+// Locks returns the individual locks that form the quorum.
+func (m *MultiLock) Locks() []*Lock {
+	if m == nil {
+		return nil
+	}
+	return append([]*Lock(nil), m.locks...)
+}
+
+// IsExpired returns if the lock is no longer held by a quorum of the tables.
+func (m *MultiLock) IsExpired() bool {
+	if m == nil {
+		return true
+	}
+	held := 0
+	for _, l := range m.locks {
+		if !l.IsExpired() {
+			held++
+		}
+	}
+	return held < m.quorum
+}
+
+// Close releases all the individual locks. The failed releases are returned
+// as LockErrors.
+func (m *MultiLock) Close() error {
+	if m == nil {
+		return ErrCannotReleaseNullLock
+	}
+	errs := make(LockErrors)
+	for _, l := range m.locks {
+		if err := l.Close(); err != nil && !errors.Is(err, ErrLockAlreadyReleased) {
+			errs[l] = err
+		}
+	}
+	return errs.orNil()
+}
+
+// AcquireLock tries to acquire the lock on all the tables at the same time and
+// succeeds if at least a quorum of them grants it. If the quorum is not
+// reached, the locks that were granted are released and the error of one of
+// the failed attempts is returned wrapped in a LockNotGrantedError. The given
+// context is passed down to the underlying dynamoDB calls.
+func (m *MultiClient) AcquireLock(ctx context.Context, key string, opts ...AcquireLockOption) (*MultiLock, error) {
+	locks := make([]*Lock, len(m.clients))
+	errs := make([]error, len(m.clients))
+	var wg sync.WaitGroup
+	for i, c := range m.clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			locks[i], errs[i] = c.AcquireLock(ctx, key, opts...)
+		}(i, c)
+	}
+	wg.Wait()
+
+	ml := &MultiLock{quorum: m.quorum}
+	var failure error
+	for i, l := range locks {
+		if errs[i] != nil {
+			failure = errs[i]
+			continue
+		}
+		ml.locks = append(ml.locks, l)
+		ml.clients = append(ml.clients, m.clients[i])
+	}
+	if len(ml.locks) >= m.quorum {
+		return ml, nil
+	}
+	for i, l := range ml.locks {
+		if _, err := ml.clients[i].ReleaseLock(ctx, l); err != nil {
+			ml.clients[i].logger.Error(ctx, "cannot release lock after failing to reach quorum: ", err)
+		}
+	}
+	return nil, &LockNotGrantedError{
+		msg:   fmt.Sprintf("quorum not reached (%d of %d)", len(ml.locks), m.quorum),
+		cause: failure,
+	}
+}
+
+// ReleaseLock releases the individual locks on all the tables. The failed
+// releases are returned as LockErrors. The given context is passed down to the
+// underlying dynamoDB calls.
+func (m *MultiClient) ReleaseLock(ctx context.Context, lock *MultiLock, opts ...ReleaseLockOption) (bool, error) {
+	if lock == nil {
+		return false, ErrCannotReleaseNullLock
+	}
+	released := 0
+	errs := make(LockErrors)
+	for i, l := range lock.locks {
+		ok, err := lock.clients[i].ReleaseLock(ctx, l, opts...)
+		if err != nil {
+			errs[l] = err
+		}
+		if ok {
+			released++
+		}
+	}
+	return released >= lock.quorum, errs.orNil()
+}
+
+// Close closes all the underlying clients.
+func (m *MultiClient) Close(ctx context.Context) error {
+	var errs []error
+	for _, c := range m.clients {
+		if err := c.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinMultiErrors(errs)
+}
+
+// joinMultiErrors combines the errors of several clients. The LockErrors
+// among them are merged into one, so every failed lock can be inspected.
+func joinMultiErrors(errs []error) error {
+	var (
+		others []error
+		locks  = make(LockErrors)
+	)
+	for _, err := range errs {
+		if lockErrs, ok := err.(LockErrors); ok {
+			for l, lockErr := range lockErrs {
+				locks[l] = lockErr
+			}
+			continue
+		}
+		others = append(others, err)
+	}
+	if len(locks) > 0 {
+		others = append(others, locks)
+	}
+	switch len(others) {
+	case 0:
+		return nil
+	case 1:
+		return others[0]
+	}
+	return multiError(others)
+}
+
+// multiError is a combination of errors that errors.Is and errors.As look
+// into.
+type multiError []error
+
+func (e multiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e multiError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e multiError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
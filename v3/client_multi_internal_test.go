@@ -0,0 +1,127 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+var errRegionDown = errors.New("region down")
+
+type unavailableDynamoDBClient struct {
+	mockDynamoDBClient
+}
+
+func (m *unavailableDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return nil, errRegionDown
+}
+
+func (m *unavailableDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return nil, errRegionDown
+}
+
+func TestMultiClient(t *testing.T) {
+	newClient := func(svc DynamoDBClient) *Client {
+		c, err := New(svc, "locksMulti", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	if _, err := NewMultiClient(3, newClient(&mockDynamoDBClient{})); !errors.Is(err, ErrInvalidQuorum) {
+		t.Fatal("expected invalid quorum error:", err)
+	}
+
+	t.Run("quorum reached", func(t *testing.T) {
+		mc, err := NewMultiClient(2,
+			newClient(&mockDynamoDBClient{}),
+			newClient(&unavailableDynamoDBClient{}),
+			newClient(&mockDynamoDBClient{}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mc.Close(context.Background())
+		l, err := mc.AcquireLock(context.Background(), "leader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Locks()) != 2 || l.IsExpired() {
+			t.Fatal("unexpected quorum lock:", len(l.Locks()), l.IsExpired())
+		}
+		if released, err := mc.ReleaseLock(context.Background(), l); !released || err != nil {
+			t.Fatal("cannot release quorum lock:", released, err)
+		}
+		if !l.IsExpired() {
+			t.Fatal("released quorum lock should be expired")
+		}
+	})
+
+	t.Run("quorum not reached", func(t *testing.T) {
+		healthy := newClient(&mockDynamoDBClient{})
+		mc, err := NewMultiClient(2,
+			healthy,
+			newClient(&unavailableDynamoDBClient{}),
+			newClient(&unavailableDynamoDBClient{}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mc.Close(context.Background())
+		_, err = mc.AcquireLock(context.Background(), "leader")
+		var errNotGranted *LockNotGrantedError
+		if !errors.As(err, &errNotGranted) || !errors.Is(err, errRegionDown) {
+			t.Fatal("expected lock not granted error:", err)
+		}
+		if _, ok := healthy.locks.Load(lockKey{partitionKey: "leader"}); ok {
+			t.Fatal("lock granted by the minority should have been released")
+		}
+	})
+}
+
+func TestJoinMultiErrors(t *testing.T) {
+	errA, errB := errors.New("a failed"), errors.New("b failed")
+	a, b := &Lock{partitionKey: "a"}, &Lock{partitionKey: "b"}
+	err := joinMultiErrors([]error{
+		LockErrors{a: errA},
+		ErrClientClosed,
+		LockErrors{b: errB},
+	})
+	for _, target := range []error{errA, errB, ErrClientClosed} {
+		if !errors.Is(err, target) {
+			t.Error("combined error should match", target, ":", err)
+		}
+	}
+	var lockErrs LockErrors
+	if !errors.As(err, &lockErrs) {
+		t.Fatal("combined error should carry the lock errors:", err)
+	}
+	if len(lockErrs) != 2 || lockErrs[a] != errA || lockErrs[b] != errB {
+		t.Fatal("the lock errors of all clients should be merged:", lockErrs)
+	}
+	if got, want := lockErrs.Error(), "2 locks failed: a: a failed; b: b failed"; got != want {
+		t.Fatalf("unexpected message: got %q, want %q", got, want)
+	}
+	if err := joinMultiErrors(nil); err != nil {
+		t.Fatal("no errors should join into nil:", err)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	orphanScanPageSize  = 100
+	orphanScanPageDelay = time.Second
+)
+
+// WithOrphanDetector starts a background job that scans the lock table every
+// interval looking for orphan locks: rows that were not heartbeated for longer
+// than their lease duration plus threshold, usually leaked by crashed
+// processes. The expiresAt attribute of the rows depends on the clock of their
+// writer, so a lock is only recognized as orphan after being observed
+// unchanged in consecutive scans.
+// Each orphan is reported once to handler, which can log it, emit a metric or
+// remove it with DeleteOrphanLock. The scan reads the table in small pages,
+// spaced apart, so it does not compete for capacity with lock operations.
+func WithOrphanDetector(interval, threshold time.Duration, handler func(ctx context.Context, l *Lock, staleFor time.Duration)) ClientOption {
+	return func(c *commonClient) {
+		c.orphanInterval = interval
+		c.orphanThreshold = threshold
+		c.orphanHandler = handler
+	}
+}
+
+type orphanObservation struct {
+	recordVersionNumber string
+	since               time.Time
+	reported            bool
+}
+
+func (c *commonClient) detectOrphans(ctx context.Context) {
+	defer c.background.Done()
+	c.logger.Info(ctx, "starting orphan detector")
+	tick := time.NewTicker(c.orphanInterval)
+	defer tick.Stop()
+	observations := make(map[lockKey]*orphanObservation)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if err := c.scanOrphans(ctx, observations); err != nil && ctx.Err() == nil {
+			c.logger.Error(ctx, "error scanning for orphan locks: ", err)
+		}
+	}
+}
+
+func (c *commonClient) scanOrphans(ctx context.Context, observations map[lockKey]*orphanObservation) error {
+	seen := make(map[lockKey]bool)
+	err := c.scanTablePaced(ctx, orphanScanPageSize, orphanScanPageDelay, func(item map[string]types.AttributeValue) error {
+		key := lockKey{partitionKey: readKeyAttr(item[c.partitionKeyName])}
+		if c.sortKeyName != "" {
+			key.sortKey = readKeyAttr(item[c.sortKeyName])
+		}
+		if _, held := c.locks.Load(key); held {
+			return nil
+		}
+		if c.isReleasedItem(item) {
+			return nil
+		}
+		seen[key] = true
+		rvn := readStringAttr(item[attrRecordVersionNumber])
+		obs, ok := observations[key]
+		if !ok || obs.recordVersionNumber != rvn {
+			observations[key] = &orphanObservation{recordVersionNumber: rvn, since: c.now()}
+			return nil
+		}
+		if obs.reported {
+			return nil
+		}
+		lockItem, err := c.createLockItem(getLockOptions{
+			partitionKey: key.partitionKey,
+			sortKey:      key.sortKey,
+		}, copyItem(item))
+		if err != nil {
+			return err
+		}
+		staleFor := c.now().Sub(obs.since) - lockItem.leaseDuration
+		if staleFor <= c.orphanThreshold {
+			return nil
+		}
+		obs.reported = true
+		c.orphanHandler(ctx, lockItem, staleFor)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key := range observations {
+		if !seen[key] {
+			delete(observations, key)
+		}
+	}
+	return nil
+}
+
+// DeleteOrphanLock removes a lock row, found by the orphan detector or read
+// with Get, as long as it was not updated since it was read. The given context
+// is passed down to the underlying dynamoDB call.
+func (c *commonClient) DeleteOrphanLock(ctx context.Context, lockItem *Lock) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if lockItem == nil {
+		return ErrCannotReleaseNullLock
+	}
+	ctx = routeToLock(ctx, lockItem)
+	lockItem.semaphore.Lock()
+	cond := ExpiredLockCondition(c.partitionKeyName, lockItem.recordVersionNumber)
+	key := c.getItemKeys(lockItem)
+	lockItem.semaphore.Unlock()
+
+	delExpr, _ := expression.NewBuilder().WithCondition(cond).Build()
+	_, err := c.dynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       key,
+		ConditionExpression:       delExpr.Condition(),
+		ExpressionAttributeNames:  delExpr.Names(),
+		ExpressionAttributeValues: delExpr.Values(),
+	})
+	return parseDynamoDBError(err, "lock was updated since it was read")
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestOrphanDetector(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksOrphans", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("orphan"),
+		attrOwnerName:           stringAttrValue("crashed-process"),
+		attrLeaseDuration:       stringAttrValue("10ms"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	})
+	svc.putRow("locksOrphans", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("released"),
+		attrOwnerName:           stringAttrValue("well-behaved-process"),
+		attrLeaseDuration:       stringAttrValue("10ms"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+		attrIsReleased:          stringAttrValue("1"),
+	})
+	orphans := make(chan *Lock, 10)
+	c, err := New(svc, "locksOrphans", "key",
+		DisableHeartbeat(),
+		WithOrphanDetector(20*time.Millisecond, 0, func(ctx context.Context, l *Lock, staleFor time.Duration) {
+			if staleFor <= 0 {
+				t.Error("unexpected stale duration:", staleFor)
+			}
+			orphans <- l
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	var orphan *Lock
+	select {
+	case orphan = <-orphans:
+		if orphan.PartitionKey() != "orphan" || orphan.OwnerName() != "crashed-process" {
+			t.Fatalf("unexpected orphan: %s %s", orphan.PartitionKey(), orphan.OwnerName())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("orphan lock not detected")
+	}
+	select {
+	case l := <-orphans:
+		t.Fatal("orphan reported more than once or released lock reported:", l.PartitionKey())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := c.DeleteOrphanLock(context.Background(), orphan); err != nil {
+		t.Fatal(err)
+	}
+	if svc.row("locksOrphans", "orphan") != nil {
+		t.Fatal("orphan lock row not deleted")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrOwnerNameRequired is returned by New when WithRequiredOwnerName is used
+// but no owner name was given with WithOwnerName.
+var ErrOwnerNameRequired = errors.New("owner name is required")
+
+// WithRequiredOwnerName makes New fail with ErrOwnerNameRequired unless an
+// explicit, non-empty owner name is given with WithOwnerName, instead of
+// falling back to a random one. It is meant for deployments in which owner
+// names must map to identifiable instances.
+func WithRequiredOwnerName() ClientOption {
+	return func(c *commonClient) { c.requireOwnerName = true }
+}
+
+// WithOwnerRegistry makes New call register with the owner name of the
+// client, and fail if it returns an error. It allows checking the owner name
+// against a registry of live instances, so two clients never share the same
+// owner name (and therefore each other's locks). Close calls deregister, if
+// not nil, with the owner name, and Restart registers the owner name again.
+// The clients of a TableManager register the shared owner name once, and
+// deregister it when the TableManager is closed.
+func WithOwnerRegistry(register func(ownerName string) error, deregister func(ownerName string)) ClientOption {
+	return func(c *commonClient) {
+		c.ownerRegistry = register
+		c.ownerDeregistry = deregister
+	}
+}
+
+const ownerNameSuffixLength = 6
+
+// WithOwnerNameFromHost sets the owner name to the hostname and the process ID,
+// followed by a short random suffix (for example, "web-1.4242.f3QzJd"), so the
+// holder of each lock can be identified in logs and in the DynamoDB console.
+// It is equivalent to WithOwnerNameTemplate("{host}.{pid}.{rand}").
+func WithOwnerNameFromHost() ClientOption {
+	return WithOwnerNameTemplate("{host}.{pid}.{rand}")
+}
+
+// WithOwnerNameTemplate sets the owner name by expanding the placeholders of
+// tmpl: {host} is replaced by the hostname, {pid} by the process ID and
+// {rand} by a short random string, which keeps the owner names of restarted
+// processes apart.
+func WithOwnerNameTemplate(tmpl string) ClientOption {
+	return func(c *commonClient) {
+		WithOwnerName(c.expandOwnerName(tmpl))(c)
+	}
+}
+
+func (c *commonClient) expandOwnerName(tmpl string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return strings.NewReplacer(
+		"{host}", host,
+		"{pid}", strconv.Itoa(os.Getpid()),
+		"{rand}", c.randString(ownerNameSuffixLength),
+	).Replace(tmpl)
+}
+
+func (c *commonClient) validateOwnerName(ownerName string) error {
+	if c.requireOwnerName && (!c.ownerNameSet || ownerName == "") {
+		return ErrOwnerNameRequired
+	}
+	if c.ownerRegistry != nil {
+		if err := c.ownerRegistry(ownerName); err != nil {
+			return fmt.Errorf("owner name %q rejected by the registry: %w", ownerName, err)
+		}
+	}
+	return nil
+}
+
+func (c *commonClient) deregisterOwnerName(ownerName string) {
+	if c.ownerDeregistry != nil {
+		c.ownerDeregistry(ownerName)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequiredOwnerName(t *testing.T) {
+	if _, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithRequiredOwnerName()); !errors.Is(err, ErrOwnerNameRequired) {
+		t.Fatal("expected owner name required error:", err)
+	}
+	if _, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithRequiredOwnerName(), WithOwnerName("")); !errors.Is(err, ErrOwnerNameRequired) {
+		t.Fatal("expected owner name required error:", err)
+	}
+	c, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithOwnerName("worker-1"), WithRequiredOwnerName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ownerName != "worker-1" {
+		t.Fatal("unexpected owner name:", c.ownerName)
+	}
+}
+
+func TestOwnerRegistry(t *testing.T) {
+	errTaken := errors.New("taken")
+	registered := map[string]bool{}
+	register := func(ownerName string) error {
+		if registered[ownerName] {
+			return errTaken
+		}
+		registered[ownerName] = true
+		return nil
+	}
+	deregister := func(ownerName string) {
+		delete(registered, ownerName)
+	}
+	c, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithOwnerName("worker-1"), WithOwnerRegistry(register, deregister))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithOwnerName("worker-1"), WithOwnerRegistry(register, deregister)); !errors.Is(err, errTaken) {
+		t.Fatal("expected registry error:", err)
+	}
+
+	t.Run("close", func(t *testing.T) {
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if registered["worker-1"] {
+			t.Fatal("Close must deregister the owner name")
+		}
+	})
+	t.Run("restart", func(t *testing.T) {
+		if err := c.Restart(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !registered["worker-1"] {
+			t.Fatal("Restart must register the owner name again")
+		}
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		registered["worker-1"] = true
+		if err := c.Restart(context.Background()); !errors.Is(err, errTaken) {
+			t.Fatal("expected registry error:", err)
+		}
+		delete(registered, "worker-1")
+	})
+	t.Run("restart with generated owner name", func(t *testing.T) {
+		c, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithOwnerRegistry(register, deregister))
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous := c.ownerName
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Restart(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if registered[previous] || !registered[c.ownerName] || len(registered) != 1 {
+			t.Fatal("unexpected registrations:", registered)
+		}
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("table manager", func(t *testing.T) {
+		m := NewTableManager(&mockDynamoDBClient{}, DisableHeartbeat(), WithOwnerName("worker-2"), WithOwnerRegistry(register, deregister))
+		first, err := m.AddTable("locksOwnerA", "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := first.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !registered["worker-2"] {
+			t.Fatal("the shared owner name must stay registered while the manager is open")
+		}
+		if _, err := m.AddTable("locksOwnerB", "key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if registered["worker-2"] {
+			t.Fatal("Close of the manager must deregister the owner name")
+		}
+	})
+}
+
+func TestOwnerNameTemplate(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("hostname not available:", err)
+	}
+	c, err := New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithOwnerNameFromHost(), WithRequiredOwnerName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := host + "." + strconv.Itoa(os.Getpid()) + "."
+	if !strings.HasPrefix(c.ownerName, prefix) || len(c.ownerName) != len(prefix)+ownerNameSuffixLength {
+		t.Fatal("unexpected owner name:", c.ownerName)
+	}
+
+	c, err = New(&mockDynamoDBClient{}, "locksOwner", "key", DisableHeartbeat(), WithOwnerNameTemplate("billing@{host}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ownerName != "billing@"+host {
+		t.Fatal("unexpected owner name:", c.ownerName)
+	}
+}
+
+func TestWithRandSource(t *testing.T) {
+	newClient := func() (*Client, *Lock) {
+		c, err := New(&mockDynamoDBClient{}, "locksOwner", "key",
+			DisableHeartbeat(),
+			WithRandSource(rand.New(rand.NewSource(42))),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "seeded")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, l
+	}
+	c1, l1 := newClient()
+	c2, l2 := newClient()
+	if c1.ownerName != c2.ownerName || len(c1.ownerName) != 32 {
+		t.Fatal("owner names should be drawn from the source:", c1.ownerName, c2.ownerName)
+	}
+	if l1.recordVersionNumber != l2.recordVersionNumber {
+		t.Fatal("record version numbers should be drawn from the source:", l1.recordVersionNumber, l2.recordVersionNumber)
+	}
+	if c1.ownerName == l1.recordVersionNumber {
+		t.Fatal("the source should not be rewound")
+	}
+}
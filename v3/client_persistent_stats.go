@@ -0,0 +1,106 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// PersistentStats is the usage history stored in the lock row when
+// WithPersistentStats is used.
+type PersistentStats struct {
+	// TimesAcquired counts how many times the lock was acquired.
+	TimesAcquired int64
+	// LastAcquiredAt is when the lock was last acquired, according to the
+	// clock of the acquiring client.
+	LastAcquiredAt time.Time
+	// LastOwner is the owner that last acquired the lock.
+	LastOwner string
+	// LastReleasedAt is when the lock was last released, according to the
+	// clock of the releasing client. It is zero if the lock was not released
+	// since it was last acquired.
+	LastReleasedAt time.Time
+}
+
+// WithPersistentStats makes the client maintain usage counters in the lock
+// rows: the timesAcquired, lastAcquiredAt, lastOwner and lastReleasedAt
+// attributes are written along with every acquisition and release, so the
+// table itself carries a lightweight usage history readable by any tool.
+// Timestamps are stored as Unix milliseconds. The counters are lost when the
+// lock row is deleted, for example on release with WithDeleteLockOnRelease.
+// It has no effect with WithV2Compatibility.
+func WithPersistentStats() ClientOption {
+	return func(c *commonClient) { c.persistentStats = true }
+}
+
+// PersistentStats returns the usage history of the lock, as seen in the last
+// read or acquisition.
+func (l *Lock) PersistentStats() PersistentStats {
+	if l == nil {
+		return PersistentStats{}
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.persistentStats
+}
+
+func readPersistentStats(item map[string]types.AttributeValue) PersistentStats {
+	return PersistentStats{
+		TimesAcquired:  readInt64Attr(item[attrTimesAcquired]),
+		LastAcquiredAt: readUnixMillisAttr(item[attrLastAcquiredAt]),
+		LastOwner:      readStringAttr(item[attrLastOwner]),
+		LastReleasedAt: readUnixMillisAttr(item[attrLastReleasedAt]),
+	}
+}
+
+func readUnixMillisAttr(attr types.AttributeValue) time.Time {
+	ms := readInt64Attr(attr)
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// addAcquisitionStats stores the usage counters of an acquisition in the new
+// lock row. As the row is written conditionally on the state of the existing
+// lock, the counter is incremented atomically.
+func (c *commonClient) addAcquisitionStats(item map[string]types.AttributeValue, existingLock *Lock) {
+	if !c.persistentStats || c.v2Compatibility {
+		return
+	}
+	var timesAcquired int64
+	if existingLock != nil {
+		timesAcquired = existingLock.persistentStats.TimesAcquired
+	}
+	item[attrTimesAcquired] = int64AttrValue(timesAcquired + 1)
+	item[attrLastAcquiredAt] = int64AttrValue(unixMillis(c.now()))
+	item[attrLastOwner] = stringAttrValue(c.currentOwnerName())
+}
+
+func (c *commonClient) addReleaseStats(update expression.UpdateBuilder) expression.UpdateBuilder {
+	if !c.persistentStats || c.v2Compatibility {
+		return update
+	}
+	return update.Set(expression.Name(attrLastReleasedAt), expression.Value(unixMillis(c.now())))
+}
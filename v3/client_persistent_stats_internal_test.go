@@ -0,0 +1,80 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestPersistentStats(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksPersistentStats", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("stats"),
+		attrOwnerName:           stringAttrValue("previous"),
+		attrLeaseDuration:       stringAttrValue("1h"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+		attrIsReleased:          stringAttrValue("1"),
+		attrTimesAcquired:       int64AttrValue(41),
+		attrLastOwner:           stringAttrValue("previous"),
+	})
+	c, err := New(svc, "locksPersistentStats", "key",
+		DisableHeartbeat(),
+		WithOwnerName("me"),
+		WithClock(clock),
+		WithPersistentStats(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PersistentStats{TimesAcquired: 42, LastAcquiredAt: clock.Now(), LastOwner: "me"}
+	if got := readPersistentStats(svc.row("locksPersistentStats", "stats")); got != want {
+		t.Fatalf("unexpected stored stats: %#v", got)
+	}
+	if got := l.PersistentStats(); got != want {
+		t.Fatalf("unexpected lock stats: %#v", got)
+	}
+	if attrs := l.AdditionalAttributes(); len(attrs) != 0 {
+		t.Fatal("stats must not leak as additional attributes:", attrs)
+	}
+
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.callCount("UpdateItem"); got != 1 {
+		t.Fatal("unexpected number of updates:", got)
+	}
+
+	plain, err := New(svc, "locksPersistentStats", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.AcquireLock(context.Background(), "stats"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := svc.row("locksPersistentStats", "stats")[attrTimesAcquired]; ok {
+		t.Fatal("stats should only be stored with WithPersistentStats")
+	}
+}
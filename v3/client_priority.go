@@ -0,0 +1,193 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// PreemptionRequest describes a higher-priority waiter asking the current
+// holder to release a lock early.
+type PreemptionRequest struct {
+	OwnerName string
+	Priority  int64
+	// Notice is how long the holder has to release the lock before it stops
+	// being heartbeated. It is zero if the waiter did not give a notice.
+	Notice time.Duration
+}
+
+// ErrLockPreempted is the cause of the heartbeat failures of locks whose
+// preemption notice period elapsed. See WithPreemptionNotice.
+var ErrLockPreempted = errors.New("lock preempted")
+
+// WithPriority stores the priority of the acquisition in the lock. Locks
+// without a priority have priority zero. See RequestPreemption.
+func WithPriority(priority int64) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.priority = priority
+	}
+}
+
+// RequestPreemption makes the client, while waiting for a lock held with a
+// lower priority, record its intent in the lock row. The holder gets to know
+// about it in its next heartbeat, and may decide to release the lock early.
+// See WithPreemptionCallback.
+func RequestPreemption() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.requestPreemption = true
+	}
+}
+
+// WithPreemptionNotice works as RequestPreemption, but also gives the holder a
+// notice period: if the holder does not release the lock within notice from
+// the heartbeat in which it learned about the request, the holder stops
+// heartbeating the lock, and the waiter takes it over once its lease expires.
+// Such takeovers are reported in AcquisitionInfo.PreemptedOwner. Preempted
+// holders notice that the lock is gone through their session monitor and the
+// heartbeat errors, which are caused by ErrLockPreempted. The notice period
+// is added to the time the acquisition waits for the lock.
+func WithPreemptionNotice(notice time.Duration) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.requestPreemption = true
+		opt.preemptionNotice = notice
+	}
+}
+
+// WithPreemptionCallback registers a callback that is called, at most once,
+// when a higher-priority waiter asks for the lock. The callback is not
+// expected to release the lock, but it is a hint that it should do so as
+// soon as it is safe.
+func WithPreemptionCallback(callback func(*Lock, PreemptionRequest)) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.preemptionCallback = callback
+	}
+}
+
+// Priority returns the priority with which the lock was acquired.
+func (l *Lock) Priority() int64 {
+	if l == nil {
+		return 0
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.priority
+}
+
+// PreemptionRequested returns whether a higher-priority waiter asked for this
+// lock, as seen in the last heartbeat or read.
+func (l *Lock) PreemptionRequested() (PreemptionRequest, bool) {
+	if l == nil {
+		return PreemptionRequest{}, false
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	if l.preemptionRequest == nil {
+		return PreemptionRequest{}, false
+	}
+	return *l.preemptionRequest, true
+}
+
+func (c *commonClient) tryRequestPreemption(ctx context.Context, getLockOptions *getLockOptions, existingLock *Lock) {
+	if c.v2Compatibility || !getLockOptions.requestPreemption ||
+		existingLock.priority >= getLockOptions.priority ||
+		getLockOptions.preemptionRequestedFrom == existingLock.ownerName {
+		return
+	}
+	priority := expression.Value(getLockOptions.priority)
+	preemptionPriorityAttr := expression.Name(attrPreemptionPriority)
+	cond := expression.And(
+		expression.AttributeExists(expression.Name(c.partitionKeyName)),
+		expression.Equal(ownerNameAttr, expression.Value(existingLock.ownerName)),
+		expression.Or(
+			expression.AttributeNotExists(priorityAttr),
+			expression.LessThan(priorityAttr, priority),
+		),
+		expression.Or(
+			expression.AttributeNotExists(preemptionPriorityAttr),
+			expression.LessThan(preemptionPriorityAttr, priority),
+		),
+	)
+	update := expression.
+		Set(expression.Name(attrPreemptionOwner), expression.Value(c.currentOwnerName())).
+		Set(preemptionPriorityAttr, priority)
+	if notice := getLockOptions.preemptionNotice; notice > 0 {
+		update = update.Set(expression.Name(attrPreemptionNotice), expression.Value(notice.Milliseconds()))
+	} else {
+		update = update.Remove(expression.Name(attrPreemptionNotice))
+	}
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(getLockOptions.partitionKey, getLockOptions.sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	err = parseDynamoDBError(err, "cannot request preemption")
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		c.logger.Info(ctx, "preemption not requested for ", getLockOptions.partitionKey, ":", err)
+		return
+	} else if err != nil {
+		c.logger.Error(ctx, "error requesting preemption of ", getLockOptions.partitionKey, ":", err)
+		return
+	}
+	getLockOptions.preemptionRequestedFrom = existingLock.ownerName
+}
+
+func readPreemptionRequest(item map[string]types.AttributeValue) *PreemptionRequest {
+	owner := readStringAttr(item[attrPreemptionOwner])
+	if owner == "" {
+		return nil
+	}
+	return &PreemptionRequest{
+		OwnerName: owner,
+		Priority:  readInt64Attr(item[attrPreemptionPriority]),
+		Notice:    time.Duration(readInt64Attr(item[attrPreemptionNotice])) * time.Millisecond,
+	}
+}
+
+// checkPreemptionRequest inspects the lock row returned by a heartbeat and
+// notifies the holder, once, about pending preemption requests. Callers must
+// hold the lock's semaphore.
+func (c *commonClient) checkPreemptionRequest(lockItem *Lock, attributes map[string]types.AttributeValue) {
+	req := readPreemptionRequest(attributes)
+	lockItem.preemptionRequest = req
+	if req != nil && req.Notice > 0 && lockItem.preemptionDeadline.IsZero() {
+		lockItem.preemptionDeadline = c.now().Add(req.Notice)
+	}
+	if req == nil || lockItem.preemptionNotified || lockItem.preemptionCallback == nil {
+		return
+	}
+	lockItem.preemptionNotified = true
+	fn, r := lockItem.preemptionCallback, *req
+	c.runCallback(func() { fn(lockItem, r) })
+}
+
+// preemptionNoticeElapsed reports whether the notice period given by a
+// preempting waiter is over. Callers must hold the lock's semaphore.
+func (l *Lock) preemptionNoticeElapsed() bool {
+	return !l.preemptionDeadline.IsZero() && l.now().After(l.preemptionDeadline)
+}
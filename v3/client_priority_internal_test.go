@@ -0,0 +1,193 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestPreemptionRequest(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksPreemption", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("preemption"),
+		attrOwnerName:           stringAttrValue("batch"),
+		attrLeaseDuration:       stringAttrValue("1h"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+		attrPriority:            int64AttrValue(1),
+	})
+	c, err := New(svc, "locksPreemption", "key",
+		DisableHeartbeat(),
+		WithOwnerName("interactive"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.AcquireLock(context.Background(), "preemption",
+		WithPriority(10),
+		RequestPreemption(),
+		FailIfLocked(),
+	)
+	var errNotGranted *LockNotGrantedError
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not to be granted:", err)
+	}
+	if got := svc.callCount("UpdateItem"); got != 1 {
+		t.Fatal("expected preemption request to be recorded:", got)
+	}
+	if got := readStringAttr(svc.row("locksPreemption", "preemption")[attrPreemptionOwner]); got != "interactive" {
+		t.Fatal("preemption request not written:", got)
+	}
+
+	_, err = c.AcquireLock(context.Background(), "preemption",
+		WithPriority(1),
+		RequestPreemption(),
+		FailIfLocked(),
+	)
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not to be granted:", err)
+	}
+	if got := svc.callCount("UpdateItem"); got != 1 {
+		t.Fatal("lower priority waiters must not request preemption:", got)
+	}
+}
+
+func TestPreemptionCallback(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksPreemption", "key",
+		DisableHeartbeat(),
+		WithOwnerName("batch"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan PreemptionRequest, 2)
+	l, err := c.AcquireLock(context.Background(), "preemption",
+		WithPriority(1),
+		WithPreemptionCallback(func(_ *Lock, req PreemptionRequest) {
+			requests <- req
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Priority() != 1 {
+		t.Fatal("unexpected priority:", l.Priority())
+	}
+	if _, ok := l.PreemptionRequested(); ok {
+		t.Fatal("unexpected preemption request")
+	}
+
+	svc.setAttributes("locksPreemption", map[string]types.AttributeValue{
+		attrPreemptionOwner:    stringAttrValue("interactive"),
+		attrPreemptionPriority: int64AttrValue(10),
+	}, "preemption")
+	for i := 0; i < 2; i++ {
+		if err := c.SendHeartbeat(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := PreemptionRequest{OwnerName: "interactive", Priority: 10}
+	select {
+	case got := <-requests:
+		if got != want {
+			t.Fatalf("unexpected preemption request: %#v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("preemption callback not called")
+	}
+	if got, ok := l.PreemptionRequested(); !ok || got != want {
+		t.Fatalf("unexpected preemption request: %#v", got)
+	}
+	select {
+	case <-requests:
+		t.Fatal("preemption callback should be called only once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPreemptionNotice(t *testing.T) {
+	t.Run("holder", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c, err := New(svc, "locksPreemption", "key",
+			DisableHeartbeat(),
+			WithOwnerName("batch"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "preemption", WithPriority(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc.setAttributes("locksPreemption", map[string]types.AttributeValue{
+			attrPreemptionOwner:    stringAttrValue("interactive"),
+			attrPreemptionPriority: int64AttrValue(10),
+			attrPreemptionNotice:   int64AttrValue(50),
+		}, "preemption")
+		if err := c.SendHeartbeat(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+		want := PreemptionRequest{OwnerName: "interactive", Priority: 10, Notice: 50 * time.Millisecond}
+		if got, ok := l.PreemptionRequested(); !ok || got != want {
+			t.Fatalf("unexpected preemption request: %#v", got)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if err := c.SendHeartbeat(context.Background(), l); !errors.Is(err, ErrLockPreempted) {
+			t.Fatal("heartbeats should stop once the notice period is over:", err)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); ok {
+			t.Fatal("preempted lock should be forgotten")
+		}
+	})
+	t.Run("waiter", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		svc.putRow("locksPreemption", map[string]types.AttributeValue{
+			"key":                   stringAttrValue("preemption"),
+			attrOwnerName:           stringAttrValue("batch"),
+			attrLeaseDuration:       stringAttrValue("50ms"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+			attrPriority:            int64AttrValue(1),
+		})
+		c, err := New(svc, "locksPreemption", "key",
+			DisableHeartbeat(),
+			WithOwnerName("interactive"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "preemption",
+			WithPriority(10),
+			WithPreemptionNotice(10*time.Millisecond),
+			WithRefreshPeriod(10*time.Millisecond),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := svc.callCount("UpdateItem"); got != 1 {
+			t.Fatal("expected preemption request to be recorded:", got)
+		}
+		if got := l.Acquisition(); got.Kind != AcquisitionExpired || got.PreemptedOwner != "batch" {
+			t.Fatalf("expected preemption to be reported: %#v", got)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "errors"
+
+// ErrAlreadyHeldLocally indicates that the lock is already held by this
+// client, and the client is configured with ReacquireFail.
+var ErrAlreadyHeldLocally = errors.New("lock already held by this client")
+
+// ReacquirePolicy defines what AcquireLock does when the client already holds
+// the requested lock.
+type ReacquirePolicy int
+
+// Policies for acquiring a lock already held by the client.
+const (
+	// ReacquireAllow goes through the regular acquisition, as if the lock
+	// was held by another client. This is the default.
+	ReacquireAllow ReacquirePolicy = iota
+	// ReacquireReturnExisting returns the lock already held. Releasing
+	// any of the handles releases the lock.
+	ReacquireReturnExisting
+	// ReacquireFail fails with ErrAlreadyHeldLocally.
+	ReacquireFail
+	// ReacquireRefCount returns the lock already held, and counts the
+	// acquisitions: ReleaseLock only releases the lock once it has been
+	// called as many times as the lock was acquired. Close releases the
+	// lock regardless of the count.
+	ReacquireRefCount
+)
+
+// WithReacquirePolicy defines what AcquireLock does when the client already
+// holds the requested lock. Concurrent acquisitions of the same lock are not
+// coordinated locally: the policy only applies once the lock is held.
+func WithReacquirePolicy(policy ReacquirePolicy) ClientOption {
+	return func(c *commonClient) { c.reacquirePolicy = policy }
+}
+
+// heldLocally applies the reacquire policy. It returns the lock to hand over
+// if the acquisition is already satisfied by a lock held by this client.
+func (c *commonClient) heldLocally(key lockKey) (*Lock, error) {
+	if c.reacquirePolicy == ReacquireAllow {
+		return nil, nil
+	}
+	v, ok := c.locks.Load(key)
+	if !ok {
+		return nil, nil
+	}
+	l := v.(*Lock)
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	if l.isExpired() || l.ownerName != c.currentOwnerName() {
+		return nil, nil
+	}
+	switch c.reacquirePolicy {
+	case ReacquireFail:
+		return nil, ErrAlreadyHeldLocally
+	case ReacquireRefCount:
+		l.extraRefs++
+	}
+	return l, nil
+}
+
+// releaseReference drops one of the extra references of a lock acquired more
+// than once under ReacquireRefCount. It reports whether the lock is still
+// referenced, and must not be released yet.
+func (c *commonClient) releaseReference(l *Lock) bool {
+	if l == nil {
+		return false
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	if l.extraRefs == 0 || l.isReleased {
+		return false
+	}
+	l.extraRefs--
+	return true
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReacquirePolicy(t *testing.T) {
+	newClient := func(svc DynamoDBClient, policy ReacquirePolicy) *Client {
+		c, err := New(svc, "locksReacquire", "key", DisableHeartbeat(), WithReacquirePolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	t.Run("return existing", func(t *testing.T) {
+		c := newClient(newMemoryDynamoDBClient(), ReacquireReturnExisting)
+		first, err := c.AcquireLock(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := c.AcquireLock(ctx, "key", FailIfLocked())
+		if err != nil || second != first {
+			t.Fatal("expected the held lock:", err)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		c := newClient(newMemoryDynamoDBClient(), ReacquireFail)
+		if _, err := c.AcquireLock(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.AcquireLock(ctx, "key"); !errors.Is(err, ErrAlreadyHeldLocally) {
+			t.Fatal("expected already held locally error:", err)
+		}
+	})
+
+	t.Run("reference counting", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c := newClient(svc, ReacquireRefCount)
+		l, err := c.AcquireLock(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, err := c.AcquireLock(ctx, "key"); err != nil || again != l {
+			t.Fatal("expected the held lock:", err)
+		}
+		if released, err := c.ReleaseLock(ctx, l); !released || err != nil {
+			t.Fatal("release of a reference failed:", err)
+		}
+		if l.IsExpired() {
+			t.Fatal("lock released while still referenced")
+		}
+		if released, err := c.ReleaseLock(ctx, l); !released || err != nil {
+			t.Fatal("release failed:", err)
+		}
+		if !l.IsExpired() {
+			t.Fatal("lock should have been released by the last reference")
+		}
+		if _, released := svc.row("locksReacquire", "key")[attrIsReleased]; !released {
+			t.Fatal("lock row not released")
+		}
+	})
+
+	t.Run("released locks are reacquired", func(t *testing.T) {
+		c := newClient(newMemoryDynamoDBClient(), ReacquireFail)
+		l, err := c.AcquireLock(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.ReleaseLock(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.AcquireLock(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
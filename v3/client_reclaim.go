@@ -0,0 +1,148 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithOwnerIndex names a global secondary index of the lock table keyed by
+// the owner name. CreateTable creates it, and ReacquireOwnLocks uses it to
+// find the locks of this client without scanning the whole table.
+func WithOwnerIndex(indexName string) ClientOption {
+	return func(c *commonClient) { c.ownerIndexName = indexName }
+}
+
+// ReacquireOwnLocks finds the locks that are still stored under the owner name
+// of this client, for instance after a quick process restart, and resumes
+// them: each lock that is not released is heartbeated, conditioned on its
+// record version number being unchanged, so locks stolen in the meantime are
+// left alone. The resumed locks are returned and heartbeated from then on. It
+// only makes sense with a stable owner name (see WithOwnerName). Without an
+// owner index (see WithOwnerIndex), the whole table is scanned. The given
+// context is passed down to the underlying dynamoDB calls.
+func (c *commonClient) ReacquireOwnLocks(ctx context.Context) ([]*Lock, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.isDraining() {
+		return nil, ErrClientDraining
+	}
+
+	tableName := c.routedTable(ctx)
+	var keys []lockKey
+	collect := func(item map[string]types.AttributeValue) error {
+		if readStringAttr(item[attrOwnerName]) != c.currentOwnerName() {
+			return nil
+		}
+		key := lockKey{tableName: tableName, partitionKey: readKeyAttr(item[c.partitionKeyName])}
+		if c.sortKeyName != "" {
+			key.sortKey = readKeyAttr(item[c.sortKeyName])
+		}
+		keys = append(keys, key)
+		return nil
+	}
+	var err error
+	if c.ownerIndexName != "" {
+		err = c.queryOwnerIndex(ctx, collect)
+	} else {
+		err = c.scanTable(ctx, collect)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot list own locks: %w", err)
+	}
+
+	var locks []*Lock
+	for _, key := range keys {
+		if _, ok := c.locks.Load(key); ok {
+			continue
+		}
+		// The index is eventually consistent: confirm the ownership
+		// with a consistent read before resuming the lock.
+		lockItem, err := c.getLockFromDynamoDB(ctx, getLockOptions{
+			tableName:    key.tableName,
+			partitionKey: key.partitionKey,
+			sortKey:      key.sortKey,
+		})
+		if err != nil {
+			return locks, err
+		}
+		if lockItem == nil || lockItem.ownerName != c.currentOwnerName() || lockItem.isReleased {
+			continue
+		}
+		if err := c.sendHeartbeat(ctx, &sendHeartbeatOptions{lockItem: lockItem}); err != nil {
+			c.logger.Info(ctx, "cannot resume lock ", key.partitionKey, " ", key.sortKey, ": ", err)
+			continue
+		}
+		c.locks.Store(lockItem.uniqueIdentifier(), lockItem)
+		locks = append(locks, lockItem)
+	}
+	return locks, nil
+}
+
+func (c *commonClient) queryOwnerIndex(ctx context.Context, fn func(map[string]types.AttributeValue) error) error {
+	keyCond := expression.Key(attrOwnerName).Equal(expression.Value(c.currentOwnerName()))
+	queryExpr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return fmt.Errorf("cannot build query: %w", err)
+	}
+	var exclusiveStartKey map[string]types.AttributeValue
+	for {
+		res, err := c.query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(c.tableName),
+			IndexName:                 aws.String(c.ownerIndexName),
+			KeyConditionExpression:    queryExpr.KeyCondition(),
+			ExpressionAttributeNames:  queryExpr.Names(),
+			ExpressionAttributeValues: queryExpr.Values(),
+			ExclusiveStartKey:         exclusiveStartKey,
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range res.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		if len(res.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		exclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+func (c *commonClient) ownerIndex(opt *createDynamoDBTableOptions) types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(c.ownerIndexName),
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String(attrOwnerName),
+			KeyType:       types.KeyTypeHash,
+		}},
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeKeysOnly,
+		},
+		ProvisionedThroughput: opt.provisionedThroughput,
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestReacquireOwnLocks(t *testing.T) {
+	row := func(key, owner string, released bool) map[string]types.AttributeValue {
+		item := map[string]types.AttributeValue{
+			"key":                   stringAttrValue(key),
+			attrOwnerName:           stringAttrValue(owner),
+			attrLeaseDuration:       stringAttrValue("20s"),
+			attrRecordVersionNumber: stringAttrValue("old-rvn"),
+		}
+		if released {
+			item[attrIsReleased] = stringAttrValue("1")
+		}
+		return item
+	}
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksReacquire", row("mine", "restarted", false))
+	svc.putRow("locksReacquire", row("stolen", "someone-else", false))
+	svc.putRow("locksReacquire", row("released", "restarted", true))
+	c, err := New(svc, "locksReacquire", "key",
+		DisableHeartbeat(),
+		WithOwnerName("restarted"),
+		WithOwnerIndex("ownerIndex"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	locks, err := c.ReacquireOwnLocks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].PartitionKey() != "mine" {
+		t.Fatalf("unexpected reacquired locks: %v", locks)
+	}
+	if locks[0].IsExpired() || locks[0].RecordVersionNumber() == "old-rvn" {
+		t.Fatal("reacquired lock was not heartbeated")
+	}
+	if got, err := c.Get(context.Background(), "mine"); err != nil || got != locks[0] {
+		t.Fatal("reacquired lock is not tracked by the client:", err)
+	}
+
+	t.Run("routed", func(t *testing.T) {
+		svc.putRow("locksReacquireOther", row("routed", "restarted", false))
+		ctx := RouteToTable(context.Background(), "locksReacquireOther")
+		locks, err := c.ReacquireOwnLocks(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(locks) != 1 || locks[0].PartitionKey() != "routed" {
+			t.Fatalf("unexpected reacquired locks: %v", locks)
+		}
+		if got, err := c.Get(ctx, "routed"); err != nil || got != locks[0] {
+			t.Fatal("reacquired lock is not tracked under its table:", err)
+		}
+		if err := c.SendHeartbeat(context.Background(), locks[0]); err != nil {
+			t.Fatal("reacquired lock is not heartbeated in its table:", err)
+		}
+		if got := readStringAttr(svc.row("locksReacquireOther", "routed")[attrRecordVersionNumber]); got != locks[0].RecordVersionNumber() {
+			t.Fatal("unexpected record version number:", got)
+		}
+	})
+}
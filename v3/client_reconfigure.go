@@ -0,0 +1,65 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"errors"
+	"time"
+)
+
+// Reconfigure changes the lease duration and the heartbeat period of the
+// client at runtime, for example to tune timings during an incident without
+// restarting the service. New acquisitions use the new lease duration right
+// away, and the locks already held are rewritten with it on their next
+// heartbeat. The same constraints of New apply: the heartbeat period must be no
+// more than half the lease duration. Heartbeats cannot be turned on or off,
+// and the heartbeat period of clients of a TableManager is set by the manager.
+func (c *commonClient) Reconfigure(leaseDuration, heartbeatPeriod time.Duration) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if leaseDuration < 2*heartbeatPeriod {
+		return errors.New("heartbeat period must be no more than half the length of the Lease Duration")
+	}
+	c.timingsMu.Lock()
+	defer c.timingsMu.Unlock()
+	if c.heartbeatScheduler != nil && heartbeatPeriod != c.heartbeatPeriod {
+		return errors.New("cannot change the heartbeat period of a client of a TableManager")
+	}
+	if (heartbeatPeriod > 0) != (c.heartbeatPeriod > 0) {
+		return errors.New("cannot enable or disable heartbeats of a running client")
+	}
+	c.leaseDuration = leaseDuration
+	c.heartbeatPeriod = heartbeatPeriod
+	select {
+	case c.heartbeatReconfigured <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *commonClient) currentLeaseDuration() time.Duration {
+	c.timingsMu.RLock()
+	defer c.timingsMu.RUnlock()
+	return c.leaseDuration
+}
+
+func (c *commonClient) currentHeartbeatPeriod() time.Duration {
+	c.timingsMu.RLock()
+	defer c.timingsMu.RUnlock()
+	return c.heartbeatPeriod
+}
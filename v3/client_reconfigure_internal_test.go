@@ -0,0 +1,73 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReconfigure(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	storedLease := func(key string) string {
+		return readStringAttr(svc.row("locksReconfigure", key)[attrLeaseDuration])
+	}
+	c, err := New(svc, "locksReconfigure", "key",
+		WithLeaseDuration(2*time.Hour),
+		WithHeartbeatPeriod(time.Hour),
+		WithOwnerName("owner"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	held, err := c.AcquireLock(context.Background(), "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storedLease("held"); got != "2h0m0s" {
+		t.Fatal("unexpected lease duration:", got)
+	}
+
+	if err := c.Reconfigure(time.Second, time.Second); err == nil {
+		t.Fatal("heartbeat period longer than half the lease must be rejected")
+	}
+	if err := c.Reconfigure(time.Second, 0); err == nil {
+		t.Fatal("heartbeats must not be disabled at runtime")
+	}
+	if err := c.Reconfigure(3*time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.AcquireLock(context.Background(), "new"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedLease("new"); got != "3h0m0s" {
+		t.Fatal("new acquisitions should use the new lease duration:", got)
+	}
+	if err := c.SendHeartbeat(context.Background(), held); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedLease("held"); got != "3h0m0s" {
+		t.Fatal("heartbeats should rewrite the lease duration:", got)
+	}
+	if got := held.LeaseDuration(); got != 3*time.Hour {
+		t.Fatal("unexpected lease duration of held lock:", got)
+	}
+}
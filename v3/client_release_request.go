@@ -0,0 +1,118 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ReleaseRequest describes someone politely asking the current holder to
+// release a lock. See RequestRelease.
+type ReleaseRequest struct {
+	OwnerName string
+	Reason    string
+}
+
+// WithReleaseRequestCallback registers a callback that is called when someone
+// asks the holder to release the lock with RequestRelease, once per request.
+// Unlike preemption, release requests carry no priority and no deadline: the
+// holder is free to ignore them.
+func WithReleaseRequestCallback(callback func(*Lock, ReleaseRequest)) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.releaseRequestCallback = callback
+	}
+}
+
+// ReleaseRequested returns the latest request to release this lock, as seen in
+// the last heartbeat or read.
+func (l *Lock) ReleaseRequested() (ReleaseRequest, bool) {
+	if l == nil {
+		return ReleaseRequest{}, false
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	if l.releaseRequest == nil {
+		return ReleaseRequest{}, false
+	}
+	return *l.releaseRequest, true
+}
+
+func (c *commonClient) requestRelease(ctx context.Context, partitionKey, sortKey, reason string) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if c.v2Compatibility {
+		return errors.New("tables shared with cirello.io/dynamolock/v2 do not support release requests")
+	}
+	releasedAttr := expression.Name(c.releasedAttribute)
+	cond := expression.And(
+		expression.AttributeExists(expression.Name(c.partitionKeyName)),
+		expression.Or(
+			expression.AttributeNotExists(releasedAttr),
+			expression.NotEqual(releasedAttr, expression.Value(c.releasedValue)),
+		),
+	)
+	update := expression.
+		Set(expression.Name(attrReleaseRequestedBy), expression.Value(c.currentOwnerName())).
+		Set(expression.Name(attrRequestedRelease), expression.Value(reason))
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(partitionKey, sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	err = parseDynamoDBError(err, "lock is not held")
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		return ErrLockAlreadyReleased
+	}
+	return err
+}
+
+func readReleaseRequest(item map[string]types.AttributeValue) *ReleaseRequest {
+	owner := readStringAttr(item[attrReleaseRequestedBy])
+	if owner == "" {
+		return nil
+	}
+	return &ReleaseRequest{
+		OwnerName: owner,
+		Reason:    readStringAttr(item[attrRequestedRelease]),
+	}
+}
+
+// checkReleaseRequest inspects the lock row returned by a heartbeat and
+// notifies the holder, once per request, about requests to release the lock.
+// Callers must hold the lock's semaphore.
+func (c *commonClient) checkReleaseRequest(lockItem *Lock, attributes map[string]types.AttributeValue) {
+	req := readReleaseRequest(attributes)
+	lockItem.releaseRequest = req
+	if req == nil || lockItem.releaseRequestNotified == *req || lockItem.releaseRequestCallback == nil {
+		return
+	}
+	lockItem.releaseRequestNotified = *req
+	fn, r := lockItem.releaseRequestCallback, *req
+	c.runCallback(func() { fn(lockItem, r) })
+}
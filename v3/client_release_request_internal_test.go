@@ -0,0 +1,115 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRequestRelease(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksReleaseRequest", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("job"),
+		attrOwnerName:           stringAttrValue("worker"),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	})
+	c, err := New(svc, "locksReleaseRequest", "key", DisableHeartbeat(), WithOwnerName("scheduler"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RequestRelease(context.Background(), "job", "maintenance window"); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.updateInputs()) != 1 {
+		t.Fatal("unexpected number of updates:", len(svc.updateInputs()))
+	}
+	values := make(map[string]bool)
+	for _, v := range svc.updateInputs()[0].ExpressionAttributeValues {
+		if s, ok := v.(*types.AttributeValueMemberS); ok {
+			values[s.Value] = true
+		}
+	}
+	if !values["scheduler"] || !values["maintenance window"] {
+		t.Fatalf("release request not written: %#v", svc.updateInputs()[0].ExpressionAttributeValues)
+	}
+
+	lost, err := New(newMemoryDynamoDBClient(), "locksReleaseRequest", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lost.RequestRelease(context.Background(), "job", "maintenance window"); !errors.Is(err, ErrLockAlreadyReleased) {
+		t.Fatal("expected lock already released error:", err)
+	}
+}
+
+func TestReleaseRequestCallback(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksReleaseRequest", "key", DisableHeartbeat(), WithOwnerName("worker"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan ReleaseRequest, 3)
+	l, err := c.AcquireLock(context.Background(), "job",
+		WithReleaseRequestCallback(func(_ *Lock, req ReleaseRequest) {
+			requests <- req
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.ReleaseRequested(); ok {
+		t.Fatal("unexpected release request")
+	}
+
+	request := func(reason string) ReleaseRequest {
+		svc.setAttributes("locksReleaseRequest", map[string]types.AttributeValue{
+			attrReleaseRequestedBy: stringAttrValue("scheduler"),
+			attrRequestedRelease:   stringAttrValue(reason),
+		}, "job")
+		for i := 0; i < 2; i++ {
+			if err := c.SendHeartbeat(context.Background(), l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := ReleaseRequest{OwnerName: "scheduler", Reason: reason}
+		select {
+		case got := <-requests:
+			if got != want {
+				t.Fatalf("unexpected release request: %#v", got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("release request callback not called")
+		}
+		return want
+	}
+	request("maintenance window")
+	want := request("rebalancing")
+	if got, ok := l.ReleaseRequested(); !ok || got != want {
+		t.Fatalf("unexpected release request: %#v", got)
+	}
+	select {
+	case <-requests:
+		t.Fatal("release request callback should be called once per request")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
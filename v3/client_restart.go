@@ -0,0 +1,89 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClientNotClosed is returned by Restart when the client is still running.
+var ErrClientNotClosed = errors.New("client is not closed")
+
+// ErrLocksNotReleased is returned by Restart when Close could not release all
+// of the locks. They are left to expire, and the client must be recreated.
+var ErrLocksNotReleased = errors.New("client still tracks locks not released by Close")
+
+// Restart brings a closed client back to life, restarting its heartbeats and
+// its orphan detector, so the same client can be used across suspend and
+// resume cycles, like the ones of Lambda SnapStart or long pauses, without
+// being recreated. Locks released by Close are not reacquired, and clients
+// whose Close failed to release some of the locks cannot be restarted. If the
+// owner name was generated by the client, a new one is generated, as clones
+// of a snapshot would otherwise share it. Either way, the owner name is
+// registered again (see WithOwnerRegistry). The channel returned by
+// HeartbeatErrors is closed by Close, so it must be fetched again after
+// Restart. Clients of a TableManager cannot be restarted.
+func (c *commonClient) Restart(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.heartbeatScheduler != nil {
+		return errors.New("cannot restart a client of a TableManager")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		return ErrClientNotClosed
+	}
+	remaining := false
+	c.locks.Range(func(_, _ interface{}) bool {
+		remaining = true
+		return false
+	})
+	if remaining {
+		return ErrLocksNotReleased
+	}
+	ownerName := c.currentOwnerName()
+	if !c.ownerNameSet {
+		ownerName = c.randString(32)
+	}
+	if err := c.validateOwnerName(ownerName); err != nil {
+		return err
+	}
+
+	c.runMu.Lock()
+	c.ownerName = ownerName
+	c.closeOnce = &sync.Once{}
+	c.heartbeatErrors = make(chan HeartbeatError, heartbeatErrorsBuffer)
+	c.startBackground()
+	c.runMu.Unlock()
+
+	c.closed = false
+	c.drainMu.Lock()
+	c.draining = false
+	c.drainMu.Unlock()
+	c.logger.Info(ctx, "client restarted")
+	return nil
+}
+
+func (c *commonClient) currentOwnerName() string {
+	c.runMu.RLock()
+	defer c.runMu.RUnlock()
+	return c.ownerName
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRestart(t *testing.T) {
+	c, err := New(&mockDynamoDBClient{}, "locksRestart", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(context.Background()); !errors.Is(err, ErrClientNotClosed) {
+		t.Fatal("running clients cannot be restarted:", err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "restart"); err != nil {
+		t.Fatal(err)
+	}
+	ownerName := c.currentOwnerName()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "restart"); !errors.Is(err, ErrClientClosed) {
+		t.Fatal("closed clients cannot acquire locks:", err)
+	}
+
+	if err := c.Restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.currentOwnerName() == ownerName {
+		t.Fatal("generated owner names should be renewed on restart")
+	}
+	if _, err := c.AcquireLock(context.Background(), "restart"); err != nil {
+		t.Fatal("restarted clients should acquire locks:", err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-c.HeartbeatErrors(); ok {
+		t.Fatal("heartbeat errors should be closed again")
+	}
+}
+
+func TestRestartWithUnreleasedLocks(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksRestart", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(0),
+		WithReleaseRetries(0, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "restart"); err != nil {
+		t.Fatal(err)
+	}
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if op == "UpdateItem" || op == "DeleteItem" {
+			return nil, &types.ProvisionedThroughputExceededException{}
+		}
+		return next()
+	})
+	if err := c.Close(context.Background()); err == nil {
+		t.Fatal("the release should have failed")
+	}
+	ownerName := c.currentOwnerName()
+	if err := c.Restart(context.Background()); !errors.Is(err, ErrLocksNotReleased) {
+		t.Fatal("clients with unreleased locks cannot be restarted:", err)
+	}
+	if !c.isClosed() || c.currentOwnerName() != ownerName {
+		t.Fatal("a rejected restart should leave the client untouched")
+	}
+}
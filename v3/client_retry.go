@@ -0,0 +1,85 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	transientRetryBaseDelay = 100 * time.Millisecond
+	transientRetryMaxDelay  = 5 * time.Second
+)
+
+// retryableErrorCodes lists the DynamoDB error codes that signal a transient
+// condition of the service rather than a problem with the request.
+var retryableErrorCodes = map[string]bool{
+	"InternalServerError":                    true,
+	"ProvisionedThroughputExceededException": true,
+	"RequestLimitExceeded":                   true,
+	"ServiceUnavailable":                     true,
+	"ThrottlingException":                    true,
+}
+
+// isRetryableError reports whether err is a transient failure, like a
+// throttled request or a network blip, that is likely to go away if the call
+// is retried.
+func isRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var retryable interface{ RetryableError() bool }
+	if errors.As(err, &retryable) {
+		return retryable.RetryableError()
+	}
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		return retryableErrorCodes[apiErr.ErrorCode()]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// transientRetryDelay is the exponential backoff after the given number of
+// consecutive transient failures.
+func transientRetryDelay(failures int) time.Duration {
+	delay := transientRetryBaseDelay
+	for i := 1; i < failures && delay < transientRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > transientRetryMaxDelay {
+		return transientRetryMaxDelay
+	}
+	return delay
+}
+
+// shouldRetryAcquisition reports whether the acquisition can keep waiting
+// after a transient failure, that is, if neither its wait budget nor its
+// maximum number of attempts are exhausted.
+func (c *commonClient) shouldRetryAcquisition(ctx context.Context, getLockOptions *getLockOptions, err error) bool {
+	if ctx.Err() != nil || !isRetryableError(err) {
+		return false
+	}
+	if getLockOptions.maxAttempts > 0 && getLockOptions.attempts >= getLockOptions.maxAttempts {
+		return false
+	}
+	waited := c.now().Sub(getLockOptions.start)
+	return !getLockOptions.waitStrategy.ShouldGiveUp(getLockOptions.attempts, waited, getLockOptions.lockTryingToBeAcquired)
+}
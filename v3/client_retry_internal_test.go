@@ -0,0 +1,96 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// blippingDynamoDBClient fails the first reads with the given error.
+type blippingDynamoDBClient struct {
+	mockDynamoDBClient
+	err      error
+	failures int32
+	reads    int32
+}
+
+func (m *blippingDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	atomic.AddInt32(&m.reads, 1)
+	if atomic.AddInt32(&m.failures, -1) >= 0 {
+		return nil, m.err
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func TestTransientAcquisitionFailures(t *testing.T) {
+	blip := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name     string
+		err      error
+		failures int32
+		opts     []AcquireLockOption
+		wantErr  bool
+		reads    int32
+	}{
+		{name: "network blip", err: blip, failures: 2, reads: 3},
+		{name: "throttling", err: &types.ProvisionedThroughputExceededException{}, failures: 1, reads: 2},
+		{name: "permanent error", err: &types.ResourceNotFoundException{}, failures: 1, wantErr: true, reads: 1},
+		{name: "max attempts", err: blip, failures: 5, opts: []AcquireLockOption{WithMaxAttempts(2)}, wantErr: true, reads: 2},
+		{name: "wait budget", err: blip, failures: 100, opts: []AcquireLockOption{WithAdditionalTimeToWaitForLock(250 * time.Millisecond)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &blippingDynamoDBClient{err: tt.err, failures: tt.failures}
+			c, err := New(svc, "locksTransient", "key", DisableHeartbeat())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.AcquireLock(context.Background(), "key", tt.opts...)
+			if tt.wantErr {
+				if !errors.Is(err, tt.err) {
+					t.Fatal("expected the underlying error:", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if reads := atomic.LoadInt32(&svc.reads); tt.reads > 0 && reads != tt.reads {
+				t.Fatal("unexpected number of reads:", reads)
+			}
+		})
+	}
+}
+
+func TestTransientRetryDelay(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:   transientRetryBaseDelay,
+		2:   2 * transientRetryBaseDelay,
+		3:   4 * transientRetryBaseDelay,
+		100: transientRetryMaxDelay,
+	} {
+		if got := transientRetryDelay(failures); got != want {
+			t.Errorf("transientRetryDelay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
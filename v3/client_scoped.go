@@ -0,0 +1,129 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "context"
+
+// Scope defines the slice of the lock space a scoped client works on.
+type Scope struct {
+	// TableName is the table the locks of the scope are stored in. If
+	// empty, the table of the parent client is used.
+	TableName string
+
+	// KeyPrefix is prepended to the partition keys of the locks of the
+	// scope, so tenants sharing a table do not collide.
+	KeyPrefix string
+}
+
+func (s Scope) context(ctx context.Context) context.Context {
+	if s.TableName == "" {
+		return ctx
+	}
+	return RouteToTable(ctx, s.TableName)
+}
+
+// ScopedClient is a lightweight handle on a Client, restricted to a Scope. It
+// shares the owner name, the heartbeats, the hooks and the metrics of the
+// parent client, so multi-tenant services do not run background goroutines
+// per tenant. It has no Close of its own: its locks are released when the
+// parent client is closed. The partition keys of its locks, as returned by
+// Lock.PartitionKey, carry the key prefix of the scope.
+type ScopedClient struct {
+	parent *Client
+	scope  Scope
+}
+
+// Scoped returns a handle on the client restricted to the given scope.
+func (c *Client) Scoped(scope Scope) *ScopedClient {
+	return &ScopedClient{parent: c, scope: scope}
+}
+
+// AcquireLock holds the defined lock within the scope. See Client.AcquireLock.
+func (s *ScopedClient) AcquireLock(ctx context.Context, partitionKey string, opts ...AcquireLockOption) (*Lock, error) {
+	return s.parent.AcquireLock(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, opts...)
+}
+
+// DoWithLock acquires the lock within the scope, runs fn while holding it and
+// releases it afterwards. See Client.DoWithLock.
+func (s *ScopedClient) DoWithLock(ctx context.Context, partitionKey string, fn func(context.Context, *Lock) error, opts ...AcquireLockOption) error {
+	return s.parent.DoWithLock(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, fn, opts...)
+}
+
+// Get finds out who owns the given lock within the scope. See Client.Get.
+func (s *ScopedClient) Get(ctx context.Context, partitionKey string) (*Lock, error) {
+	return s.parent.Get(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey)
+}
+
+// Lookup finds out whether the given lock exists within the scope and who
+// owns it. See Client.Lookup.
+func (s *ScopedClient) Lookup(ctx context.Context, partitionKey string) (LookupResult, error) {
+	return s.parent.Lookup(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey)
+}
+
+// RequestRelease politely asks the holder of the given lock within the scope
+// to release it. See Client.RequestRelease.
+func (s *ScopedClient) RequestRelease(ctx context.Context, partitionKey, reason string) error {
+	return s.parent.RequestRelease(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, reason)
+}
+
+// ScopedClientWithSortKey is the ScopedClient of a ClientWithSortKey. The key
+// prefix of the scope applies to the partition keys only.
+type ScopedClientWithSortKey struct {
+	parent *ClientWithSortKey
+	scope  Scope
+}
+
+// Scoped returns a handle on the client restricted to the given scope.
+func (c *ClientWithSortKey) Scoped(scope Scope) *ScopedClientWithSortKey {
+	return &ScopedClientWithSortKey{parent: c, scope: scope}
+}
+
+// AcquireLock holds the defined lock within the scope. See
+// ClientWithSortKey.AcquireLock.
+func (s *ScopedClientWithSortKey) AcquireLock(ctx context.Context, partitionKey, sortKey string, opts ...AcquireLockOption) (*Lock, error) {
+	return s.parent.AcquireLock(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, sortKey, opts...)
+}
+
+// DoWithLock acquires the lock within the scope, runs fn while holding it and
+// releases it afterwards. See ClientWithSortKey.DoWithLock.
+func (s *ScopedClientWithSortKey) DoWithLock(ctx context.Context, partitionKey, sortKey string, fn func(context.Context, *Lock) error, opts ...AcquireLockOption) error {
+	return s.parent.DoWithLock(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, sortKey, fn, opts...)
+}
+
+// Get finds out who owns the given lock within the scope. See
+// ClientWithSortKey.Get.
+func (s *ScopedClientWithSortKey) Get(ctx context.Context, partitionKey, sortKey string) (*Lock, error) {
+	return s.parent.Get(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, sortKey)
+}
+
+// Lookup finds out whether the given lock exists within the scope and who
+// owns it. See ClientWithSortKey.Lookup.
+func (s *ScopedClientWithSortKey) Lookup(ctx context.Context, partitionKey, sortKey string) (LookupResult, error) {
+	return s.parent.Lookup(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, sortKey)
+}
+
+// RequestRelease politely asks the holder of the given lock within the scope
+// to release it. See ClientWithSortKey.RequestRelease.
+func (s *ScopedClientWithSortKey) RequestRelease(ctx context.Context, partitionKey, sortKey, reason string) error {
+	return s.parent.RequestRelease(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, sortKey, reason)
+}
+
+// QueryLocks lists the locks stored under the given partition key within the
+// scope. See ClientWithSortKey.QueryLocks.
+func (s *ScopedClientWithSortKey) QueryLocks(ctx context.Context, partitionKey string, opts ...QueryLocksOption) ([]*Lock, error) {
+	return s.parent.QueryLocks(s.scope.context(ctx), s.scope.KeyPrefix+partitionKey, opts...)
+}
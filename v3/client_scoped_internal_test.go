@@ -0,0 +1,61 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScoped(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locks", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	tenantA := c.Scoped(Scope{TableName: "tenantLocks", KeyPrefix: "a/"})
+	tenantB := c.Scoped(Scope{KeyPrefix: "b/"})
+	lockA, err := tenantA.AcquireLock(context.Background(), "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.row("tenantLocks", "a/job") == nil || svc.rowCount("locks") != 0 {
+		t.Fatal("acquisition not routed to the table of the scope")
+	}
+	lockB, err := tenantB.AcquireLock(context.Background(), "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.row("locks", "b/job") == nil {
+		t.Fatal("acquisition not sent to the table of the client")
+	}
+	if lockA.PartitionKey() != "a/job" || lockB.PartitionKey() != "b/job" {
+		t.Fatal("unexpected partition keys:", lockA.PartitionKey(), lockB.PartitionKey())
+	}
+	if got, err := tenantA.Get(context.Background(), "job"); err != nil || got != lockA {
+		t.Fatal("expected the lock of the scope to be found:", err)
+	}
+
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !lockA.IsExpired() || !lockB.IsExpired() {
+		t.Fatal("closing the parent client should release the locks of all scopes")
+	}
+}
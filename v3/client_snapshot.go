@@ -0,0 +1,325 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LockSnapshot is the representation of a lock row used by Export and Import.
+// Data is encoded in base64, and additional attributes in DynamoDB's JSON
+// format.
+type LockSnapshot struct {
+	PartitionKey         string                          `json:"partitionKey"`
+	SortKey              string                          `json:"sortKey,omitempty"`
+	OwnerName            string                          `json:"ownerName"`
+	LeaseDuration        string                          `json:"leaseDuration"`
+	RecordVersionNumber  string                          `json:"recordVersionNumber"`
+	IsReleased           bool                            `json:"isReleased,omitempty"`
+	Priority             int64                           `json:"priority,omitempty"`
+	Data                 []byte                          `json:"data,omitempty"`
+	AdditionalAttributes map[string]types.AttributeValue `json:"-"`
+}
+
+type lockSnapshotJSON LockSnapshot
+
+// MarshalJSON implements json.Marshaler.
+func (s LockSnapshot) MarshalJSON() ([]byte, error) {
+	additionalAttributes := make(map[string]*jsonAttributeValue, len(s.AdditionalAttributes))
+	for k, v := range s.AdditionalAttributes {
+		additionalAttributes[k] = toJSONAttributeValue(v)
+	}
+	return json.Marshal(struct {
+		lockSnapshotJSON
+		AdditionalAttributes map[string]*jsonAttributeValue `json:"additionalAttributes,omitempty"`
+	}{lockSnapshotJSON(s), additionalAttributes})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *LockSnapshot) UnmarshalJSON(b []byte) error {
+	var v struct {
+		lockSnapshotJSON
+		AdditionalAttributes map[string]*jsonAttributeValue `json:"additionalAttributes,omitempty"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = LockSnapshot(v.lockSnapshotJSON)
+	if len(v.AdditionalAttributes) > 0 {
+		s.AdditionalAttributes = make(map[string]types.AttributeValue, len(v.AdditionalAttributes))
+	}
+	for k, attr := range v.AdditionalAttributes {
+		av, err := attr.attributeValue()
+		if err != nil {
+			return fmt.Errorf("cannot decode additional attribute %q: %w", k, err)
+		}
+		s.AdditionalAttributes[k] = av
+	}
+	return nil
+}
+
+// Export streams all the lock rows of the table, one JSON encoded LockSnapshot
+// per line, for backups, migrations or offline analysis. The given context is
+// passed down to the underlying dynamoDB calls.
+func (c *commonClient) Export(ctx context.Context, w io.Writer) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	enc := json.NewEncoder(w)
+	return c.scanTable(ctx, func(item map[string]types.AttributeValue) error {
+		return enc.Encode(c.snapshotFromItem(item))
+	})
+}
+
+func (c *commonClient) snapshotFromItem(item map[string]types.AttributeValue) LockSnapshot {
+	s := LockSnapshot{
+		PartitionKey:         readKeyAttr(item[c.partitionKeyName]),
+		OwnerName:            readStringAttr(item[attrOwnerName]),
+		LeaseDuration:        readStringAttr(item[attrLeaseDuration]),
+		RecordVersionNumber:  readStringAttr(item[attrRecordVersionNumber]),
+		Data:                 readBytesAttr(item[attrData]),
+		AdditionalAttributes: make(map[string]types.AttributeValue),
+	}
+	if _, ok := item[attrLeaseDuration].(*types.AttributeValueMemberN); ok {
+		// Snapshots always hold duration strings, whatever the format
+		// of the table.
+		if d, err := readLeaseDuration(item[attrLeaseDuration]); err == nil {
+			s.LeaseDuration = d.String()
+		}
+	}
+	s.IsReleased = c.isReleasedItem(item)
+	releasedAttribute, _ := c.releasedMarker()
+	known := []string{c.partitionKeyName, attrOwnerName, attrLeaseDuration,
+		attrRecordVersionNumber, attrData, releasedAttribute}
+	if c.sortKeyName != "" {
+		s.SortKey = readKeyAttr(item[c.sortKeyName])
+		known = append(known, c.sortKeyName)
+	}
+	if !c.v2Compatibility {
+		s.Priority = readInt64Attr(item[attrPriority])
+		known = append(known, attrPriority)
+		known = append(known, internalAttributes...)
+	}
+	for k, v := range item {
+		s.AdditionalAttributes[k] = v
+	}
+	for _, k := range known {
+		delete(s.AdditionalAttributes, k)
+	}
+	return s
+}
+
+// ImportOption changes how Import writes the lock rows.
+type ImportOption func(*importOptions)
+
+type importOptions struct {
+	markReleased bool
+	overwrite    bool
+}
+
+// MarkReleasedOnImport stores all imported locks as released, so they can be
+// immediately acquired by anyone.
+func MarkReleasedOnImport() ImportOption {
+	return func(opt *importOptions) {
+		opt.markReleased = true
+	}
+}
+
+// OverwriteOnImport replaces lock rows that already exist in the table. By
+// default, existing rows are kept and the corresponding snapshot is skipped.
+func OverwriteOnImport() ImportOption {
+	return func(opt *importOptions) {
+		opt.overwrite = true
+	}
+}
+
+// Import writes back the lock rows read from the JSON lines produced by
+// Export. It is meant for table migrations and disaster recovery rehearsals;
+// imported locks are not tracked nor heartbeated by this client. The given
+// context is passed down to the underlying dynamoDB calls.
+func (c *commonClient) Import(ctx context.Context, r io.Reader, opts ...ImportOption) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	opt := &importOptions{}
+	for _, o := range opts {
+		o(opt)
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var s LockSnapshot
+		if err := dec.Decode(&s); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("cannot decode lock snapshot: %w", err)
+		}
+		if err := c.importSnapshot(ctx, s, opt); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *commonClient) importSnapshot(ctx context.Context, s LockSnapshot, opt *importOptions) error {
+	item := make(map[string]types.AttributeValue)
+	for k, v := range s.AdditionalAttributes {
+		item[k] = v
+	}
+	for k, v := range c.itemKey(s.PartitionKey, s.SortKey) {
+		item[k] = v
+	}
+	item[attrOwnerName] = stringAttrValue(s.OwnerName)
+	item[attrLeaseDuration] = stringAttrValue(s.LeaseDuration)
+	if c.leaseDurationFormat != LeaseDurationString {
+		d, err := time.ParseDuration(s.LeaseDuration)
+		if err != nil {
+			return fmt.Errorf("cannot parse lease duration of %s: %w", s.PartitionKey, err)
+		}
+		item[attrLeaseDuration] = c.leaseDurationAttrValue(d)
+	}
+	item[attrRecordVersionNumber] = stringAttrValue(s.RecordVersionNumber)
+	if s.Data != nil {
+		item[attrData] = bytesAttrValue(s.Data)
+	}
+	if s.IsReleased || opt.markReleased {
+		releasedAttribute, marker := c.releasedMarker()
+		item[releasedAttribute] = marker
+	}
+	if s.Priority != 0 && !c.v2Compatibility {
+		item[attrPriority] = int64AttrValue(s.Priority)
+	}
+
+	putItemInput := &dynamodb.PutItemInput{
+		TableName: aws.String(c.tableName),
+		Item:      item,
+	}
+	if !opt.overwrite {
+		cond := expression.AttributeNotExists(expression.Name(c.partitionKeyName))
+		putItemExpr, _ := expression.NewBuilder().WithCondition(cond).Build()
+		putItemInput.ConditionExpression = putItemExpr.Condition()
+		putItemInput.ExpressionAttributeNames = putItemExpr.Names()
+		putItemInput.ExpressionAttributeValues = putItemExpr.Values()
+	}
+	_, err := c.dynamoDB.PutItem(ctx, putItemInput)
+	err = parseDynamoDBError(err, "lock already exists")
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		c.logger.Info(ctx, "skipping import of existing lock ", s.PartitionKey, " ", s.SortKey)
+		return nil
+	}
+	return err
+}
+
+type jsonAttributeValue struct {
+	S    *string                        `json:"S,omitempty"`
+	N    *string                        `json:"N,omitempty"`
+	B    []byte                         `json:"B,omitempty"`
+	BOOL *bool                          `json:"BOOL,omitempty"`
+	NULL *bool                          `json:"NULL,omitempty"`
+	M    map[string]*jsonAttributeValue `json:"M,omitempty"`
+	L    []*jsonAttributeValue          `json:"L,omitempty"`
+	SS   []string                       `json:"SS,omitempty"`
+	NS   []string                       `json:"NS,omitempty"`
+	BS   [][]byte                       `json:"BS,omitempty"`
+}
+
+func toJSONAttributeValue(attr types.AttributeValue) *jsonAttributeValue {
+	switch v := attr.(type) {
+	case *types.AttributeValueMemberS:
+		return &jsonAttributeValue{S: &v.Value}
+	case *types.AttributeValueMemberN:
+		return &jsonAttributeValue{N: &v.Value}
+	case *types.AttributeValueMemberB:
+		return &jsonAttributeValue{B: v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &jsonAttributeValue{BOOL: &v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &jsonAttributeValue{NULL: &v.Value}
+	case *types.AttributeValueMemberM:
+		m := make(map[string]*jsonAttributeValue, len(v.Value))
+		for k, av := range v.Value {
+			m[k] = toJSONAttributeValue(av)
+		}
+		return &jsonAttributeValue{M: m}
+	case *types.AttributeValueMemberL:
+		l := make([]*jsonAttributeValue, len(v.Value))
+		for i, av := range v.Value {
+			l[i] = toJSONAttributeValue(av)
+		}
+		return &jsonAttributeValue{L: l}
+	case *types.AttributeValueMemberSS:
+		return &jsonAttributeValue{SS: v.Value}
+	case *types.AttributeValueMemberNS:
+		return &jsonAttributeValue{NS: v.Value}
+	case *types.AttributeValueMemberBS:
+		return &jsonAttributeValue{BS: v.Value}
+	}
+	return &jsonAttributeValue{}
+}
+
+func (v *jsonAttributeValue) attributeValue() (types.AttributeValue, error) {
+	switch {
+	case v == nil:
+		return nil, fmt.Errorf("missing attribute value")
+	case v.S != nil:
+		return &types.AttributeValueMemberS{Value: *v.S}, nil
+	case v.N != nil:
+		return &types.AttributeValueMemberN{Value: *v.N}, nil
+	case v.B != nil:
+		return &types.AttributeValueMemberB{Value: v.B}, nil
+	case v.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *v.BOOL}, nil
+	case v.NULL != nil:
+		return &types.AttributeValueMemberNULL{Value: *v.NULL}, nil
+	case v.M != nil:
+		m := make(map[string]types.AttributeValue, len(v.M))
+		for k, jav := range v.M {
+			av, err := jav.attributeValue()
+			if err != nil {
+				return nil, err
+			}
+			m[k] = av
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	case v.L != nil:
+		l := make([]types.AttributeValue, len(v.L))
+		for i, jav := range v.L {
+			av, err := jav.attributeValue()
+			if err != nil {
+				return nil, err
+			}
+			l[i] = av
+		}
+		return &types.AttributeValueMemberL{Value: l}, nil
+	case v.SS != nil:
+		return &types.AttributeValueMemberSS{Value: v.SS}, nil
+	case v.NS != nil:
+		return &types.AttributeValueMemberNS{Value: v.NS}, nil
+	case v.BS != nil:
+		return &types.AttributeValueMemberBS{Value: v.BS}, nil
+	}
+	return nil, fmt.Errorf("unknown attribute value type")
+}
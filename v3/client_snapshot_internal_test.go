@@ -0,0 +1,155 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// newPagedScanDynamoDBClient returns an in-memory DynamoDB holding the given
+// rows of tableName, whose scans return a single row per page.
+func newPagedScanDynamoDBClient(tableName string, rows ...map[string]types.AttributeValue) *memoryDynamoDBClient {
+	svc := newMemoryDynamoDBClient()
+	for _, row := range rows {
+		svc.putRow(tableName, row)
+	}
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if scan, ok := input.(*dynamodb.ScanInput); ok {
+			scan.Limit = aws.Int32(1)
+		}
+		return next()
+	})
+	return svc
+}
+
+func TestExport(t *testing.T) {
+	svc := newPagedScanDynamoDBClient("locksExport",
+		map[string]types.AttributeValue{
+			"key":                   stringAttrValue("a"),
+			attrOwnerName:           stringAttrValue("owner-a"),
+			attrLeaseDuration:       stringAttrValue("20s"),
+			attrRecordVersionNumber: stringAttrValue("rvn-a"),
+			attrData:                bytesAttrValue([]byte("data a")),
+			attrPriority:            int64AttrValue(5),
+			attrPreemptionOwner:     stringAttrValue("owner-b"),
+			"custom": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"list": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+					&types.AttributeValueMemberBOOL{Value: true},
+				}},
+			}},
+		},
+		map[string]types.AttributeValue{
+			"key":                   stringAttrValue("b"),
+			attrOwnerName:           stringAttrValue("owner-b"),
+			attrLeaseDuration:       stringAttrValue("20s"),
+			attrRecordVersionNumber: stringAttrValue("rvn-b"),
+			attrIsReleased:          stringAttrValue("1"),
+		},
+	)
+	c, err := New(svc, "locksExport", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Export(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []LockSnapshot
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var s LockSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, s)
+	}
+	want := []LockSnapshot{
+		{
+			PartitionKey:        "a",
+			OwnerName:           "owner-a",
+			LeaseDuration:       "20s",
+			RecordVersionNumber: "rvn-a",
+			Priority:            5,
+			Data:                []byte("data a"),
+			AdditionalAttributes: map[string]types.AttributeValue{
+				"custom": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+					"list": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+						&types.AttributeValueMemberBOOL{Value: true},
+					}},
+				}},
+			},
+		},
+		{
+			PartitionKey:        "b",
+			OwnerName:           "owner-b",
+			LeaseDuration:       "20s",
+			RecordVersionNumber: "rvn-b",
+			IsReleased:          true,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected export:\ngot  %#v\nwant %#v", got, want)
+	}
+	if got := svc.callCount("Scan"); got < 2 {
+		t.Fatal("export should follow the pages of the scan:", got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	c, err := NewWithSortKey(svc, "locksImport", "key", "sortKey", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := `{"partitionKey":"a","sortKey":"1","ownerName":"owner-a","leaseDuration":"20s","recordVersionNumber":"rvn-a","priority":5,"data":"ZGF0YSBh","additionalAttributes":{"custom":{"S":"value"}}}
+{"partitionKey":"b","sortKey":"2","ownerName":"owner-b","leaseDuration":"20s","recordVersionNumber":"rvn-b"}
+`
+	if err := c.Import(context.Background(), bytes.NewBufferString(snapshot), MarkReleasedOnImport()); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.putInputs()) != 2 {
+		t.Fatal("unexpected number of imported rows:", len(svc.putInputs()))
+	}
+	want := map[string]types.AttributeValue{
+		"key":                   stringAttrValue("a"),
+		"sortKey":               stringAttrValue("1"),
+		attrOwnerName:           stringAttrValue("owner-a"),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue("rvn-a"),
+		attrIsReleased:          stringAttrValue("1"),
+		attrPriority:            int64AttrValue(5),
+		attrData:                bytesAttrValue([]byte("data a")),
+		"custom":                stringAttrValue("value"),
+	}
+	if !reflect.DeepEqual(svc.putInputs()[0].Item, want) {
+		t.Fatalf("unexpected imported row:\ngot  %#v\nwant %#v", svc.putInputs()[0].Item, want)
+	}
+
+	if err := c.Import(context.Background(), bytes.NewBufferString("{bad json")); err == nil {
+		t.Fatal("expected error missing")
+	}
+}
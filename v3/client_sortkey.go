@@ -19,17 +19,17 @@ package dynamolock
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 // ClientWithSortKey is a dynamoDB based distributed lock client, but with a required sort key.
-type ClientWithSortKey struct {
-	*commonClient
-	sortKeyName string
-}
+type ClientWithSortKey struct{ *commonClient }
 
 // NewWithSortKey creates a new dynamoDB based distributed lock client.
 func NewWithSortKey(dynamoDB DynamoDBClient, tableName, partitionKeyName, sortKeyName string, opts ...ClientOption) (*ClientWithSortKey, error) {
@@ -37,19 +37,58 @@ func NewWithSortKey(dynamoDB DynamoDBClient, tableName, partitionKeyName, sortKe
 		return nil, errors.New("a sortKeyName must be supplied; use `Client` if you don't want a sort key")
 	}
 
-	commonClient, err := newCommon(dynamoDB, tableName, partitionKeyName, opts...)
+	commonClient, err := newCommon(dynamoDB, tableName, partitionKeyName, sortKeyName, opts...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &ClientWithSortKey{commonClient, sortKeyName}, nil
+	c := &ClientWithSortKey{commonClient}
+	if err := c.ensureTable(c.createTableSchema); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 // AcquireLock holds the defined lock. The given context is passed
 // down to the underlying dynamoDB call.
 func (c *ClientWithSortKey) AcquireLock(ctx context.Context, partitionKey, sortKey string, opts ...AcquireLockOption) (*Lock, error) {
-	return c.acquireLock(ctx, partitionKey, opts...)
+	return c.acquireLock(ctx, partitionKey, sortKey, opts...)
+}
+
+// AcquireLockWithStatus acquires the lock in the background, reporting each
+// state transition of the acquisition on the returned channel. See
+// Client.AcquireLockWithStatus.
+func (c *ClientWithSortKey) AcquireLockWithStatus(ctx context.Context, partitionKey, sortKey string, opts ...AcquireLockOption) <-chan AcquisitionStatus {
+	return c.acquireLockWithStatus(ctx, partitionKey, sortKey, opts...)
+}
+
+// DoWithLock acquires the lock, runs fn while holding it and releases it
+// afterwards. Critical sections started with DoWithLock are waited on by Drain.
+// The given context is passed down to fn and to the underlying dynamoDB calls.
+func (c *ClientWithSortKey) DoWithLock(ctx context.Context, partitionKey, sortKey string, fn func(context.Context, *Lock) error, opts ...AcquireLockOption) error {
+	return c.doWithLock(ctx, partitionKey, sortKey, fn, opts...)
+}
+
+// AcquireAnyLock tries to hold one of the given sort key slots under the
+// partition key, returning the first one it manages to lock. Each slot is tried
+// once, in order, as if FailIfLocked was set. If all of them are taken, it
+// returns a LockNotGrantedError. This allows to implement fixed-size worker
+// pools or license slots. The given context is passed down to the underlying
+// dynamoDB calls.
+func (c *ClientWithSortKey) AcquireAnyLock(ctx context.Context, partitionKey string, slots []string, opts ...AcquireLockOption) (*Lock, error) {
+	opts = append(opts[:len(opts):len(opts)], FailIfLocked())
+	for _, slot := range slots {
+		l, err := c.acquireLock(ctx, partitionKey, slot, opts...)
+		var errNotGranted *LockNotGrantedError
+		if errors.As(err, &errNotGranted) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
+	return nil, &LockNotGrantedError{msg: "Didn't acquire any lock because all slots are locked"}
 }
 
 // Get finds out who owns the given lock, but does not acquire the
@@ -60,9 +99,132 @@ func (c *ClientWithSortKey) AcquireLock(ctx context.Context, partitionKey, sortK
 // the caller should check lockItem.isExpired() to figure out if it currently
 // has the lock.) If the context is canceled, it is going to return the context
 // error on local cache hit. The given context is passed down to the underlying
-// dynamoDB call.
+// dynamoDB call. Lookup tells apart missing, free and owned locks more
+// clearly.
 func (c *ClientWithSortKey) Get(ctx context.Context, partitionKey, sortKey string) (*Lock, error) {
-	return c.get(ctx, partitionKey)
+	return c.get(ctx, partitionKey, sortKey)
+}
+
+// Lookup finds out whether the given lock exists and who owns it, without
+// acquiring it. See Client.Lookup.
+func (c *ClientWithSortKey) Lookup(ctx context.Context, partitionKey, sortKey string) (LookupResult, error) {
+	return c.lookup(ctx, partitionKey, sortKey)
+}
+
+// RequestRelease politely asks the holder of the given lock to release it,
+// stating why. See Client.RequestRelease.
+func (c *ClientWithSortKey) RequestRelease(ctx context.Context, partitionKey, sortKey, reason string) error {
+	return c.requestRelease(ctx, partitionKey, sortKey, reason)
+}
+
+// QueryLocksOption narrows down which locks are listed by QueryLocks.
+type QueryLocksOption func(*queryLocksOptions)
+
+// WithSortKeyPrefix lists only the locks whose sort key starts with the given
+// prefix. It cannot be used with numeric sort keys.
+func WithSortKeyPrefix(prefix string) QueryLocksOption {
+	return func(opt *queryLocksOptions) {
+		opt.sortKeyCondition = func(k expression.KeyBuilder, _ types.ScalarAttributeType) expression.KeyConditionBuilder {
+			return k.BeginsWith(prefix)
+		}
+	}
+}
+
+// WithSortKeyBetween lists only the locks whose sort key is between lower and
+// upper, inclusive.
+func WithSortKeyBetween(lower, upper string) QueryLocksOption {
+	return func(opt *queryLocksOptions) {
+		opt.sortKeyCondition = func(k expression.KeyBuilder, t types.ScalarAttributeType) expression.KeyConditionBuilder {
+			return k.Between(keyExpressionValue(t, lower), keyExpressionValue(t, upper))
+		}
+	}
+}
+
+// QueryLocks lists all the locks stored under the given partition key,
+// optionally narrowed down by a sort key condition. Locks currently held by
+// this client are returned as is; the others behave like the ones returned by
+// Get. The given context is passed down to the underlying dynamoDB calls.
+func (c *ClientWithSortKey) QueryLocks(ctx context.Context, partitionKey string, opts ...QueryLocksOption) ([]*Lock, error) {
+	if c.isClosed() {
+		return nil, ErrClientClosed
+	}
+	opt := &queryLocksOptions{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	keyCond := expression.Key(c.partitionKeyName).Equal(keyExpressionValue(c.partitionKeyType, partitionKey))
+	if opt.sortKeyCondition != nil {
+		keyCond = keyCond.And(opt.sortKeyCondition(expression.Key(c.sortKeyName), c.sortKeyType))
+	}
+	queryExpr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("cannot build query: %w", err)
+	}
+
+	var (
+		locks             []*Lock
+		exclusiveStartKey map[string]types.AttributeValue
+	)
+	for {
+		res, err := c.query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(c.tableName),
+			ConsistentRead:            aws.Bool(true),
+			KeyConditionExpression:    queryExpr.KeyCondition(),
+			ExpressionAttributeNames:  queryExpr.Names(),
+			ExpressionAttributeValues: queryExpr.Values(),
+			ExclusiveStartKey:         exclusiveStartKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range res.Items {
+			sortKey := readKeyAttr(item[c.sortKeyName])
+			if v, ok := c.locks.Load(c.lockKeyOf(ctx, partitionKey, sortKey)); ok {
+				locks = append(locks, v.(*Lock))
+				continue
+			}
+			lockItem, err := c.createLockItem(getLockOptions{
+				tableName:    c.routedTable(ctx),
+				partitionKey: partitionKey,
+				sortKey:      sortKey,
+			}, item)
+			if err != nil {
+				return nil, err
+			}
+			lockItem.updateRVN("", time.Time{}, lockItem.leaseDuration)
+			locks = append(locks, lockItem)
+		}
+		if len(res.LastEvaluatedKey) == 0 {
+			return locks, nil
+		}
+		exclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// ReleaseGroup releases all the locks held by this client under the given
+// partition key, which is useful to tear down all resources of a job at once.
+// Only the locks of the table the context is routed to are released (see
+// RouteToTable). It attempts to release every lock of the group even if some
+// of them fail, and returns the failures as LockErrors. The given context is
+// passed down to the underlying dynamoDB calls.
+func (c *ClientWithSortKey) ReleaseGroup(ctx context.Context, partitionKey string, opts ...ReleaseLockOption) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	tableName := c.routedTable(ctx)
+	errs := make(LockErrors)
+	c.locks.Range(func(key interface{}, value interface{}) bool {
+		if k := key.(lockKey); k.tableName != tableName || k.partitionKey != partitionKey {
+			return true
+		}
+		lockItem := value.(*Lock)
+		if err := c.releaseLock(ctx, lockItem, opts...); err != nil {
+			errs[lockItem] = err
+		}
+		return true
+	})
+	return errs.orNil()
 }
 
 // CreateTable prepares a DynamoDB table with the right schema for it
@@ -89,11 +251,11 @@ func (c *ClientWithSortKey) createTableSchema() ([]types.KeySchemaElement, []typ
 	attributeDefinitions := []types.AttributeDefinition{
 		{
 			AttributeName: aws.String(c.partitionKeyName),
-			AttributeType: types.ScalarAttributeTypeS,
+			AttributeType: c.partitionKeyType,
 		},
 		{
 			AttributeName: aws.String(c.sortKeyName),
-			AttributeType: types.ScalarAttributeTypeS,
+			AttributeType: c.sortKeyType,
 		},
 	}
 
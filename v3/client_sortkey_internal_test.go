@@ -0,0 +1,86 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestQueryLocksUnsupported(t *testing.T) {
+	c, err := NewWithSortKey(&mockDynamoDBClient{}, "locksQuery", "key", "sortKey", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if _, err := c.QueryLocks(context.Background(), "key"); !errors.Is(err, ErrOperationNotSupported) {
+		t.Fatal("expected unsupported operation error:", err)
+	}
+}
+
+func TestReleaseGroup(t *testing.T) {
+	errRelease := errors.New("cannot release")
+	svc := newMemoryDynamoDBClient("key", "sortKey")
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		if update, ok := input.(*dynamodb.UpdateItemInput); ok && readStringAttr(update.Key["sortKey"]) != "ok" {
+			return nil, errRelease
+		}
+		return next()
+	})
+	c, err := NewWithSortKey(svc, "locksReleaseGroup", "key", "sortKey", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	routed := RouteToTable(context.Background(), "locksReleaseGroupOther")
+	other, err := c.AcquireLock(routed, "job", "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []*Lock
+	for _, sortKey := range []string{"ok", "fail1", "fail2"} {
+		l, err := c.AcquireLock(context.Background(), "job", sortKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sortKey != "ok" {
+			failed = append(failed, l)
+		}
+	}
+
+	err = c.ReleaseGroup(context.Background(), "job")
+	var errs LockErrors
+	if !errors.As(err, &errs) || len(errs) != len(failed) {
+		t.Fatal("expected the failed releases to be reported:", err)
+	}
+	for _, l := range failed {
+		if !errors.Is(errs[l], errRelease) {
+			t.Fatal("missing release error of", l.SortKey(), ":", errs[l])
+		}
+	}
+	if row := svc.row("locksReleaseGroup", "job", "ok"); row[attrIsReleased] == nil {
+		t.Fatal("lock not released:", row)
+	}
+	if other.IsExpired() {
+		t.Fatal("locks of other tables should not be released")
+	}
+	if _, ok := c.locks.Load(other.uniqueIdentifier()); !ok {
+		t.Fatal("locks of other tables should still be held")
+	}
+}
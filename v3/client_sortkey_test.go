@@ -0,0 +1,275 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cirello.io/dynamolock/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestSortKeyClientBasicFlow(t *testing.T) {
+	t.Parallel()
+	svc := dynamodb.NewFromConfig(defaultConfig(t))
+	c, err := dynamolock.NewWithSortKey(svc,
+		"locksWithSortKey", "key", "sortKey",
+		dynamolock.WithLeaseDuration(3*time.Second),
+		dynamolock.WithHeartbeatPeriod(1*time.Second),
+		dynamolock.WithOwnerName("TestSortKeyClientBasicFlow#1"),
+		dynamolock.WithLogger(&testLogger{t: t}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("ensuring table exists")
+	c.CreateTable(context.Background(),
+		dynamolock.WithProvisionedThroughput(&types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		}),
+	)
+
+	lockA, err := c.AcquireLock(context.Background(), "spock", "a",
+		dynamolock.WithData([]byte("content a")),
+		dynamolock.ReplaceData(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockB, err := c.AcquireLock(context.Background(), "spock", "b",
+		dynamolock.WithData([]byte("content b")),
+		dynamolock.ReplaceData(),
+		dynamolock.FailIfLocked(),
+	)
+	if err != nil {
+		t.Fatal("locks with different sort keys should not conflict:", err)
+	}
+	if lockA.SortKey() != "a" || lockB.SortKey() != "b" || lockB.PartitionKey() != "spock" {
+		t.Fatal("locks do not carry their keys")
+	}
+
+	c2, err := dynamolock.NewWithSortKey(svc,
+		"locksWithSortKey", "key", "sortKey",
+		dynamolock.WithLeaseDuration(3*time.Second),
+		dynamolock.WithHeartbeatPeriod(1*time.Second),
+		dynamolock.WithOwnerName("TestSortKeyClientBasicFlow#2"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.AcquireLock(context.Background(), "spock", "a", dynamolock.FailIfLocked()); err == nil {
+		t.Fatal("expected to fail to grab the lock")
+	}
+
+	got, err := c2.Get(context.Background(), "spock", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OwnerName() != "TestSortKeyClientBasicFlow#1" || string(got.Data()) != "content b" {
+		t.Fatalf("unexpected lock read: %s %s", got.OwnerName(), got.Data())
+	}
+
+	if _, err := c.ReleaseLock(context.Background(), lockA); err != nil {
+		t.Fatal(err)
+	}
+	lockA2, err := c2.AcquireLock(context.Background(), "spock", "a", dynamolock.FailIfLocked())
+	if err != nil {
+		t.Fatal("cannot acquire released lock:", err)
+	}
+	if string(lockA2.Data()) != "content a" {
+		t.Fatal("lost data of sort key lock:", string(lockA2.Data()))
+	}
+	lockA2.Close()
+	lockB.Close()
+}
+
+func TestSortKeyQueryLocks(t *testing.T) {
+	t.Parallel()
+	svc := dynamodb.NewFromConfig(defaultConfig(t))
+	c, err := dynamolock.NewWithSortKey(svc,
+		"locksWithSortKey", "key", "sortKey",
+		dynamolock.WithLeaseDuration(3*time.Second),
+		dynamolock.WithHeartbeatPeriod(1*time.Second),
+		dynamolock.WithOwnerName("TestSortKeyQueryLocks#1"),
+		dynamolock.WithLogger(&testLogger{t: t}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	t.Log("ensuring table exists")
+	c.CreateTable(context.Background(),
+		dynamolock.WithProvisionedThroughput(&types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		}),
+	)
+
+	const tenant = "queryLocksTenant"
+	for _, sortKey := range []string{"shard-1", "shard-2", "shard-3", "other"} {
+		if _, err := c.AcquireLock(context.Background(), tenant, sortKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := c.QueryLocks(context.Background(), tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatal("unexpected number of locks:", len(all))
+	}
+
+	shards, err := c.QueryLocks(context.Background(), tenant, dynamolock.WithSortKeyPrefix("shard-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 3 {
+		t.Fatal("unexpected number of shard locks:", len(shards))
+	}
+
+	between, err := c.QueryLocks(context.Background(), tenant, dynamolock.WithSortKeyBetween("shard-2", "shard-3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(between) != 2 || between[0].SortKey() != "shard-2" || between[1].SortKey() != "shard-3" {
+		t.Fatalf("unexpected range of locks: %v", between)
+	}
+	for _, l := range between {
+		if l.IsExpired() {
+			t.Error("held locks should be returned from the client's cache")
+		}
+	}
+}
+
+func TestSortKeyReleaseGroup(t *testing.T) {
+	t.Parallel()
+	svc := dynamodb.NewFromConfig(defaultConfig(t))
+	c, err := dynamolock.NewWithSortKey(svc,
+		"locksWithSortKey", "key", "sortKey",
+		dynamolock.WithLeaseDuration(3*time.Second),
+		dynamolock.WithHeartbeatPeriod(1*time.Second),
+		dynamolock.WithOwnerName("TestSortKeyReleaseGroup#1"),
+		dynamolock.WithLogger(&testLogger{t: t}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	t.Log("ensuring table exists")
+	c.CreateTable(context.Background(),
+		dynamolock.WithProvisionedThroughput(&types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		}),
+	)
+
+	var jobLocks []*dynamolock.Lock
+	for _, sortKey := range []string{"step-1", "step-2", "step-3"} {
+		l, err := c.AcquireLock(context.Background(), "releaseGroupJob", sortKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobLocks = append(jobLocks, l)
+	}
+	otherJob, err := c.AcquireLock(context.Background(), "releaseGroupOtherJob", "step-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ReleaseGroup(context.Background(), "releaseGroupJob", dynamolock.WithDeleteLock(true)); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range jobLocks {
+		if !l.IsExpired() {
+			t.Error("lock not released:", l.SortKey())
+		}
+	}
+	if otherJob.IsExpired() {
+		t.Error("lock from another group should not be released")
+	}
+	remaining, err := c.QueryLocks(context.Background(), "releaseGroupJob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 0 {
+		t.Error("locks should have been deleted:", len(remaining))
+	}
+}
+
+func TestSortKeyAcquireAnyLock(t *testing.T) {
+	t.Parallel()
+	svc := dynamodb.NewFromConfig(defaultConfig(t))
+	c, err := dynamolock.NewWithSortKey(svc,
+		"locksWithSortKey", "key", "sortKey",
+		dynamolock.WithLeaseDuration(3*time.Second),
+		dynamolock.WithHeartbeatPeriod(1*time.Second),
+		dynamolock.WithOwnerName("TestSortKeyAcquireAnyLock#1"),
+		dynamolock.WithLogger(&testLogger{t: t}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	t.Log("ensuring table exists")
+	c.CreateTable(context.Background(),
+		dynamolock.WithProvisionedThroughput(&types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		}),
+	)
+
+	slots := []string{"slot-1", "slot-2"}
+	first, err := c.AcquireAnyLock(context.Background(), "acquireAnyLockPool", slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.AcquireAnyLock(context.Background(), "acquireAnyLockPool", slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SortKey() == second.SortKey() {
+		t.Fatal("the same slot was granted twice:", first.SortKey())
+	}
+
+	_, err = c.AcquireAnyLock(context.Background(), "acquireAnyLockPool", slots)
+	var errNotGranted *dynamolock.LockNotGrantedError
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not granted error when the pool is exhausted:", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	third, err := c.AcquireAnyLock(context.Background(), "acquireAnyLockPool", slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.SortKey() != first.SortKey() {
+		t.Fatal("expected the freed slot to be granted:", third.SortKey())
+	}
+}
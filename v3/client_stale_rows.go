@@ -0,0 +1,124 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"time"
+)
+
+// WithCleanupStaleRows makes the acquisitions that give up on a lock, because
+// of FailIfLocked, WithMaxAttempts or their wait strategy, delete its row once
+// it looks abandoned: the client must have seen the same record version number
+// in the row, by its own clock, for longer than the lease duration of the row
+// plus olderThan. Such rows are usually left behind by crashed processes. As a
+// single failed acquisition rarely waits that long, the evidence is kept
+// across acquisitions of the same lock, for the 1024 rows seen most recently,
+// until the client is closed. The row is only deleted if it was not
+// updated since it was read, and the acquisition fails regardless: it is the
+// next one that finds the lock free.
+func WithCleanupStaleRows(olderThan time.Duration) ClientOption {
+	return func(c *commonClient) {
+		c.cleanupStaleRows = true
+		c.staleRowAge = olderThan
+	}
+}
+
+// maxStaleRows bounds the number of lock rows whose sightings are kept by
+// WithCleanupStaleRows. Past it, the rows seen least recently are forgotten.
+const maxStaleRows = 1024
+
+// staleRowObservation is the first sighting of a record version number of a
+// lock row by an acquisition that gave up on it.
+type staleRowObservation struct {
+	recordVersionNumber string
+	since               time.Time
+	// lastSeen is the latest sighting, used to pick the observations to
+	// forget.
+	lastSeen time.Time
+}
+
+// cleanupStaleRow deletes the row of the given lock, observed by a failed
+// acquisition, if it was seen unchanged for long enough.
+func (c *commonClient) cleanupStaleRow(ctx context.Context, existingLock *Lock) {
+	if !c.cleanupStaleRows || existingLock == nil || existingLock.isReleased {
+		return
+	}
+	key := existingLock.uniqueIdentifier()
+	obs := c.observeStaleRow(key, existingLock)
+	if c.now().Sub(obs.since)-existingLock.leaseDuration <= c.staleRowAge {
+		return
+	}
+	err := c.DeleteOrphanLock(ctx, existingLock)
+	if err == nil || isOwnershipLost(err) {
+		// Either way, the observed row is gone.
+		c.staleRowsMu.Lock()
+		delete(c.staleRows, key)
+		c.staleRowsMu.Unlock()
+	}
+	if err != nil {
+		c.logger.Info(ctx, "cannot clean up stale lock ", existingLock.partitionKey, ": ", err)
+		return
+	}
+	c.logger.Info(ctx, "cleaned up stale lock ", existingLock.partitionKey, " last owned by ", existingLock.ownerName)
+}
+
+// observeStaleRow records the sighting of the given lock row and returns the
+// first sighting of its current record version number.
+func (c *commonClient) observeStaleRow(key lockKey, existingLock *Lock) staleRowObservation {
+	c.staleRowsMu.Lock()
+	defer c.staleRowsMu.Unlock()
+	obs, ok := c.staleRows[key]
+	if !ok || obs.recordVersionNumber != existingLock.recordVersionNumber {
+		obs = staleRowObservation{
+			recordVersionNumber: existingLock.recordVersionNumber,
+			since:               existingLock.lookupTime,
+		}
+	}
+	obs.lastSeen = existingLock.lookupTime
+	if !ok && len(c.staleRows) >= maxStaleRows {
+		c.forgetOldestStaleRow()
+	}
+	if c.staleRows == nil {
+		c.staleRows = make(map[lockKey]staleRowObservation)
+	}
+	c.staleRows[key] = obs
+	return obs
+}
+
+// forgetOldestStaleRow drops the observation seen least recently. It must be
+// called with staleRowsMu held.
+func (c *commonClient) forgetOldestStaleRow() {
+	var (
+		oldest   lockKey
+		lastSeen time.Time
+		found    bool
+	)
+	for key, obs := range c.staleRows {
+		if !found || obs.lastSeen.Before(lastSeen) {
+			oldest, lastSeen, found = key, obs.lastSeen, true
+		}
+	}
+	delete(c.staleRows, oldest)
+}
+
+// forgetStaleRows drops all the observations, once the client is closed.
+func (c *commonClient) forgetStaleRows() {
+	c.staleRowsMu.Lock()
+	defer c.staleRowsMu.Unlock()
+	c.staleRows = nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// staleRow is the row of a lock whose owner crashed, at the given record
+// version number.
+func staleRow(rvn string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key":                   stringAttrValue("stale"),
+		attrOwnerName:           stringAttrValue("crashed"),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue(rvn),
+		// The owner's clock is far behind: its own expiration must
+		// not be trusted.
+		attrExpiresAt: int64AttrValue(unixMillis(time.Unix(0, 0))),
+	}
+}
+
+func TestCleanupStaleRows(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksStale", staleRow("rvn-1"))
+	c, err := New(svc, "locksStale", "key",
+		DisableHeartbeat(),
+		WithClock(clock),
+		WithCleanupStaleRows(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	tryAcquire := func() {
+		t.Helper()
+		_, err := c.AcquireLock(context.Background(), "stale", FailIfLocked())
+		var errNotGranted *LockNotGrantedError
+		if !errors.As(err, &errNotGranted) {
+			t.Fatal("expected lock not to be granted:", err)
+		}
+	}
+
+	tryAcquire()
+	if d := svc.deleteInputs(); len(d) != 0 {
+		t.Fatal("a single sighting must not be enough to delete the row:", d)
+	}
+	clock.Advance(time.Minute)
+	tryAcquire()
+	if d := svc.deleteInputs(); len(d) != 0 {
+		t.Fatal("the row was not seen unchanged for its lease plus the grace period:", d)
+	}
+
+	// A heartbeat restarts the observation.
+	svc.putRow("locksStale", staleRow("rvn-2"))
+	clock.Advance(time.Minute)
+	tryAcquire()
+	if d := svc.deleteInputs(); len(d) != 0 {
+		t.Fatal("a heartbeated row must not be deleted:", d)
+	}
+
+	clock.Advance(81 * time.Second)
+	tryAcquire()
+	if d := svc.deleteInputs(); len(d) != 1 || !hasStringValue(d[0].ExpressionAttributeValues, "rvn-2") {
+		t.Fatal("the stale row should have been deleted conditioned on its record version number:", d)
+	}
+	if svc.row("locksStale", "stale") != nil {
+		t.Fatal("the stale row should have been deleted")
+	}
+}
+
+func TestCleanupStaleRowsBounded(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	c, err := New(newMemoryDynamoDBClient(), "locksStale", "key",
+		DisableHeartbeat(),
+		WithClock(clock),
+		WithCleanupStaleRows(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= maxStaleRows; i++ {
+		clock.Advance(time.Second)
+		c.observeStaleRow(lockKey{partitionKey: fmt.Sprint(i)}, &Lock{recordVersionNumber: "rvn", lookupTime: clock.Now()})
+	}
+	if len(c.staleRows) != maxStaleRows {
+		t.Fatal("the observations should be bounded:", len(c.staleRows))
+	}
+	if _, ok := c.staleRows[lockKey{partitionKey: "0"}]; ok {
+		t.Fatal("the row seen least recently should have been forgotten")
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.staleRows) != 0 {
+		t.Fatal("the observations should be dropped on Close:", len(c.staleRows))
+	}
+}
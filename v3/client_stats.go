@@ -0,0 +1,271 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of the latency
+// histograms.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+}
+
+// Histogram is the distribution of a set of observed durations.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets.
+	Bounds []time.Duration
+	// Counts holds the number of observations of each bucket. It has one
+	// more entry than Bounds, for the observations above the last bound.
+	Counts []uint64
+	// Count is the total number of observations.
+	Count uint64
+	// Sum is the total of the observed durations.
+	Sum time.Duration
+	// Max is the longest observed duration.
+	Max time.Duration
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Bounds = latencyBuckets
+		h.Counts = make([]uint64, len(latencyBuckets)+1)
+	}
+	h.Counts[sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// KeyStats holds the acquisition wait time, hold duration and contention of
+// the locks of a given key, as observed by this client.
+type KeyStats struct {
+	PartitionKey string
+	SortKey      string
+	// AcquireWait is the time spent by AcquireLock until the lock was
+	// granted.
+	AcquireWait Histogram
+	// HoldDuration is the time between the acquisition and the release of
+	// the lock.
+	HoldDuration Histogram
+	// ConditionalFailures is the number of conditional puts that failed
+	// because another client stored the lock first.
+	ConditionalFailures uint64
+	// Steals is the number of times the lock was acquired after its
+	// previous owner let it expire without releasing it.
+	Steals uint64
+}
+
+// contention scores how contended the key is.
+func (s KeyStats) contention() uint64 {
+	return s.ConditionalFailures + s.Steals
+}
+
+// MetricKind identifies the measurement reported to the metrics hook.
+type MetricKind int
+
+// Measurements reported to the metrics hook.
+const (
+	// MetricAcquireWait is the time spent by AcquireLock until the lock was
+	// granted.
+	MetricAcquireWait MetricKind = iota
+	// MetricHoldDuration is the time between the acquisition and the
+	// release of the lock.
+	MetricHoldDuration
+	// MetricConditionalFailure is reported when a conditional put of the
+	// lock fails because another client stored the lock first.
+	MetricConditionalFailure
+	// MetricSteal is reported when the lock is acquired after its previous
+	// owner let it expire without releasing it.
+	MetricSteal
+	// MetricConsumedCapacity is reported for every DynamoDB call when
+	// WithConsumedCapacity is used.
+	MetricConsumedCapacity
+)
+
+// Metric is a single measurement reported to the metrics hook.
+type Metric struct {
+	Kind         MetricKind
+	PartitionKey string
+	SortKey      string
+	// Value is the measured duration. It is zero for the events that are
+	// only counted, MetricConditionalFailure and MetricSteal.
+	Value time.Duration
+	// Operation is the name of the DynamoDB API whose consumed capacity is
+	// reported by MetricConsumedCapacity. The keys are only set for the
+	// operations on a single lock row.
+	Operation          string
+	ReadCapacityUnits  float64
+	WriteCapacityUnits float64
+}
+
+type lockStats struct {
+	mu   sync.Mutex
+	keys map[lockKey]*KeyStats
+}
+
+// WithMetricsHook calls hook with every acquisition wait time and hold
+// duration measured by the client, so they can be forwarded to a metrics
+// system. The hook is called synchronously and must not block.
+func WithMetricsHook(hook func(Metric)) ClientOption {
+	return func(c *commonClient) {
+		c.metricsHook = hook
+	}
+}
+
+// WithLongHoldHook reports, once per lock, the locks that are held by this
+// client for longer than threshold, so code paths holding locks far longer
+// than intended can be found. Locks are checked on every automatic heartbeat,
+// so the hook is never called if heartbeats are disabled.
+func WithLongHoldHook(threshold time.Duration, hook func(l *Lock, held time.Duration)) ClientOption {
+	return func(c *commonClient) {
+		c.longHoldThreshold = threshold
+		c.longHoldHook = hook
+	}
+}
+
+// Stats returns, for each key acquired by this client, the distribution of
+// the acquisition wait times and hold durations, and how contended the key
+// was. The result is sorted by key.
+func (c *commonClient) Stats() []KeyStats {
+	stats := c.snapshotStats()
+	sort.Slice(stats, func(i, j int) bool {
+		return lessKeyStats(stats[i], stats[j])
+	})
+	return stats
+}
+
+// HotKeys returns the n most contended keys seen by this client, the most
+// contended first. Keys are ranked by the sum of their conditional failures
+// and steals, and then by their total acquisition wait time; keys that were
+// never contended are left out. A non-positive n returns all of them.
+func (c *commonClient) HotKeys(n int) []KeyStats {
+	stats := c.snapshotStats()
+	hot := stats[:0]
+	for _, s := range stats {
+		if s.contention() > 0 {
+			hot = append(hot, s)
+		}
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		if a, b := hot[i].contention(), hot[j].contention(); a != b {
+			return a > b
+		}
+		if a, b := hot[i].AcquireWait.Sum, hot[j].AcquireWait.Sum; a != b {
+			return a > b
+		}
+		return lessKeyStats(hot[i], hot[j])
+	})
+	if n > 0 && len(hot) > n {
+		hot = hot[:n]
+	}
+	return hot
+}
+
+func (c *commonClient) snapshotStats() []KeyStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	stats := make([]KeyStats, 0, len(c.stats.keys))
+	for _, s := range c.stats.keys {
+		stats = append(stats, KeyStats{
+			PartitionKey:        s.PartitionKey,
+			SortKey:             s.SortKey,
+			AcquireWait:         s.AcquireWait.clone(),
+			HoldDuration:        s.HoldDuration.clone(),
+			ConditionalFailures: s.ConditionalFailures,
+			Steals:              s.Steals,
+		})
+	}
+	return stats
+}
+
+func lessKeyStats(a, b KeyStats) bool {
+	if a.PartitionKey != b.PartitionKey {
+		return a.PartitionKey < b.PartitionKey
+	}
+	return a.SortKey < b.SortKey
+}
+
+func (c *commonClient) recordMetric(kind MetricKind, partitionKey, sortKey string, d time.Duration) {
+	c.stats.mu.Lock()
+	if c.stats.keys == nil {
+		c.stats.keys = make(map[lockKey]*KeyStats)
+	}
+	key := lockKey{partitionKey: partitionKey, sortKey: sortKey}
+	s, ok := c.stats.keys[key]
+	if !ok {
+		s = &KeyStats{PartitionKey: partitionKey, SortKey: sortKey}
+		c.stats.keys[key] = s
+	}
+	switch kind {
+	case MetricAcquireWait:
+		s.AcquireWait.observe(d)
+	case MetricHoldDuration:
+		s.HoldDuration.observe(d)
+	case MetricConditionalFailure:
+		s.ConditionalFailures++
+	case MetricSteal:
+		s.Steals++
+	}
+	c.stats.mu.Unlock()
+
+	if c.metricsHook != nil {
+		c.metricsHook(Metric{Kind: kind, PartitionKey: partitionKey, SortKey: sortKey, Value: d})
+	}
+}
+
+func (c *commonClient) checkLongHold(ctx context.Context, lockItem *Lock) {
+	if c.longHoldHook == nil {
+		return
+	}
+	lockItem.semaphore.Lock()
+	if lockItem.acquiredAt.IsZero() || lockItem.longHoldReported || lockItem.isReleased {
+		lockItem.semaphore.Unlock()
+		return
+	}
+	held := c.now().Sub(lockItem.acquiredAt)
+	report := held > c.longHoldThreshold
+	if report {
+		lockItem.longHoldReported = true
+	}
+	lockItem.semaphore.Unlock()
+
+	if report {
+		c.logger.Error(ctx, "lock ", lockItem.partitionKey, " held for ", held)
+		c.longHoldHook(lockItem, held)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestStats(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var (
+		mu      sync.Mutex
+		metrics []Metric
+	)
+	c, err := New(&mockDynamoDBClient{}, "locksStats", "key",
+		DisableHeartbeat(),
+		WithClock(clock),
+		WithMetricsHook(func(m Metric) {
+			mu.Lock()
+			defer mu.Unlock()
+			metrics = append(metrics, m)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hold := range []time.Duration{time.Second, 2 * time.Minute} {
+		l, err := c.AcquireLock(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(hold)
+		if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := c.Stats()
+	if len(stats) != 1 || stats[0].PartitionKey != "key" {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+	hold := stats[0].HoldDuration
+	if hold.Count != 2 || hold.Sum != time.Second+2*time.Minute || hold.Max != 2*time.Minute {
+		t.Fatalf("unexpected hold duration histogram: %#v", hold)
+	}
+	if hold.Counts[4] != 1 || hold.Counts[9] != 1 {
+		t.Fatalf("unexpected hold duration buckets: %v", hold.Counts)
+	}
+	if wait := stats[0].AcquireWait; wait.Count != 2 || wait.Counts[0] != 2 {
+		t.Fatalf("unexpected acquire wait histogram: %#v", wait)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []MetricKind{MetricAcquireWait, MetricHoldDuration, MetricAcquireWait, MetricHoldDuration}
+	if len(metrics) != len(want) {
+		t.Fatalf("unexpected metrics: %#v", metrics)
+	}
+	for i, m := range metrics {
+		if m.Kind != want[i] || m.PartitionKey != "key" {
+			t.Fatalf("unexpected metric %d: %#v", i, m)
+		}
+	}
+}
+
+func TestLongHoldHook(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	reported := make(chan time.Duration, 10)
+	c, err := New(&mockDynamoDBClient{}, "locksLongHold", "key",
+		WithLeaseDuration(time.Second),
+		WithHeartbeatPeriod(10*time.Millisecond),
+		WithClock(clock),
+		WithLongHoldHook(time.Minute, func(l *Lock, held time.Duration) {
+			reported <- held
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if _, err := c.AcquireLock(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reported:
+		t.Fatal("lock reported before the threshold")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(2 * time.Minute)
+	select {
+	case held := <-reported:
+		if held != 2*time.Minute {
+			t.Fatal("unexpected hold duration:", held)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("long hold not reported")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(reported) != 0 {
+		t.Fatal("long hold reported more than once")
+	}
+}
+
+// racingPutDynamoDBClient fails the first puts, as if other clients were
+// storing the lock first.
+type racingPutDynamoDBClient struct {
+	mockDynamoDBClient
+	failures int32
+}
+
+func (m *racingPutDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if atomic.AddInt32(&m.failures, -1) >= 0 {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestHotKeys(t *testing.T) {
+	svc := &racingPutDynamoDBClient{failures: 3}
+	c, err := New(svc, "locksHotKeys", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "hot", WithRefreshPeriod(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "cold"); err != nil {
+		t.Fatal(err)
+	}
+	c.recordMetric(MetricSteal, "stolen", "", 0)
+
+	hot := c.HotKeys(1)
+	if len(hot) != 1 || hot[0].PartitionKey != "hot" || hot[0].ConditionalFailures != 3 {
+		t.Fatalf("unexpected hot keys: %#v", hot)
+	}
+	hot = c.HotKeys(0)
+	if len(hot) != 2 || hot[1].PartitionKey != "stolen" || hot[1].Steals != 1 {
+		t.Fatalf("uncontended keys should not be reported: %#v", hot)
+	}
+	if stats := c.Stats(); len(stats) != 3 || stats[1].PartitionKey != "hot" || stats[1].ConditionalFailures != 3 {
+		t.Fatalf("unexpected stats: %#v", stats)
+	}
+}
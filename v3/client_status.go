@@ -0,0 +1,133 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"time"
+)
+
+// acquisitionStatusBuffer is the capacity of the channels returned by
+// AcquireLockWithStatus.
+const acquisitionStatusBuffer = 16
+
+// AcquisitionState is a step of an acquisition reported by
+// AcquireLockWithStatus.
+type AcquisitionState int
+
+// Steps of an acquisition.
+const (
+	// AcquisitionStateFoundHolder means the lock was found held by someone
+	// else, and the acquisition started waiting for it.
+	AcquisitionStateFoundHolder AcquisitionState = iota
+	// AcquisitionStateHolderRefreshed means the holder heartbeated the lock
+	// while it was being waited for.
+	AcquisitionStateHolderRefreshed
+	// AcquisitionStateHolderExpired means the holder stopped heartbeating
+	// the lock for longer than its lease duration.
+	AcquisitionStateHolderExpired
+	// AcquisitionStateAttemptingTakeover means the client is trying to
+	// store the lock in place of its expired holder.
+	AcquisitionStateAttemptingTakeover
+	// AcquisitionStateAcquired means the lock was acquired. It is always
+	// the last reported state of a successful acquisition.
+	AcquisitionStateAcquired
+	// AcquisitionStateFailed means the acquisition failed. It is always the
+	// last reported state of a failed acquisition.
+	AcquisitionStateFailed
+)
+
+func (s AcquisitionState) String() string {
+	switch s {
+	case AcquisitionStateFoundHolder:
+		return "found holder"
+	case AcquisitionStateHolderRefreshed:
+		return "holder refreshed"
+	case AcquisitionStateHolderExpired:
+		return "holder expired"
+	case AcquisitionStateAttemptingTakeover:
+		return "attempting takeover"
+	case AcquisitionStateAcquired:
+		return "acquired"
+	case AcquisitionStateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// AcquisitionStatus is a state transition of an acquisition reported by
+// AcquireLockWithStatus.
+type AcquisitionStatus struct {
+	State AcquisitionState
+	// Holder describes the lock as it was observed in the table. It is
+	// empty in the final states.
+	Holder LockInfo
+	// Attempt counts the attempts made so far to store the lock.
+	Attempt int
+	// Waited is how long the acquisition has been running.
+	Waited time.Duration
+	// Lock is the acquired lock, set in the AcquisitionStateAcquired state.
+	Lock *Lock
+	// Err is the cause of the failure, set in the AcquisitionStateFailed
+	// state.
+	Err error
+}
+
+func (c *commonClient) acquireLockWithStatus(ctx context.Context, partitionKey, sortKey string, opts ...AcquireLockOption) <-chan AcquisitionStatus {
+	ch := make(chan AcquisitionStatus, acquisitionStatusBuffer)
+	report := func(s AcquisitionStatus) {
+		// Intermediate states are dropped rather than stalling the
+		// acquisition, but there is always room for the final one. This
+		// is the only sender, so the length cannot grow behind its back.
+		if len(ch) < cap(ch)-1 {
+			ch <- s
+		}
+	}
+	opts = append(opts[:len(opts):len(opts)], func(opt *acquireLockOptions) {
+		opt.onStatus = report
+	})
+	go func() {
+		defer close(ch)
+		start := c.now()
+		l, err := c.acquireLock(ctx, partitionKey, sortKey, opts...)
+		if err != nil {
+			ch <- AcquisitionStatus{State: AcquisitionStateFailed, Waited: c.now().Sub(start), Err: err}
+			return
+		}
+		acquisition := l.Acquisition()
+		ch <- AcquisitionStatus{
+			State:   AcquisitionStateAcquired,
+			Attempt: acquisition.Attempts,
+			Waited:  acquisition.WaitTime,
+			Lock:    l,
+		}
+	}()
+	return ch
+}
+
+func (c *commonClient) reportAcquisitionState(getLockOptions *getLockOptions, state AcquisitionState, holder *Lock) {
+	if getLockOptions.onStatus == nil {
+		return
+	}
+	getLockOptions.onStatus(AcquisitionStatus{
+		State:   state,
+		Holder:  lockInfo(holder),
+		Attempt: getLockOptions.attempts,
+		Waited:  c.now().Sub(getLockOptions.start),
+	})
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// newAbandonedLockDynamoDBClient returns an in-memory DynamoDB serving a lock
+// row that is heartbeated once by its holder, right after it is first read,
+// and then abandoned.
+func newAbandonedLockDynamoDBClient(tableName string) *memoryDynamoDBClient {
+	row := func(rvn string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"key":                   stringAttrValue("status"),
+			attrOwnerName:           stringAttrValue("holder"),
+			attrLeaseDuration:       stringAttrValue("50ms"),
+			attrRecordVersionNumber: stringAttrValue(rvn),
+		}
+	}
+	svc := newMemoryDynamoDBClient()
+	svc.putRow(tableName, row("before-heartbeat"))
+	var once sync.Once
+	svc.setIntercept(func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error) {
+		out, err := next()
+		if op == "GetItem" {
+			once.Do(func() { svc.putRow(tableName, row("after-heartbeat")) })
+		}
+		return out, err
+	})
+	return svc
+}
+
+func TestAcquireLockWithStatus(t *testing.T) {
+	c, err := New(newAbandonedLockDynamoDBClient("locksStatus"), "locksStatus", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var states []AcquisitionState
+	var last AcquisitionStatus
+	for status := range c.AcquireLockWithStatus(context.Background(), "status", WithRefreshPeriod(10*time.Millisecond)) {
+		states = append(states, status.State)
+		if status.State == AcquisitionStateFoundHolder && status.Holder.OwnerName != "holder" {
+			t.Fatalf("unexpected holder: %#v", status.Holder)
+		}
+		last = status
+	}
+	want := []AcquisitionState{
+		AcquisitionStateFoundHolder,
+		AcquisitionStateHolderRefreshed,
+		AcquisitionStateHolderExpired,
+		AcquisitionStateAttemptingTakeover,
+		AcquisitionStateAcquired,
+	}
+	if len(states) != len(want) {
+		t.Fatalf("unexpected states: %v", states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("unexpected states: %v", states)
+		}
+	}
+	if last.Lock == nil || last.Lock.OwnerName() != c.ownerName || last.Err != nil {
+		t.Fatalf("unexpected final status: %#v", last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var final AcquisitionStatus
+	for status := range c.AcquireLockWithStatus(ctx, "status") {
+		final = status
+	}
+	if final.State != AcquisitionStateFailed || !errors.Is(final.Err, context.Canceled) {
+		t.Fatalf("unexpected final status: %#v", final)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// LockChange is the state of a lock reported by Subscribe.
+type LockChange struct {
+	// Found reports whether the key has a lock row in the table.
+	Found bool
+	// Released reports whether the lock row was released by its last
+	// owner.
+	Released bool
+	// Info describes the lock row, if found.
+	Info LockInfo
+	// Data is the data stored in the lock row, if found.
+	Data []byte
+}
+
+func (l LockChange) equal(other LockChange) bool {
+	return l.Found == other.Found &&
+		l.Released == other.Released &&
+		l.Info == other.Info &&
+		bytes.Equal(l.Data, other.Data)
+}
+
+// SubscribeOption changes how Subscribe watches a lock.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	pollInterval time.Duration
+}
+
+// WithPollInterval defines how often Subscribe reads the lock row. It defaults
+// to the heartbeat period of the client, or to one second if heartbeats are
+// disabled.
+func WithPollInterval(d time.Duration) SubscribeOption {
+	return func(opt *subscribeOptions) {
+		opt.pollInterval = d
+	}
+}
+
+// Subscribe watches the given lock, so followers can react to the data
+// published by the leader without their own polling loop. See
+// Client.Subscribe.
+func (c *ClientWithSortKey) Subscribe(ctx context.Context, partitionKey, sortKey string, opts ...SubscribeOption) <-chan LockChange {
+	return c.subscribe(ctx, partitionKey, sortKey, opts...)
+}
+
+// Subscribe watches the given lock, polling its row and reporting on the
+// returned channel whenever its owner, record version number or data change,
+// so followers can react to the data published by the leader without their
+// own polling loop. The current state is reported right away. If the channel
+// is not read fast enough, intermediate changes are dropped in favor of the
+// latest one. Failed reads are logged and retried in the next poll. The
+// channel is closed once the context is canceled or the client is closed. The
+// given context is passed down to the underlying dynamoDB calls.
+func (c *Client) Subscribe(ctx context.Context, partitionKey string, opts ...SubscribeOption) <-chan LockChange {
+	return c.subscribe(ctx, partitionKey, "", opts...)
+}
+
+func (c *commonClient) subscribe(ctx context.Context, partitionKey, sortKey string, opts ...SubscribeOption) <-chan LockChange {
+	opt := &subscribeOptions{pollInterval: c.currentHeartbeatPeriod()}
+	if opt.pollInterval <= 0 {
+		opt.pollInterval = c.defaultRefreshPeriod
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	changes := make(chan LockChange, 1)
+	go func() {
+		defer close(changes)
+		var (
+			last     LockChange
+			reported bool
+		)
+		for !c.isClosed() {
+			change, err := c.observeLock(ctx, partitionKey, sortKey)
+			if err != nil && ctx.Err() == nil {
+				c.logger.Error(ctx, "cannot observe lock ", partitionKey, ":", err)
+			} else if err == nil && (!reported || !change.equal(last)) {
+				last, reported = change, true
+				// Only the latest change is kept for slow readers.
+				select {
+				case <-changes:
+				default:
+				}
+				changes <- change
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(opt.pollInterval):
+			}
+		}
+	}()
+	return changes
+}
+
+func (c *commonClient) observeLock(ctx context.Context, partitionKey, sortKey string) (LockChange, error) {
+	lockItem, err := c.getLockFromDynamoDB(ctx, getLockOptions{
+		partitionKey: partitionKey,
+		sortKey:      sortKey,
+	})
+	if err != nil || lockItem == nil {
+		return LockChange{}, err
+	}
+	return LockChange{
+		Found:    true,
+		Released: lockItem.isReleased,
+		Info:     lockInfo(lockItem),
+		Data:     lockItem.data,
+	}, nil
+}
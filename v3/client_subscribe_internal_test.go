@@ -0,0 +1,90 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestSubscribe(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksSubscribe", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("leader"),
+		attrOwnerName:           stringAttrValue("leader-1"),
+		attrLeaseDuration:       stringAttrValue("20s"),
+		attrRecordVersionNumber: stringAttrValue("rvn-1"),
+		attrData:                bytesAttrValue([]byte("progress 1")),
+	})
+	c, err := New(svc, "locksSubscribe", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.Subscribe(ctx, "leader", WithPollInterval(10*time.Millisecond))
+
+	next := func() LockChange {
+		t.Helper()
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				t.Fatal("subscription closed too early")
+			}
+			return change
+		case <-time.After(time.Second):
+			t.Fatal("change not reported")
+		}
+		return LockChange{}
+	}
+	if got := next(); !got.Found || got.Info.OwnerName != "leader-1" || string(got.Data) != "progress 1" {
+		t.Fatalf("unexpected initial state: %#v", got)
+	}
+	select {
+	case got := <-changes:
+		t.Fatalf("unchanged lock should not be reported: %#v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	svc.setAttributes("locksSubscribe", map[string]types.AttributeValue{
+		attrRecordVersionNumber: stringAttrValue("rvn-2"),
+		attrData:                bytesAttrValue([]byte("progress 2")),
+	}, "leader")
+	if got := next(); got.Info.RecordVersionNumber != "rvn-2" || string(got.Data) != "progress 2" {
+		t.Fatalf("unexpected change: %#v", got)
+	}
+
+	svc.setAttributes("locksSubscribe", map[string]types.AttributeValue{
+		attrIsReleased: stringAttrValue("1"),
+	}, "leader")
+	if got := next(); !got.Released {
+		t.Fatalf("released lock should be reported: %#v", got)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Fatal("subscription should be closed once the context is canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+type tableNameKey struct{}
+
+// RouteToTable makes the DynamoDB calls done with the returned context target
+// the given table instead of the one the client was created with, so a single
+// client, with one owner name and one heartbeat goroutine, can hold locks
+// stored in several tables with the same key schema, like tenant-specific
+// tables. Locks acquired with such a context remember their table: their
+// heartbeats and releases are routed to it regardless of the context they are
+// given. Locks with the same keys in different tables are different locks.
+func RouteToTable(ctx context.Context, tableName string) context.Context {
+	return context.WithValue(ctx, tableNameKey{}, tableName)
+}
+
+// routedTable returns the table the calls made with ctx are routed to, or
+// an empty string if it is the table of the client.
+func (c *commonClient) routedTable(ctx context.Context) string {
+	tableName, _ := ctx.Value(tableNameKey{}).(string)
+	if tableName == c.tableName {
+		return ""
+	}
+	return tableName
+}
+
+// lockKeyOf returns the key of the given lock in the table the calls made
+// with ctx are routed to.
+func (c *commonClient) lockKeyOf(ctx context.Context, partitionKey, sortKey string) lockKey {
+	return lockKey{
+		tableName:    c.routedTable(ctx),
+		partitionKey: partitionKey,
+		sortKey:      sortKey,
+	}
+}
+
+// routeToLock routes the calls made with ctx to the table of the lock.
+func routeToLock(ctx context.Context, l *Lock) context.Context {
+	if l == nil || l.tableName == "" {
+		return ctx
+	}
+	return RouteToTable(ctx, l.tableName)
+}
+
+// routeToToken routes the calls made with ctx to the table of the lock the
+// token was created from.
+func routeToToken(ctx context.Context, t lockToken) context.Context {
+	if t.TableName == "" {
+		return ctx
+	}
+	return RouteToTable(ctx, t.TableName)
+}
+
+// tableRoutingMiddleware replaces the table name of the calls whose context
+// was routed to another table.
+func tableRoutingMiddleware(next Operation) Operation {
+	return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		tableName, ok := ctx.Value(tableNameKey{}).(string)
+		if !ok || tableName == "" {
+			return next(ctx, name, input)
+		}
+		switch in := input.(type) {
+		case *dynamodb.GetItemInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.PutItemInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.UpdateItemInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.DeleteItemInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.CreateTableInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.ScanInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.QueryInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		case *dynamodb.DescribeTableInput:
+			routed := *in
+			routed.TableName = aws.String(tableName)
+			input = &routed
+		}
+		return next(ctx, name, input)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRouteToTable(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	storedRVN := func(tableName string) string {
+		return readStringAttr(svc.row(tableName, "job")[attrRecordVersionNumber])
+	}
+	c, err := New(svc, "locks", "key",
+		DisableHeartbeat(),
+		WithReacquirePolicy(ReacquireFail),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	tenantCtx := RouteToTable(context.Background(), "tenantLocks")
+	tenantLock, err := c.AcquireLock(tenantCtx, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storedRVN("tenantLocks"); got != tenantLock.RecordVersionNumber() || svc.rowCount("locks") != 0 {
+		t.Fatal("acquisition not routed to the tenant table:", got)
+	}
+
+	// The same key in the table of the client is another lock.
+	defaultLock, err := c.AcquireLock(context.Background(), "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storedRVN("locks"); got != defaultLock.RecordVersionNumber() {
+		t.Fatal("acquisition not sent to the table of the client:", got)
+	}
+	if _, err := c.AcquireLock(tenantCtx, "job"); err != ErrAlreadyHeldLocally {
+		t.Fatal("expected the tenant lock to be held locally:", err)
+	}
+	if got, err := c.Get(tenantCtx, "job"); err != nil || got != tenantLock {
+		t.Fatal("expected the tenant lock to be found:", err)
+	}
+
+	if err := c.SendHeartbeat(context.Background(), tenantLock); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedRVN("tenantLocks"); got != tenantLock.RecordVersionNumber() {
+		t.Fatal("heartbeat not routed to the table of the lock:", got)
+	}
+	if _, err := c.ReleaseLock(context.Background(), tenantLock); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := svc.row("tenantLocks", "job")[attrIsReleased]; !ok {
+		t.Fatal("release not routed to the table of the lock")
+	}
+	if _, ok := svc.row("locks", "job")[attrIsReleased]; ok {
+		t.Fatal("release of the tenant lock reached the table of the client")
+	}
+	if _, err := c.ReleaseLock(context.Background(), defaultLock); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := svc.row("locks", "job")[attrIsReleased]; !ok {
+		t.Fatal("release not sent to the table of the client")
+	}
+}
@@ -41,6 +41,10 @@ import (
 
 func TestMain(m *testing.M) {
 	flag.Parse()
+	if benchmarksOnly() {
+		// The benchmarks run on fakes and need no DynamoDB Local.
+		os.Exit(m.Run())
+	}
 	javaPath, err := exec.LookPath("java")
 	if err != nil {
 		panic("cannot execute tests without Java")
@@ -67,6 +71,13 @@ func TestMain(m *testing.M) {
 	os.Exit(exitCode)
 }
 
+// benchmarksOnly tells whether the tests were invoked to run the benchmarks
+// alone, as with "go test -run '^$' -bench .".
+func benchmarksOnly() bool {
+	return flag.Lookup("test.bench").Value.String() != "" &&
+		flag.Lookup("test.run").Value.String() == "^$"
+}
+
 func defaultConfig(t *testing.T) aws.Config {
 	return aws.Config{
 		Region: "us-west-2",
@@ -120,6 +131,22 @@ func TestClientBasicFlow(t *testing.T) {
 		t.Error("losing information inside lock storage, wanted:", string(data), " got:", got)
 	}
 
+	if got := lockedItem.OwnerName(); got != "TestClientBasicFlow#1" {
+		t.Error("unexpected owner name:", got)
+	}
+	if got := lockedItem.LeaseDuration(); got != 3*time.Second {
+		t.Error("unexpected lease duration:", got)
+	}
+	if lockedItem.RecordVersionNumber() == "" {
+		t.Error("missing record version number")
+	}
+	if got := lockedItem.Acquisition(); got.Kind != dynamolock.AcquisitionFresh || got.Attempts != 1 {
+		t.Errorf("unexpected acquisition diagnostics: %#v", got)
+	}
+	if got := lockedItem.ExpiresAt().Sub(lockedItem.LastHeartbeat()); got != 3*time.Second {
+		t.Error("expiration should be one lease after the last heartbeat:", got)
+	}
+
 	t.Log("cleaning lock")
 	success, err := c.ReleaseLock(context.Background(), lockedItem)
 	if !success {
@@ -143,6 +170,9 @@ func TestClientBasicFlow(t *testing.T) {
 	if got := string(lockedItem2.Data()); string(data2) != got {
 		t.Error("losing information inside lock storage, wanted:", string(data2), " got:", got)
 	}
+	if got := lockedItem2.Acquisition(); got.Kind != dynamolock.AcquisitionReleased || got.Attempts != 1 {
+		t.Errorf("unexpected acquisition diagnostics: %#v", got)
+	}
 
 	c2, err := dynamolock.New(svc,
 		"locks", "key",
@@ -936,6 +966,138 @@ func TestHeartbeatError(t *testing.T) {
 	}
 }
 
+func TestExpiryGrace(t *testing.T) {
+	t.Parallel()
+	svc := dynamodb.NewFromConfig(defaultConfig(t))
+	c, err := dynamolock.New(svc,
+		"locks", "key",
+		dynamolock.WithLeaseDuration(1*time.Second),
+		dynamolock.DisableHeartbeat(),
+		dynamolock.WithOwnerName("TestExpiryGrace#1"),
+		dynamolock.WithLogger(&testLogger{t: t}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("ensuring table exists")
+	c.CreateTable(context.Background(),
+		dynamolock.WithProvisionedThroughput(&types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		}),
+	)
+
+	const expiryGrace = 2 * time.Second
+	c2, err := dynamolock.New(svc,
+		"locks", "key",
+		dynamolock.WithLeaseDuration(1*time.Second),
+		dynamolock.DisableHeartbeat(),
+		dynamolock.WithExpiryGrace(expiryGrace),
+		dynamolock.WithOwnerName("TestExpiryGrace#2"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.AcquireLock(context.Background(), "expiryGrace"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	lockItem, err := c2.AcquireLock(context.Background(), "expiryGrace",
+		dynamolock.WithRefreshPeriod(100*time.Millisecond),
+		dynamolock.WithAdditionalTimeToWaitForLock(5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lockItem.Close()
+	if elapsed := time.Since(start); elapsed < 1*time.Second+expiryGrace {
+		t.Fatal("lock was taken over before the grace period elapsed:", elapsed)
+	}
+}
+
+func TestLockRefresh(t *testing.T) {
+	t.Parallel()
+	svc := dynamodb.NewFromConfig(defaultConfig(t))
+	c, err := dynamolock.New(svc,
+		"locks", "key",
+		dynamolock.WithLeaseDuration(2*time.Second),
+		dynamolock.DisableHeartbeat(),
+		dynamolock.WithOwnerName("TestLockRefresh#1"),
+		dynamolock.WithLogger(&testLogger{t: t}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("ensuring table exists")
+	c.CreateTable(context.Background(),
+		dynamolock.WithProvisionedThroughput(&types.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(5),
+			WriteCapacityUnits: aws.Int64(5),
+		}),
+	)
+
+	const lockName = "lockRefresh"
+	lockItem, err := c.AcquireLock(context.Background(), lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lockItem.Refresh(context.Background()); err != nil {
+		t.Fatal("cannot refresh held lock:", err)
+	}
+
+	if err := c.SendHeartbeat(context.Background(), lockItem); err != nil {
+		t.Fatal(err)
+	}
+	wantRVN := lockItem.RVN()
+	c2, err := dynamolock.New(svc,
+		"locks", "key",
+		dynamolock.WithLeaseDuration(2*time.Second),
+		dynamolock.DisableHeartbeat(),
+		dynamolock.WithOwnerName("TestLockRefresh#2"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer, err := c2.Get(context.Background(), lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := observer.Refresh(context.Background()); err != nil {
+		t.Fatal("cannot refresh observed lock:", err)
+	}
+	if observer.RVN() != wantRVN {
+		t.Fatal("refresh did not re-sync the record version number")
+	}
+
+	c3, err := dynamolock.New(svc,
+		"locks", "key",
+		dynamolock.WithLeaseDuration(2*time.Second),
+		dynamolock.DisableHeartbeat(),
+		dynamolock.WithOwnerName("TestLockRefresh#3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolenLock, err := c3.AcquireLock(context.Background(), lockName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stolenLock.Close()
+	if got := stolenLock.Acquisition(); got.Kind != dynamolock.AcquisitionExpired || got.Attempts < 2 {
+		t.Errorf("unexpected acquisition diagnostics: %#v", got)
+	}
+
+	if err := lockItem.Refresh(context.Background()); err != dynamolock.ErrOwnerMismatched {
+		t.Fatal("expected ownership change to be detected:", err)
+	}
+	if !lockItem.IsExpired() {
+		t.Fatal("lost lock should be reported as expired")
+	}
+}
+
 type lockStepBuffer struct {
 	mu  sync.Mutex
 	buf bytes.Buffer
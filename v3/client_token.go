@@ -0,0 +1,153 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidLockToken indicates that the token given to ResumeLock could not
+// be decoded.
+var ErrInvalidLockToken = errors.New("invalid lock token")
+
+type lockToken struct {
+	// TableName is the table the lock was acquired in with RouteToTable,
+	// empty for the table of the client.
+	TableName           string `json:"tableName,omitempty"`
+	PartitionKey        string `json:"partitionKey"`
+	SortKey             string `json:"sortKey,omitempty"`
+	OwnerName           string `json:"ownerName"`
+	RecordVersionNumber string `json:"recordVersionNumber"`
+	LeaseDuration       string `json:"leaseDuration"`
+	DeleteLockOnRelease bool   `json:"deleteLockOnRelease,omitempty"`
+}
+
+// MarshalToken serializes the identity of the lock (table, key, owner, record
+// version number and lease) so another process can take over its stewardship with
+// ResumeLock, for example across a fork/exec or a Lambda invocation boundary.
+// Once the lock is resumed elsewhere, this handle stops being valid: its next
+// heartbeat fails and the lock is dropped by this client. To keep the lock
+// alive without handing it over, use Lock.StartDelegation instead.
+func (l *Lock) MarshalToken() ([]byte, error) {
+	if l == nil {
+		return nil, ErrCannotReleaseNullLock
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.marshalToken()
+}
+
+// marshalToken serializes the identity of the lock. Callers must hold
+// l.semaphore.
+func (l *Lock) marshalToken() ([]byte, error) {
+	if l.isExpired() {
+		return nil, ErrLockAlreadyReleased
+	}
+	return json.Marshal(lockToken{
+		TableName:           l.tableName,
+		PartitionKey:        l.partitionKey,
+		SortKey:             l.sortKey,
+		OwnerName:           l.ownerName,
+		RecordVersionNumber: l.recordVersionNumber,
+		LeaseDuration:       l.leaseDuration.String(),
+		DeleteLockOnRelease: l.deleteLockOnRelease,
+	})
+}
+
+// ResumeLock takes over the stewardship of a lock serialized with
+// Lock.MarshalToken. The lock row is atomically transferred to the owner name
+// of this client, as long as it was not updated since the token was created,
+// and the returned lock is heartbeated by this client from then on. A lock
+// acquired in a table selected with RouteToTable is resumed in that table;
+// otherwise it is resumed in the table of this client. The given context is
+// passed down to the underlying dynamoDB call.
+func (c *commonClient) ResumeLock(ctx context.Context, token []byte) (*Lock, error) {
+	var t lockToken
+	if err := json.Unmarshal(token, &t); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLockToken, err)
+	}
+	if _, err := time.ParseDuration(t.LeaseDuration); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLockToken, err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.isDraining() {
+		return nil, ErrClientDraining
+	}
+
+	ctx = routeToToken(ctx, t)
+	newRvn := c.generateRecordVersionNumber()
+	leaseDuration := c.currentLeaseDuration()
+	cond := OwnershipCondition(c.partitionKeyName, t.RecordVersionNumber, t.OwnerName)
+	update := expression.
+		Set(ownerNameAttr, expression.Value(c.currentOwnerName())).
+		Set(leaseDurationAttr, expression.Value(c.leaseDurationValue(leaseDuration))).
+		Set(rvnAttr, expression.Value(newRvn))
+	if !c.v2Compatibility {
+		update = update.Set(expiresAtAttr, expression.Value(c.expiresAt(leaseDuration)))
+	}
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+
+	lastUpdateOfLock := c.now()
+	res, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(t.PartitionKey, t.SortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, parseOwnershipError(err, "cannot resume lock: lock was updated since the token was created")
+	}
+
+	opt := getLockOptions{
+		tableName:           c.routedTable(ctx),
+		partitionKey:        t.PartitionKey,
+		sortKey:             t.SortKey,
+		deleteLockOnRelease: t.DeleteLockOnRelease,
+	}
+	var lockItem *Lock
+	if res != nil && len(res.Attributes) > 0 {
+		lockItem, err = c.createLockItem(opt, res.Attributes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		lockItem, _ = c.createLockItem(opt, map[string]types.AttributeValue{})
+	}
+	lockItem.ownerName = c.currentOwnerName()
+	lockItem.isReleased = false
+	lockItem.updateRVN(newRvn, lastUpdateOfLock, leaseDuration)
+	lockItem.values = c.propagatedValues(ctx)
+	c.locks.Store(lockItem.uniqueIdentifier(), lockItem)
+	return lockItem, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResumeLock(t *testing.T) {
+	svc := newMemoryDynamoDBClient("key", "sortKey")
+	first, err := NewWithSortKey(svc, "locksToken", "key", "sortKey", DisableHeartbeat(), WithOwnerName("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close(context.Background())
+	l, err := first.AcquireLock(context.Background(), "job", "step-1", WithDeleteLockOnRelease())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := l.MarshalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewWithSortKey(svc, "locksToken", "key", "sortKey", DisableHeartbeat(), WithOwnerName("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close(context.Background())
+	resumed, err := second.ResumeLock(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.PartitionKey() != "job" || resumed.SortKey() != "step-1" || resumed.OwnerName() != "second" {
+		t.Fatalf("unexpected resumed lock: %s %s %s", resumed.PartitionKey(), resumed.SortKey(), resumed.OwnerName())
+	}
+	if resumed.IsExpired() || resumed.RecordVersionNumber() == l.RecordVersionNumber() {
+		t.Fatal("resumed lock was not refreshed")
+	}
+	if got, err := second.Get(context.Background(), "job", "step-1"); err != nil || got != resumed {
+		t.Fatal("resumed lock is not tracked by the client:", err)
+	}
+	if readStringAttr(svc.updateInputs()[0].Key["sortKey"]) != "step-1" {
+		t.Fatal("resume did not target the lock row")
+	}
+
+	if _, err := second.ResumeLock(context.Background(), []byte("garbage")); !errors.Is(err, ErrInvalidLockToken) {
+		t.Fatal("expected invalid token error:", err)
+	}
+}
+
+func TestResumeRoutedLock(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	// The same key in the table of the clients is another lock.
+	svc.putRow("locksToken", heldLockRow("bystander"))
+	newClient := func(owner string) *Client {
+		c, err := New(svc, "locksToken", "key", DisableHeartbeat(), WithOwnerName(owner))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close(context.Background()) })
+		return c
+	}
+	storedOwner := func(tableName string) string {
+		return readStringAttr(svc.row(tableName, "leader")[attrOwnerName])
+	}
+
+	l, err := newClient("first").AcquireLock(RouteToTable(context.Background(), "tenantLocks"), "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := l.MarshalToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newClient("second")
+	resumed, err := second.ResumeLock(context.Background(), token)
+	if err != nil {
+		t.Fatal("lock not resumed in the table it was acquired in:", err)
+	}
+	if got := storedOwner("tenantLocks"); got != "second" {
+		t.Fatal("unexpected owner of the routed lock:", got)
+	}
+	if got := storedOwner("locksToken"); got != "bystander" {
+		t.Fatal("lock in the table of the client must not be touched:", got)
+	}
+	if got, err := second.Get(RouteToTable(context.Background(), "tenantLocks"), "leader"); err != nil || got != resumed {
+		t.Fatal("resumed lock is not tracked in the table it was acquired in:", err)
+	}
+	if err := second.SendHeartbeat(context.Background(), resumed); err != nil {
+		t.Fatal("heartbeat not routed to the table of the resumed lock:", err)
+	}
+	if got := readStringAttr(svc.row("tenantLocks", "leader")[attrRecordVersionNumber]); got != resumed.RecordVersionNumber() {
+		t.Fatal("heartbeat not routed to the table of the resumed lock:", got)
+	}
+
+	token, err = resumed.StartDelegation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newClient("delegate").DelegateHeartbeats(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SendHeartbeat(context.Background()); err != nil {
+		t.Fatal("delegated heartbeat not routed to the table of the lock:", err)
+	}
+	if got := storedOwner("locksToken"); got != "bystander" {
+		t.Fatal("lock in the table of the client must not be touched:", got)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TouchAttributes sets the given additional attributes on a lock held by this
+// client, leaving the rest of the item untouched. The write is conditioned on
+// the ownership of the lock, but it neither refreshes the lease nor changes the
+// record version number, so it is suitable to record progress markers or labels
+// between heartbeats. The attributes maintained by the client cannot be set.
+// The given context is passed down to the underlying dynamoDB call.
+func (c *commonClient) TouchAttributes(ctx context.Context, lockItem *Lock, attrs map[string]types.AttributeValue) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	if lockItem == nil {
+		return ErrCannotReleaseNullLock
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	ctx = routeToLock(ctx, lockItem)
+	for k := range attrs {
+		if c.isReservedAttribute(k) {
+			return fmt.Errorf("additional attribute cannot be one of the following types: %s",
+				strings.Join(c.reservedAttributes(), ", "))
+		}
+	}
+
+	lockItem.semaphore.Lock()
+	defer lockItem.semaphore.Unlock()
+
+	if lockItem.isExpired() || lockItem.ownerName != c.currentOwnerName() {
+		c.locks.Delete(lockItem.uniqueIdentifier())
+		return &LockNotGrantedError{msg: "cannot touch attributes because lock is not granted", cause: &ownershipLostError{}}
+	}
+
+	cond := OwnershipCondition(c.partitionKeyName, lockItem.recordVersionNumber, lockItem.ownerName)
+	var update expression.UpdateBuilder
+	for k, v := range attrs {
+		update = update.Set(expression.Name(k), expression.Value(v))
+	}
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.getItemKeys(lockItem),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	if err != nil {
+		err := parseOwnershipError(err, "lock was lost, cannot touch attributes")
+		var errNotGranted *LockNotGrantedError
+		if errors.As(err, &errNotGranted) {
+			c.locks.Delete(lockItem.uniqueIdentifier())
+		}
+		return err
+	}
+
+	additionalAttributes := make(map[string]types.AttributeValue, len(lockItem.additionalAttributes)+len(attrs))
+	for k, v := range lockItem.additionalAttributes {
+		additionalAttributes[k] = v
+	}
+	for k, v := range attrs {
+		additionalAttributes[k] = v
+	}
+	lockItem.additionalAttributes = additionalAttributes
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestTouchAttributes(t *testing.T) {
+	ctx := context.Background()
+	t.Run("set", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c, err := New(svc, "locksTouch", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(ctx, "key", WithAdditionalAttributes(map[string]types.AttributeValue{
+			"label": &types.AttributeValueMemberS{Value: "a"},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rvn := l.RecordVersionNumber()
+		err = c.TouchAttributes(ctx, l, map[string]types.AttributeValue{
+			"progress": &types.AttributeValueMemberN{Value: "42"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(svc.updateInputs()) != 1 {
+			t.Fatal("unexpected number of updates:", len(svc.updateInputs()))
+		}
+		if l.RecordVersionNumber() != rvn {
+			t.Fatal("touching attributes must not change the record version number")
+		}
+		attrs := l.AdditionalAttributes()
+		if _, ok := attrs["label"]; !ok {
+			t.Fatal("existing attributes must be kept:", attrs)
+		}
+		if v, ok := attrs["progress"].(*types.AttributeValueMemberN); !ok || v.Value != "42" {
+			t.Fatal("touched attribute missing:", attrs)
+		}
+	})
+	t.Run("reserved", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c, err := New(svc, "locksTouch", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.TouchAttributes(ctx, l, map[string]types.AttributeValue{
+			attrOwnerName: &types.AttributeValueMemberS{Value: "someone else"},
+		})
+		if err == nil {
+			t.Fatal("reserved attributes must be rejected")
+		}
+		if len(svc.updateInputs()) != 0 {
+			t.Fatal("no update expected:", len(svc.updateInputs()))
+		}
+	})
+	t.Run("lost", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		c, err := New(svc, "locksTouch", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc.deleteRow("locksTouch", "key")
+		err = c.TouchAttributes(ctx, l, map[string]types.AttributeValue{
+			"progress": &types.AttributeValueMemberN{Value: "42"},
+		})
+		var notGranted *LockNotGrantedError
+		if !errors.As(err, &notGranted) {
+			t.Fatal("expected lock not granted error:", err)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); ok {
+			t.Fatal("lost lock should be forgotten")
+		}
+	})
+}
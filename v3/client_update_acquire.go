@@ -0,0 +1,263 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithUpdateItemAcquisition acquires the lock with a single conditional
+// UpdateItem, instead of reading the lock row and rewriting it with PutItem.
+// The update succeeds if the lock row does not exist, is released, or its
+// expiresAt attribute is in the past, so expiry relies on the clocks of the
+// clients being roughly in sync: a client whose clock runs ahead of the
+// holder's takes the lock over that much earlier than the lease allows. Use
+// WithExpiryGrace or WithTakeoverOnlyIfExpiredBy to absorb the expected clock
+// skew. Additional attributes already in the lock row
+// are preserved server-side. The lock row is only read when the lock is
+// taken, to learn about the holder. It has no effect with WithV2Compatibility,
+// whose lock rows carry no expiresAt attribute; lock rows written that way are
+// acquired as without this option, once they are found held.
+func WithUpdateItemAcquisition() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.updateItemAcquisition = true
+	}
+}
+
+// keptOnUpdateAcquisition are the internal attributes left in place when the
+// lock row is acquired with UpdateItem; the others belong to the previous
+// holder and are removed.
+var keptOnUpdateAcquisition = map[string]bool{
+	attrExpiresAt:     true,
+	attrSchemaVersion: true,
+	attrWaiterCount:   true,
+	attrWaiterInbox:   true,
+	attrDataHistory:   true,
+	// Set by the acquisition itself.
+	attrAcquisitionToken: true,
+}
+
+func (c *commonClient) storeLockWithUpdate(ctx context.Context, getLockOptions *getLockOptions) (*Lock, error) {
+	now := c.now()
+	recordVersionNumber := c.generateRecordVersionNumber()
+	leaseDuration := c.currentLeaseDuration()
+	update := expression.Set(ownerNameAttr, expression.Value(c.currentOwnerName())).
+		Set(leaseDurationAttr, expression.Value(c.leaseDurationAttrValue(leaseDuration))).
+		Set(rvnAttr, expression.Value(recordVersionNumber)).
+		Set(expiresAtAttr, expression.Value(c.expiresAt(leaseDuration))).
+		Set(expression.Name(attrSchemaVersion), expression.Value(SchemaVersion)).
+		Set(expression.Name(attrAcquisitionToken), expression.Value(getLockOptions.acquisitionToken))
+	for k, v := range getLockOptions.additionalAttributes {
+		update = update.Set(expression.Name(k), expression.Value(v))
+	}
+	if getLockOptions.replaceData && getLockOptions.data == nil {
+		update = update.Remove(dataAttr)
+	} else if getLockOptions.replaceData {
+		update = update.Set(dataAttr, expression.Value(getLockOptions.data))
+	} else if getLockOptions.data != nil {
+		update = update.Set(dataAttr, dataAttr.IfNotExists(expression.Value(getLockOptions.data)))
+	}
+	if getLockOptions.priority != 0 {
+		update = update.Set(priorityAttr, expression.Value(getLockOptions.priority))
+	} else {
+		update = update.Remove(priorityAttr)
+	}
+	releasedAttribute, _ := c.releasedMarker()
+	update = update.Remove(expression.Name(releasedAttribute))
+	for _, attr := range internalAttributes {
+		if keptOnUpdateAcquisition[attr] || c.persistentStats && isAcquisitionStat(attr) {
+			continue
+		}
+		update = update.Remove(expression.Name(attr))
+	}
+	if c.persistentStats {
+		update = update.Add(expression.Name(attrTimesAcquired), expression.Value(1)).
+			Set(expression.Name(attrLastAcquiredAt), expression.Value(unixMillis(now))).
+			Set(expression.Name(attrLastOwner), expression.Value(c.currentOwnerName()))
+	}
+
+	expired := expiresAtAttr.LessThan(expression.Value(unixMillis(now.Add(-getLockOptions.expiryGrace))))
+	handedOff := expression.Equal(expression.Name(attrHandoffTo), expression.Value(c.currentOwnerName()))
+	cond := expression.Or(c.newOrReleasedLockCondition(), expired, handedOff)
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	out, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(getLockOptions.partitionKey, getLockOptions.sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+		ReturnValues:              types.ReturnValueAllOld,
+	})
+	err = parseDynamoDBError(err, "cannot store lock item: lock already acquired by other client")
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		return c.observeHolder(ctx, getLockOptions)
+	} else if err != nil {
+		return nil, err
+	}
+	return c.updatedLock(ctx, getLockOptions, out.Attributes, recordVersionNumber, now)
+}
+
+func isAcquisitionStat(attr string) bool {
+	return attr == attrTimesAcquired || attr == attrLastAcquiredAt || attr == attrLastOwner
+}
+
+// updatedLock rebuilds, from the previous lock row, the lock that was just
+// acquired with UpdateItem.
+func (c *commonClient) updatedLock(ctx context.Context, getLockOptions *getLockOptions, old map[string]types.AttributeValue, recordVersionNumber string, lastUpdatedTime time.Time) (*Lock, error) {
+	getLockOptions.acquisitionKind = AcquisitionFresh
+	previous := &Lock{}
+	if len(old) > 0 {
+		var err error
+		previous, err = c.createLockItem(*getLockOptions, copyItem(old))
+		if err != nil {
+			return nil, err
+		}
+		getLockOptions.acquisitionKind = AcquisitionExpired
+		if previous.isReleased {
+			getLockOptions.acquisitionKind = AcquisitionReleased
+		} else if c.isHandedOffTo(previous) {
+			getLockOptions.acquisitionKind = AcquisitionHandoff
+			getLockOptions.handoffFrom = previous.ownerName
+		} else if getLockOptions.preemptionRequestedFrom == previous.ownerName {
+			getLockOptions.preemptedOwner = previous.ownerName
+		}
+	}
+
+	data := previous.data
+	if getLockOptions.replaceData || data == nil {
+		data = getLockOptions.data
+	}
+	additionalAttributes := make(map[string]types.AttributeValue)
+	for k, v := range previous.additionalAttributes {
+		additionalAttributes[k] = v
+	}
+	for k, v := range getLockOptions.additionalAttributes {
+		additionalAttributes[k] = v
+	}
+	var persistentStats PersistentStats
+	if c.persistentStats {
+		persistentStats = PersistentStats{
+			TimesAcquired:  previous.persistentStats.TimesAcquired + 1,
+			LastAcquiredAt: time.Unix(0, unixMillis(lastUpdatedTime)*int64(time.Millisecond)),
+			LastOwner:      c.currentOwnerName(),
+		}
+	}
+
+	lockItem := &Lock{
+		tableName:            getLockOptions.tableName,
+		partitionKey:         getLockOptions.partitionKey,
+		sortKey:              getLockOptions.sortKey,
+		data:                 data,
+		deleteLockOnRelease:  getLockOptions.deleteLockOnRelease,
+		lookupTime:           lastUpdatedTime,
+		recordVersionNumber:  recordVersionNumber,
+		additionalAttributes: additionalAttributes,
+		sessionMonitor:       getLockOptions.sessionMonitor,
+		waiters:              previous.waiters,
+		inbox:                previous.inbox,
+		persistentStats:      persistentStats,
+		dataHistory:          previous.dataHistory,
+		acquisitionToken:     getLockOptions.acquisitionToken,
+	}
+	if previous.data != nil && !bytes.Equal(previous.data, data) {
+		c.appendDataHistory(ctx, lockItem, previous)
+	}
+	c.startOwnedLock(lockItem)
+	return lockItem, nil
+}
+
+// appendDataHistory adds the data replaced by an UpdateItem acquisition to the
+// data history of the lock row. The replaced data is only known once the row
+// was updated, so it takes a second call. A failure is only logged: the lock
+// is held regardless.
+func (c *commonClient) appendDataHistory(ctx context.Context, lockItem, previous *Lock) {
+	history := c.nextDataHistory(previous, lockItem.data)
+	if len(history) == 0 {
+		return
+	}
+	cond := OwnershipCondition(c.partitionKeyName, lockItem.recordVersionNumber, c.currentOwnerName())
+	update := expression.Set(expression.Name(attrDataHistory), expression.Value(dataHistoryAttrValue(history)))
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(lockItem.partitionKey, lockItem.sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	if err != nil {
+		c.logger.Error(ctx, "cannot append to the data history of ", lockItem.partitionKey, ": ", err)
+		return
+	}
+	lockItem.dataHistory = history
+}
+
+// observeHolder reads the lock row after a failed UpdateItem acquisition, to
+// learn about the current holder as storeLock would. If the row turns out to
+// be the acquisition's own write, the lock is returned.
+func (c *commonClient) observeHolder(ctx context.Context, getLockOptions *getLockOptions) (*Lock, error) {
+	existingLock, err := c.getWaitedLock(ctx, *getLockOptions)
+	if err != nil {
+		return nil, err
+	}
+	if l := c.adoptOwnAcquisition(ctx, getLockOptions, existingLock); l != nil {
+		return l, nil
+	}
+	if existingLock == nil || existingLock.isReleased {
+		// The lock was released in the meantime.
+		return nil, nil
+	}
+	if existingLock.expiresAt.IsZero() {
+		// The row carries no expiration, as it was written with
+		// WithV2Compatibility: the condition of the update cannot tell
+		// it expired, so the next attempts watch its record version
+		// number and write with PutItem instead.
+		getLockOptions.updateItem = false
+	}
+	if getLockOptions.lockTryingToBeAcquired == nil {
+		c.reportAcquisitionState(getLockOptions, AcquisitionStateFoundHolder, existingLock)
+		c.tryRequestPreemption(ctx, getLockOptions, existingLock)
+		c.tryRecordWaitsFor(ctx, getLockOptions)
+		c.tryCountWaiter(ctx, getLockOptions)
+		c.tryDeclareIntent(ctx, getLockOptions, existingLock)
+		c.tryJoinInbox(ctx, getLockOptions)
+		if getLockOptions.failIfLocked {
+			return nil, &LockNotGrantedError{
+				msg:            "Didn't acquire lock because it is locked and request is configured not to retry.",
+				holder:         holderInfo(existingLock),
+				remainingLease: c.remainingLease(existingLock, getLockOptions.expiryGrace),
+			}
+		}
+		getLockOptions.lockTryingToBeAcquired = existingLock
+	} else if getLockOptions.lockTryingToBeAcquired.recordVersionNumber != existingLock.recordVersionNumber {
+		getLockOptions.lockTryingToBeAcquired = existingLock
+		c.reportAcquisitionState(getLockOptions, AcquisitionStateHolderRefreshed, existingLock)
+		c.tryRequestPreemption(ctx, getLockOptions, existingLock)
+	}
+	return nil, c.checkGiveUp(getLockOptions)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestUpdateItemAcquisition(t *testing.T) {
+	t.Run("acquired", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		svc.putRow("locksUpdateAcquisition", map[string]types.AttributeValue{
+			"key":                   stringAttrValue("update"),
+			attrOwnerName:           stringAttrValue("previous"),
+			attrLeaseDuration:       stringAttrValue("20s"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+			attrIsReleased:          stringAttrValue("1"),
+			attrData:                bytesAttrValue([]byte("kept")),
+			attrTimesAcquired:       int64AttrValue(2),
+			"extra":                 stringAttrValue("kept"),
+		})
+		c, err := New(svc, "locksUpdateAcquisition", "key", DisableHeartbeat(), WithOwnerName("me"), WithPersistentStats())
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := c.AcquireLock(context.Background(), "update",
+			WithUpdateItemAcquisition(),
+			WithData([]byte("ignored")),
+			WithAdditionalAttributes(map[string]types.AttributeValue{"mine": stringAttrValue("set")}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updates := svc.updateInputs()
+		if reads := svc.callCount("GetItem"); reads != 0 || len(updates) != 1 {
+			t.Fatal("expected a single UpdateItem call:", reads, len(updates))
+		}
+		if updates[0].ReturnValues != types.ReturnValueAllOld {
+			t.Fatal("previous row should be returned:", updates[0].ReturnValues)
+		}
+		row := svc.row("locksUpdateAcquisition", "update")
+		if readStringAttr(row[attrOwnerName]) != "me" || readStringAttr(row["extra"]) != "kept" || readStringAttr(row["mine"]) != "set" {
+			t.Fatalf("unexpected stored row: %#v", row)
+		}
+		if l.OwnerName() != "me" || l.Acquisition().Kind != AcquisitionReleased {
+			t.Fatalf("unexpected lock: %v %#v", l.OwnerName(), l.Acquisition())
+		}
+		if !bytes.Equal(l.Data(), []byte("kept")) {
+			t.Fatal("existing data should be kept:", string(l.Data()))
+		}
+		attrs := l.AdditionalAttributes()
+		if readStringAttr(attrs["extra"]) != "kept" || readStringAttr(attrs["mine"]) != "set" || len(attrs) != 2 {
+			t.Fatalf("unexpected additional attributes: %#v", attrs)
+		}
+		if stats := l.PersistentStats(); stats.TimesAcquired != 3 || stats.LastOwner != "me" {
+			t.Fatalf("unexpected persistent stats: %#v", stats)
+		}
+		if _, ok := c.locks.Load(l.uniqueIdentifier()); !ok {
+			t.Fatal("acquired lock should be tracked")
+		}
+	})
+	t.Run("held", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		svc.putRow("locksUpdateAcquisition", map[string]types.AttributeValue{
+			"key":                   stringAttrValue("update"),
+			attrOwnerName:           stringAttrValue("holder"),
+			attrLeaseDuration:       stringAttrValue("20s"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+		})
+		c, err := New(svc, "locksUpdateAcquisition", "key", DisableHeartbeat())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.AcquireLock(context.Background(), "update", WithUpdateItemAcquisition(), FailIfLocked())
+		var errNotGranted *LockNotGrantedError
+		if !errors.As(err, &errNotGranted) {
+			t.Fatal("expected lock not granted error:", err)
+		}
+		if holder, ok := errNotGranted.Holder(); !ok || holder.OwnerName != "holder" {
+			t.Fatalf("unexpected holder: %#v", holder)
+		}
+		if reads := svc.callCount("GetItem"); reads != 1 {
+			t.Fatal("lock row should be read once the update fails:", reads)
+		}
+	})
+	t.Run("expired without expiresAt", func(t *testing.T) {
+		svc := newMemoryDynamoDBClient()
+		svc.putRow("locksUpdateAcquisition", map[string]types.AttributeValue{
+			"key":                   stringAttrValue("update"),
+			attrOwnerName:           stringAttrValue("v2"),
+			attrLeaseDuration:       stringAttrValue("1s"),
+			attrRecordVersionNumber: stringAttrValue("rvn"),
+		})
+		clock := &fakeClock{now: time.Unix(1000, 0)}
+		c, err := New(svc, "locksUpdateAcquisition", "key", DisableHeartbeat(), WithClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		observed := make(chan struct{}, 1)
+		acquired := make(chan error, 1)
+		go func() {
+			_, err := c.AcquireLock(context.Background(), "update",
+				WithUpdateItemAcquisition(),
+				WithRefreshPeriod(time.Millisecond),
+				WithAdditionalTimeToWaitForLock(time.Hour),
+				WithOnWait(func(string, LockInfo, time.Duration) {
+					select {
+					case observed <- struct{}{}:
+					default:
+					}
+				}),
+			)
+			acquired <- err
+		}()
+		<-observed
+		clock.Advance(2 * time.Second)
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the row should be taken over once its record version number is seen unchanged for its lease")
+		}
+		if owner := readStringAttr(svc.row("locksUpdateAcquisition", "update")[attrOwnerName]); owner != c.currentOwnerName() {
+			t.Fatal("unexpected owner:", owner)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "time"
+
+// WaitStrategy decides how AcquireLock waits for a lock that is held by
+// someone else. Implementations passed to WithWaitStrategy are shared by all
+// acquisitions that use them, so they must be safe for concurrent use.
+type WaitStrategy interface {
+	// NextDelay returns how long to sleep before the next attempt to
+	// acquire the lock. attempt counts the attempts made so far, and
+	// observed is the lock currently stored in the table, which is nil if
+	// the previous attempt lost a race for a free lock.
+	NextDelay(attempt int, observed *Lock) time.Duration
+	// ShouldGiveUp reports whether the acquisition must stop, failing with a
+	// TimeoutError, after having waited for the given duration.
+	ShouldGiveUp(attempt int, waited time.Duration, observed *Lock) bool
+}
+
+// WithWaitStrategy replaces the default wait strategy, which sleeps for the
+// refresh period between attempts and gives up after the lease duration of
+// the current owner plus the additional time to wait. When set,
+// WithRefreshPeriod and WithAdditionalTimeToWaitForLock are ignored.
+func WithWaitStrategy(s WaitStrategy) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.waitStrategy = s
+	}
+}
+
+// defaultWaitStrategy keeps the state of a single acquisition: the lease
+// duration of the first observed owner is added once to the time to wait.
+type defaultWaitStrategy struct {
+	refreshPeriod time.Duration
+	timeToWait    time.Duration
+	expiryGrace   time.Duration
+
+	addedLeaseDuration bool
+}
+
+func (s *defaultWaitStrategy) NextDelay(int, *Lock) time.Duration {
+	return s.refreshPeriod
+}
+
+func (s *defaultWaitStrategy) ShouldGiveUp(_ int, waited time.Duration, observed *Lock) bool {
+	if observed != nil && !s.addedLeaseDuration {
+		s.addedLeaseDuration = true
+		s.timeToWait += observed.leaseDuration + s.expiryGrace
+	}
+	return waited > s.timeToWait
+}
+
+// LockInfo describes the owner of a lock observed while waiting for it.
+type LockInfo struct {
+	PartitionKey        string
+	SortKey             string
+	OwnerName           string
+	RecordVersionNumber string
+	LeaseDuration       time.Duration
+}
+
+// WithOnWait registers a callback invoked before every sleep while waiting for
+// a lock held by someone else, with the lock key, its current holder and how
+// long the acquisition has been waiting so far. It provides visibility into
+// long acquisitions. The callback runs synchronously in the acquisition
+// goroutine and must not close the client.
+func WithOnWait(fn func(key string, holder LockInfo, waited time.Duration)) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.onWait = fn
+	}
+}
+
+func lockInfo(l *Lock) LockInfo {
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return LockInfo{
+		PartitionKey:        l.partitionKey,
+		SortKey:             l.sortKey,
+		OwnerName:           l.ownerName,
+		RecordVersionNumber: l.recordVersionNumber,
+		LeaseDuration:       l.leaseDuration,
+	}
+}
+
+// remainingLease estimates how long the observed holder has until its lease,
+// plus the takeover grace, runs out.
+func (c *commonClient) remainingLease(l *Lock, grace time.Duration) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	remaining := l.leaseDuration + grace - c.now().Sub(l.lookupTime)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func holderInfo(l *Lock) *LockInfo {
+	if l == nil {
+		return nil
+	}
+	info := lockInfo(l)
+	return &info
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type countingWaitStrategy struct {
+	mu       sync.Mutex
+	delays   int
+	observed []string
+}
+
+func (s *countingWaitStrategy) NextDelay(attempt int, observed *Lock) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays++
+	return time.Millisecond
+}
+
+func (s *countingWaitStrategy) ShouldGiveUp(attempt int, waited time.Duration, observed *Lock) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observed = append(s.observed, observed.OwnerName())
+	return attempt >= 3
+}
+
+func TestWaitStrategy(t *testing.T) {
+	c, err := New(newHeldLockDynamoDBClient("locksWaitStrategy", "someone-else"), "locksWaitStrategy", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	s := &countingWaitStrategy{}
+	start := time.Now()
+	_, err = c.AcquireLock(context.Background(), "leader", WithWaitStrategy(s))
+	var errTimeout *TimeoutError
+	if !errors.As(err, &errTimeout) {
+		t.Fatal("expected timeout error:", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("custom wait strategy was not used")
+	}
+	if s.delays != 2 || len(s.observed) != 3 {
+		t.Fatal("unexpected number of strategy calls:", s.delays, len(s.observed))
+	}
+	for _, owner := range s.observed {
+		if owner != "someone-else" {
+			t.Fatal("strategy did not receive the observed lock:", owner)
+		}
+	}
+}
+
+func TestOnWait(t *testing.T) {
+	c, err := New(newHeldLockDynamoDBClient("locksOnWait", "someone-else"), "locksOnWait", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	var (
+		calls   int
+		holders []LockInfo
+	)
+	_, err = c.AcquireLock(context.Background(), "leader",
+		WithWaitStrategy(&countingWaitStrategy{}),
+		WithOnWait(func(key string, holder LockInfo, waited time.Duration) {
+			calls++
+			if key != "leader" || waited <= 0 {
+				t.Errorf("unexpected wait report: %q %v", key, waited)
+			}
+			holders = append(holders, holder)
+		}),
+	)
+	if err == nil {
+		t.Fatal("expected error missing")
+	}
+	if calls != 2 {
+		t.Fatal("unexpected number of wait reports:", calls)
+	}
+	if holders[0].OwnerName != "someone-else" || holders[0].LeaseDuration != 20*time.Second {
+		t.Fatalf("unexpected holder: %#v", holders[0])
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	c, err := New(newHeldLockDynamoDBClient("locksMaxAttempts", "someone-else"), "locksMaxAttempts", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	_, err = c.AcquireLock(context.Background(), "leader",
+		WithMaxAttempts(3),
+		WithRefreshPeriod(time.Millisecond),
+	)
+	var errNotGranted *LockNotGrantedError
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not granted error:", err)
+	}
+	var errMaxAttempts *MaxAttemptsError
+	if !errors.As(err, &errMaxAttempts) || errMaxAttempts.Attempts != 3 {
+		t.Fatal("expected max attempts error:", err)
+	}
+}
+
+func TestDefaultRefreshPeriod(t *testing.T) {
+	if _, err := New(&mockDynamoDBClient{}, "locksDefaultRefresh", "key", DisableHeartbeat(), WithDefaultWaitBuffer(0)); err == nil {
+		t.Fatal("non-positive wait buffers should be rejected")
+	}
+	svc := newMemoryDynamoDBClient()
+	svc.putRow("locksDefaultRefresh", map[string]types.AttributeValue{
+		"key":                   stringAttrValue("leader"),
+		attrOwnerName:           stringAttrValue("someone-else"),
+		attrLeaseDuration:       stringAttrValue("10ms"),
+		attrRecordVersionNumber: stringAttrValue("rvn"),
+	})
+	c, err := New(svc, "locksDefaultRefresh", "key",
+		DisableHeartbeat(),
+		WithDefaultRefreshPeriod(time.Millisecond),
+		WithDefaultWaitBuffer(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	start := time.Now()
+	l, err := c.AcquireLock(context.Background(), "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("default refresh period was not used:", time.Since(start))
+	}
+	if got := l.Acquisition(); got.Kind != AcquisitionExpired || got.Attempts < 2 {
+		t.Fatalf("unexpected acquisition: %#v", got)
+	}
+}
+
+// giveUpWaitStrategy gives up right after the first attempt.
+type giveUpWaitStrategy struct{}
+
+func (giveUpWaitStrategy) NextDelay(int, *Lock) time.Duration          { return 0 }
+func (giveUpWaitStrategy) ShouldGiveUp(int, time.Duration, *Lock) bool { return true }
+
+func TestLockNotGrantedHolder(t *testing.T) {
+	c, err := New(newHeldLockDynamoDBClient("locksNotGrantedHolder", "winner"), "locksNotGrantedHolder", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opt := range map[string]AcquireLockOption{
+		"fail if locked": FailIfLocked(),
+		"max attempts":   WithMaxAttempts(2),
+		"timeout":        WithWaitStrategy(giveUpWaitStrategy{}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.AcquireLock(context.Background(), "leader", opt, WithRefreshPeriod(time.Millisecond))
+			var errNotGranted *LockNotGrantedError
+			if !errors.As(err, &errNotGranted) {
+				t.Fatal("expected lock not granted error:", err)
+			}
+			holder, ok := errNotGranted.Holder()
+			if !ok || holder.OwnerName != "winner" || holder.RecordVersionNumber != "rvn" || holder.LeaseDuration != 20*time.Second {
+				t.Fatalf("unexpected holder: %#v %v", holder, ok)
+			}
+		})
+	}
+
+	var unknown *LockNotGrantedError
+	if _, ok := (&LockNotGrantedError{msg: "unknown"}).Holder(); ok {
+		t.Fatal("holder should be unknown")
+	}
+	if !errors.As(&LockNotGrantedError{msg: "wrapped", cause: &LockNotGrantedError{holder: &LockInfo{OwnerName: "winner"}}}, &unknown) {
+		t.Fatal("expected lock not granted error")
+	}
+	if holder, ok := unknown.Holder(); !ok || holder.OwnerName != "winner" {
+		t.Fatal("holder of the cause should be reported:", holder, ok)
+	}
+}
+
+func TestLockNotGrantedRemainingLease(t *testing.T) {
+	c, err := New(newHeldLockDynamoDBClient("locksNotGrantedRemainingLease", "winner"), "locksNotGrantedRemainingLease", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		opt  AcquireLockOption
+		want time.Duration
+	}{
+		"lease":          {opt: FailIfLocked(), want: 20 * time.Second},
+		"takeover grace": {opt: WithTakeoverOnlyIfExpiredBy(5 * time.Second), want: 25 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.AcquireLock(context.Background(), "leader", FailIfLocked(), tc.opt)
+			var errNotGranted *LockNotGrantedError
+			if !errors.As(err, &errNotGranted) {
+				t.Fatal("expected lock not granted error:", err)
+			}
+			remaining, ok := errNotGranted.RemainingLease()
+			if !ok || remaining > tc.want || remaining < tc.want-time.Second {
+				t.Fatal("unexpected remaining lease:", remaining, ok)
+			}
+		})
+	}
+	if _, ok := (&LockNotGrantedError{msg: "unknown"}).RemainingLease(); ok {
+		t.Fatal("remaining lease should be unknown")
+	}
+}
+
+func TestCallBudget(t *testing.T) {
+	c, err := New(newHeldLockDynamoDBClient("locksCallBudget", "winner"), "locksCallBudget", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	_, err = c.AcquireLock(context.Background(), "leader",
+		WithCallBudget(3),
+		WithRefreshPeriod(time.Millisecond),
+	)
+	var errNotGranted *LockNotGrantedError
+	if !errors.As(err, &errNotGranted) {
+		t.Fatal("expected lock not granted error:", err)
+	}
+	var errBudget *BudgetExceededError
+	if !errors.As(err, &errBudget) || errBudget.Budget != 3 {
+		t.Fatal("expected budget exceeded error:", err)
+	}
+	if holder, ok := errNotGranted.Holder(); !ok || holder.OwnerName != "winner" {
+		t.Fatalf("unexpected holder: %#v", holder)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// CountAsWaiter makes the client, while waiting for the lock, increment the
+// waiterCount attribute of the lock row, and decrement it once the
+// acquisition finishes, successfully or not. Holders see how contended their
+// locks are with Lock.Waiters, and dashboards can read the attribute directly.
+// The count is carried over when the lock changes hands, but it is an
+// approximation: increments racing with a change of hands may be lost.
+func CountAsWaiter() AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.countAsWaiter = true
+	}
+}
+
+// Waiters returns how many owners were waiting for the lock, as seen in the
+// last heartbeat or read. Only the waiters using CountAsWaiter are counted.
+func (l *Lock) Waiters() int64 {
+	if l == nil {
+		return 0
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.waiters
+}
+
+func (c *commonClient) tryCountWaiter(ctx context.Context, getLockOptions *getLockOptions) {
+	if c.v2Compatibility || !getLockOptions.countAsWaiter || getLockOptions.waiterCounted {
+		return
+	}
+	if err := c.addWaiters(ctx, getLockOptions.partitionKey, getLockOptions.sortKey, 1); err != nil {
+		c.logger.Error(ctx, "cannot count waiter of ", getLockOptions.partitionKey, ":", err)
+		return
+	}
+	getLockOptions.waiterCounted = true
+}
+
+func (c *commonClient) uncountWaiter(ctx context.Context, getLockOptions *getLockOptions) {
+	if !getLockOptions.waiterCounted {
+		return
+	}
+	// Stale counts overstate the contention, so they are cleared even if
+	// the acquisition was canceled.
+	uncountCtx := ctx
+	if ctx.Err() != nil {
+		uncountCtx = context.Background()
+	}
+	if err := c.addWaiters(uncountCtx, getLockOptions.partitionKey, getLockOptions.sortKey, -1); err != nil {
+		c.logger.Error(ctx, "cannot uncount waiter of ", getLockOptions.partitionKey, ":", err)
+	}
+}
+
+// addWaiters atomically changes the waiter count of an existing lock row,
+// without changing its record version number.
+func (c *commonClient) addWaiters(ctx context.Context, partitionKey, sortKey string, delta int64) error {
+	cond := expression.AttributeExists(expression.Name(c.partitionKeyName))
+	update := expression.Add(expression.Name(attrWaiterCount), expression.Value(delta))
+	updateExpr, _ := expression.NewBuilder().WithCondition(cond).WithUpdate(update).Build()
+	_, err := c.dynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(c.tableName),
+		Key:                       c.itemKey(partitionKey, sortKey),
+		ConditionExpression:       updateExpr.Condition(),
+		UpdateExpression:          updateExpr.Update(),
+		ExpressionAttributeNames:  updateExpr.Names(),
+		ExpressionAttributeValues: updateExpr.Values(),
+	})
+	err = parseDynamoDBError(err, "lock row does not exist")
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		// The lock row was deleted, and the count with it.
+		return nil
+	}
+	return err
+}
+
+// carriedWaiters is the waiter count to store in the lock row when it changes
+// hands, discounting the acquiring waiter itself.
+func carriedWaiters(existingLock *Lock, getLockOptions *getLockOptions) int64 {
+	n := existingLock.waiters
+	if getLockOptions.waiterCounted {
+		n--
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountAsWaiter(t *testing.T) {
+	svc := newMemoryDynamoDBClient()
+	waiterCount := func() int64 {
+		return readInt64Attr(svc.row("locksCountAsWaiter", "contended")[attrWaiterCount])
+	}
+	c, err := New(svc, "locksCountAsWaiter", "key",
+		WithLeaseDuration(time.Minute),
+		DisableHeartbeat(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, err := c.AcquireLock(context.Background(), "contended")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("canceled waiter", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := c.AcquireLock(ctx, "contended", CountAsWaiter(), WithRefreshPeriod(10*time.Millisecond))
+		if err == nil {
+			t.Fatal("lock should not have been acquired")
+		}
+		if n := waiterCount(); n != 0 {
+			t.Fatal("waiter count was not decremented:", n)
+		}
+	})
+
+	var (
+		wg     sync.WaitGroup
+		waiter *Lock
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		l, err := c.AcquireLock(context.Background(), "contended", CountAsWaiter(), WithRefreshPeriod(10*time.Millisecond))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		waiter = l
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for waiterCount() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("waiter was not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.SendHeartbeat(context.Background(), holder); err != nil {
+		t.Fatal(err)
+	}
+	if n := holder.Waiters(); n != 1 {
+		t.Fatal("holder should see one waiter:", n)
+	}
+
+	if _, err := c.ReleaseLock(context.Background(), holder); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if waiter == nil {
+		t.FailNow()
+	}
+	if n := waiterCount(); n != 0 {
+		t.Fatal("acquiring waiter should not be counted anymore:", n)
+	}
+	if n := waiter.Waiters(); n != 0 {
+		t.Fatal("new holder should see no waiters:", n)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "time"
+
+// Clock is the source of time used by the client to compute lease expiries and
+// to time its operations, as reported by the audit log.
+// Lease math is always done by subtracting instants read from the same Clock,
+// so a Clock whose times carry a monotonic reading, like the ones returned by
+// time.Now, is immune to wall-clock adjustments such as NTP steps.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithClock replaces the source of time used for the lease math. The default
+// clock uses time.Now, whose monotonic reading makes the lease math immune to
+// wall-clock adjustments. It is meant for tests that need to control the
+// passage of time.
+func WithClock(clock Clock) ClientOption {
+	return func(c *commonClient) {
+		c.clock = clock
+	}
+}
+
+// now reads the current time from the client's clock.
+func (c *commonClient) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// now reads the current time from the lock's clock.
+func (l *Lock) now() time.Time {
+	if l.clock == nil {
+		return time.Now()
+	}
+	return l.clock.Now()
+}
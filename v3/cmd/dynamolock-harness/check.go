@@ -0,0 +1,132 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+type eventKind string
+
+const (
+	// eventAcquired is reported by a worker once it acquires the lock.
+	eventAcquired eventKind = "acquired"
+	// eventReleasing is reported by a worker right before it releases the
+	// lock.
+	eventReleasing eventKind = "releasing"
+	// eventLost is reported by a worker that finds out the lock expired
+	// while it was held.
+	eventLost eventKind = "lost"
+	// eventKilled is recorded by the supervisor when it crashes a worker.
+	eventKilled eventKind = "killed"
+)
+
+type event struct {
+	Worker int       `json:"worker"`
+	Kind   eventKind `json:"kind"`
+	// At is the Unix time of the event, in nanoseconds. All processes run
+	// in the same host, so their clocks agree.
+	At int64 `json:"at"`
+}
+
+func (e event) time() time.Time {
+	return time.Unix(0, e.At)
+}
+
+type report struct {
+	acquisitions int
+	kills        int
+	lost         int
+	takeovers    []time.Duration
+	maxTakeover  time.Duration
+	violations   []string
+}
+
+func (r *report) ok() bool {
+	return len(r.violations) == 0
+}
+
+func (r *report) print(w io.Writer) {
+	fmt.Fprintln(w, "acquisitions:", r.acquisitions)
+	fmt.Fprintln(w, "killed holders:", r.kills)
+	fmt.Fprintln(w, "locks lost while held:", r.lost)
+	if len(r.takeovers) > 0 {
+		var sum, max time.Duration
+		for _, d := range r.takeovers {
+			sum += d
+			if d > max {
+				max = d
+			}
+		}
+		fmt.Fprintln(w, "takeovers:", len(r.takeovers), "mean:", sum/time.Duration(len(r.takeovers)), "max:", max)
+	}
+	for _, v := range r.violations {
+		fmt.Fprintln(w, "VIOLATION:", v)
+	}
+	if r.ok() {
+		fmt.Fprintln(w, "OK")
+	}
+}
+
+// check replays the events of a run in chronological order, and verifies
+// that the lock was never held by two workers at the same time and that
+// killed holders were taken over within maxTakeover.
+func check(events []event, maxTakeover time.Duration) *report {
+	events = append([]event(nil), events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At < events[j].At })
+
+	r := &report{maxTakeover: maxTakeover}
+	holder := -1
+	var heldSince, killedAt time.Time
+	for _, e := range events {
+		switch e.Kind {
+		case eventAcquired:
+			r.acquisitions++
+			if holder >= 0 {
+				r.violations = append(r.violations, fmt.Sprintf(
+					"worker %d acquired the lock at %s while worker %d held it since %s",
+					e.Worker, e.time().Format(time.RFC3339Nano), holder, heldSince.Format(time.RFC3339Nano)))
+			}
+			if !killedAt.IsZero() {
+				r.takeovers = append(r.takeovers, e.time().Sub(killedAt))
+				killedAt = time.Time{}
+			}
+			holder, heldSince = e.Worker, e.time()
+		case eventReleasing, eventLost:
+			if e.Kind == eventLost {
+				r.lost++
+			}
+			if holder == e.Worker {
+				holder = -1
+			}
+		case eventKilled:
+			if holder == e.Worker {
+				r.kills++
+				holder, killedAt = -1, e.time()
+			}
+		}
+	}
+	for _, d := range r.takeovers {
+		if d > maxTakeover {
+			r.violations = append(r.violations, fmt.Sprintf("takeover of a killed holder took %s, more than %s", d, maxTakeover))
+		}
+	}
+	return r
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	at := func(d time.Duration) int64 { return time.Unix(0, 0).Add(d).UnixNano() }
+	tests := []struct {
+		name       string
+		events     []event
+		violations int
+		takeovers  []time.Duration
+	}{
+		{
+			name: "clean handoffs",
+			events: []event{
+				{Worker: 0, Kind: eventAcquired, At: at(0)},
+				{Worker: 0, Kind: eventReleasing, At: at(time.Second)},
+				{Worker: 1, Kind: eventAcquired, At: at(2 * time.Second)},
+				{Worker: 1, Kind: eventReleasing, At: at(3 * time.Second)},
+			},
+		},
+		{
+			name: "overlap",
+			events: []event{
+				{Worker: 0, Kind: eventAcquired, At: at(0)},
+				{Worker: 1, Kind: eventAcquired, At: at(time.Second)},
+				{Worker: 0, Kind: eventLost, At: at(2 * time.Second)},
+			},
+			violations: 1,
+		},
+		{
+			name: "timely takeover",
+			events: []event{
+				{Worker: 0, Kind: eventAcquired, At: at(0)},
+				{Worker: 0, Kind: eventKilled, At: at(time.Second)},
+				{Worker: 1, Kind: eventAcquired, At: at(6 * time.Second)},
+			},
+			takeovers: []time.Duration{5 * time.Second},
+		},
+		{
+			name: "slow takeover",
+			events: []event{
+				{Worker: 0, Kind: eventAcquired, At: at(0)},
+				{Worker: 0, Kind: eventKilled, At: at(time.Second)},
+				{Worker: 1, Kind: eventAcquired, At: at(time.Minute)},
+			},
+			violations: 1,
+			takeovers:  []time.Duration{59 * time.Second},
+		},
+		{
+			name: "unordered events",
+			events: []event{
+				{Worker: 1, Kind: eventAcquired, At: at(2 * time.Second)},
+				{Worker: 0, Kind: eventReleasing, At: at(time.Second)},
+				{Worker: 0, Kind: eventAcquired, At: at(0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := check(tt.events, 10*time.Second)
+			if len(r.violations) != tt.violations {
+				t.Fatalf("unexpected violations: %q", r.violations)
+			}
+			if len(r.takeovers) != len(tt.takeovers) {
+				t.Fatalf("unexpected takeovers: %v", r.takeovers)
+			}
+			for i, d := range tt.takeovers {
+				if r.takeovers[i] != d {
+					t.Fatalf("unexpected takeovers: %v", r.takeovers)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	cfg, err := parseFlags([]string{"-lease", "3s", "-heartbeat", "1s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.maxTakeover != 6*time.Second || cfg.workerID != -1 {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+	if _, err := parseFlags([]string{"-lease", "1s", "-heartbeat", "1s"}, nil); err == nil {
+		t.Fatal("heartbeat period as long as the lease should be rejected")
+	}
+}
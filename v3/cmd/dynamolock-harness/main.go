@@ -0,0 +1,185 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command dynamolock-harness validates lease settings by running several
+// client processes that contend for the same lock, killing some of them along
+// the way, and verifying that no two processes ever held the lock at the same
+// time and that killed holders were taken over in time.
+//
+// Usage:
+//
+//	dynamolock-harness -table locks -workers 5 -duration 2m \
+//		-lease 5s -heartbeat 1s -kill-every 20s
+//
+// Each worker process is a copy of the harness itself. Workers report to the
+// supervisor, through their standard output, when they acquire the lock and
+// when they are about to release it. The supervisor records when it kills a
+// worker, and once the run is over it checks the following invariants:
+//
+//   - an acquisition never happens while another worker still holds the lock;
+//   - a killed holder is taken over within -max-takeover.
+//
+// The exit code is 1 if any invariant is violated. The AWS credentials are
+// read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables; -endpoint points the workers to another DynamoDB
+// endpoint, like DynamoDB Local.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"cirello.io/dynamolock/v3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type config struct {
+	table       string
+	key         string
+	region      string
+	endpoint    string
+	createTable bool
+
+	workers      int
+	duration     time.Duration
+	hold         time.Duration
+	lease        time.Duration
+	heartbeat    time.Duration
+	refresh      time.Duration
+	killEvery    time.Duration
+	killSignal   string
+	killTarget   string
+	restartDelay time.Duration
+	maxTakeover  time.Duration
+
+	workerID int
+}
+
+func main() {
+	log.SetPrefix("dynamolock-harness: ")
+	log.SetFlags(0)
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		return err
+	}
+	if cfg.workerID >= 0 {
+		return runWorker(cfg, stdout)
+	}
+	if cfg.createTable {
+		if err := createTable(cfg); err != nil {
+			return err
+		}
+	}
+	rep, err := supervise(cfg, args, stderr)
+	if err != nil {
+		return err
+	}
+	rep.print(stdout)
+	if !rep.ok() {
+		return errors.New("invariants violated")
+	}
+	return nil
+}
+
+func parseFlags(args []string, output io.Writer) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("dynamolock-harness", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&cfg.table, "table", "locks", "name of the lock table")
+	fs.StringVar(&cfg.key, "key", "dynamolock-harness", "partition key of the contended lock")
+	fs.StringVar(&cfg.region, "region", os.Getenv("AWS_REGION"), "AWS region of the table")
+	fs.StringVar(&cfg.endpoint, "endpoint", "", "DynamoDB endpoint URL, for example http://localhost:8000/")
+	fs.BoolVar(&cfg.createTable, "create-table", false, "create the lock table if it does not exist")
+	fs.IntVar(&cfg.workers, "workers", 3, "number of worker processes")
+	fs.DurationVar(&cfg.duration, "duration", time.Minute, "duration of the run")
+	fs.DurationVar(&cfg.hold, "hold", 500*time.Millisecond, "how long each worker holds the lock")
+	fs.DurationVar(&cfg.lease, "lease", 5*time.Second, "lease duration of the clients")
+	fs.DurationVar(&cfg.heartbeat, "heartbeat", time.Second, "heartbeat period of the clients")
+	fs.DurationVar(&cfg.refresh, "refresh", 500*time.Millisecond, "how often waiting workers check the lock")
+	fs.DurationVar(&cfg.killEvery, "kill-every", 0, "kill a worker at this interval; 0 disables kills")
+	fs.StringVar(&cfg.killSignal, "kill-signal", "KILL", "signal sent to the killed workers: KILL (crash) or TERM (graceful)")
+	fs.StringVar(&cfg.killTarget, "kill-target", "holder", "which worker is killed: holder or random")
+	fs.DurationVar(&cfg.restartDelay, "restart-delay", time.Second, "how long killed workers stay down")
+	fs.DurationVar(&cfg.maxTakeover, "max-takeover", 0, "longest acceptable takeover of a killed holder; defaults to twice the lease")
+	fs.IntVar(&cfg.workerID, "worker-id", -1, "internal: run as the worker with this ID")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.workers < 1:
+		return nil, errors.New("-workers must be positive")
+	case cfg.lease <= 0 || cfg.heartbeat <= 0 || cfg.heartbeat >= cfg.lease:
+		return nil, errors.New("-heartbeat must be positive and shorter than -lease")
+	case cfg.killSignal != "KILL" && cfg.killSignal != "TERM":
+		return nil, fmt.Errorf("unknown -kill-signal %q", cfg.killSignal)
+	case cfg.killTarget != "holder" && cfg.killTarget != "random":
+		return nil, fmt.Errorf("unknown -kill-target %q", cfg.killTarget)
+	}
+	if cfg.maxTakeover <= 0 {
+		cfg.maxTakeover = 2 * cfg.lease
+	}
+	return cfg, nil
+}
+
+func newDynamoDBClient(cfg *config) *dynamodb.Client {
+	awsCfg := aws.Config{Region: cfg.region}
+	if cfg.endpoint != "" {
+		awsCfg.EndpointResolver = aws.EndpointResolverFunc(
+			func(service, region string) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: cfg.endpoint}, nil
+			},
+		)
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		awsCfg.Credentials = credentials.StaticCredentialsProvider{
+			Value: aws.Credentials{
+				AccessKeyID:     id,
+				SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			},
+		}
+	}
+	return dynamodb.NewFromConfig(awsCfg)
+}
+
+func createTable(cfg *config) error {
+	c, err := dynamolock.New(newDynamoDBClient(cfg), cfg.table, "key", dynamolock.DisableHeartbeat())
+	if err != nil {
+		return err
+	}
+	defer c.Close(context.Background())
+	_, err = c.CreateTable(context.Background(), dynamolock.WithWaitForActive(time.Minute))
+	var errInUse *types.ResourceInUseException
+	if errors.As(err, &errInUse) {
+		return nil
+	}
+	return err
+}
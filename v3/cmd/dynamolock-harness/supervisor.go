@@ -0,0 +1,210 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const shutdownTimeout = 30 * time.Second
+
+// supervisor runs the worker processes and collects their events.
+type supervisor struct {
+	cfg    *config
+	args   []string
+	stderr io.Writer
+	exe    string
+
+	mu      sync.Mutex
+	events  []event
+	holder  int
+	running map[int]*exec.Cmd
+	wg      sync.WaitGroup
+}
+
+func supervise(cfg *config, args []string, stderr io.Writer) (*report, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the harness executable: %w", err)
+	}
+	s := &supervisor{
+		cfg:     cfg,
+		args:    args,
+		stderr:  stderr,
+		exe:     exe,
+		holder:  -1,
+		running: make(map[int]*exec.Cmd),
+	}
+	for id := 0; id < cfg.workers; id++ {
+		if err := s.start(id); err != nil {
+			s.stopAll()
+			return nil, err
+		}
+	}
+
+	deadline := time.After(cfg.duration)
+	var kills <-chan time.Time
+	if cfg.killEvery > 0 {
+		ticker := time.NewTicker(cfg.killEvery)
+		defer ticker.Stop()
+		kills = ticker.C
+	}
+	for done := false; !done; {
+		select {
+		case <-deadline:
+			done = true
+		case <-kills:
+			s.killOne()
+		}
+	}
+	s.stopAll()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return check(s.events, cfg.maxTakeover), nil
+}
+
+func (s *supervisor) start(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running == nil {
+		// The run is over.
+		return nil
+	}
+	cmd := exec.Command(s.exe, append(append([]string(nil), s.args...), "-worker-id", strconv.Itoa(id))...)
+	cmd.Stderr = s.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot start worker %d: %w", id, err)
+	}
+	s.running[id] = cmd
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			var e event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				log.Printf("worker %d: unexpected output: %s", id, scanner.Text())
+				continue
+			}
+			s.record(e)
+		}
+		_ = cmd.Wait()
+		s.mu.Lock()
+		if s.running[id] == cmd {
+			delete(s.running, id)
+		}
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+func (s *supervisor) record(e event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	switch {
+	case e.Kind == eventAcquired:
+		s.holder = e.Worker
+	case s.holder == e.Worker:
+		s.holder = -1
+	}
+}
+
+// killOne kills the current holder, or a random worker, and restarts it after
+// the restart delay.
+func (s *supervisor) killOne() {
+	s.mu.Lock()
+	id := s.holder
+	if s.cfg.killTarget == "random" || id < 0 {
+		ids := make([]int, 0, len(s.running))
+		for id := range s.running {
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		id = ids[rand.Intn(len(ids))]
+	}
+	cmd, ok := s.running[id]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.running, id)
+	s.mu.Unlock()
+
+	log.Printf("sending SIG%s to worker %d", s.cfg.killSignal, id)
+	if s.cfg.killSignal == "TERM" {
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			log.Printf("cannot signal worker %d: %v", id, err)
+		}
+	} else if err := cmd.Process.Kill(); err != nil {
+		log.Printf("cannot kill worker %d: %v", id, err)
+	} else {
+		// A crashed worker cannot report that it is gone, so the
+		// supervisor does it on its behalf, once the worker can no
+		// longer run.
+		s.record(event{Worker: id, Kind: eventKilled, At: time.Now().UnixNano()})
+	}
+	time.AfterFunc(s.cfg.restartDelay, func() {
+		if err := s.start(id); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// stopAll gracefully stops the workers, and waits for them to exit.
+func (s *supervisor) stopAll() {
+	s.mu.Lock()
+	running := s.running
+	s.running = nil
+	s.mu.Unlock()
+	for _, cmd := range running {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		for _, cmd := range running {
+			_ = cmd.Process.Kill()
+		}
+		<-done
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"cirello.io/dynamolock/v3"
+)
+
+// reporter writes the events of a worker as JSON lines.
+type reporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	id  int
+}
+
+func (r *reporter) report(kind eventKind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(event{Worker: r.id, Kind: kind, At: time.Now().UnixNano()}); err != nil {
+		log.Println("cannot report event:", err)
+	}
+}
+
+func runWorker(cfg *config, stdout io.Writer) error {
+	log.SetPrefix(fmt.Sprintf("dynamolock-harness[worker %d]: ", cfg.workerID))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c, err := dynamolock.New(newDynamoDBClient(cfg), cfg.table, "key",
+		dynamolock.WithLeaseDuration(cfg.lease),
+		dynamolock.WithHeartbeatPeriod(cfg.heartbeat),
+		dynamolock.WithOwnerName(fmt.Sprintf("dynamolock-harness-%d-%d", cfg.workerID, os.Getpid())),
+	)
+	if err != nil {
+		return err
+	}
+	defer c.Close(context.Background())
+
+	r := &reporter{enc: json.NewEncoder(stdout), id: cfg.workerID}
+	for ctx.Err() == nil {
+		l, err := c.AcquireLock(ctx, cfg.key,
+			dynamolock.WithRefreshPeriod(cfg.refresh),
+			dynamolock.WithAdditionalTimeToWaitForLock(cfg.duration),
+		)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("cannot acquire lock:", err)
+			}
+			continue
+		}
+		r.report(eventAcquired)
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.hold):
+		}
+		// The lock may have been lost while held, in which case another
+		// worker may hold it now; the overlap is what the supervisor
+		// looks for.
+		if l.IsExpired() {
+			r.report(eventLost)
+		} else {
+			r.report(eventReleasing)
+		}
+		if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+			log.Println("cannot release lock:", err)
+		}
+	}
+	return nil
+}
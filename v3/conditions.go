@@ -0,0 +1,59 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+
+// The condition builders below are the ones used by the client to guard its
+// writes. They allow advanced users crafting their own UpdateItem calls or
+// transactions against the lock table to keep exactly the same semantics.
+
+// OwnershipCondition holds when the lock row exists, has not been updated
+// since recordVersionNumber was observed and belongs to ownerName. It guards
+// heartbeats and releases.
+func OwnershipCondition(partitionKeyName, recordVersionNumber, ownerName string) expression.ConditionBuilder {
+	return expression.And(
+		expression.And(
+			expression.AttributeExists(expression.Name(partitionKeyName)),
+			expression.Equal(rvnAttr, expression.Value(recordVersionNumber)),
+		),
+		expression.Equal(ownerNameAttr, expression.Value(ownerName)),
+	)
+}
+
+// NewOrReleasedLockCondition holds when the lock row does not exist or was
+// released by its owner. It guards the acquisition of free locks. It expects
+// the default released marker (see WithReleasedMarker).
+func NewOrReleasedLockCondition(partitionKeyName string) expression.ConditionBuilder {
+	return expression.Or(
+		expression.AttributeNotExists(expression.Name(partitionKeyName)),
+		expression.And(
+			expression.AttributeExists(expression.Name(partitionKeyName)),
+			expression.Equal(isReleasedAttr, isReleasedAttrVal),
+		),
+	)
+}
+
+// ExpiredLockCondition holds when the lock row exists and has not been updated
+// since recordVersionNumber was observed. It guards the takeover of locks
+// whose owner stopped heartbeating them for longer than their lease.
+func ExpiredLockCondition(partitionKeyName, recordVersionNumber string) expression.ConditionBuilder {
+	return expression.And(
+		expression.AttributeExists(expression.Name(partitionKeyName)),
+		expression.Equal(rvnAttr, expression.Value(recordVersionNumber)),
+	)
+}
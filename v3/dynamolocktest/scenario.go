@@ -0,0 +1,205 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolocktest
+
+import (
+	"context"
+	"time"
+)
+
+// StepKind is the kind of a step of a Scenario.
+type StepKind int
+
+// Kinds of steps of a scenario.
+const (
+	// StepAdvance moves the clocks forward by the duration of the step, as
+	// seen by the holder. The observer sees it scaled by its clock rate.
+	StepAdvance StepKind = iota
+	// StepHeartbeat makes the holder heartbeat its lock.
+	StepHeartbeat
+	// StepObserve makes the observer read the lock, starting to count its
+	// lease.
+	StepObserve
+	// StepTakeover makes the observer try to take the lock over.
+	StepTakeover
+)
+
+// Step is a single action of a Scenario.
+type Step struct {
+	Kind     StepKind
+	Duration time.Duration
+}
+
+// Scenario is a prebuilt timeline of a holder and an observer of the same
+// lock, reproducing one of the classic failure modes of lease-based locks.
+// Applications run it against their own clients to assert that their fencing
+// logic, such as checking the record version number before committing work,
+// holds up.
+type Scenario struct {
+	Name string
+	// Lease is the lease duration the clients must be configured with.
+	Lease time.Duration
+	// ObserverRate is how fast the clock of the observer runs compared to
+	// the one of the holder: 2 means twice as fast.
+	ObserverRate float64
+	// Steps is the timeline of the scenario.
+	Steps []Step
+	// HolderStillValid reports whether, at the end of the scenario, the
+	// holder still believes, by its own clock, that it holds the lock.
+	HolderStillValid bool
+	// TakeoverExpected reports whether the observer is expected to take the
+	// lock over. If both HolderStillValid and TakeoverExpected are true,
+	// only fencing keeps the holder from corrupting the protected resource.
+	TakeoverExpected bool
+}
+
+// Participants are the actions the application under test performs on behalf
+// of the holder and the observer of the lock. The holder must be created with
+// HolderClock and the observer with ObserverClock, both with automatic
+// heartbeats disabled and with the lease of the scenario.
+type Participants struct {
+	HolderClock   *ManualClock
+	ObserverClock *ManualClock
+
+	Heartbeat func(context.Context) error
+	Observe   func(context.Context) error
+	Takeover  func(context.Context) error
+}
+
+// Outcome holds the results of the last steps of each kind of a scenario.
+type Outcome struct {
+	HeartbeatErr error
+	ObserveErr   error
+	TakeoverErr  error
+}
+
+// NewClocks returns the clocks of the holder and of the observer, stopped at
+// the given instant.
+func (s Scenario) NewClocks(now time.Time) (holder, observer *ManualClock) {
+	return NewManualClock(now), NewManualClock(now)
+}
+
+// Run plays the scenario with the given participants. Steps whose action is
+// nil are skipped.
+func (s Scenario) Run(ctx context.Context, p Participants) Outcome {
+	var out Outcome
+	rate := s.ObserverRate
+	if rate == 0 {
+		rate = 1
+	}
+	for _, step := range s.Steps {
+		switch step.Kind {
+		case StepAdvance:
+			p.HolderClock.Advance(step.Duration)
+			p.ObserverClock.Advance(time.Duration(float64(step.Duration) * rate))
+		case StepHeartbeat:
+			if p.Heartbeat != nil {
+				out.HeartbeatErr = p.Heartbeat(ctx)
+			}
+		case StepObserve:
+			if p.Observe != nil {
+				out.ObserveErr = p.Observe(ctx)
+			}
+		case StepTakeover:
+			if p.Takeover != nil {
+				out.TakeoverErr = p.Takeover(ctx)
+			}
+		}
+	}
+	return out
+}
+
+// Scenarios returns all the prebuilt scenarios for the given lease.
+func Scenarios(lease time.Duration) []Scenario {
+	return []Scenario{
+		HolderPaused(lease),
+		ObserverClockFast(lease),
+		ObserverClockSlow(lease),
+		HeartbeatAtBoundary(lease),
+	}
+}
+
+// HolderPaused is the scenario of a holder that stops, for example because of
+// a long garbage collection pause, for longer than its lease. The observer
+// takes the lock over, and the holder must find out that it lost the lock
+// when it resumes.
+func HolderPaused(lease time.Duration) Scenario {
+	return Scenario{
+		Name:  "holder paused beyond lease",
+		Lease: lease,
+		Steps: []Step{
+			{Kind: StepObserve},
+			{Kind: StepAdvance, Duration: lease + lease/2},
+			{Kind: StepTakeover},
+			{Kind: StepHeartbeat},
+		},
+		TakeoverExpected: true,
+	}
+}
+
+// ObserverClockFast is the scenario of an observer whose clock runs twice as
+// fast as the one of the holder. The observer takes the lock over while the
+// holder still believes it holds it: only fencing protects the resource.
+func ObserverClockFast(lease time.Duration) Scenario {
+	return Scenario{
+		Name:         "observer clock fast",
+		Lease:        lease,
+		ObserverRate: 2,
+		Steps: []Step{
+			{Kind: StepObserve},
+			{Kind: StepAdvance, Duration: lease/2 + lease/10},
+			{Kind: StepTakeover},
+		},
+		HolderStillValid: true,
+		TakeoverExpected: true,
+	}
+}
+
+// ObserverClockSlow is the scenario of an observer whose clock runs at half
+// the speed of the one of the holder. The lease of the holder runs out by its
+// own clock, but the observer must not take the lock over yet.
+func ObserverClockSlow(lease time.Duration) Scenario {
+	return Scenario{
+		Name:         "observer clock slow",
+		Lease:        lease,
+		ObserverRate: 0.5,
+		Steps: []Step{
+			{Kind: StepObserve},
+			{Kind: StepAdvance, Duration: lease + lease/10},
+			{Kind: StepTakeover},
+		},
+	}
+}
+
+// HeartbeatAtBoundary is the scenario of a heartbeat delayed until exactly the
+// end of the lease, sent right before the observer tries to take the lock
+// over. The heartbeat changes the record version number the observer is
+// waiting on, so the takeover must fail and the holder keeps the lock.
+func HeartbeatAtBoundary(lease time.Duration) Scenario {
+	return Scenario{
+		Name:  "heartbeat delayed exactly at boundary",
+		Lease: lease,
+		Steps: []Step{
+			{Kind: StepHeartbeat},
+			{Kind: StepObserve},
+			{Kind: StepAdvance, Duration: lease},
+			{Kind: StepHeartbeat},
+			{Kind: StepTakeover},
+		},
+		HolderStillValid: true,
+	}
+}
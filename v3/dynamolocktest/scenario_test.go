@@ -0,0 +1,73 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolocktest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScenarioRun(t *testing.T) {
+	const lease = 10 * time.Second
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	errLost := errors.New("lost")
+
+	s := ObserverClockFast(lease)
+	holder, observer := s.NewClocks(start)
+	var (
+		actions    []string
+		observedAt time.Time
+	)
+	out := s.Run(context.Background(), Participants{
+		HolderClock:   holder,
+		ObserverClock: observer,
+		Observe: func(context.Context) error {
+			actions = append(actions, "observe")
+			observedAt = observer.Now()
+			return nil
+		},
+		Takeover: func(context.Context) error {
+			actions = append(actions, "takeover")
+			if observer.Now().Sub(observedAt) <= lease {
+				return errLost
+			}
+			return nil
+		},
+	})
+	if len(actions) != 2 || actions[0] != "observe" || actions[1] != "takeover" {
+		t.Fatal("unexpected actions:", actions)
+	}
+	if out.TakeoverErr != nil {
+		t.Fatal("expected the fast observer to see the lease expired:", out.TakeoverErr)
+	}
+	if held := holder.Now().Sub(start); held > lease {
+		t.Fatal("expected the holder to still be within its lease:", held)
+	}
+	if !s.HolderStillValid || !s.TakeoverExpected {
+		t.Fatal("unexpected expectations:", s)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	for _, s := range Scenarios(time.Second) {
+		if s.Name == "" || len(s.Steps) == 0 || s.Lease != time.Second {
+			t.Errorf("incomplete scenario: %#v", s)
+		}
+	}
+}
@@ -19,6 +19,9 @@ package dynamolock
 import (
 	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -34,11 +37,25 @@ func (e *TimeoutError) Error() string {
 	return fmt.Sprintf("timeout: %s", e.Age)
 }
 
+// MaxAttemptsError indicates that the dynamolock gave up acquiring the lock
+// after the maximum number of attempts set with WithMaxAttempts.
+type MaxAttemptsError struct {
+	Attempts int
+}
+
+func (e *MaxAttemptsError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts", e.Attempts)
+}
+
 // LockNotGrantedError indicates that an AcquireLock call has failed to
 // establish a lock because of its current lifecycle state.
 type LockNotGrantedError struct {
-	msg   string
-	cause error
+	msg    string
+	cause  error
+	holder *LockInfo
+
+	// remainingLease is only meaningful if holder is set.
+	remainingLease time.Duration
 }
 
 func (e *LockNotGrantedError) Error() string {
@@ -46,6 +63,9 @@ func (e *LockNotGrantedError) Error() string {
 	if e.cause != nil {
 		msg += ": " + e.cause.Error()
 	}
+	if e.holder != nil {
+		msg += fmt.Sprintf(" (held by %s, lease expires in about %s)", e.holder.OwnerName, e.remainingLease)
+	}
 	return msg
 }
 
@@ -54,6 +74,61 @@ func (e *LockNotGrantedError) Unwrap() error {
 	return e.cause
 }
 
+// Holder describes the lock that prevented the acquisition, as it was last
+// read from the table, so callers can find out who beat them without reading
+// the lock again. It reports false if the holder is unknown.
+func (e *LockNotGrantedError) Holder() (LockInfo, bool) {
+	if e.holder != nil {
+		return *e.holder, true
+	}
+	var cause *LockNotGrantedError
+	if errors.As(e.cause, &cause) {
+		return cause.Holder()
+	}
+	return LockInfo{}, false
+}
+
+// RemainingLease estimates how long the lease of the holder that prevented the
+// acquisition still had to run, so callers can tell whether retrying soon is
+// sensible. The estimate is measured from the moment the holder was last seen
+// refreshing the lock, and it is only reached if the holder stops
+// heartbeating: a live holder keeps extending it. It reports false if the
+// holder is unknown.
+func (e *LockNotGrantedError) RemainingLease() (time.Duration, bool) {
+	if e.holder != nil {
+		return e.remainingLease, true
+	}
+	var cause *LockNotGrantedError
+	if errors.As(e.cause, &cause) {
+		return cause.RemainingLease()
+	}
+	return 0, false
+}
+
+// DeadlockSuspectedError indicates that a cycle of owners waiting for locks
+// held by each other was found in the lock table.
+type DeadlockSuspectedError struct {
+	// Cycle lists the waits-for edges that form the cycle.
+	Cycle []WaitsForEdge
+}
+
+func (e *DeadlockSuspectedError) Error() string {
+	edges := make([]string, len(e.Cycle))
+	for i, edge := range e.Cycle {
+		edges[i] = edge.String()
+	}
+	return "deadlock suspected: " + strings.Join(edges, ", ")
+}
+
+// Owners returns the owners involved in the suspected deadlock.
+func (e *DeadlockSuspectedError) Owners() []string {
+	owners := make([]string, len(e.Cycle))
+	for i, edge := range e.Cycle {
+		owners[i] = edge.Owner
+	}
+	return owners
+}
+
 func parseDynamoDBError(err error, msg string) error {
 	var conditionalCheckFailedException *types.ConditionalCheckFailedException
 	if errors.As(err, &conditionalCheckFailedException) {
@@ -64,3 +139,132 @@ func parseDynamoDBError(err error, msg string) error {
 	}
 	return err
 }
+
+// parseOwnershipError is parseDynamoDBError for the conditional writes on a
+// lock held by this client, where a failed condition means the lock was lost.
+func parseOwnershipError(err error, msg string) error {
+	var conditionalCheckFailedException *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailedException) {
+		return &LockNotGrantedError{
+			msg:   msg,
+			cause: &ownershipLostError{cause: conditionalCheckFailedException},
+		}
+	}
+	return err
+}
+
+// IsRetryable reports whether err is a transient failure, such as throttling,
+// a network error or a DynamoDB internal error, that is likely to go away if
+// the same call is retried. Lock contention is not retryable in this sense;
+// see IsContention.
+func IsRetryable(err error) bool {
+	return isRetryableError(err)
+}
+
+// IsOwnershipLost reports whether err proves that a lock held by this client
+// no longer belongs to it: it expired, was preempted, or its row was changed
+// or taken over by someone else. Retrying the same operation does not help;
+// the lock must be acquired again.
+func IsOwnershipLost(err error) bool {
+	if errors.Is(err, ErrOwnershipLost) || errors.Is(err, ErrLockPreempted) {
+		return true
+	}
+	var errNotGranted *LockNotGrantedError
+	return !errors.As(err, &errNotGranted) && isOwnershipLost(err)
+}
+
+// IsContention reports whether err means that the lock is, or was just,
+// held by someone else, such as a LockNotGrantedError from AcquireLock. The
+// call may succeed later, once the lock is released.
+func IsContention(err error) bool {
+	var errNotGranted *LockNotGrantedError
+	if errors.As(err, &errNotGranted) {
+		return !IsOwnershipLost(err)
+	}
+	return errors.Is(err, ErrAlreadyHeldLocally)
+}
+
+// IsInfrastructure reports whether err comes from DynamoDB or the network
+// rather than from the state of the lock: throttling, missing tables,
+// permissions, validation or connectivity errors, whether they are retryable
+// or not.
+func IsInfrastructure(err error) bool {
+	if err == nil || IsContention(err) || IsOwnershipLost(err) {
+		return false
+	}
+	if isRetryableError(err) {
+		return true
+	}
+	var apiErr interface{ ErrorCode() string }
+	var netErr net.Error
+	return errors.As(err, &apiErr) || errors.As(err, &netErr)
+}
+
+// LockErrors collects the failures of an operation on several locks, like the
+// release of all the locks on Close, by lock. errors.Is and errors.As look into
+// all of them.
+type LockErrors map[*Lock]error
+
+func (e LockErrors) Error() string {
+	locks := e.locks()
+	msgs := make([]string, len(locks))
+	for i, l := range locks {
+		msgs[i] = l.PartitionKey()
+		if l.SortKey() != "" {
+			msgs[i] += "/" + l.SortKey()
+		}
+		msgs[i] += ": " + e[l].Error()
+	}
+	if len(msgs) == 1 {
+		return "1 lock failed: " + msgs[0]
+	}
+	return fmt.Sprintf("%d locks failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether the error of any of the locks matches target.
+func (e LockErrors) Is(target error) bool {
+	for _, l := range e.locks() {
+		if errors.Is(e[l], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error, in lock order, that matches target.
+func (e LockErrors) As(target interface{}) bool {
+	for _, l := range e.locks() {
+		if errors.As(e[l], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// locks returns the failed locks sorted by table, partition key and sort key.
+func (e LockErrors) locks() []*Lock {
+	locks := make([]*Lock, 0, len(e))
+	for l := range e {
+		locks = append(locks, l)
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		a, b := locks[i].uniqueIdentifier(), locks[j].uniqueIdentifier()
+		if a.tableName != b.tableName {
+			return a.tableName < b.tableName
+		}
+		if a.partitionKey != b.partitionKey {
+			return a.partitionKey < b.partitionKey
+		}
+		return a.sortKey < b.sortKey
+	})
+	return locks
+}
+
+// orNil returns nil if no lock failed, so that the result of an operation that
+// collects LockErrors can be returned as an error.
+func (e LockErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -68,3 +68,71 @@ func TestParseDynamoDBError(t *testing.T) {
 		t.Error("wrong error wrapping (awserr):", err)
 	}
 }
+
+func TestErrorClassification(t *testing.T) {
+	t.Parallel()
+
+	msg := "conditional check failed"
+	conditional := &types.ConditionalCheckFailedException{Message: &msg}
+	tests := []struct {
+		name           string
+		err            error
+		retryable      bool
+		ownershipLost  bool
+		contention     bool
+		infrastructure bool
+	}{
+		{name: "nil"},
+		{name: "vanilla", err: errors.New("vanilla error")},
+		{
+			name:       "not granted",
+			err:        &LockNotGrantedError{msg: "not granted", cause: &TimeoutError{time.Minute}},
+			contention: true,
+		},
+		{
+			name:       "lost put race",
+			err:        parseDynamoDBError(conditional, "lock already acquired"),
+			contention: true,
+		},
+		{name: "held locally", err: fmt.Errorf("envelope: %w", ErrAlreadyHeldLocally), contention: true},
+		{
+			name:          "lost heartbeat",
+			err:           parseOwnershipError(fmt.Errorf("envelope: %w", conditional), "lock lost"),
+			ownershipLost: true,
+		},
+		{
+			name:          "preempted",
+			err:           &LockNotGrantedError{msg: "preempted", cause: ErrLockPreempted},
+			ownershipLost: true,
+		},
+		{name: "lost release", err: &ownershipLostError{cause: conditional}, ownershipLost: true},
+		{
+			name:           "throttled",
+			err:            fmt.Errorf("envelope: %w", &types.ProvisionedThroughputExceededException{}),
+			retryable:      true,
+			infrastructure: true,
+		},
+		{
+			name:           "missing table",
+			err:            &types.ResourceNotFoundException{},
+			infrastructure: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Error("IsRetryable:", got)
+			}
+			if got := IsOwnershipLost(tt.err); got != tt.ownershipLost {
+				t.Error("IsOwnershipLost:", got)
+			}
+			if got := IsContention(tt.err); got != tt.contention {
+				t.Error("IsContention:", got)
+			}
+			if got := IsInfrastructure(tt.err); got != tt.infrastructure {
+				t.Error("IsInfrastructure:", got)
+			}
+		})
+	}
+}
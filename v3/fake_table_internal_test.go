@@ -0,0 +1,1530 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// memoryDynamoDBClient is an in-memory DynamoDB shared by the tests. It keeps
+// the rows of every table it is called with, and evaluates the condition,
+// update, key condition and filter expressions of the calls as DynamoDB does,
+// so the client is exercised against the real semantics of its requests.
+// Tables are created on first use, with the key schema given to
+// newMemoryDynamoDBClient, unless CreateTable says otherwise.
+//
+// Tests seed and inspect rows with putRow, row and deleteRow, inspect the
+// calls with callCount and the *Inputs methods, and change the behavior of the
+// calls, to inject failures or delay them, with intercept.
+type memoryDynamoDBClient struct {
+	mu        sync.Mutex
+	keyNames  []string
+	tables    map[string]*memoryTable
+	calls     map[string]int
+	inputs    map[string][]interface{}
+	intercept memoryInterceptor
+}
+
+// memoryInterceptor wraps a call to the in-memory DynamoDB. op is the name of
+// the DynamoDB API and input the pointer to its input structure; next runs
+// the call, and can be skipped, delayed or followed by other actions.
+type memoryInterceptor func(ctx context.Context, op string, input interface{}, next func() (interface{}, error)) (interface{}, error)
+
+type memoryTable struct {
+	keyNames []string
+	created  bool
+	rows     map[string]map[string]types.AttributeValue
+}
+
+// newMemoryDynamoDBClient creates an in-memory DynamoDB whose tables have the
+// given partition key and, optionally, sort key names. It defaults to the
+// "key" partition key used by most tests.
+func newMemoryDynamoDBClient(keyNames ...string) *memoryDynamoDBClient {
+	if len(keyNames) == 0 {
+		keyNames = []string{"key"}
+	}
+	return &memoryDynamoDBClient{
+		keyNames: keyNames,
+		tables:   make(map[string]*memoryTable),
+		calls:    make(map[string]int),
+		inputs:   make(map[string][]interface{}),
+	}
+}
+
+// setIntercept replaces the interceptor of the calls.
+func (m *memoryDynamoDBClient) setIntercept(intercept memoryInterceptor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.intercept = intercept
+}
+
+// callCount returns how many times the given DynamoDB API was called,
+// including the calls that failed.
+func (m *memoryDynamoDBClient) callCount(op string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[op]
+}
+
+// putInputs returns the inputs of the PutItem calls, in call order.
+func (m *memoryDynamoDBClient) putInputs() []*dynamodb.PutItemInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	puts := make([]*dynamodb.PutItemInput, 0, len(m.inputs["PutItem"]))
+	for _, input := range m.inputs["PutItem"] {
+		puts = append(puts, input.(*dynamodb.PutItemInput))
+	}
+	return puts
+}
+
+// updateInputs returns the inputs of the UpdateItem calls, in call order.
+func (m *memoryDynamoDBClient) updateInputs() []*dynamodb.UpdateItemInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	updates := make([]*dynamodb.UpdateItemInput, 0, len(m.inputs["UpdateItem"]))
+	for _, input := range m.inputs["UpdateItem"] {
+		updates = append(updates, input.(*dynamodb.UpdateItemInput))
+	}
+	return updates
+}
+
+// deleteInputs returns the inputs of the DeleteItem calls, in call order.
+func (m *memoryDynamoDBClient) deleteInputs() []*dynamodb.DeleteItemInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deletes := make([]*dynamodb.DeleteItemInput, 0, len(m.inputs["DeleteItem"]))
+	for _, input := range m.inputs["DeleteItem"] {
+		deletes = append(deletes, input.(*dynamodb.DeleteItemInput))
+	}
+	return deletes
+}
+
+// row returns a copy of the row with the given string key attributes, or nil
+// if it does not exist.
+func (m *memoryDynamoDBClient) row(tableName string, key ...string) map[string]types.AttributeValue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(tableName)
+	k, err := t.key(t.stringKey(key))
+	if err != nil {
+		panic(err)
+	}
+	return copyItem(t.rows[k])
+}
+
+// putRow stores a copy of the given row, replacing any existing one.
+func (m *memoryDynamoDBClient) putRow(tableName string, row map[string]types.AttributeValue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(tableName)
+	k, err := t.key(row)
+	if err != nil {
+		panic(err)
+	}
+	t.rows[k] = copyItem(row)
+}
+
+// setAttributes sets the given attributes on the row with the given string
+// key attributes, as a write by another client would.
+func (m *memoryDynamoDBClient) setAttributes(tableName string, attrs map[string]types.AttributeValue, key ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(tableName)
+	k, err := t.key(t.stringKey(key))
+	if err != nil {
+		panic(err)
+	}
+	row := copyItem(t.rows[k])
+	if row == nil {
+		row = t.stringKey(key)
+	}
+	for name, v := range attrs {
+		row[name] = v
+	}
+	t.rows[k] = row
+}
+
+// deleteRow removes the row with the given string key attributes.
+func (m *memoryDynamoDBClient) deleteRow(tableName string, key ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.table(tableName)
+	k, err := t.key(t.stringKey(key))
+	if err != nil {
+		panic(err)
+	}
+	delete(t.rows, k)
+}
+
+// rowCount returns how many rows the given table holds.
+func (m *memoryDynamoDBClient) rowCount(tableName string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.table(tableName).rows)
+}
+
+// table returns the table with the given name, creating it if needed. It must
+// be called with mu held.
+func (m *memoryDynamoDBClient) table(name string) *memoryTable {
+	t, ok := m.tables[name]
+	if !ok {
+		t = &memoryTable{
+			keyNames: m.keyNames,
+			rows:     make(map[string]map[string]types.AttributeValue),
+		}
+		m.tables[name] = t
+	}
+	return t
+}
+
+func (t *memoryTable) stringKey(key []string) map[string]types.AttributeValue {
+	item := make(map[string]types.AttributeValue, len(key))
+	for i, v := range key {
+		if i < len(t.keyNames) {
+			item[t.keyNames[i]] = stringAttrValue(v)
+		}
+	}
+	return item
+}
+
+// key returns the identity of the row with the key attributes of item.
+func (t *memoryTable) key(item map[string]types.AttributeValue) (string, error) {
+	parts := make([]string, len(t.keyNames))
+	for i, name := range t.keyNames {
+		v, ok := item[name]
+		if !ok {
+			return "", &memoryValidationError{msg: "missing key attribute " + name}
+		}
+		parts[i] = attrString(v)
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+// keyOf returns the key attributes of the given row.
+func (t *memoryTable) keyOf(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	key := make(map[string]types.AttributeValue, len(t.keyNames))
+	for _, name := range t.keyNames {
+		key[name] = item[name]
+	}
+	return key
+}
+
+// sortedKeys returns the identities of the rows in key order.
+func (t *memoryTable) sortedKeys() []string {
+	keys := make([]string, 0, len(t.rows))
+	for k := range t.rows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *memoryDynamoDBClient) call(ctx context.Context, op string, input interface{}, fn func() (interface{}, error)) (interface{}, error) {
+	m.mu.Lock()
+	m.calls[op]++
+	m.inputs[op] = append(m.inputs[op], input)
+	intercept := m.intercept
+	m.mu.Unlock()
+	next := func() (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return fn()
+	}
+	if intercept == nil {
+		return next()
+	}
+	return intercept(ctx, op, input, next)
+}
+
+func (m *memoryDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	out, err := m.call(ctx, "GetItem", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		k, err := t.key(params.Key)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.GetItemOutput{Item: copyItem(t.rows[k])}, nil
+	})
+	o, _ := out.(*dynamodb.GetItemOutput)
+	return o, err
+}
+
+func (m *memoryDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	out, err := m.call(ctx, "PutItem", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		k, err := t.key(params.Item)
+		if err != nil {
+			return nil, err
+		}
+		old := t.rows[k]
+		env := newExprEnv(params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err := env.check(params.ConditionExpression, old); err != nil {
+			return nil, err
+		}
+		if err := env.unused(); err != nil {
+			return nil, err
+		}
+		t.rows[k] = copyItem(params.Item)
+		out := &dynamodb.PutItemOutput{}
+		if params.ReturnValues == types.ReturnValueAllOld {
+			out.Attributes = copyItem(old)
+		}
+		return out, nil
+	})
+	o, _ := out.(*dynamodb.PutItemOutput)
+	return o, err
+}
+
+func (m *memoryDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	out, err := m.call(ctx, "UpdateItem", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		k, err := t.key(params.Key)
+		if err != nil {
+			return nil, err
+		}
+		old := t.rows[k]
+		env := newExprEnv(params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err := env.check(params.ConditionExpression, old); err != nil {
+			return nil, err
+		}
+		row := copyItem(old)
+		if row == nil {
+			row = copyItem(params.Key)
+		}
+		if params.UpdateExpression != nil {
+			if row, err = env.update(aws.ToString(params.UpdateExpression), row); err != nil {
+				return nil, err
+			}
+		}
+		if err := env.unused(); err != nil {
+			return nil, err
+		}
+		t.rows[k] = row
+		out := &dynamodb.UpdateItemOutput{}
+		switch params.ReturnValues {
+		case types.ReturnValueAllNew:
+			out.Attributes = copyItem(row)
+		case types.ReturnValueAllOld:
+			out.Attributes = copyItem(old)
+		}
+		return out, nil
+	})
+	o, _ := out.(*dynamodb.UpdateItemOutput)
+	return o, err
+}
+
+func (m *memoryDynamoDBClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	out, err := m.call(ctx, "DeleteItem", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		k, err := t.key(params.Key)
+		if err != nil {
+			return nil, err
+		}
+		old := t.rows[k]
+		env := newExprEnv(params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err := env.check(params.ConditionExpression, old); err != nil {
+			return nil, err
+		}
+		if err := env.unused(); err != nil {
+			return nil, err
+		}
+		delete(t.rows, k)
+		out := &dynamodb.DeleteItemOutput{}
+		if params.ReturnValues == types.ReturnValueAllOld {
+			out.Attributes = copyItem(old)
+		}
+		return out, nil
+	})
+	o, _ := out.(*dynamodb.DeleteItemOutput)
+	return o, err
+}
+
+func (m *memoryDynamoDBClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	out, err := m.call(ctx, "CreateTable", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		if t.created {
+			return nil, &types.ResourceInUseException{Message: aws.String("table already exists")}
+		}
+		t.created = true
+		var keyNames []string
+		for _, k := range params.KeySchema {
+			if k.KeyType == types.KeyTypeHash {
+				keyNames = append([]string{aws.ToString(k.AttributeName)}, keyNames...)
+			} else {
+				keyNames = append(keyNames, aws.ToString(k.AttributeName))
+			}
+		}
+		if len(keyNames) > 0 {
+			t.keyNames = keyNames
+		}
+		return &dynamodb.CreateTableOutput{TableDescription: t.describe(aws.ToString(params.TableName))}, nil
+	})
+	o, _ := out.(*dynamodb.CreateTableOutput)
+	return o, err
+}
+
+func (m *memoryDynamoDBClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	out, err := m.call(ctx, "DescribeTable", params, func() (interface{}, error) {
+		name := aws.ToString(params.TableName)
+		t, ok := m.tables[name]
+		if !ok || (!t.created && len(t.rows) == 0) {
+			return nil, &types.ResourceNotFoundException{Message: aws.String("table not found")}
+		}
+		return &dynamodb.DescribeTableOutput{Table: t.describe(name)}, nil
+	})
+	o, _ := out.(*dynamodb.DescribeTableOutput)
+	return o, err
+}
+
+func (t *memoryTable) describe(name string) *types.TableDescription {
+	desc := &types.TableDescription{
+		TableName:   aws.String(name),
+		TableStatus: types.TableStatusActive,
+		ItemCount:   int64(len(t.rows)),
+	}
+	for i, k := range t.keyNames {
+		keyType := types.KeyTypeHash
+		if i > 0 {
+			keyType = types.KeyTypeRange
+		}
+		desc.KeySchema = append(desc.KeySchema, types.KeySchemaElement{
+			AttributeName: aws.String(k),
+			KeyType:       keyType,
+		})
+	}
+	return desc
+}
+
+func (m *memoryDynamoDBClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	out, err := m.call(ctx, "Query", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		env := newExprEnv(params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		items, lastKey, err := t.read(env, params.KeyConditionExpression, params.FilterExpression, params.ExclusiveStartKey, params.Limit)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.QueryOutput{Items: items, Count: int32(len(items)), LastEvaluatedKey: lastKey}, nil
+	})
+	o, _ := out.(*dynamodb.QueryOutput)
+	return o, err
+}
+
+func (m *memoryDynamoDBClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	out, err := m.call(ctx, "Scan", params, func() (interface{}, error) {
+		t := m.table(aws.ToString(params.TableName))
+		env := newExprEnv(params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		items, lastKey, err := t.read(env, nil, params.FilterExpression, params.ExclusiveStartKey, params.Limit)
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.ScanOutput{Items: items, Count: int32(len(items)), LastEvaluatedKey: lastKey}, nil
+	})
+	o, _ := out.(*dynamodb.ScanOutput)
+	return o, err
+}
+
+// read returns a page of the rows, in key order, that match the key condition
+// and the filter.
+func (t *memoryTable) read(env *exprEnv, keyCondition, filter *string, startKey map[string]types.AttributeValue, limit *int32) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	matchesKey, err := env.condition(keyCondition)
+	if err != nil {
+		return nil, nil, err
+	}
+	matchesFilter, err := env.condition(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := env.unused(); err != nil {
+		return nil, nil, err
+	}
+	keys := t.sortedKeys()
+	if startKey != nil {
+		start, err := t.key(startKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = keys[sort.SearchStrings(keys, start+"\x00"):]
+	}
+	var items []map[string]types.AttributeValue
+	for i, k := range keys {
+		if limit != nil && i == int(*limit) {
+			return items, t.keyOf(t.rows[keys[i-1]]), nil
+		}
+		if row := t.rows[k]; matchesKey(row) && matchesFilter(row) {
+			items = append(items, copyItem(row))
+		}
+	}
+	return items, nil, nil
+}
+
+// memoryValidationError is the error DynamoDB returns for malformed requests.
+type memoryValidationError struct {
+	msg string
+}
+
+func (e *memoryValidationError) Error() string {
+	return "ValidationException: " + e.msg
+}
+
+func (e *memoryValidationError) ErrorCode() string {
+	return "ValidationException"
+}
+
+// exprEnv evaluates the expressions of a call, resolving their placeholders
+// and tracking which ones were used, as DynamoDB rejects the calls with
+// unused placeholders.
+type exprEnv struct {
+	names      map[string]string
+	values     map[string]types.AttributeValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newExprEnv(names map[string]string, values map[string]types.AttributeValue) *exprEnv {
+	return &exprEnv{
+		names:      names,
+		values:     values,
+		usedNames:  make(map[string]bool),
+		usedValues: make(map[string]bool),
+	}
+}
+
+func (env *exprEnv) unused() error {
+	for name := range env.names {
+		if !env.usedNames[name] {
+			return &memoryValidationError{msg: "unused expression attribute name " + name}
+		}
+	}
+	for value := range env.values {
+		if !env.usedValues[value] {
+			return &memoryValidationError{msg: "unused expression attribute value " + value}
+		}
+	}
+	return nil
+}
+
+// check evaluates the condition against row, which is nil if the row does not
+// exist, failing with ConditionalCheckFailedException if it does not hold.
+func (env *exprEnv) check(condition *string, row map[string]types.AttributeValue) error {
+	cond, err := env.condition(condition)
+	if err != nil {
+		return err
+	}
+	if !cond(row) {
+		return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	return nil
+}
+
+// condition parses a condition expression, which always holds if it is nil.
+func (env *exprEnv) condition(condition *string) (conditionFn, error) {
+	if condition == nil {
+		return func(map[string]types.AttributeValue) bool { return true }, nil
+	}
+	p, err := env.parser(*condition)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	return cond, p.end()
+}
+
+// update applies the update expression to a copy of row and returns it.
+func (env *exprEnv) update(expr string, row map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	p, err := env.parser(expr)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := p.parseUpdate()
+	if err != nil {
+		return nil, err
+	}
+	// All the operands refer to the row as it was before the update.
+	newRow := copyItem(row)
+	for _, action := range actions {
+		if err := action(row, newRow); err != nil {
+			return nil, err
+		}
+	}
+	return newRow, nil
+}
+
+func (env *exprEnv) parser(expr string) (*exprParser, error) {
+	toks, err := tokenizeExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{env: env, toks: toks}, nil
+}
+
+func tokenizeExpr(expr string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(expr); {
+		r := rune(expr[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || r == ':' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '_' || unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j]))) {
+				j++
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		case strings.HasPrefix(expr[i:], "<>"), strings.HasPrefix(expr[i:], "<="), strings.HasPrefix(expr[i:], ">="):
+			toks = append(toks, expr[i:i+2])
+			i += 2
+		case strings.ContainsRune("()[],.=<>+-", r):
+			toks = append(toks, expr[i:i+1])
+			i++
+		default:
+			return nil, &memoryValidationError{msg: fmt.Sprintf("unexpected %q in expression %q", r, expr)}
+		}
+	}
+	return toks, nil
+}
+
+type (
+	conditionFn func(row map[string]types.AttributeValue) bool
+	operandFn   func(row map[string]types.AttributeValue) types.AttributeValue
+	updateFn    func(oldRow, newRow map[string]types.AttributeValue) error
+)
+
+type exprParser struct {
+	env  *exprEnv
+	toks []string
+	pos  int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *exprParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return p.errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+func (p *exprParser) end() error {
+	if p.pos < len(p.toks) {
+		return p.errorf("unexpected %q", p.peek())
+	}
+	return nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return &memoryValidationError{msg: fmt.Sprintf(format, args...) + " in expression " + strings.Join(p.toks, " ")}
+}
+
+func (p *exprParser) parseCondition() (conditionFn, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row map[string]types.AttributeValue) bool { return l(row) || right(row) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (conditionFn, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row map[string]types.AttributeValue) bool { return l(row) && right(row) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (conditionFn, error) {
+	if strings.EqualFold(p.peek(), "NOT") {
+		p.next()
+		cond, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) bool { return !cond(row) }, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (conditionFn, error) {
+	switch fn := p.peek(); fn {
+	case "(":
+		p.next()
+		cond, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(")")
+	case "attribute_exists", "attribute_not_exists":
+		p.next()
+		args, err := p.parseArgs(1)
+		if err != nil {
+			return nil, err
+		}
+		exists := fn == "attribute_exists"
+		return func(row map[string]types.AttributeValue) bool {
+			return (args[0](row) != nil) == exists
+		}, nil
+	case "attribute_type", "begins_with", "contains":
+		p.next()
+		args, err := p.parseArgs(2)
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) bool {
+			a, b := args[0](row), args[1](row)
+			if a == nil || b == nil {
+				return false
+			}
+			switch fn {
+			case "attribute_type":
+				return attrType(a) == readStringAttr(b)
+			case "begins_with":
+				return attrBeginsWith(a, b)
+			}
+			return attrContains(a, b)
+		}, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.next(); {
+	case op == "=" || op == "<>" || op == "<" || op == "<=" || op == ">" || op == ">=":
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) bool {
+			return compareOp(op, left(row), right(row))
+		}, nil
+	case strings.EqualFold(op, "BETWEEN"):
+		lower, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		upper, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) bool {
+			v := left(row)
+			return compareOp(">=", v, lower(row)) && compareOp("<=", v, upper(row))
+		}, nil
+	case strings.EqualFold(op, "IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var candidates []operandFn
+		for {
+			candidate, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, candidate)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) bool {
+			v := left(row)
+			for _, candidate := range candidates {
+				if compareOp("=", v, candidate(row)) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	default:
+		return nil, p.errorf("unexpected operator %q", op)
+	}
+}
+
+// parseArgs parses the parenthesized arguments of a function.
+func (p *exprParser) parseArgs(n int) ([]operandFn, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := make([]operandFn, n)
+	for i := range args {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	return args, p.expect(")")
+}
+
+func (p *exprParser) parseOperand() (operandFn, error) {
+	switch tok := p.peek(); {
+	case tok == "size":
+		p.next()
+		args, err := p.parseArgs(1)
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) types.AttributeValue {
+			return attrSize(args[0](row))
+		}, nil
+	case tok == "if_not_exists":
+		p.next()
+		args, err := p.parseArgs(2)
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) types.AttributeValue {
+			if v := args[0](row); v != nil {
+				return v
+			}
+			return args[1](row)
+		}, nil
+	case tok == "list_append":
+		p.next()
+		args, err := p.parseArgs(2)
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) types.AttributeValue {
+			a, _ := args[0](row).(*types.AttributeValueMemberL)
+			b, _ := args[1](row).(*types.AttributeValueMemberL)
+			if a == nil || b == nil {
+				return nil
+			}
+			list := append(append([]types.AttributeValue(nil), a.Value...), b.Value...)
+			return &types.AttributeValueMemberL{Value: list}
+		}, nil
+	case strings.HasPrefix(tok, ":"):
+		p.next()
+		v, ok := p.env.values[tok]
+		if !ok {
+			return nil, p.errorf("undefined value %s", tok)
+		}
+		p.env.usedValues[tok] = true
+		return func(map[string]types.AttributeValue) types.AttributeValue { return v }, nil
+	case strings.HasPrefix(tok, "#"):
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]types.AttributeValue) types.AttributeValue {
+			return path.get(row)
+		}, nil
+	default:
+		return nil, p.errorf("unexpected operand %q", tok)
+	}
+}
+
+// attrPath is a document path, like a.b[1].
+type attrPath []attrPathElement
+
+type attrPathElement struct {
+	name  string
+	index int
+}
+
+func (p *exprParser) parsePath() (attrPath, error) {
+	var path attrPath
+	for {
+		tok := p.next()
+		name, ok := p.env.names[tok]
+		if !strings.HasPrefix(tok, "#") || !ok {
+			return nil, p.errorf("undefined name %q", tok)
+		}
+		p.env.usedNames[tok] = true
+		path = append(path, attrPathElement{name: name, index: -1})
+		for p.peek() == "[" {
+			p.next()
+			index, err := strconv.Atoi(p.next())
+			if err != nil {
+				return nil, p.errorf("invalid list index")
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, attrPathElement{index: index})
+		}
+		if p.peek() != "." {
+			return path, nil
+		}
+		p.next()
+	}
+}
+
+func (path attrPath) get(row map[string]types.AttributeValue) types.AttributeValue {
+	var v types.AttributeValue = &types.AttributeValueMemberM{Value: row}
+	for _, elem := range path {
+		switch container := v.(type) {
+		case *types.AttributeValueMemberM:
+			if elem.index >= 0 {
+				return nil
+			}
+			v = container.Value[elem.name]
+		case *types.AttributeValueMemberL:
+			if elem.index < 0 || elem.index >= len(container.Value) {
+				return nil
+			}
+			v = container.Value[elem.index]
+		default:
+			return nil
+		}
+		if v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// with returns a copy of container with the value at path replaced by v, or
+// removed if v is nil.
+func (path attrPath) with(container types.AttributeValue, v types.AttributeValue) (types.AttributeValue, error) {
+	elem := path[0]
+	switch c := container.(type) {
+	case *types.AttributeValueMemberM:
+		if elem.index >= 0 {
+			break
+		}
+		m := copyItem(c.Value)
+		if m == nil {
+			m = make(map[string]types.AttributeValue)
+		}
+		if len(path) == 1 {
+			if v == nil {
+				delete(m, elem.name)
+			} else {
+				m[elem.name] = v
+			}
+			return &types.AttributeValueMemberM{Value: m}, nil
+		}
+		child, err := path[1:].with(m[elem.name], v)
+		if err != nil {
+			return nil, err
+		}
+		m[elem.name] = child
+		return &types.AttributeValueMemberM{Value: m}, nil
+	case *types.AttributeValueMemberL:
+		if elem.index < 0 {
+			break
+		}
+		l := append([]types.AttributeValue(nil), c.Value...)
+		if len(path) == 1 {
+			switch {
+			case v == nil && elem.index < len(l):
+				l = append(l[:elem.index], l[elem.index+1:]...)
+			case v != nil && elem.index < len(l):
+				l[elem.index] = v
+			case v != nil:
+				l = append(l, v)
+			}
+			return &types.AttributeValueMemberL{Value: l}, nil
+		}
+		if elem.index >= len(l) {
+			break
+		}
+		child, err := path[1:].with(l[elem.index], v)
+		if err != nil {
+			return nil, err
+		}
+		l[elem.index] = child
+		return &types.AttributeValueMemberL{Value: l}, nil
+	}
+	return nil, &memoryValidationError{msg: "the document path provided in the update expression is invalid for update"}
+}
+
+// set replaces the value at path in row, or removes it if v is nil.
+func (path attrPath) set(row map[string]types.AttributeValue, v types.AttributeValue) error {
+	updated, err := path.with(&types.AttributeValueMemberM{Value: row}, v)
+	if err != nil {
+		return err
+	}
+	for k := range row {
+		delete(row, k)
+	}
+	for k, v := range updated.(*types.AttributeValueMemberM).Value {
+		row[k] = v
+	}
+	return nil
+}
+
+func (p *exprParser) parseUpdate() ([]updateFn, error) {
+	var actions []updateFn
+	for p.pos < len(p.toks) {
+		clause := strings.ToUpper(p.next())
+		if clause != "SET" && clause != "REMOVE" && clause != "ADD" && clause != "DELETE" {
+			return nil, p.errorf("unexpected update clause %q", clause)
+		}
+		for {
+			action, err := p.parseUpdateAction(clause)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, action)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	return actions, nil
+}
+
+func (p *exprParser) parseUpdateAction(clause string) (updateFn, error) {
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	switch clause {
+	case "REMOVE":
+		return func(_, newRow map[string]types.AttributeValue) error {
+			if path.get(newRow) == nil {
+				return nil
+			}
+			return path.set(newRow, nil)
+		}, nil
+	case "SET":
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if op := p.peek(); op == "+" || op == "-" {
+			p.next()
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			left := value
+			value = func(row map[string]types.AttributeValue) types.AttributeValue {
+				return addNumbers(left(row), right(row), op == "-")
+			}
+		}
+		return func(oldRow, newRow map[string]types.AttributeValue) error {
+			v := value(oldRow)
+			if v == nil {
+				return &memoryValidationError{msg: "an operand in the update expression has an incorrect data type"}
+			}
+			return path.set(newRow, v)
+		}, nil
+	}
+	operand, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(oldRow, newRow map[string]types.AttributeValue) error {
+		current, v := path.get(oldRow), operand(oldRow)
+		var updated types.AttributeValue
+		if clause == "ADD" {
+			updated = addAttr(current, v)
+		} else {
+			updated = deleteFromSet(current, v)
+		}
+		if updated == nil && (clause == "ADD" || current != nil) {
+			return &memoryValidationError{msg: "an operand in the update expression has an incorrect data type"}
+		}
+		if isEmptySet(updated) {
+			updated = nil
+		}
+		return path.set(newRow, updated)
+	}, nil
+}
+
+// attrString returns a representation of v that identifies its type and value,
+// and sorts numbers after strings.
+func attrString(v types.AttributeValue) string {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return "S:" + v.Value
+	case *types.AttributeValueMemberN:
+		return "N:" + v.Value
+	case *types.AttributeValueMemberB:
+		return "B:" + string(v.Value)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+func attrType(v types.AttributeValue) string {
+	switch v.(type) {
+	case *types.AttributeValueMemberS:
+		return "S"
+	case *types.AttributeValueMemberN:
+		return "N"
+	case *types.AttributeValueMemberB:
+		return "B"
+	case *types.AttributeValueMemberBOOL:
+		return "BOOL"
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case *types.AttributeValueMemberL:
+		return "L"
+	case *types.AttributeValueMemberM:
+		return "M"
+	case *types.AttributeValueMemberSS:
+		return "SS"
+	case *types.AttributeValueMemberNS:
+		return "NS"
+	case *types.AttributeValueMemberBS:
+		return "BS"
+	}
+	return ""
+}
+
+func parseNumber(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil
+	}
+	return r
+}
+
+func formatNumber(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	return strings.TrimRight(r.FloatString(38), "0")
+}
+
+// compareOp compares two values as DynamoDB does: values of different types,
+// and missing values, are neither equal nor different.
+func compareOp(op string, a, b types.AttributeValue) bool {
+	if a == nil || b == nil || attrType(a) != attrType(b) {
+		return false
+	}
+	if op == "=" {
+		return attrEqual(a, b)
+	} else if op == "<>" {
+		return !attrEqual(a, b)
+	}
+	var cmp int
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		cmp = strings.Compare(a.Value, b.(*types.AttributeValueMemberS).Value)
+	case *types.AttributeValueMemberN:
+		x, y := parseNumber(a.Value), parseNumber(b.(*types.AttributeValueMemberN).Value)
+		if x == nil || y == nil {
+			return false
+		}
+		cmp = x.Cmp(y)
+	case *types.AttributeValueMemberB:
+		cmp = bytes.Compare(a.Value, b.(*types.AttributeValueMemberB).Value)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func attrEqual(a, b types.AttributeValue) bool {
+	if attrType(a) != attrType(b) {
+		return false
+	}
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		return a.Value == b.(*types.AttributeValueMemberS).Value
+	case *types.AttributeValueMemberN:
+		x, y := parseNumber(a.Value), parseNumber(b.(*types.AttributeValueMemberN).Value)
+		return x != nil && y != nil && x.Cmp(y) == 0
+	case *types.AttributeValueMemberB:
+		return bytes.Equal(a.Value, b.(*types.AttributeValueMemberB).Value)
+	case *types.AttributeValueMemberBOOL:
+		return a.Value == b.(*types.AttributeValueMemberBOOL).Value
+	case *types.AttributeValueMemberNULL:
+		return true
+	case *types.AttributeValueMemberL:
+		other := b.(*types.AttributeValueMemberL).Value
+		if len(a.Value) != len(other) {
+			return false
+		}
+		for i := range a.Value {
+			if !attrEqual(a.Value[i], other[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		other := b.(*types.AttributeValueMemberM).Value
+		if len(a.Value) != len(other) {
+			return false
+		}
+		for k, v := range a.Value {
+			if w, ok := other[k]; !ok || !attrEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	x, y := setElements(a), setElements(b)
+	if len(x) != len(y) {
+		return false
+	}
+	for _, v := range x {
+		if !setContains(y, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// setElements returns the elements of a set as single values.
+func setElements(v types.AttributeValue) []types.AttributeValue {
+	var elems []types.AttributeValue
+	switch v := v.(type) {
+	case *types.AttributeValueMemberSS:
+		for _, s := range v.Value {
+			elems = append(elems, &types.AttributeValueMemberS{Value: s})
+		}
+	case *types.AttributeValueMemberNS:
+		for _, n := range v.Value {
+			elems = append(elems, &types.AttributeValueMemberN{Value: n})
+		}
+	case *types.AttributeValueMemberBS:
+		for _, b := range v.Value {
+			elems = append(elems, &types.AttributeValueMemberB{Value: b})
+		}
+	}
+	return elems
+}
+
+func setContains(elems []types.AttributeValue, v types.AttributeValue) bool {
+	for _, elem := range elems {
+		if attrEqual(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// newSet builds a set of the type of like out of single values.
+func newSet(like types.AttributeValue, elems []types.AttributeValue) types.AttributeValue {
+	switch like.(type) {
+	case *types.AttributeValueMemberSS:
+		set := &types.AttributeValueMemberSS{}
+		for _, elem := range elems {
+			set.Value = append(set.Value, elem.(*types.AttributeValueMemberS).Value)
+		}
+		return set
+	case *types.AttributeValueMemberNS:
+		set := &types.AttributeValueMemberNS{}
+		for _, elem := range elems {
+			set.Value = append(set.Value, elem.(*types.AttributeValueMemberN).Value)
+		}
+		return set
+	case *types.AttributeValueMemberBS:
+		set := &types.AttributeValueMemberBS{}
+		for _, elem := range elems {
+			set.Value = append(set.Value, elem.(*types.AttributeValueMemberB).Value)
+		}
+		return set
+	}
+	return nil
+}
+
+func isEmptySet(v types.AttributeValue) bool {
+	switch v.(type) {
+	case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+		return len(setElements(v)) == 0
+	}
+	return false
+}
+
+func addNumbers(a, b types.AttributeValue, subtract bool) types.AttributeValue {
+	x, _ := a.(*types.AttributeValueMemberN)
+	y, _ := b.(*types.AttributeValueMemberN)
+	if x == nil || y == nil {
+		return nil
+	}
+	rx, ry := parseNumber(x.Value), parseNumber(y.Value)
+	if rx == nil || ry == nil {
+		return nil
+	}
+	if subtract {
+		ry.Neg(ry)
+	}
+	return &types.AttributeValueMemberN{Value: formatNumber(rx.Add(rx, ry))}
+}
+
+// addAttr implements the ADD action: numbers are added up, and sets are
+// merged.
+func addAttr(current, v types.AttributeValue) types.AttributeValue {
+	if _, ok := v.(*types.AttributeValueMemberN); ok {
+		if current == nil {
+			return v
+		}
+		return addNumbers(current, v, false)
+	}
+	if newSet(v, nil) == nil {
+		return nil
+	}
+	if current == nil {
+		return v
+	}
+	if attrType(current) != attrType(v) {
+		return nil
+	}
+	elems := setElements(current)
+	for _, elem := range setElements(v) {
+		if !setContains(elems, elem) {
+			elems = append(elems, elem)
+		}
+	}
+	return newSet(v, elems)
+}
+
+// deleteFromSet implements the DELETE action.
+func deleteFromSet(current, v types.AttributeValue) types.AttributeValue {
+	if current == nil || newSet(v, nil) == nil || attrType(current) != attrType(v) {
+		return nil
+	}
+	remove := setElements(v)
+	var elems []types.AttributeValue
+	for _, elem := range setElements(current) {
+		if !setContains(remove, elem) {
+			elems = append(elems, elem)
+		}
+	}
+	return newSet(v, elems)
+}
+
+func attrSize(v types.AttributeValue) types.AttributeValue {
+	var n int
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberB:
+		n = len(v.Value)
+	case *types.AttributeValueMemberL:
+		n = len(v.Value)
+	case *types.AttributeValueMemberM:
+		n = len(v.Value)
+	case *types.AttributeValueMemberSS, *types.AttributeValueMemberNS, *types.AttributeValueMemberBS:
+		n = len(setElements(v))
+	default:
+		return nil
+	}
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+}
+
+func attrBeginsWith(a, b types.AttributeValue) bool {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		prefix, ok := b.(*types.AttributeValueMemberS)
+		return ok && strings.HasPrefix(a.Value, prefix.Value)
+	case *types.AttributeValueMemberB:
+		prefix, ok := b.(*types.AttributeValueMemberB)
+		return ok && bytes.HasPrefix(a.Value, prefix.Value)
+	}
+	return false
+}
+
+func attrContains(a, b types.AttributeValue) bool {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		sub, ok := b.(*types.AttributeValueMemberS)
+		return ok && strings.Contains(a.Value, sub.Value)
+	case *types.AttributeValueMemberB:
+		sub, ok := b.(*types.AttributeValueMemberB)
+		return ok && bytes.Contains(a.Value, sub.Value)
+	case *types.AttributeValueMemberL:
+		return setContains(a.Value, b)
+	}
+	return setContains(setElements(a), b)
+}
+
+func TestMemoryDynamoDBClient(t *testing.T) {
+	ctx := context.Background()
+	svc := newMemoryDynamoDBClient()
+	table := aws.String("locksMemory")
+	key := map[string]types.AttributeValue{"key": stringAttrValue("k")}
+
+	build := func(b expression.Builder) expression.Expression {
+		t.Helper()
+		expr, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return expr
+	}
+	update := func(cond *expression.ConditionBuilder, upd expression.UpdateBuilder) error {
+		b := expression.NewBuilder().WithUpdate(upd)
+		if cond != nil {
+			b = b.WithCondition(*cond)
+		}
+		expr := build(b)
+		_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 table,
+			Key:                       key,
+			ConditionExpression:       expr.Condition(),
+			UpdateExpression:          expr.Update(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		return err
+	}
+
+	notExists := expression.AttributeNotExists(expression.Name("key"))
+	if err := update(&notExists, expression.
+		Set(expression.Name("owner"), expression.Value("a")).
+		Set(expression.Name("doc"), expression.Value(map[string]string{})).
+		Add(expression.Name("count"), expression.Value(2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := update(&notExists, expression.Set(expression.Name("owner"), expression.Value("b"))); !isOwnershipLost(err) {
+		t.Fatal("the condition should have failed:", err)
+	}
+	owned := expression.And(
+		expression.AttributeExists(expression.Name("key")),
+		expression.Equal(expression.Name("owner"), expression.Value("a")),
+		expression.Or(
+			expression.AttributeNotExists(expression.Name("released")),
+			expression.NotEqual(expression.Name("released"), expression.Value("1")),
+		),
+	)
+	if err := update(&owned, expression.
+		Set(expression.Name("doc.inner"), expression.Value("v")).
+		Set(expression.Name("count"), expression.Name("count").Plus(expression.Value(3))).
+		Add(expression.Name("tags"), expression.Value(&types.AttributeValueMemberSS{Value: []string{"x", "y"}})).
+		Remove(expression.Name("missing"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := update(nil, expression.Delete(expression.Name("tags"), expression.Value(&types.AttributeValueMemberSS{Value: []string{"x"}}))); err != nil {
+		t.Fatal(err)
+	}
+	row := svc.row("locksMemory", "k")
+	if readStringAttr(row["owner"]) != "a" || readInt64Attr(row["count"]) != 5 {
+		t.Fatal("unexpected row:", row)
+	}
+	if doc, _ := row["doc"].(*types.AttributeValueMemberM); doc == nil || readStringAttr(doc.Value["inner"]) != "v" {
+		t.Fatal("nested attribute was not set:", row["doc"])
+	}
+	if tags, _ := row["tags"].(*types.AttributeValueMemberSS); tags == nil || len(tags.Value) != 1 || tags.Value[0] != "y" {
+		t.Fatal("set was not updated:", row["tags"])
+	}
+	if err := update(nil, expression.Set(expression.Name("a.b"), expression.Value("v"))); err == nil {
+		t.Fatal("setting a nested attribute of a missing map should fail")
+	}
+
+	_, err := svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 table,
+		Item:                      map[string]types.AttributeValue{"key": stringAttrValue("k")},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":unused": stringAttrValue("v")},
+	})
+	if err == nil {
+		t.Fatal("unused placeholders should be rejected")
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		svc.putRow("locksMemory", map[string]types.AttributeValue{"key": stringAttrValue(k), "owner": stringAttrValue("z")})
+	}
+	filter := build(expression.NewBuilder().WithFilter(expression.Equal(expression.Name("owner"), expression.Value("z"))))
+	var (
+		seen     []string
+		startKey map[string]types.AttributeValue
+	)
+	for {
+		res, err := svc.Scan(ctx, &dynamodb.ScanInput{
+			TableName:                 table,
+			FilterExpression:          filter.Filter(),
+			ExpressionAttributeNames:  filter.Names(),
+			ExpressionAttributeValues: filter.Values(),
+			ExclusiveStartKey:         startKey,
+			Limit:                     aws.Int32(2),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range res.Items {
+			seen = append(seen, readStringAttr(item["key"]))
+		}
+		if startKey = res.LastEvaluatedKey; startKey == nil {
+			break
+		}
+	}
+	if !reflect.DeepEqual(seen, []string{"a", "b", "c"}) {
+		t.Fatal("unexpected scan:", seen)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// CallInfo describes a DynamoDB call observed by an instrumented client.
+type CallInfo struct {
+	// Operation is the name of the DynamoDB API, for example "GetItem".
+	Operation string
+	// TableName is the table the call was made to.
+	TableName string
+	// Latency is how long the call took.
+	Latency time.Duration
+	// Err is the error the call failed with, if any.
+	Err error
+	// Retry is how many times the lock client already tried the work this
+	// call is part of, after transient failures. It is zero for first
+	// tries.
+	Retry int
+}
+
+// InstrumentationHooks are the callbacks of an instrumented client. All of
+// them are optional, and they are called synchronously, so they must not
+// block.
+type InstrumentationHooks struct {
+	// OnCall is called after every call.
+	OnCall func(CallInfo)
+	// OnError is called after every failed call.
+	OnError func(CallInfo)
+	// OnRetry is called before every call that retries failed work.
+	OnRetry func(CallInfo)
+}
+
+// WrapWithInstrumentation returns a DynamoDBClient that reports the latency,
+// errors and retries of every call made through it to hooks, so the calls of
+// the lock client can be observed without writing a middleware (see
+// WithMiddleware). Retries made by the AWS SDK itself happen within a single
+// call, so they are part of its latency; only the retries of the lock client,
+// like the ones of transient acquisition or release failures, are reported.
+func WrapWithInstrumentation(client DynamoDBClient, hooks InstrumentationHooks) DynamoDBClient {
+	return newMiddlewareDynamoDBClient(client, []func(Operation) Operation{hooks.middleware})
+}
+
+func (h InstrumentationHooks) middleware(next Operation) Operation {
+	return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		info := CallInfo{
+			Operation: name,
+			TableName: tableNameOf(input),
+			Retry:     retryAttempt(ctx),
+		}
+		if info.Retry > 0 && h.OnRetry != nil {
+			h.OnRetry(info)
+		}
+		start := time.Now()
+		out, err := next(ctx, name, input)
+		info.Latency = time.Since(start)
+		info.Err = err
+		if h.OnCall != nil {
+			h.OnCall(info)
+		}
+		if err != nil && h.OnError != nil {
+			h.OnError(info)
+		}
+		return out, err
+	}
+}
+
+func tableNameOf(input interface{}) string {
+	switch in := input.(type) {
+	case *dynamodb.GetItemInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.PutItemInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.UpdateItemInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.DeleteItemInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.CreateTableInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.ScanInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.QueryInput:
+		return aws.ToString(in.TableName)
+	case *dynamodb.DescribeTableInput:
+		return aws.ToString(in.TableName)
+	}
+	return ""
+}
+
+type retryAttemptKey struct{}
+
+// withRetryAttempt marks the calls made with the returned context as the
+// given retry of failed work.
+func withRetryAttempt(ctx context.Context, attempt int) context.Context {
+	if attempt <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, retryAttemptKey{}, attempt)
+}
+
+func retryAttempt(ctx context.Context) int {
+	attempt, _ := ctx.Value(retryAttemptKey{}).(int)
+	return attempt
+}
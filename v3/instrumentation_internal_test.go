@@ -0,0 +1,73 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type throttledOnceDynamoDBClient struct {
+	mockDynamoDBClient
+	mu    sync.Mutex
+	reads int
+}
+
+func (m *throttledOnceDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reads++
+	if m.reads == 1 {
+		return nil, &types.ProvisionedThroughputExceededException{}
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func TestWrapWithInstrumentation(t *testing.T) {
+	var calls, errs, retries []CallInfo
+	svc := WrapWithInstrumentation(&throttledOnceDynamoDBClient{}, InstrumentationHooks{
+		OnCall:  func(info CallInfo) { calls = append(calls, info) },
+		OnError: func(info CallInfo) { errs = append(errs, info) },
+		OnRetry: func(info CallInfo) { retries = append(retries, info) },
+	})
+	c, err := New(svc, "locksInstrumented", "key", DisableHeartbeat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "instrumented"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 3 || calls[0].Operation != "GetItem" || calls[2].Operation != "PutItem" {
+		t.Fatalf("unexpected calls: %#v", calls)
+	}
+	for _, call := range calls {
+		if call.TableName != "locksInstrumented" || call.Latency <= 0 {
+			t.Fatalf("unexpected call: %#v", call)
+		}
+	}
+	if len(errs) != 1 || errs[0].Operation != "GetItem" || errs[0].Err == nil || errs[0].Retry != 0 {
+		t.Fatalf("unexpected errors: %#v", errs)
+	}
+	if len(retries) != 2 || retries[0].Retry != 1 || retries[0].Operation != "GetItem" {
+		t.Fatalf("unexpected retries: %#v", retries)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	keySegmentSeparator = "/"
+	keyKindSeparator    = "="
+)
+
+var (
+	keyEscaper   = strings.NewReplacer("%", "%25", keySegmentSeparator, "%2F", keyKindSeparator, "%3D")
+	keyUnescaper = strings.NewReplacer("%2F", keySegmentSeparator, "%3D", keyKindSeparator, "%25", "%")
+)
+
+type keySegment struct {
+	kind string
+	id   string
+}
+
+func (s keySegment) String() string {
+	return keyEscaper.Replace(s.kind) + keyKindSeparator + keyEscaper.Replace(s.id)
+}
+
+// KeyBuilder composes hierarchical lock identifiers in a standard encoding, so
+// different teams sharing a lock table do not come up with incompatible
+// schemes. Each level is a kind and an identifier pair, for example:
+//
+//	k := dynamolock.Key("tenant", tenantID).Sub("resource", name)
+//	lock, err := client.AcquireLock(ctx, k.String())
+//
+// With a ClientWithSortKey, the first level is used as the partition key and
+// the remaining levels as the sort key:
+//
+//	lock, err := client.AcquireLock(ctx, k.PartitionKey(), k.SortKey())
+//
+// KeyBuilder values are immutable; Sub returns a new one.
+type KeyBuilder struct {
+	segments []keySegment
+}
+
+// Key starts a new hierarchical identifier.
+func Key(kind, id string) KeyBuilder {
+	return KeyBuilder{segments: []keySegment{{kind: kind, id: id}}}
+}
+
+// Sub appends a nested level to the identifier.
+func (k KeyBuilder) Sub(kind, id string) KeyBuilder {
+	segments := make([]keySegment, len(k.segments), len(k.segments)+1)
+	copy(segments, k.segments)
+	return KeyBuilder{segments: append(segments, keySegment{kind: kind, id: id})}
+}
+
+// String returns the full encoded identifier, suitable as the partition key of
+// a Client.
+func (k KeyBuilder) String() string {
+	return encodeKeySegments(k.segments)
+}
+
+// PartitionKey returns the encoded first level of the identifier.
+func (k KeyBuilder) PartitionKey() string {
+	if len(k.segments) == 0 {
+		return ""
+	}
+	return k.segments[0].String()
+}
+
+// SortKey returns the encoded nested levels of the identifier. It is empty if
+// the identifier has a single level.
+func (k KeyBuilder) SortKey() string {
+	if len(k.segments) < 2 {
+		return ""
+	}
+	return encodeKeySegments(k.segments[1:])
+}
+
+// SortKeyPrefix returns the prefix shared by the sort keys of all identifiers
+// nested under this one. It can be used with WithSortKeyPrefix to list them.
+func (k KeyBuilder) SortKeyPrefix() string {
+	if sk := k.SortKey(); sk != "" {
+		return sk + keySegmentSeparator
+	}
+	return ""
+}
+
+func encodeKeySegments(segments []keySegment) string {
+	encoded := make([]string, len(segments))
+	for i, s := range segments {
+		encoded[i] = s.String()
+	}
+	return strings.Join(encoded, keySegmentSeparator)
+}
+
+// ParseKey decodes an identifier produced by KeyBuilder.String. For locks
+// stored with a sort key, join both parts with a "/" before parsing.
+func ParseKey(s string) (KeyBuilder, error) {
+	var k KeyBuilder
+	for _, raw := range strings.Split(s, keySegmentSeparator) {
+		parts := strings.Split(raw, keyKindSeparator)
+		if len(parts) != 2 {
+			return KeyBuilder{}, fmt.Errorf("invalid key segment: %q", raw)
+		}
+		k.segments = append(k.segments, keySegment{
+			kind: keyUnescaper.Replace(parts[0]),
+			id:   keyUnescaper.Replace(parts[1]),
+		})
+	}
+	return k, nil
+}
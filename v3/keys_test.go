@@ -0,0 +1,60 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock_test
+
+import (
+	"testing"
+
+	"cirello.io/dynamolock/v3"
+)
+
+func TestKeyBuilder(t *testing.T) {
+	t.Parallel()
+	tenant := dynamolock.Key("tenant", "acme")
+	k := tenant.Sub("resource", "db/main").Sub("shard", "1")
+	other := tenant.Sub("resource", "queue")
+
+	if got, want := k.String(), "tenant=acme/resource=db%2Fmain/shard=1"; got != want {
+		t.Errorf("unexpected encoding, got %q, want %q", got, want)
+	}
+	if got, want := other.String(), "tenant=acme/resource=queue"; got != want {
+		t.Errorf("Sub must not alias the parent, got %q, want %q", got, want)
+	}
+	if got, want := k.PartitionKey(), "tenant=acme"; got != want {
+		t.Errorf("unexpected partition key, got %q, want %q", got, want)
+	}
+	if got, want := k.SortKey(), "resource=db%2Fmain/shard=1"; got != want {
+		t.Errorf("unexpected sort key, got %q, want %q", got, want)
+	}
+	if got, want := other.SortKeyPrefix(), "resource=queue/"; got != want {
+		t.Errorf("unexpected sort key prefix, got %q, want %q", got, want)
+	}
+	if tenant.SortKey() != "" || tenant.SortKeyPrefix() != "" {
+		t.Error("single level keys should not have sort keys")
+	}
+
+	parsed, err := dynamolock.ParseKey(k.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != k.String() {
+		t.Errorf("round trip failed, got %q, want %q", parsed.String(), k.String())
+	}
+	if _, err := dynamolock.ParseKey("tenant"); err == nil {
+		t.Error("expected error missing")
+	}
+}
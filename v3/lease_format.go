@@ -0,0 +1,85 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LeaseDurationFormat defines how the lease duration is stored in the lock
+// rows.
+type LeaseDurationFormat int
+
+// Formats of the lease duration attribute.
+const (
+	// LeaseDurationString stores the lease duration as a Go duration
+	// string, like "20s". This is the default.
+	LeaseDurationString LeaseDurationFormat = iota
+	// LeaseDurationMillis stores the lease duration as a number of
+	// milliseconds, which non-Go consumers and filter expressions can
+	// interpret.
+	LeaseDurationMillis
+)
+
+// WithLeaseDurationFormat defines how the lease duration is written to the
+// lock rows. Both formats are always read, so clients using different formats
+// can share a table while it is being migrated. It cannot be used with
+// WithV2Compatibility, as dynamolock v2 only reads duration strings.
+func WithLeaseDurationFormat(format LeaseDurationFormat) ClientOption {
+	return func(c *commonClient) { c.leaseDurationFormat = format }
+}
+
+// leaseDurationValue is the lease duration as stored in the lock rows, to be
+// used in expressions.
+func (c *commonClient) leaseDurationValue(d time.Duration) interface{} {
+	if c.leaseDurationFormat == LeaseDurationMillis {
+		return int64(d / time.Millisecond)
+	}
+	return d.String()
+}
+
+func (c *commonClient) leaseDurationAttrValue(d time.Duration) types.AttributeValue {
+	if c.leaseDurationFormat == LeaseDurationMillis {
+		return int64AttrValue(int64(d / time.Millisecond))
+	}
+	return stringAttrValue(d.String())
+}
+
+// readLeaseDuration parses a lease duration stored in either format. Missing
+// lease durations are zero.
+func readLeaseDuration(attr types.AttributeValue) (time.Duration, error) {
+	switch v := attr.(type) {
+	case *types.AttributeValueMemberS:
+		if v.Value == "" {
+			return 0, nil
+		}
+		return time.ParseDuration(v.Value)
+	case *types.AttributeValueMemberN:
+		ms, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected attribute type %T", attr)
+}
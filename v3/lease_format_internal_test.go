@@ -0,0 +1,63 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestLeaseDurationFormat(t *testing.T) {
+	if _, err := New(&mockDynamoDBClient{}, "locksLeaseFormat", "key", WithV2Compatibility(), WithLeaseDurationFormat(LeaseDurationMillis)); err == nil {
+		t.Fatal("v2 compatible clients must store lease durations as strings")
+	}
+
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksLeaseFormat", "key",
+		DisableHeartbeat(),
+		WithLeaseDuration(1500*time.Millisecond),
+		WithLeaseDurationFormat(LeaseDurationMillis),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AcquireLock(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := svc.putInputs()[0].Item[attrLeaseDuration].(*types.AttributeValueMemberN); !ok || n.Value != "1500" {
+		t.Fatalf("lease duration not stored in milliseconds: %#v", svc.putInputs()[0].Item[attrLeaseDuration])
+	}
+
+	for _, attr := range []types.AttributeValue{stringAttrValue("1.5s"), int64AttrValue(1500)} {
+		l, err := c.createLockItem(getLockOptions{partitionKey: "key"}, map[string]types.AttributeValue{
+			"key":             stringAttrValue("key"),
+			attrLeaseDuration: attr,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.LeaseDuration() != 1500*time.Millisecond {
+			t.Fatalf("unexpected lease duration read from %#v: %s", attr, l.LeaseDuration())
+		}
+	}
+	if s := c.snapshotFromItem(map[string]types.AttributeValue{"key": stringAttrValue("key"), attrLeaseDuration: int64AttrValue(1500)}); s.LeaseDuration != "1.5s" {
+		t.Fatal("snapshots should hold duration strings:", s.LeaseDuration)
+	}
+}
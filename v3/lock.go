@@ -27,23 +27,142 @@ import (
 
 type releaseLockCallback func(context.Context, *Lock) error
 
+type refreshLockCallback func(context.Context, *Lock) error
+
+type handoffCallback func(context.Context, *Lock, string) error
+
 // Lock item properly speaking.
 type Lock struct {
 	semaphore sync.Mutex
 
 	releaseLock  releaseLockCallback
+	refreshLock  refreshLockCallback
+	handoff      handoffCallback
+	tableName    string
 	partitionKey string
+	sortKey      string
 
 	data                []byte
 	ownerName           string
 	deleteLockOnRelease bool
 	isReleased          bool
 	sessionMonitor      *sessionMonitor
+	clock               Clock
+	serializer          Serializer
 
 	lookupTime           time.Time
 	recordVersionNumber  string
 	leaseDuration        time.Duration
 	additionalAttributes map[string]types.AttributeValue
+	// expiresAt is the expiration written by the owner of the lock row,
+	// zero if unknown.
+	expiresAt time.Time
+
+	acquisition AcquisitionInfo
+
+	priority           int64
+	preemptionRequest  *PreemptionRequest
+	preemptionCallback func(*Lock, PreemptionRequest)
+	preemptionNotified bool
+	preemptionDeadline time.Time
+	waiters            int64
+	inbox              map[string]types.AttributeValue
+	persistentStats    PersistentStats
+	dataHistory        [][]byte
+	acquisitionToken   string
+	handoffTo          string
+	// sessionMonitorRun tells whether Maintain already ran the session
+	// monitor callback.
+	sessionMonitorRun bool
+	nonCritical       bool
+	// delegated tells whether the heartbeats of the lock are sent by a
+	// HeartbeatDelegate instead of this client.
+	delegated bool
+	// inFailoverStandby tells whether the lock is held in the standby
+	// table of a FailoverClient.
+	inFailoverStandby bool
+
+	ownershipLostCallback func(*Lock, error)
+
+	intentOwner    string
+	intentCallback func(*Lock, string)
+	intentNotified string
+
+	releaseRequest         *ReleaseRequest
+	releaseRequestCallback func(*Lock, ReleaseRequest)
+	releaseRequestNotified ReleaseRequest
+
+	leaseExtender    func() time.Duration
+	maxLeaseDuration time.Duration
+
+	done         <-chan struct{}
+	doneAt       time.Time
+	idleReported bool
+
+	acquiredAt       time.Time
+	longHoldReported bool
+
+	values    context.Context
+	extraRefs int
+}
+
+// AcquisitionKind describes the state of the lock row at the moment it was
+// acquired.
+type AcquisitionKind int
+
+// Possible states of the lock row when it was acquired.
+const (
+	// AcquisitionUnknown means the lock was not acquired by this client,
+	// for example, when it was read with Get.
+	AcquisitionUnknown AcquisitionKind = iota
+	// AcquisitionFresh means there was no lock row in the table.
+	AcquisitionFresh
+	// AcquisitionReleased means the lock row existed but had been released
+	// by its previous owner.
+	AcquisitionReleased
+	// AcquisitionExpired means the lock was taken over from an owner that
+	// stopped heartbeating it.
+	AcquisitionExpired
+	// AcquisitionAdvisory means the lock was recorded over the row of
+	// another owner, without waiting for it, as allowed by Advisory.
+	AcquisitionAdvisory
+	// AcquisitionHandoff means the lock was handed over by its previous
+	// owner with Lock.Handoff.
+	AcquisitionHandoff
+)
+
+func (k AcquisitionKind) String() string {
+	switch k {
+	case AcquisitionFresh:
+		return "fresh"
+	case AcquisitionReleased:
+		return "released"
+	case AcquisitionExpired:
+		return "expired"
+	case AcquisitionAdvisory:
+		return "advisory"
+	case AcquisitionHandoff:
+		return "handoff"
+	default:
+		return "unknown"
+	}
+}
+
+// AcquisitionInfo holds diagnostic information about how a lock was acquired.
+type AcquisitionInfo struct {
+	// Attempts is the number of times the client tried to store the lock.
+	Attempts int
+	// WaitTime is how long AcquireLock took to return the lock.
+	WaitTime time.Duration
+	// Kind indicates the state of the lock row prior to the acquisition.
+	Kind AcquisitionKind
+	// PreemptedOwner is the owner whose lock was taken over after it was
+	// asked to release it with RequestPreemption or WithPreemptionNotice.
+	// It is empty if the lock was not preempted.
+	PreemptedOwner string
+	// HandoffFrom is the owner that handed the lock over with Lock.Handoff.
+	// It is empty if the lock was not handed over.
+	HandoffFrom string
 }
 
 // Data returns the content of the lock, if any is available.
@@ -51,6 +170,8 @@ func (l *Lock) Data() []byte {
 	if l == nil {
 		return nil
 	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
 	return l.data
 }
 
@@ -65,11 +186,65 @@ func (l *Lock) Close() error {
 	return ErrCannotReleaseNullLock
 }
 
-func (l *Lock) uniqueIdentifier() string {
+// Refresh re-reads the lock from DynamoDB and re-synchronizes the local record
+// version number, lease duration and lookup time with what is stored in the
+// table. It is useful after network partitions or when the lock handle has
+// been idle for a while. It returns ErrLockAlreadyReleased if the lock is gone
+// or was released, and ErrOwnerMismatched if someone else took it over. The
+// given context is passed down to the underlying dynamoDB call.
+func (l *Lock) Refresh(ctx context.Context) error {
+	if l == nil || l.refreshLock == nil {
+		return ErrCannotRefreshNullLock
+	}
+	return l.refreshLock(ctx, l)
+}
+
+// Handoff hands the lock over to the client with the given owner name, which
+// acquires it as soon as it notices, instead of waiting for the lease to run
+// out. Calling Handoff confirms that the current holder is quiescent: the lock
+// is no longer heartbeated nor released by this client, and it is reported as
+// expired. If the successor does not show up, the lock expires as if this
+// client had stopped. It avoids the leadership gap of planned deploys. The
+// given context is passed down to the underlying dynamoDB call.
+func (l *Lock) Handoff(ctx context.Context, successorOwner string) error {
+	if l == nil || l.handoff == nil {
+		return ErrCannotHandoffNullLock
+	}
+	return l.handoff(ctx, l, successorOwner)
+}
+
+// lockKey identifies a lock within the client's local cache.
+type lockKey struct {
+	// tableName is empty for the table of the client.
+	tableName    string
+	partitionKey string
+	sortKey      string
+}
+
+func (l *Lock) uniqueIdentifier() lockKey {
+	return lockKey{tableName: l.tableName, partitionKey: l.partitionKey, sortKey: l.sortKey}
+}
+
+// PartitionKey returns the partition key of the lock.
+func (l *Lock) PartitionKey() string {
+	if l == nil {
+		return ""
+	}
 	return l.partitionKey
 }
 
-// IsExpired returns if the lock is expired, released, or neither.
+// SortKey returns the sort key of the lock. It is empty for locks managed by
+// a Client without sort key.
+func (l *Lock) SortKey() string {
+	if l == nil {
+		return ""
+	}
+	return l.sortKey
+}
+
+// IsExpired returns if the lock is expired, released, or neither. A lock whose
+// heartbeats are delegated is not considered expired, as its lease is kept by
+// the delegate.
 func (l *Lock) IsExpired() bool {
 	if l == nil {
 		return true
@@ -87,7 +262,23 @@ func (l *Lock) isExpired() bool {
 	if l.isReleased {
 		return true
 	}
-	return time.Since(l.lookupTime) > l.leaseDuration
+	if l.delegated {
+		return false
+	}
+	return l.now().Sub(l.lookupTime) > l.leaseDuration
+}
+
+// isExpiredWithGrace reports whether the lock is expired once the grace
+// period is added to its lease duration.
+func (l *Lock) isExpiredWithGrace(grace time.Duration) bool {
+	if l == nil {
+		return true
+	}
+
+	if l.isReleased {
+		return true
+	}
+	return l.now().Sub(l.lookupTime) > l.leaseDuration+grace
 }
 
 func (l *Lock) updateRVN(rvn string, lastUpdate time.Time, leaseDuration time.Duration) {
@@ -104,6 +295,65 @@ func (l *Lock) OwnerName() string {
 	return l.ownerName
 }
 
+// RecordVersionNumber returns the record version number of the lock as last
+// seen by this client. It can be used as a fencing token.
+func (l *Lock) RecordVersionNumber() string {
+	if l == nil {
+		return ""
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.recordVersionNumber
+}
+
+// LeaseDuration returns the lease duration of the lock as last seen by this
+// client.
+func (l *Lock) LeaseDuration() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.leaseDuration
+}
+
+// LastHeartbeat returns the last time this client observed the lock being
+// refreshed, either by acquiring it, sending a heartbeat or reading it from
+// DynamoDB.
+func (l *Lock) LastHeartbeat() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.lookupTime
+}
+
+// ExpiresAt returns the moment in which the lock lease is expected to expire,
+// according to this client's view of the lock. Released locks return the zero
+// time.
+func (l *Lock) ExpiresAt() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	if l.isReleased {
+		return time.Time{}
+	}
+	return l.lookupTime.Add(l.leaseDuration)
+}
+
+// Acquisition returns diagnostic information about how this lock was acquired.
+func (l *Lock) Acquisition() AcquisitionInfo {
+	if l == nil {
+		return AcquisitionInfo{}
+	}
+	l.semaphore.Lock()
+	defer l.semaphore.Unlock()
+	return l.acquisition
+}
+
 // AdditionalAttributes returns the lock's additional data stored during
 // acquisition.
 func (l *Lock) AdditionalAttributes() map[string]types.AttributeValue {
@@ -135,6 +385,8 @@ var (
 	ErrSessionMonitorNotSet  = errors.New("session monitor is not set")
 	ErrLockAlreadyReleased   = errors.New("lock is already released")
 	ErrCannotReleaseNullLock = errors.New("cannot release null lock item")
+	ErrCannotRefreshNullLock = errors.New("cannot refresh null lock item")
+	ErrCannotHandoffNullLock = errors.New("cannot hand off null lock item")
 	ErrOwnerMismatched       = errors.New("lock owner mismatched")
 )
 
@@ -148,5 +400,13 @@ func (l *Lock) timeUntilDangerZoneEntered() (time.Duration, error) {
 	if l.IsExpired() {
 		return 0, ErrLockAlreadyReleased
 	}
-	return l.sessionMonitor.timeUntilLeaseEntersDangerZone(l.lookupTime), nil
+	l.semaphore.Lock()
+	lookupTime, now := l.lookupTime, l.now()
+	if l.delegated {
+		// The heartbeats of the delegate are not seen here: as far as
+		// the session monitor goes, the lease was just renewed.
+		lookupTime = now
+	}
+	l.semaphore.Unlock()
+	return l.sessionMonitor.timeUntilLeaseEntersDangerZone(lookupTime, now), nil
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package dynamolock_test
 
 import (
+	"context"
 	"testing"
 
 	"cirello.io/dynamolock/v3"
@@ -34,9 +35,21 @@ func TestNilLock(t *testing.T) {
 	if l.OwnerName() != "" {
 		t.Fatal("nil locks should report no owner")
 	}
+	if l.RecordVersionNumber() != "" {
+		t.Fatal("nil locks should report no record version number")
+	}
+	if l.LeaseDuration() != 0 {
+		t.Fatal("nil locks should report no lease duration")
+	}
+	if !l.ExpiresAt().IsZero() || !l.LastHeartbeat().IsZero() {
+		t.Fatal("nil locks should report zero times")
+	}
 	if _, err := l.IsAlmostExpired(); err != dynamolock.ErrLockAlreadyReleased {
 		t.Fatal("nil locks should report error on testing for closing expiration")
 	}
+	if err := l.Refresh(context.Background()); err != dynamolock.ErrCannotRefreshNullLock {
+		t.Fatal("nil locks should not be refreshable")
+	}
 	l.Close()
 }
 
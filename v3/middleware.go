@@ -0,0 +1,140 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Operation is a DynamoDB call made by the client. name is the name of the
+// DynamoDB API (for example, "GetItem" or "PutItem"), input is the pointer to
+// its input structure (for example, *dynamodb.GetItemInput) and the returned
+// value must be the pointer to its output structure (for example,
+// *dynamodb.GetItemOutput).
+type Operation func(ctx context.Context, name string, input interface{}) (interface{}, error)
+
+// WithMiddleware wraps every DynamoDB call made by the client with mw, so
+// cross-cutting concerns (metrics, retries, capacity accounting, logging) can
+// be implemented without wrapping the SDK client. Middlewares are applied in
+// the order they are given: the first one is the outermost.
+func WithMiddleware(mw func(next Operation) Operation) ClientOption {
+	return func(c *commonClient) { c.middlewares = append(c.middlewares, mw) }
+}
+
+type optFnsKey struct{}
+
+// middlewareDynamoDBClient routes all the calls through the chain of
+// middlewares before reaching the underlying DynamoDB client.
+type middlewareDynamoDBClient struct {
+	op Operation
+}
+
+func newMiddlewareDynamoDBClient(base DynamoDBClient, middlewares []func(Operation) Operation) *middlewareDynamoDBClient {
+	op := func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		optFns, _ := ctx.Value(optFnsKey{}).([]func(*dynamodb.Options))
+		switch in := input.(type) {
+		case *dynamodb.GetItemInput:
+			return base.GetItem(ctx, in, optFns...)
+		case *dynamodb.PutItemInput:
+			return base.PutItem(ctx, in, optFns...)
+		case *dynamodb.UpdateItemInput:
+			return base.UpdateItem(ctx, in, optFns...)
+		case *dynamodb.DeleteItemInput:
+			return base.DeleteItem(ctx, in, optFns...)
+		case *dynamodb.CreateTableInput:
+			return base.CreateTable(ctx, in, optFns...)
+		case *dynamodb.ScanInput:
+			if s, ok := base.(scanClient); ok {
+				return s.Scan(ctx, in, optFns...)
+			}
+			return nil, unsupportedOperation(name, base)
+		case *dynamodb.QueryInput:
+			if q, ok := base.(queryClient); ok {
+				return q.Query(ctx, in, optFns...)
+			}
+			return nil, unsupportedOperation(name, base)
+		case *dynamodb.DescribeTableInput:
+			if d, ok := base.(describeTableClient); ok {
+				return d.DescribeTable(ctx, in, optFns...)
+			}
+			return nil, unsupportedOperation(name, base)
+		}
+		return nil, fmt.Errorf("unsupported operation %s (%T)", name, input)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		op = middlewares[i](op)
+	}
+	return &middlewareDynamoDBClient{op: op}
+}
+
+func (m *middlewareDynamoDBClient) call(ctx context.Context, name string, input interface{}, optFns []func(*dynamodb.Options)) (interface{}, error) {
+	if len(optFns) > 0 {
+		ctx = context.WithValue(ctx, optFnsKey{}, optFns)
+	}
+	return m.op(ctx, name, input)
+}
+
+func (m *middlewareDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	out, err := m.call(ctx, "GetItem", params, optFns)
+	o, _ := out.(*dynamodb.GetItemOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	out, err := m.call(ctx, "PutItem", params, optFns)
+	o, _ := out.(*dynamodb.PutItemOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	out, err := m.call(ctx, "UpdateItem", params, optFns)
+	o, _ := out.(*dynamodb.UpdateItemOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	out, err := m.call(ctx, "DeleteItem", params, optFns)
+	o, _ := out.(*dynamodb.DeleteItemOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	out, err := m.call(ctx, "CreateTable", params, optFns)
+	o, _ := out.(*dynamodb.CreateTableOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	out, err := m.call(ctx, "Scan", params, optFns)
+	o, _ := out.(*dynamodb.ScanOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	out, err := m.call(ctx, "Query", params, optFns)
+	o, _ := out.(*dynamodb.QueryOutput)
+	return o, err
+}
+
+func (m *middlewareDynamoDBClient) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	out, err := m.call(ctx, "DescribeTable", params, optFns)
+	o, _ := out.(*dynamodb.DescribeTableOutput)
+	return o, err
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	record := func(label string) func(Operation) Operation {
+		return func(next Operation) Operation {
+			return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+				mu.Lock()
+				calls = append(calls, label+":"+name)
+				mu.Unlock()
+				return next(ctx, name, input)
+			}
+		}
+	}
+	c, err := New(&mockDynamoDBClient{}, "locksMiddleware", "key",
+		DisableHeartbeat(),
+		WithMiddleware(record("outer")),
+		WithMiddleware(record("inner")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	l, err := c.AcquireLock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"outer:GetItem", "inner:GetItem",
+		"outer:PutItem", "inner:PutItem",
+		"outer:UpdateItem", "inner:UpdateItem",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("unexpected calls:\ngot  %v\nwant %v", calls, want)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WithReleasedMarker changes how released locks are marked in the lock rows,
+// so the client can share tables with other tooling that uses a different
+// marker. By default, released locks have the isReleased attribute set to the
+// string "1". value must be a string, a bool or an int; for example,
+// WithReleasedMarker("released", true) stores released locks with a boolean
+// released attribute. A lock row is only considered released if the attribute
+// holds exactly the given value. It cannot be used with WithV2Compatibility.
+func WithReleasedMarker(attribute string, value interface{}) ClientOption {
+	return func(c *commonClient) {
+		c.releasedAttribute = attribute
+		c.releasedValue = value
+	}
+}
+
+// releasedMarkerAttrValue converts the value of the released marker to the
+// attribute value stored in the lock rows.
+func releasedMarkerAttrValue(value interface{}) (types.AttributeValue, error) {
+	switch v := value.(type) {
+	case string:
+		return stringAttrValue(v), nil
+	case bool:
+		return &types.AttributeValueMemberBOOL{Value: v}, nil
+	case int:
+		return &types.AttributeValueMemberN{Value: strconv.Itoa(v)}, nil
+	}
+	return nil, fmt.Errorf("released marker must be a string, a bool or an int, got %T", value)
+}
+
+// releasedMarker returns the attribute and value of the released marker,
+// falling back to the default one for clients built without New.
+func (c *commonClient) releasedMarker() (string, types.AttributeValue) {
+	if c.releasedAttribute == "" || c.releasedAttrValue == nil {
+		return attrIsReleased, stringAttrValue("1")
+	}
+	return c.releasedAttribute, c.releasedAttrValue
+}
+
+// isReleasedItem reports whether the lock row carries the released marker.
+func (c *commonClient) isReleasedItem(item map[string]types.AttributeValue) bool {
+	attribute, marker := c.releasedMarker()
+	switch v := item[attribute].(type) {
+	case *types.AttributeValueMemberS:
+		m, ok := marker.(*types.AttributeValueMemberS)
+		return ok && m.Value == v.Value
+	case *types.AttributeValueMemberN:
+		m, ok := marker.(*types.AttributeValueMemberN)
+		return ok && m.Value == v.Value
+	case *types.AttributeValueMemberBOOL:
+		m, ok := marker.(*types.AttributeValueMemberBOOL)
+		return ok && m.Value == v.Value
+	}
+	return false
+}
+
+// newOrReleasedLockCondition is NewOrReleasedLockCondition with the released
+// marker of the client.
+func (c *commonClient) newOrReleasedLockCondition() expression.ConditionBuilder {
+	return expression.Or(
+		expression.AttributeNotExists(expression.Name(c.partitionKeyName)),
+		expression.And(
+			expression.AttributeExists(expression.Name(c.partitionKeyName)),
+			expression.Equal(expression.Name(c.releasedAttribute), expression.Value(c.releasedValue)),
+		),
+	)
+}
+
+func (c *commonClient) releasedMarkerUpdate() expression.UpdateBuilder {
+	return expression.Set(expression.Name(c.releasedAttribute), expression.Value(c.releasedValue))
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestReleasedMarker(t *testing.T) {
+	if _, err := New(&mockDynamoDBClient{}, "locksReleasedMarker", "key", WithReleasedMarker("released", 1.5)); err == nil {
+		t.Fatal("unsupported marker values should be rejected")
+	}
+	if _, err := New(&mockDynamoDBClient{}, "locksReleasedMarker", "key", WithV2Compatibility(), WithReleasedMarker("released", true)); err == nil {
+		t.Fatal("v2 compatible clients must use the default released marker")
+	}
+
+	svc := newMemoryDynamoDBClient()
+	c, err := New(svc, "locksReleasedMarker", "key", DisableHeartbeat(), WithReleasedMarker("released", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		attrs    map[string]types.AttributeValue
+		released bool
+	}{
+		{attrs: map[string]types.AttributeValue{"released": &types.AttributeValueMemberBOOL{Value: true}}, released: true},
+		{attrs: map[string]types.AttributeValue{"released": &types.AttributeValueMemberBOOL{Value: false}}},
+		{attrs: map[string]types.AttributeValue{attrIsReleased: stringAttrValue("1")}},
+	} {
+		item := map[string]types.AttributeValue{"key": stringAttrValue("key")}
+		for k, v := range tt.attrs {
+			item[k] = v
+		}
+		l, err := c.createLockItem(getLockOptions{partitionKey: "key"}, item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.isReleased != tt.released {
+			t.Fatalf("unexpected released state of %#v: %v", tt.attrs, l.isReleased)
+		}
+		if _, ok := l.AdditionalAttributes()["released"]; ok {
+			t.Fatal("released marker exposed as an additional attribute")
+		}
+	}
+
+	l, err := c.AcquireLock(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.updateInputs()) != 1 {
+		t.Fatal("unexpected updates:", len(svc.updateInputs()))
+	}
+	var marked bool
+	for _, v := range svc.updateInputs()[0].ExpressionAttributeValues {
+		if b, ok := v.(*types.AttributeValueMemberBOOL); ok && b.Value {
+			marked = true
+		}
+	}
+	if !marked {
+		t.Fatalf("release did not set the released marker: %#v", svc.updateInputs()[0].ExpressionAttributeValues)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// WithRVNGenerator replaces the generator of record version numbers. By
+// default, they are random alphanumeric strings. Use NewUUIDv7 to make record
+// version numbers double as sortable acquisition identifiers.
+func WithRVNGenerator(fn func() string) ClientOption {
+	return func(c *commonClient) { c.rvnGenerator = fn }
+}
+
+// WithRandSource replaces crypto/rand as the source of the random owner names
+// and record version numbers, so deterministic tests and environments with
+// restricted entropy can supply their own generator. If the source fails,
+// crypto/rand is used instead. The generator of WithRVNGenerator, if any,
+// takes precedence for record version numbers. It must be given before
+// WithOwnerNameTemplate for the random part of the template to be drawn from
+// it.
+func WithRandSource(source io.Reader) ClientOption {
+	return func(c *commonClient) { c.randSource = &lockedReader{r: source} }
+}
+
+// lockedReader serializes the reads from sources, like math/rand, that are not
+// safe for concurrent use.
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+func (c *commonClient) randString(n int) string {
+	if c.randSource == nil {
+		return randString(n)
+	}
+	return randStringFrom(c.randSource, n)
+}
+
+// NewUUIDv4 returns a random (version 4) UUID. It can be used with
+// WithRVNGenerator.
+func NewUUIDv4() string {
+	var u [16]byte
+	// ignoring error as the only possible error is for io.ReadFull
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return formatUUID(u)
+}
+
+// NewUUIDv7 returns a time-ordered (version 7) UUID, whose lexicographical
+// order follows the millisecond in which it was generated. It can be used
+// with WithRVNGenerator.
+func NewUUIDv7() string {
+	var u [16]byte
+	// ignoring error as the only possible error is for io.ReadFull
+	_, _ = rand.Read(u[6:])
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(u[:6], ts[2:])
+	u[6] = (u[6] & 0x0f) | 0x70
+	u[8] = (u[8] & 0x3f) | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock_test
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"cirello.io/dynamolock/v3"
+)
+
+func TestUUIDGenerators(t *testing.T) {
+	t.Parallel()
+	v4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	v7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if u := dynamolock.NewUUIDv4(); !v4.MatchString(u) {
+		t.Errorf("invalid UUIDv4: %s", u)
+	}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		u := dynamolock.NewUUIDv7()
+		if !v7.MatchString(u) {
+			t.Errorf("invalid UUIDv7: %s", u)
+		}
+		ids = append(ids, u)
+		time.Sleep(2 * time.Millisecond)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("UUIDv7 are not time ordered: %v", ids)
+	}
+}
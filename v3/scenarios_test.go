@@ -0,0 +1,112 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cirello.io/dynamolock/v3"
+	"cirello.io/dynamolock/v3/dynamolocktest"
+)
+
+// TestScenarios plays the clock-skew scenarios of dynamolocktest against two
+// clients sharing a lock table, and checks that the client behaves as each
+// scenario expects.
+func TestScenarios(t *testing.T) {
+	const lease = 10 * time.Second
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range dynamolocktest.Scenarios(lease) {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			svc := dynamolock.NewMemoryDynamoDBClient()
+			holderClock, observerClock := s.NewClocks(start)
+			newClient := func(ownerName string, clock dynamolock.Clock) *dynamolock.Client {
+				c, err := dynamolock.New(svc, "locksScenarios", "key",
+					dynamolock.WithLeaseDuration(s.Lease),
+					dynamolock.DisableHeartbeat(),
+					dynamolock.WithOwnerName(ownerName),
+					dynamolock.WithClock(clock),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return c
+			}
+			holder := newClient("holder", holderClock)
+			defer holder.Close(context.Background())
+			observer := newClient("observer", observerClock)
+			defer observer.Close(context.Background())
+
+			l, err := holder.AcquireLock(context.Background(), "scenario")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The observer waits for the lock in the background, from
+			// the moment it is observed until the takeover step tells
+			// whether it got it.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			observed := make(chan struct{})
+			acquired := make(chan error, 1)
+			out := s.Run(context.Background(), dynamolocktest.Participants{
+				HolderClock:   holderClock,
+				ObserverClock: observerClock,
+				Heartbeat: func(ctx context.Context) error {
+					return holder.SendHeartbeat(ctx, l)
+				},
+				Observe: func(context.Context) error {
+					var once sync.Once
+					go func() {
+						_, err := observer.AcquireLock(ctx, "scenario",
+							dynamolock.WithRefreshPeriod(time.Millisecond),
+							dynamolock.WithAdditionalTimeToWaitForLock(time.Hour),
+							dynamolock.WithOnWait(func(string, dynamolock.LockInfo, time.Duration) {
+								once.Do(func() { close(observed) })
+							}),
+						)
+						acquired <- err
+					}()
+					<-observed
+					return nil
+				},
+				Takeover: func(context.Context) error {
+					select {
+					case err := <-acquired:
+						return err
+					case <-time.After(100 * time.Millisecond):
+						cancel()
+						return <-acquired
+					}
+				},
+			})
+
+			if tookOver := out.TakeoverErr == nil; tookOver != s.TakeoverExpected {
+				t.Fatal("unexpected takeover outcome:", out.TakeoverErr)
+			}
+			if stillValid := !l.IsExpired(); stillValid != s.HolderStillValid {
+				t.Fatal("unexpected holder validity:", stillValid)
+			}
+			if s.TakeoverExpected && !s.HolderStillValid && out.HeartbeatErr == nil {
+				t.Fatal("the holder should find out that it lost the lock")
+			}
+		})
+	}
+}
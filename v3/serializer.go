@@ -0,0 +1,155 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Serializer encodes and decodes the data payload of the locks, when it is
+// given as a value with WithDataValue, ReplaceHeartbeatDataValue or
+// WithDataValueAfterRelease, and read with Lock.DecodeData.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// ContentType is the media type of the encoded data.
+	ContentType() string
+}
+
+// JSONSerializer encodes the lock data as JSON. It is the default serializer.
+type JSONSerializer struct{}
+
+// Marshal implements Serializer.
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Serializer.
+func (JSONSerializer) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ContentType implements Serializer.
+func (JSONSerializer) ContentType() string { return "application/json" }
+
+// GobSerializer encodes the lock data with encoding/gob. It is only suitable
+// when all the readers of the lock data are Go programs.
+type GobSerializer struct{}
+
+// Marshal implements Serializer.
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Serializer.
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ContentType implements Serializer.
+func (GobSerializer) ContentType() string { return "application/x-gob" }
+
+// ErrNotProtoMessage is returned by ProtoSerializer when the value does not
+// implement the required Marshal or Unmarshal method.
+var ErrNotProtoMessage = errors.New("value is not a protocol buffers message")
+
+// ProtoSerializer encodes the lock data as protocol buffers. The values must
+// implement Marshal() ([]byte, error) to be encoded and Unmarshal([]byte)
+// error to be decoded, as the messages generated by gogo/protobuf and
+// vtprotobuf do. It keeps the client free of a protocol buffers runtime
+// dependency; messages generated by google.golang.org/protobuf can be adapted
+// with a thin wrapper calling proto.Marshal and proto.Unmarshal.
+type ProtoSerializer struct{}
+
+// Marshal implements Serializer.
+func (ProtoSerializer) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(interface{ Marshal() ([]byte, error) })
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return m.Marshal()
+}
+
+// Unmarshal implements Serializer.
+func (ProtoSerializer) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(interface{ Unmarshal([]byte) error })
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return m.Unmarshal(data)
+}
+
+// ContentType implements Serializer.
+func (ProtoSerializer) ContentType() string { return "application/x-protobuf" }
+
+// WithSerializer replaces the serializer of the lock data. The default is
+// JSONSerializer.
+func WithSerializer(s Serializer) ClientOption {
+	return func(c *commonClient) { c.serializer = s }
+}
+
+// WithDataValue stores v, encoded by the client serializer, as the lock data.
+// It replaces WithData.
+func WithDataValue(v interface{}) AcquireLockOption {
+	return func(opt *acquireLockOptions) {
+		opt.dataValue = v
+	}
+}
+
+// ReplaceHeartbeatDataValue overrides the content of the Lock in the heartbeat
+// cycle with v, encoded by the client serializer.
+func ReplaceHeartbeatDataValue(v interface{}) SendHeartbeatOption {
+	return func(o *sendHeartbeatOptions) {
+		o.deleteData = false
+		o.dataValue = v
+	}
+}
+
+// WithDataValueAfterRelease is like WithDataAfterRelease, but the data is v,
+// encoded by the client serializer.
+func WithDataValueAfterRelease(v interface{}) ReleaseLockOption {
+	return func(opt *releaseLockOptions) {
+		opt.dataValue = v
+	}
+}
+
+// DecodeData decodes the lock data into v, with the serializer of the client
+// that read or acquired the lock.
+func (l *Lock) DecodeData(v interface{}) error {
+	if l == nil {
+		return ErrLockAlreadyReleased
+	}
+	l.semaphore.Lock()
+	data, s := l.data, l.serializer
+	l.semaphore.Unlock()
+	if s == nil {
+		s = JSONSerializer{}
+	}
+	return s.Unmarshal(data, v)
+}
+
+func (c *commonClient) marshalData(v interface{}) ([]byte, error) {
+	data, err := c.serializer.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal lock data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type progress struct {
+	Step  int
+	Owner string
+}
+
+type fakeProtoMessage struct {
+	payload string
+}
+
+func (m *fakeProtoMessage) Marshal() ([]byte, error) { return []byte(m.payload), nil }
+
+func (m *fakeProtoMessage) Unmarshal(b []byte) error {
+	m.payload = string(b)
+	return nil
+}
+
+func TestSerializers(t *testing.T) {
+	for _, s := range []Serializer{JSONSerializer{}, GobSerializer{}} {
+		t.Run(s.ContentType(), func(t *testing.T) {
+			svc := newMemoryDynamoDBClient()
+			c, err := New(svc, "locksSerializer", "key", DisableHeartbeat(), WithSerializer(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := progress{Step: 3, Owner: "worker"}
+			l, err := c.AcquireLock(context.Background(), "key", WithDataValue(want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got progress
+			if err := l.DecodeData(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected data: %#v", got)
+			}
+			if err := s.Unmarshal(readBytesAttr(svc.putInputs()[0].Item[attrData]), &got); err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatal("unexpected stored data:", got, err)
+			}
+
+			want.Step = 4
+			if err := c.SendHeartbeat(context.Background(), l, ReplaceHeartbeatDataValue(want)); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.DecodeData(&got); err != nil || !reflect.DeepEqual(got, want) {
+				t.Fatal("unexpected data after heartbeat:", got, err)
+			}
+		})
+	}
+
+	t.Run("proto", func(t *testing.T) {
+		var s ProtoSerializer
+		b, err := s.Marshal(&fakeProtoMessage{payload: "payload"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m fakeProtoMessage
+		if err := s.Unmarshal(b, &m); err != nil || m.payload != "payload" {
+			t.Fatal("unexpected message:", m.payload, err)
+		}
+		if _, err := s.Marshal(progress{}); !errors.Is(err, ErrNotProtoMessage) {
+			t.Fatal("expected not proto message error:", err)
+		}
+	})
+
+	t.Run("marshal error", func(t *testing.T) {
+		c, err := New(&mockDynamoDBClient{}, "locksSerializer", "key", DisableHeartbeat(), WithSerializer(ProtoSerializer{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.AcquireLock(context.Background(), "key", WithDataValue(progress{})); !errors.Is(err, ErrNotProtoMessage) {
+			t.Fatal("expected not proto message error:", err)
+		}
+	})
+}
@@ -19,11 +19,13 @@ package dynamolock
 import (
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 type acquireLockOptions struct {
 	partitionKey                string
+	sortKey                     string
 	data                        []byte
 	replaceData                 bool
 	deleteLockOnRelease         bool
@@ -32,31 +34,98 @@ type acquireLockOptions struct {
 	additionalTimeToWaitForLock time.Duration
 	additionalAttributes        map[string]types.AttributeValue
 	sessionMonitor              *sessionMonitor
+	priority                    int64
+	requestPreemption           bool
+	preemptionNotice            time.Duration
+	preemptionCallback          func(*Lock, PreemptionRequest)
+	recordWaitsFor              bool
+	immediateHeartbeat          bool
+	waitStrategy                WaitStrategy
+	onWait                      func(string, LockInfo, time.Duration)
+	onStatus                    func(AcquisitionStatus)
+	leaseExtender               func() time.Duration
+	maxLeaseDuration            time.Duration
+	done                        <-chan struct{}
+	maxAttempts                 int
+	dataValue                   interface{}
+	countAsWaiter               bool
+	takeoverGrace               time.Duration
+	declareIntent               bool
+	intentCallback              func(*Lock, string)
+	releaseRequestCallback      func(*Lock, ReleaseRequest)
+	joinInbox                   bool
+	nonCritical                 bool
+	eventuallyConsistentRead    bool
+	updateItemAcquisition       bool
+	ownershipLostCallback       func(*Lock, error)
+	callBudget                  int
+	advisory                    bool
+	onAdvisoryConflict          func(LockInfo)
+	acquisitionToken            string
 }
 
 type getLockOptions struct {
-	partitionKey                      string
-	deleteLockOnRelease               bool
-	millisecondsToWait                time.Duration
-	refreshPeriodDuration             time.Duration
-	lockTryingToBeAcquired            *Lock
-	alreadySleptOnceForOneLeasePeriod bool
-	sessionMonitor                    *sessionMonitor
-	start                             time.Time
-	replaceData                       bool
-	data                              []byte
-	additionalAttributes              map[string]types.AttributeValue
-	failIfLocked                      bool
+	tableName               string
+	partitionKey            string
+	sortKey                 string
+	deleteLockOnRelease     bool
+	waitStrategy            WaitStrategy
+	lockTryingToBeAcquired  *Lock
+	sessionMonitor          *sessionMonitor
+	start                   time.Time
+	replaceData             bool
+	data                    []byte
+	additionalAttributes    map[string]types.AttributeValue
+	failIfLocked            bool
+	attempts                int
+	acquisitionKind         AcquisitionKind
+	priority                int64
+	requestPreemption       bool
+	preemptionRequestedFrom string
+	preemptionNotice        time.Duration
+	preemptedOwner          string
+	handoffFrom             string
+	joinInbox               bool
+	inboxJoined             bool
+	recordWaitsFor          bool
+	waitsForRecorded        []*Lock
+	maxAttempts             int
+	countAsWaiter           bool
+	waiterCounted           bool
+	onStatus                func(AcquisitionStatus)
+	expiryGrace             time.Duration
+	declareIntent           bool
+	intentDeclaredTo        string
+	eventuallyConsistent    bool
+	conflicted              bool
+	updateItem              bool
+	advisory                bool
+	onAdvisoryConflict      func(LockInfo)
+	acquisitionToken        string
 }
 
 type releaseLockOptions struct {
-	lockItem   *Lock
-	deleteLock bool
-	data       []byte
+	lockItem      *Lock
+	deleteLock    bool
+	data          []byte
+	dataValue     interface{}
+	dataTransform func([]byte) []byte
+}
+
+type queryLocksOptions struct {
+	sortKeyCondition func(expression.KeyBuilder, types.ScalarAttributeType) expression.KeyConditionBuilder
 }
 
 type createDynamoDBTableOptions struct {
 	billingMode           types.BillingMode
 	provisionedThroughput *types.ProvisionedThroughput
 	tags                  []types.Tag
+	localIndexes          []localIndex
+	waitForActive         time.Duration
+}
+
+type localIndex struct {
+	name          string
+	attributeName string
+	attributeType types.ScalarAttributeType
 }
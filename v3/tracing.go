@@ -0,0 +1,89 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import "context"
+
+// Tracer receives the spans of the operations of the client, so it can be
+// bridged to any tracing system without this package importing it. Each lock
+// operation (acquisition, release and heartbeat) is a span, and so is each
+// DynamoDB call made on its behalf, as a child of the former. Adapters, such
+// as one for OpenTelemetry, are expected to live in their own modules.
+type Tracer interface {
+	// StartSpan starts a span with the given name and attributes, as a
+	// child of the span in ctx, if any, and returns the context that
+	// carries it.
+	StartSpan(ctx context.Context, name string, attributes map[string]string) context.Context
+	// EndSpan ends the span carried by ctx, as returned by StartSpan, with
+	// the error the operation failed with, if any.
+	EndSpan(ctx context.Context, err error)
+}
+
+// Names of the spans reported to the Tracer. DynamoDB calls are reported as
+// "dynamodb." followed by the name of the API, for example "dynamodb.PutItem".
+const (
+	SpanAcquireLock   = "dynamolock.AcquireLock"
+	SpanReleaseLock   = "dynamolock.ReleaseLock"
+	SpanSendHeartbeat = "dynamolock.SendHeartbeat"
+)
+
+// Attributes of the spans reported to the Tracer.
+const (
+	SpanAttributeTable        = "dynamolock.table"
+	SpanAttributePartitionKey = "dynamolock.partition_key"
+	SpanAttributeSortKey      = "dynamolock.sort_key"
+	SpanAttributeOwner        = "dynamolock.owner"
+)
+
+// WithTracer reports the spans of the operations of the client to tracer.
+func WithTracer(tracer Tracer) ClientOption {
+	return func(c *commonClient) { c.tracer = tracer }
+}
+
+// startSpan starts the span of a lock operation, returning the context that
+// carries it and the function that ends it.
+func (c *commonClient) startSpan(ctx context.Context, name string, key lockKey) (context.Context, func(error)) {
+	if c.tracer == nil {
+		return ctx, func(error) {}
+	}
+	tableName := key.tableName
+	if tableName == "" {
+		tableName = c.tableName
+	}
+	attributes := map[string]string{
+		SpanAttributeTable:        tableName,
+		SpanAttributePartitionKey: key.partitionKey,
+		SpanAttributeOwner:        c.currentOwnerName(),
+	}
+	if key.sortKey != "" {
+		attributes[SpanAttributeSortKey] = key.sortKey
+	}
+	spanCtx := c.tracer.StartSpan(ctx, name, attributes)
+	return spanCtx, func(err error) { c.tracer.EndSpan(spanCtx, err) }
+}
+
+// tracingMiddleware reports each DynamoDB call as a span.
+func (c *commonClient) tracingMiddleware(next Operation) Operation {
+	return func(ctx context.Context, name string, input interface{}) (interface{}, error) {
+		ctx = c.tracer.StartSpan(ctx, "dynamodb."+name, map[string]string{
+			SpanAttributeTable: tableNameOf(input),
+		})
+		out, err := next(ctx, name, input)
+		c.tracer.EndSpan(ctx, err)
+		return out, err
+	}
+}
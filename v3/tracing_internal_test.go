@@ -0,0 +1,110 @@
+/*
+Copyright 2021 U. Cirello (cirello.io and github.com/cirello-io)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dynamolock
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name       string
+	parent     string
+	attributes map[string]string
+	ended      bool
+	err        error
+}
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, name string, attributes map[string]string) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordedSpan{name: name, attributes: attributes}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func (t *recordingTracer) EndSpan(ctx context.Context, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := ctx.Value(spanKey{}).(*recordedSpan)
+	span.ended = true
+	span.err = err
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	c, err := New(&mockDynamoDBClient{}, "locksTracing", "key",
+		DisableHeartbeat(),
+		WithOwnerName("owner"),
+		WithTracer(tracer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.AcquireLock(context.Background(), "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendHeartbeat(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReleaseLock(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, span := range tracer.spans {
+		if !span.ended {
+			t.Errorf("span %s not ended", span.name)
+		}
+		if span.attributes[SpanAttributeTable] != "locksTracing" {
+			t.Errorf("span %s: unexpected table: %v", span.name, span.attributes)
+		}
+		switch span.name {
+		case SpanAcquireLock, SpanReleaseLock, SpanSendHeartbeat:
+			if span.parent != "" {
+				t.Errorf("span %s: unexpected parent %s", span.name, span.parent)
+			}
+			if span.attributes[SpanAttributePartitionKey] != "tracing" || span.attributes[SpanAttributeOwner] != "owner" {
+				t.Errorf("span %s: unexpected attributes: %v", span.name, span.attributes)
+			}
+		default:
+			if span.parent == "" {
+				t.Errorf("span %s should have a parent", span.name)
+			}
+		}
+		seen[span.name] = true
+	}
+	for _, name := range []string{SpanAcquireLock, SpanSendHeartbeat, SpanReleaseLock, "dynamodb.PutItem", "dynamodb.UpdateItem"} {
+		if !seen[name] {
+			t.Errorf("missing span %s", name)
+		}
+	}
+}